@@ -0,0 +1,74 @@
+package main
+
+import (
+	"Users/models"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ------CREATE API KEY------
+// the key is printed once; only its hash is stored
+func createAPIKey(a *app, args []string) error {
+	fs := flag.NewFlagSet("create-api-key", flag.ExitOnError)
+	name := fs.String("name", "", "what the key is for")
+	scopes := fs.String("scopes", "", "comma separated scopes: "+strings.Join(models.APIKeyScopes, ", "))
+	expires := fs.Duration("expires", 0, "lifetime of the key, e.g. 2160h; never expires when omitted")
+	fs.Parse(args)
+
+	req := models.APIKeyRequest{Name: *name}
+	for _, scope := range strings.Split(*scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			req.Scopes = append(req.Scopes, scope)
+		}
+	}
+	if *expires > 0 {
+		at := time.Now().Add(*expires)
+		req.ExpiresAt = &at
+	}
+	key, err := a.apiKeys.CreateAPIKey(a.tenant, req, "")
+	if err != nil {
+		return err
+	}
+	fmt.Println("created API key", key.ID, "for tenant", a.tenant)
+	fmt.Println(key.Key)
+	return nil
+}
+
+// ------LIST API KEYS------
+func listAPIKeys(a *app, args []string) error {
+	keys, err := a.apiKeys.ListAPIKeys(a.tenant)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPREFIX\tNAME\tSCOPES\tEXPIRES\tLAST USED\tREVOKED")
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Prefix, key.Name, strings.Join(key.Scopes, ","),
+			formatTime(key.ExpiresAt), formatTime(key.LastUsedAt), formatTime(key.RevokedAt))
+	}
+	return tw.Flush()
+}
+
+// ------REVOKE API KEY------
+func revokeAPIKey(a *app, args []string) error {
+	fs := flag.NewFlagSet("revoke-api-key", flag.ExitOnError)
+	id := fs.String("id", "", "ID of the key")
+	fs.Parse(args)
+
+	if err := a.apiKeys.RevokeAPIKey(a.tenant, *id); err != nil {
+		return err
+	}
+	fmt.Println("revoked API key", *id)
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,141 @@
+// Command usersctl is the operator CLI for the user service. It talks to the
+// same database through the repository and services packages, so every change
+// it makes goes through the same validation and hashing as the API.
+package main
+
+import (
+	"Users/database"
+	"Users/models"
+	"Users/repository"
+	"Users/services"
+	"Users/utils"
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// app holds the dependencies shared by every command
+type app struct {
+	client   *mongo.Client
+	db       string
+	tenant   string
+	repo     repository.UserRepository
+	canon    *utils.Canonicalizer
+	create   services.CreateInterface
+	update   services.UpdateInterface
+	bulk     services.ImportInterface
+	apiKeys  services.APIKeyInterface
+	keys     services.KeyRingInterface
+	sessions services.SessionInterface
+	lockout  services.LockoutInterface
+}
+
+type command struct {
+	usage string
+	run   func(a *app, args []string) error
+}
+
+var commands = map[string]command{
+	"create-admin":   {"create-admin -email EMAIL [-username NAME] [-password PASS]", createAdmin},
+	"reset-password": {"reset-password -user EMAIL|USERNAME|ID [-password PASS]", resetPassword},
+	"reset-2fa":      {"reset-2fa -user EMAIL|USERNAME|ID", resetTwoFactor},
+	"set-status":     {"set-status -user EMAIL|USERNAME|ID -status active|suspended (active also unlocks a locked user)", setStatus},
+	"list":           {"list [-q PREFIX] [-status STATUS] [-role ROLE] [-limit N]", listUsers},
+	"migrate":        {"migrate [-status] [-dry-run] [-down N] [-target VERSION]", migrate},
+	"export":         {"export [-out FILE]", exportUsers},
+	"import":         {"import [-in FILE]", importUsers},
+	"bulk-import":    {"bulk-import -in FILE [-format csv|ndjson] [-on-conflict skip|update] [-dry-run]", bulkImport},
+	"create-api-key": {"create-api-key -name NAME -scopes SCOPE[,SCOPE] [-expires DURATION]", createAPIKey},
+	"list-api-keys":  {"list-api-keys", listAPIKeys},
+	"revoke-api-key": {"revoke-api-key -id ID", revokeAPIKey},
+	//rotating needs the server's SIGNING_KEY_SECRET to seal the new key
+	"list-signing-keys":  {"list-signing-keys", listSigningKeys},
+	"rotate-signing-key": {"rotate-signing-key [-alg RS256|EdDSA] [-in DURATION]", rotateSigningKey},
+	"revoke-signing-key": {"revoke-signing-key -kid KID", revokeSigningKey},
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	a, err := newApp()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer a.client.Disconnect(context.Background())
+
+	if err := cmd.run(a, os.Args[2:]); err != nil {
+		log.Fatalf("%s: %v", os.Args[1], err)
+	}
+}
+
+func newApp() (*app, error) {
+	client := database.ConnectDB()
+	passwordConfig, err := utils.PasswordConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	hasher, err := utils.NewPasswordHasher(passwordConfig)
+	if err != nil {
+		return nil, err
+	}
+	canon := utils.NewCanonicalizer(utils.EmailPolicyFromEnv())
+	//USERSCTL_TENANT picks the tenant commands act on; TENANT_ISOLATION
+	//must match the server's so the tenant's database is found
+	tenant := os.Getenv("USERSCTL_TENANT")
+	if tenant == "" {
+		tenant = models.DefaultTenant
+	}
+	db := database.TenantDatabase(tenant, os.Getenv("TENANT_ISOLATION") == "database")
+	repo := repository.NewMongo(client, db, tenant)
+	attributes := services.NewAttributeService(repository.NewMongoAttributeSchemas(client, db), tenant)
+	var keySealer *utils.Sealer
+	if secret := os.Getenv("SIGNING_KEY_SECRET"); secret != "" {
+		if keySealer, err = utils.NewSealer(utils.DeriveKey([]byte(secret), "signing-keys")); err != nil {
+			return nil, err
+		}
+	}
+	return &app{
+		client:  client,
+		db:      db,
+		tenant:  tenant,
+		repo:    repo,
+		canon:   canon,
+		create:  services.NewCreateService(repo, hasher, canon, attributes),
+		update:  services.NewUpdateService(repo, hasher, canon, attributes),
+		bulk:    services.NewImportService(repo, hasher, canon, attributes),
+		apiKeys: services.NewAPIKeyService(repository.NewMongoAPIKeys(client, database.DatabaseName), slog.Default()),
+		keys:    services.NewKeyRing(repository.NewMongoSigningKeys(client, database.DatabaseName), keySealer, services.DefaultKeyRingOptions()),
+		//sessions are shared by all tenants; only their revocation is used
+		sessions: services.NewSessionService(repository.NewMongoSessions(client, database.DatabaseName), services.DefaultSessionOptions(), slog.Default()),
+		lockout:  services.NewLockoutService(repository.NewMongoAttempts(client, db, tenant), repo, services.NewLogNotifier(slog.Default()), services.DefaultLockoutOptions(), slog.Default()),
+	}, nil
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("usage: usersctl <command> [flags]\n\ncommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprint(os.Stderr, b.String())
+}
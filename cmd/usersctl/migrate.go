@@ -0,0 +1,71 @@
+package main
+
+import (
+	"Users/migrations"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ------MIGRATE------
+func migrate(a *app, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	status := fs.Bool("status", false, "show applied and pending migrations")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	down := fs.Int("down", 0, "roll back this many migrations")
+	target := fs.Int("target", 0, "migrate up to this version only")
+	fs.Parse(args)
+
+	hostname, _ := os.Hostname()
+	runner, err := migrations.NewRunner(a.client.Database(a.db), migrations.All(a.canon), fmt.Sprintf("usersctl-%s-%d", hostname, os.Getpid()))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	if *status {
+		applied, err := runner.Applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range applied {
+			fmt.Printf("applied  %4d  %s  (%s)\n", m.Version, m.Name, m.AppliedAt.Format(time.RFC3339))
+		}
+		pending, err := runner.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range pending {
+			fmt.Printf("pending  %4d  %s\n", m.Version, m.Name)
+		}
+		return nil
+	}
+
+	opts := migrations.Options{DryRun: *dryRun, Target: *target}
+	var results []migrations.Result
+	if *down > 0 {
+		results, err = runner.Down(ctx, *down, opts)
+	} else {
+		results, err = runner.Up(ctx, opts)
+	}
+	verb := "applied"
+	if *down > 0 {
+		verb = "rolled back"
+	}
+	if *dryRun {
+		verb = "would be " + verb
+	}
+	for _, r := range results {
+		fmt.Printf("%s %d %s (%d documents)\n", verb, r.Version, r.Name, r.Affected)
+	}
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		fmt.Println("nothing to do")
+	}
+	return nil
+}
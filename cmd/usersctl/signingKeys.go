@@ -0,0 +1,59 @@
+package main
+
+import (
+	"Users/jose"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// ------LIST SIGNING KEYS------
+func listSigningKeys(a *app, args []string) error {
+	keys, err := a.keys.ListKeys()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KID\tALG\tSTATUS\tACTIVATES\tRETIRES\tEXPIRES\tREVOKED")
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Algorithm, key.Status(now),
+			key.ActivatesAt.Format(time.RFC3339), key.RetiresAt.Format(time.RFC3339), key.ExpiresAt.Format(time.RFC3339), formatTime(key.RevokedAt))
+	}
+	return tw.Flush()
+}
+
+// ------ROTATE SIGNING KEY------
+// the new key is published at once and takes over signing after -in, so
+// verifiers caching the key set pick it up first; the keys it replaces stay
+// published until the tokens they signed have expired
+func rotateSigningKey(a *app, args []string) error {
+	fs := flag.NewFlagSet("rotate-signing-key", flag.ExitOnError)
+	alg := fs.String("alg", jose.RS256, "algorithm of the new key: RS256 or EdDSA")
+	in := fs.Duration("in", time.Hour, "when the new key starts signing; 0 for at once")
+	fs.Parse(args)
+
+	key, err := a.keys.Rotate(*alg, *in)
+	if err != nil {
+		return err
+	}
+	fmt.Println("created signing key", key.ID, "signing from", key.ActivatesAt.Format(time.RFC3339))
+	return nil
+}
+
+// ------REVOKE SIGNING KEY------
+// tokens signed with the key stop verifying at once; replicas notice within
+// a minute
+func revokeSigningKey(a *app, args []string) error {
+	fs := flag.NewFlagSet("revoke-signing-key", flag.ExitOnError)
+	kid := fs.String("kid", "", "key ID")
+	fs.Parse(args)
+
+	if err := a.keys.Revoke(*kid); err != nil {
+		return err
+	}
+	fmt.Println("revoked signing key", *kid)
+	return nil
+}
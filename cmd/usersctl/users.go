@@ -0,0 +1,182 @@
+package main
+
+import (
+	"Users/models"
+	"Users/validation"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ------CREATE ADMIN------
+func createAdmin(a *app, args []string) error {
+	fs := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	email := fs.String("email", "", "admin email")
+	username := fs.String("username", "", "admin username")
+	password := fs.String("password", "", "admin password, generated when empty")
+	fs.Parse(args)
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+
+	generated := *password == ""
+	if generated {
+		*password = generatePassword()
+	}
+	user := &models.User{Email: *email, Username: *username, Password: *password}
+	if err := a.create.CreateUserWithRole(user, models.RoleAdmin); err != nil {
+		return err
+	}
+	fmt.Println("created admin", user.ID)
+	if generated {
+		fmt.Println("password:", *password)
+	}
+	return nil
+}
+
+// ------RESET PASSWORD------
+func resetPassword(a *app, args []string) error {
+	fs := flag.NewFlagSet("reset-password", flag.ExitOnError)
+	ident := fs.String("user", "", "email, username or ID")
+	password := fs.String("password", "", "new password, generated when empty")
+	fs.Parse(args)
+
+	user, err := a.findUser(*ident)
+	if err != nil {
+		return err
+	}
+	generated := *password == ""
+	if generated {
+		*password = generatePassword()
+	}
+	if err := a.update.UpdateUser(&models.User{ID: user.ID, Password: *password}); err != nil {
+		return err
+	}
+	fmt.Println("password reset for", user.ID)
+	if generated {
+		fmt.Println("password:", *password)
+	}
+	return nil
+}
+
+// ------RESET 2FA------
+// removes a user's second factor, for users who lost their authenticator
+// app and recovery codes
+func resetTwoFactor(a *app, args []string) error {
+	fs := flag.NewFlagSet("reset-2fa", flag.ExitOnError)
+	ident := fs.String("user", "", "email, username or ID")
+	fs.Parse(args)
+
+	user, err := a.findUser(*ident)
+	if err != nil {
+		return err
+	}
+	if err := a.repo.SetTwoFactor(user.ID, nil); err != nil {
+		return err
+	}
+	fmt.Println("two-factor authentication reset for", user.ID)
+	return nil
+}
+
+// ------SET STATUS------
+func setStatus(a *app, args []string) error {
+	fs := flag.NewFlagSet("set-status", flag.ExitOnError)
+	ident := fs.String("user", "", "email, username or ID")
+	status := fs.String("status", "", "active or suspended; locked is only set by the lockout, and active lifts it")
+	fs.Parse(args)
+
+	if err := validation.ValidateStatus(*status); err != nil {
+		return err
+	}
+	user, err := a.findUser(*ident)
+	if err != nil {
+		return err
+	}
+	if err := a.repo.UpdateUserStatus(user.ID, *status); err != nil {
+		return err
+	}
+	//as through the API: reactivating forgives failed logins, and
+	//suspending signs the user out everywhere
+	switch *status {
+	case models.StatusActive:
+		if err := a.lockout.Unlock(user.ID); err != nil {
+			return fmt.Errorf("status set, but clearing failed logins: %w", err)
+		}
+	case models.StatusSuspended:
+		if err := a.sessions.RevokeUserSessions(a.tenant, user.ID); err != nil {
+			return fmt.Errorf("status set, but revoking sessions: %w", err)
+		}
+	}
+	fmt.Printf("status of %s set to %s\n", user.ID, *status)
+	return nil
+}
+
+// ------LIST USERS------
+func listUsers(a *app, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	var filter models.UserFilter
+	fs.StringVar(&filter.Query, "q", "", "email or username prefix")
+	fs.StringVar(&filter.Status, "status", "", "only users with this status")
+	fs.StringVar(&filter.Role, "role", "", "only users with this role")
+	fs.Int64Var(&filter.Limit, "limit", 50, "maximum number of users, 0 for all")
+	fs.Parse(args)
+	filter.Query = a.canon.Display(filter.Query)
+
+	users, err := a.repo.ListUsers(filter)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEMAIL\tUSERNAME\tSTATUS\tROLE\tCREATED")
+	for _, u := range users {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", u.ID, u.Email, u.Username, u.Status, u.Role, u.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	return tw.Flush()
+}
+
+// findUser resolves an ID, email or username to a user
+func (a *app) findUser(ident string) (*models.User, error) {
+	if ident == "" {
+		return nil, errors.New("-user is required")
+	}
+	if _, err := primitive.ObjectIDFromHex(ident); err == nil {
+		return a.repo.FetchUserByID(ident)
+	}
+	if strings.Contains(ident, "@") {
+		return a.repo.FetchUserByEmail(a.canon.Email(ident))
+	}
+	return a.repo.FetchUserByUsername(a.canon.Username(ident))
+}
+
+// generatePassword returns a random password that passes ValidatePassword
+func generatePassword() string {
+	const (
+		upper  = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+		lower  = "abcdefghijkmnopqrstuvwxyz"
+		digits = "23456789"
+	)
+	all := upper + lower + digits
+	var b strings.Builder
+	for _, set := range []string{upper, lower, digits} {
+		b.WriteByte(randomChar(set))
+	}
+	for b.Len() < 20 {
+		b.WriteByte(randomChar(all))
+	}
+	return b.String()
+}
+
+func randomChar(set string) byte {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(set))))
+	if err != nil {
+		panic(err)
+	}
+	return set[n.Int64()]
+}
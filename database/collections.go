@@ -0,0 +1,359 @@
+package database
+
+import (
+	"Users/models"
+	"context"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const DatabaseName = "usersdb"
+
+// Schema declares every collection the service uses. Add new collections and
+// indexes here rather than creating them from the repositories.
+func Schema() []CollectionSpec {
+	expireAtTime := time.Duration(0)
+	return []CollectionSpec{
+		{
+			Name:      "users",
+			Validator: usersValidator(),
+			Indexes: []IndexSpec{
+				//identifiers are unique per tenant; partial so documents
+				//written before canonical fields existed don't collide
+				{
+					Name:          "uniq_tenant_email_canonical",
+					Keys:          bson.D{{Key: "tenantId", Value: 1}, {Key: "emailCanonical", Value: 1}},
+					Unique:        true,
+					PartialFilter: bson.D{{Key: "emailCanonical", Value: bson.M{"$type": "string"}}},
+				},
+				{
+					Name:          "uniq_tenant_username_canonical",
+					Keys:          bson.D{{Key: "tenantId", Value: 1}, {Key: "usernameCanonical", Value: 1}},
+					Unique:        true,
+					PartialFilter: bson.D{{Key: "usernameCanonical", Value: bson.M{"$type": "string"}}},
+				},
+				{
+					Name: "status_createdAt",
+					Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: -1}},
+				},
+				{
+					//a collection holds one text index; migration 5
+					//replaces text_username_email with this one
+					Name: "text_username_email_displayName",
+					Keys: bson.D{{Key: "username", Value: "text"}, {Key: "email", Value: "text"}, {Key: "profile.displayName", Value: "text"}},
+				},
+				{
+					Name: "searchGrams",
+					Keys: bson.D{{Key: "searchGrams", Value: 1}},
+				},
+			},
+		},
+		{
+			//one JSON Schema for custom user attributes per scope
+			Name: "attribute_schemas",
+		},
+		{
+			//invitations are removed by mongo once expiresAt has passed
+			Name: "invitations",
+			Indexes: []IndexSpec{
+				{
+					Name: "ttl_expiresAt",
+					Keys: bson.D{{Key: "expiresAt", Value: 1}},
+					TTL:  &expireAtTime,
+				},
+			},
+		},
+		{
+			Name: "passkeys",
+			Indexes: []IndexSpec{
+				{
+					Name:   "uniq_tenant_credentialId",
+					Keys:   bson.D{{Key: "tenantId", Value: 1}, {Key: "credentialId", Value: 1}},
+					Unique: true,
+				},
+				{
+					Name: "tenant_userId",
+					Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "userId", Value: 1}},
+				},
+			},
+		},
+		{
+			//an upstream account links to one user, and a user to one
+			//account per provider
+			Name: "identities",
+			Indexes: []IndexSpec{
+				{
+					Name:   "uniq_tenant_provider_subject",
+					Keys:   bson.D{{Key: "tenantId", Value: 1}, {Key: "provider", Value: 1}, {Key: "subject", Value: 1}},
+					Unique: true,
+				},
+				{
+					Name:   "uniq_tenant_userId_provider",
+					Keys:   bson.D{{Key: "tenantId", Value: 1}, {Key: "userId", Value: 1}, {Key: "provider", Value: 1}},
+					Unique: true,
+				},
+			},
+		},
+		{
+			//sign ins at upstream providers in progress, dropped once expired
+			Name: "federation_states",
+			Indexes: []IndexSpec{
+				{
+					Name: "ttl_expiresAt",
+					Keys: bson.D{{Key: "expiresAt", Value: 1}},
+					TTL:  &expireAtTime,
+				},
+			},
+		},
+		{
+			//registrations and logins in progress, dropped once expired
+			Name: "webauthn_ceremonies",
+			Indexes: []IndexSpec{
+				{
+					Name: "ttl_expiresAt",
+					Keys: bson.D{{Key: "expiresAt", Value: 1}},
+					TTL:  &expireAtTime,
+				},
+			},
+		},
+		{
+			//failed login counters per account and address, shared by
+			//replicas and dropped once idle
+			Name: "login_attempts",
+			Indexes: []IndexSpec{
+				{
+					Name:   "uniq_tenant_key",
+					Keys:   bson.D{{Key: "tenantId", Value: 1}, {Key: "key", Value: 1}},
+					Unique: true,
+				},
+				{
+					Name: "ttl_expiresAt",
+					Keys: bson.D{{Key: "expiresAt", Value: 1}},
+					TTL:  &expireAtTime,
+				},
+			},
+		},
+		{
+			Name: "groups",
+			Indexes: []IndexSpec{
+				{
+					Name:   "uniq_tenant_name_canonical",
+					Keys:   bson.D{{Key: "tenantId", Value: 1}, {Key: "nameCanonical", Value: 1}},
+					Unique: true,
+				},
+				//removing a deleted user or group from the groups containing it
+				{
+					Name: "tenant_members",
+					Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "members", Value: 1}},
+				},
+				{
+					Name: "tenant_subgroups",
+					Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "subgroups", Value: 1}},
+				},
+			},
+		},
+		{
+			//GridFS avatar bucket; these are the indexes the driver would
+			//otherwise create on first upload
+			Name: "avatars.files",
+			Indexes: []IndexSpec{
+				{
+					Name: "filename_1_uploadDate_1",
+					Keys: bson.D{{Key: "filename", Value: 1}, {Key: "uploadDate", Value: 1}},
+				},
+			},
+		},
+		{
+			Name: "avatars.chunks",
+			Indexes: []IndexSpec{
+				{
+					Name:   "files_id_1_n_1",
+					Keys:   bson.D{{Key: "files_id", Value: 1}, {Key: "n", Value: 1}},
+					Unique: true,
+				},
+			},
+		},
+		{
+			//finished bulk jobs are kept for a day so callers can poll results
+			Name: "jobs",
+			Indexes: []IndexSpec{
+				{
+					Name: "ttl_expiresAt",
+					Keys: bson.D{{Key: "expiresAt", Value: 1}},
+					TTL:  &expireAtTime,
+				},
+			},
+		},
+	}
+}
+
+// RegistrySchema declares the collections that exist once, in the service
+// database, whatever the tenant isolation mode.
+func RegistrySchema() []CollectionSpec {
+	expireAtTime := time.Duration(0)
+	return []CollectionSpec{
+		{Name: "tenants"},
+		{
+			//API keys are looked up by prefix before the tenant is known
+			Name: "api_keys",
+			Indexes: []IndexSpec{
+				{
+					Name:   "uniq_prefix",
+					Keys:   bson.D{{Key: "prefix", Value: 1}},
+					Unique: true,
+				},
+				{
+					Name: "tenantId_createdAt",
+					Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "createdAt", Value: -1}},
+				},
+			},
+		},
+		{
+			//sessions are resolved from their bearer token before the
+			//tenant is known, and dropped by mongo once expired
+			Name: "sessions",
+			Indexes: []IndexSpec{
+				{
+					Name: "tenantId_userId",
+					Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "userId", Value: 1}},
+				},
+				{
+					Name: "ttl_expiresAt",
+					Keys: bson.D{{Key: "expiresAt", Value: 1}},
+					TTL:  &expireAtTime,
+				},
+			},
+		},
+		{
+			//OAuth clients are looked up by client ID before the tenant is
+			//known, and so are the codes, tokens and consents they hold
+			Name: "oauth_clients",
+			Indexes: []IndexSpec{
+				{
+					Name: "tenantId_createdAt",
+					Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "createdAt", Value: -1}},
+				},
+			},
+		},
+		{
+			Name: "oauth_codes",
+			Indexes: []IndexSpec{
+				{
+					Name: "ttl_expiresAt",
+					Keys: bson.D{{Key: "expiresAt", Value: 1}},
+					TTL:  &expireAtTime,
+				},
+			},
+		},
+		{
+			Name: "oauth_tokens",
+			Indexes: []IndexSpec{
+				{
+					Name: "tenantId_clientId_userId",
+					Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "clientId", Value: 1}, {Key: "userId", Value: 1}},
+				},
+				{
+					Name: "tenantId_userId",
+					Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "userId", Value: 1}},
+				},
+				{
+					Name: "ttl_expiresAt",
+					Keys: bson.D{{Key: "expiresAt", Value: 1}},
+					TTL:  &expireAtTime,
+				},
+			},
+		},
+		{
+			Name: "oauth_consents",
+			Indexes: []IndexSpec{
+				{
+					Name:   "uniq_tenantId_userId_clientId",
+					Keys:   bson.D{{Key: "tenantId", Value: 1}, {Key: "userId", Value: 1}, {Key: "clientId", Value: 1}},
+					Unique: true,
+				},
+				{
+					Name: "tenantId_clientId",
+					Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "clientId", Value: 1}},
+				},
+			},
+		},
+		{
+			//token signing keys, dropped by mongo once nothing signed with
+			//them can still be valid
+			Name: "signing_keys",
+			Indexes: []IndexSpec{
+				{
+					Name: "activatesAt",
+					Keys: bson.D{{Key: "activatesAt", Value: -1}},
+				},
+				{
+					Name: "ttl_expiresAt",
+					Keys: bson.D{{Key: "expiresAt", Value: 1}},
+					TTL:  &expireAtTime,
+				},
+			},
+		},
+		{
+			//request counts per rate limit window, shared by replicas
+			Name: "rate_limits",
+			Indexes: []IndexSpec{
+				{
+					Name: "ttl_expiresAt",
+					Keys: bson.D{{Key: "expiresAt", Value: 1}},
+					TTL:  &expireAtTime,
+				},
+			},
+		},
+	}
+}
+
+// TenantDatabase returns the database holding a tenant's data. With
+// perTenant every tenant gets its own database, except the default tenant,
+// which keeps the data written before tenants existed.
+func TenantDatabase(tenant string, perTenant bool) string {
+	if !perTenant || tenant == models.DefaultTenant {
+		return DatabaseName
+	}
+	return DatabaseName + "_" + tenant
+}
+
+func usersValidator() bson.M {
+	return bson.M{
+		"bsonType": "object",
+		"required": bson.A{"tenantId", "email", "password", "status", "createdAt"},
+		"properties": bson.M{
+			"email":             bson.M{"bsonType": "string"},
+			"emailCanonical":    bson.M{"bsonType": "string"},
+			"username":          bson.M{"bsonType": "string"},
+			"usernameCanonical": bson.M{"bsonType": "string"},
+			"password":          bson.M{"bsonType": "string"},
+			"status":            bson.M{"bsonType": "string"},
+			"role":              bson.M{"bsonType": "string"},
+			"tenantId":          bson.M{"bsonType": "string"},
+			"searchGrams":       bson.M{"bsonType": "array", "items": bson.M{"bsonType": "string"}},
+			"profile":           bson.M{"bsonType": "object"},
+			"attributes":        bson.M{"bsonType": "object"},
+			"createdAt":         bson.M{"bsonType": "date"},
+		},
+	}
+}
+
+// EnsureSchema applies Schema to db, plus RegistrySchema when db is the
+// service database. The validation action comes from
+// SCHEMA_VALIDATION_ACTION ("error" or "warn", default "error").
+func EnsureSchema(client *mongo.Client, db string) (*SchemaReport, error) {
+	action := os.Getenv("SCHEMA_VALIDATION_ACTION")
+	if action == "" {
+		action = "error"
+	}
+	specs := Schema()
+	if db == DatabaseName {
+		specs = append(specs, RegistrySchema()...)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return ApplySchema(ctx, client.Database(db), specs, action)
+}
@@ -0,0 +1,259 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IndexSpec declares an index the service depends on.
+type IndexSpec struct {
+	Name          string
+	Keys          bson.D
+	Unique        bool
+	TTL           *time.Duration
+	PartialFilter bson.D
+}
+
+// CollectionSpec declares a collection with its indexes and optional
+// $jsonSchema validator.
+type CollectionSpec struct {
+	Name      string
+	Validator bson.M
+	Indexes   []IndexSpec
+}
+
+// SchemaReport lists what ApplySchema changed and where the database
+// disagrees with the declared specs. Drifted indexes are never dropped
+// automatically; they need an operator to look at them.
+type SchemaReport struct {
+	CreatedCollections []string
+	CreatedIndexes     []string
+	UpdatedValidators  []string
+	DriftedIndexes     []string
+	UnexpectedIndexes  []string
+}
+
+func (r *SchemaReport) HasDrift() bool {
+	return len(r.DriftedIndexes) > 0 || len(r.UnexpectedIndexes) > 0
+}
+
+// ApplySchema makes the database match the specs. It is idempotent: running
+// it against an up-to-date database changes nothing.
+func ApplySchema(ctx context.Context, db *mongo.Database, specs []CollectionSpec, validationAction string) (*SchemaReport, error) {
+	report := &SchemaReport{}
+	existing, err := db.ListCollectionSpecifications(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("listing collections: %w", err)
+	}
+	byName := map[string]*mongo.CollectionSpecification{}
+	for _, c := range existing {
+		byName[c.Name] = c
+	}
+
+	for _, spec := range specs {
+		current, found := byName[spec.Name]
+		if !found {
+			opts := options.CreateCollection()
+			if spec.Validator != nil {
+				opts.SetValidator(bson.M{"$jsonSchema": spec.Validator}).
+					SetValidationLevel("moderate").
+					SetValidationAction(validationAction)
+			}
+			if err := db.CreateCollection(ctx, spec.Name, opts); err != nil {
+				return nil, fmt.Errorf("creating collection %s: %w", spec.Name, err)
+			}
+			report.CreatedCollections = append(report.CreatedCollections, spec.Name)
+		} else if spec.Validator != nil && !validatorMatches(current, spec.Validator, validationAction) {
+			cmd := bson.D{
+				{Key: "collMod", Value: spec.Name},
+				{Key: "validator", Value: bson.M{"$jsonSchema": spec.Validator}},
+				{Key: "validationLevel", Value: "moderate"},
+				{Key: "validationAction", Value: validationAction},
+			}
+			if err := db.RunCommand(ctx, cmd).Err(); err != nil {
+				return nil, fmt.Errorf("updating validator on %s: %w", spec.Name, err)
+			}
+			report.UpdatedValidators = append(report.UpdatedValidators, spec.Name)
+		}
+
+		if err := applyIndexes(ctx, db.Collection(spec.Name), spec.Indexes, report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// indexInfo is the subset of listIndexes output the specs are compared on.
+type indexInfo struct {
+	Name                    string   `bson:"name"`
+	Key                     bson.D   `bson:"key"`
+	Unique                  bool     `bson:"unique"`
+	ExpireAfterSeconds      *int64   `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.Raw `bson:"partialFilterExpression"`
+	Weights                 bson.Raw `bson:"weights"`
+}
+
+func applyIndexes(ctx context.Context, collection *mongo.Collection, specs []IndexSpec, report *SchemaReport) error {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return fmt.Errorf("listing indexes on %s: %w", collection.Name(), err)
+	}
+	var current []indexInfo
+	if err := cursor.All(ctx, &current); err != nil {
+		return fmt.Errorf("listing indexes on %s: %w", collection.Name(), err)
+	}
+
+	declared := map[string]bool{"_id_": true}
+	var missing []mongo.IndexModel
+	for _, spec := range specs {
+		declared[spec.Name] = true
+		existing := findIndex(current, spec)
+		if existing == nil {
+			missing = append(missing, spec.model())
+			report.CreatedIndexes = append(report.CreatedIndexes, collection.Name()+"."+spec.Name)
+			continue
+		}
+		if !indexMatches(existing, spec) {
+			report.DriftedIndexes = append(report.DriftedIndexes, collection.Name()+"."+spec.Name)
+		}
+	}
+	for _, idx := range current {
+		if !declared[idx.Name] {
+			report.UnexpectedIndexes = append(report.UnexpectedIndexes, collection.Name()+"."+idx.Name)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+	if _, err := collection.Indexes().CreateMany(ctx, missing); err != nil {
+		return fmt.Errorf("creating indexes on %s: %w", collection.Name(), err)
+	}
+	return nil
+}
+
+func (s IndexSpec) model() mongo.IndexModel {
+	opts := options.Index().SetName(s.Name)
+	if s.Unique {
+		opts.SetUnique(true)
+	}
+	if s.TTL != nil {
+		opts.SetExpireAfterSeconds(int32(s.TTL.Seconds()))
+	}
+	if s.PartialFilter != nil {
+		opts.SetPartialFilterExpression(s.PartialFilter)
+	}
+	return mongo.IndexModel{Keys: s.Keys, Options: opts}
+}
+
+// findIndex matches an existing index by name, or by key pattern when the
+// same keys were indexed under another name.
+func findIndex(current []indexInfo, spec IndexSpec) *indexInfo {
+	for i := range current {
+		if current[i].Name == spec.Name {
+			return &current[i]
+		}
+	}
+	keys, _ := storedKeys(spec.Keys)
+	for i := range current {
+		if sameKeys(current[i].Key, keys) {
+			return &current[i]
+		}
+	}
+	return nil
+}
+
+// storedKeys is the key pattern as listIndexes reports it: text fields are
+// folded into _fts/_ftsx and listed in the weights instead
+func storedKeys(keys bson.D) (bson.D, bson.M) {
+	var stored bson.D
+	var weights bson.M
+	for _, k := range keys {
+		if k.Value != "text" {
+			stored = append(stored, k)
+			continue
+		}
+		if weights == nil {
+			weights = bson.M{}
+			stored = append(stored, bson.E{Key: "_fts", Value: "text"}, bson.E{Key: "_ftsx", Value: 1})
+		}
+		weights[k.Key] = 1
+	}
+	return stored, weights
+}
+
+func indexMatches(idx *indexInfo, spec IndexSpec) bool {
+	keys, weights := storedKeys(spec.Keys)
+	if idx.Name != spec.Name || !sameKeys(idx.Key, keys) || idx.Unique != spec.Unique {
+		return false
+	}
+	if weights != nil && !sameDocument(idx.Weights, weights) {
+		return false
+	}
+	if (idx.ExpireAfterSeconds != nil) != (spec.TTL != nil) {
+		return false
+	}
+	if spec.TTL != nil && *idx.ExpireAfterSeconds != int64(spec.TTL.Seconds()) {
+		return false
+	}
+	if (idx.PartialFilterExpression != nil) != (spec.PartialFilter != nil) {
+		return false
+	}
+	return spec.PartialFilter == nil || sameDocument(idx.PartialFilterExpression, spec.PartialFilter)
+}
+
+func validatorMatches(current *mongo.CollectionSpecification, schema bson.M, validationAction string) bool {
+	if current.Options == nil {
+		return false
+	}
+	var opts struct {
+		Validator        bson.Raw `bson:"validator"`
+		ValidationAction string   `bson:"validationAction"`
+	}
+	if err := bson.Unmarshal(current.Options, &opts); err != nil || opts.Validator == nil {
+		return false
+	}
+	if opts.ValidationAction != "" && opts.ValidationAction != validationAction {
+		return false
+	}
+	return sameDocument(opts.Validator, bson.M{"$jsonSchema": schema})
+}
+
+// sameKeys compares index key patterns in order; the direction values are
+// compared as JSON so int32/int64/double differences don't count.
+func sameKeys(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || !sameDocument(bson.M{"v": a[i].Value}, bson.M{"v": b[i].Value}) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameDocument compares two documents ignoring field order and numeric
+// types, by round-tripping them through relaxed extended JSON.
+func sameDocument(a, b interface{}) bool {
+	na, errA := normalizeDocument(a)
+	nb, errB := normalizeDocument(b)
+	return errA == nil && errB == nil && reflect.DeepEqual(na, nb)
+}
+
+func normalizeDocument(doc interface{}) (interface{}, error) {
+	raw, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	err = json.Unmarshal(raw, &out)
+	return out, err
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"Users/middleware"
+	"Users/models"
+	"Users/repository"
+	"Users/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ------------------ API KEYS ------------------
+// GET lists the tenant's API keys; POST creates one from {"name", "scopes",
+// "expires_at"} and returns it with its secret, which is shown only once
+func (h *Handler) APIKeysEndpoint(w http.ResponseWriter, r *http.Request) {
+
+	tenantID := middleware.TenantFrom(r.Context())
+	if r.Method == http.MethodGet {
+		keys, err := h.APIKeys.ListAPIKeys(tenantID)
+		if err != nil {
+			http.Error(w, "Error fetching API keys", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+		return
+	}
+
+	var req models.APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	key, err := h.APIKeys.CreateAPIKey(tenantID, req, middleware.UserFrom(r.Context()))
+	if errors.Is(err, services.ErrInvalidInput) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error creating API key", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// ------------------ REVOKE API KEY ------------------
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	err := h.APIKeys.RevokeAPIKey(middleware.TenantFrom(r.Context()), r.PathValue("id"))
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error revoking API key", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Message{Message: "API key revoked"})
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"Users/repository"
+	"Users/services"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// ------------------ ATTRIBUTE SCHEMA ------------------
+// GET returns the JSON Schema custom user attributes are validated against,
+// PUT replaces it
+func (h *Handler) AttributeSchema(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method == http.MethodPut {
+		RequireAdmin((*Handler).setAttributeSchema)(h, w, r)
+		return
+	}
+
+	schema, err := h.Attributes.Schema()
+	if errors.Is(err, repository.ErrSchemaNotFound) {
+		http.Error(w, "No attribute schema defined", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error fetching attribute schema", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schema)
+}
+
+// the body is the JSON Schema itself
+func (h *Handler) setAttributeSchema(w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, services.MaxAttributeSchemaBytes))
+	if err != nil {
+		http.Error(w, "Attribute schema too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	schema, err := h.Attributes.SetSchema(body)
+	if errors.Is(err, services.ErrInvalidInput) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error saving attribute schema", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schema)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"Users/middleware"
+	"Users/models"
+	"net/http"
+	"slices"
+)
+
+// Endpoint is a Handler method, e.g. (*Handler).CreateUser.
+type Endpoint func(h *Handler, w http.ResponseWriter, r *http.Request)
+
+// Access decides who may call the user endpoints. Admins are users with the
+// admin role or members, possibly through nested groups, of AdminGroups.
+// Without Enforce every request is allowed; that is only meant for local
+// development.
+type Access struct {
+	Enforce     bool
+	AdminGroups []string
+}
+
+// RequireAdmin lets only admins call fn, and API keys holding any of
+// scopes.
+func RequireAdmin(fn Endpoint, scopes ...string) Endpoint {
+	return func(h *Handler, w http.ResponseWriter, r *http.Request) {
+		if h.authorize(w, r, "", scopes) {
+			fn(h, w, r)
+		}
+	}
+}
+
+// RequireSelfOrAdmin lets admins and the user named by the {id} path value
+// call fn.
+func RequireSelfOrAdmin(fn Endpoint) Endpoint {
+	return func(h *Handler, w http.ResponseWriter, r *http.Request) {
+		if h.authorize(w, r, r.PathValue("id"), nil) {
+			fn(h, w, r)
+		}
+	}
+}
+
+// RequireSelf lets only the user named by the {id} path value call fn, for
+// actions even an admin must not take on someone's behalf.
+func RequireSelf(fn Endpoint) Endpoint {
+	return func(h *Handler, w http.ResponseWriter, r *http.Request) {
+		if !h.Access.Enforce {
+			fn(h, w, r)
+			return
+		}
+		if middleware.APIKeyFrom(r.Context()) != "" {
+			http.Error(w, "Not allowed for API keys", http.StatusForbidden)
+			return
+		}
+		callerID := middleware.UserFrom(r.Context())
+		if callerID == "" {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		if callerID != r.PathValue("id") {
+			http.Error(w, "Not allowed", http.StatusForbidden)
+			return
+		}
+		fn(h, w, r)
+	}
+}
+
+// authorize reports whether the caller is an admin, the user self or an API
+// key with one of scopes, and writes the error response when not
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, self string, scopes []string) bool {
+	if !h.Access.Enforce {
+		return true
+	}
+	//API keys act for a service, never for a user, so only scopes count
+	if middleware.APIKeyFrom(r.Context()) != "" {
+		granted := middleware.ScopesFrom(r.Context())
+		if slices.ContainsFunc(scopes, func(scope string) bool { return slices.Contains(granted, scope) }) {
+			return true
+		}
+		http.Error(w, "API key lacks the required scope", http.StatusForbidden)
+		return false
+	}
+	callerID := middleware.UserFrom(r.Context())
+	if callerID == "" {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return false
+	}
+	caller, err := h.Users.FetchUserByID(callerID)
+	if err != nil {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return false
+	}
+	if caller.Status != models.StatusActive {
+		http.Error(w, "Account is not active", http.StatusForbidden)
+		return false
+	}
+	if self != "" && caller.ID == self {
+		return true
+	}
+	if caller.Role == models.RoleAdmin {
+		return true
+	}
+	admin, err := h.Groups.InAnyGroup(caller.ID, h.Access.AdminGroups)
+	if err != nil {
+		http.Error(w, "Error checking permissions", http.StatusInternalServerError)
+		return false
+	}
+	if !admin {
+		http.Error(w, "Not allowed", http.StatusForbidden)
+		return false
+	}
+	return true
+}
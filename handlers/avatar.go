@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"Users/imaging"
+	"Users/models"
+	"Users/repository"
+	"Users/services"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ------------------ UPLOAD AVATAR ------------------
+// expects a multipart form with the image in the "avatar" field
+func (h *Handler) uploadAvatar(w http.ResponseWriter, r *http.Request) {
+
+	//leaving room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, services.MaxAvatarBytes+64<<10)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected a multipart/form-data body", http.StatusBadRequest)
+		return
+	}
+	var data []byte
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "Invalid multipart body or avatar too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if part.FormName() != "avatar" {
+			part.Close()
+			continue
+		}
+		data, err = io.ReadAll(io.LimitReader(part, services.MaxAvatarBytes+1))
+		part.Close()
+		if err != nil {
+			http.Error(w, "Invalid multipart body or avatar too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		break
+	}
+	if data == nil {
+		http.Error(w, "Missing avatar file field", http.StatusBadRequest)
+		return
+	}
+	if len(data) > services.MaxAvatarBytes {
+		http.Error(w, "Avatar too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	//the declared part type is ignored; the content decides
+	if _, err := imaging.Sniff(data); err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	url, err := h.Avatars.SetAvatar(r.PathValue("id"), data)
+	if errors.Is(err, services.ErrInvalidInput) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, repository.ErrInvalidUserID) {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, repository.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error storing avatar", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", url)
+	json.NewEncoder(w).Encode(models.Profile{AvatarURL: url})
+}
+
+// ------------------ SERVE AVATAR ------------------
+// ?size= picks the closest rendition; requests carrying the current ?v=
+// hash from the profile URL can be cached for good, others revalidate by ETag
+func (h *Handler) ServeAvatar(w http.ResponseWriter, r *http.Request) {
+
+	size := 0
+	if v := r.URL.Query().Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid size", http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+	blob, current, err := h.Avatars.Avatar(r.PathValue("id"), size)
+	if errors.Is(err, repository.ErrInvalidUserID) {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, repository.ErrUserNotFound) || errors.Is(err, repository.ErrBlobNotFound) {
+		http.Error(w, "Avatar not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error fetching avatar", http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(blob.Data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Content-Type", blob.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if v := r.URL.Query().Get("v"); v != "" && strings.HasSuffix(current, "?v="+v) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=300, must-revalidate")
+	}
+	//ServeContent answers If-None-Match and If-Modified-Since with a 304
+	http.ServeContent(w, r, "", blob.ModTime, bytes.NewReader(blob.Data))
+}
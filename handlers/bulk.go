@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ------------------ BULK OPERATIONS ------------------
+func (h *Handler) BulkUsers(w http.ResponseWriter, r *http.Request) {
+
+	var req models.BulkRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2<<20)).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Async {
+		job, err := h.Bulk.Start(req)
+		if errors.Is(err, services.ErrInvalidInput) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error starting bulk job", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/api/users/bulk/"+job.ID)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+		return
+	}
+
+	result, err := h.Bulk.Run(req)
+	if errors.Is(err, services.ErrInvalidInput) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error running bulk operation", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// ------------------ BULK JOB STATUS ------------------
+func (h *Handler) BulkJob(w http.ResponseWriter, r *http.Request) {
+
+	job, err := h.Bulk.Job(r.PathValue("id"))
+	if errors.Is(err, repository.ErrJobNotFound) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error fetching job", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
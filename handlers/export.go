@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"Users/models"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	exportJSON   = "application/json"
+	exportNDJSON = "application/x-ndjson"
+	exportCSV    = "text/csv"
+)
+
+// exportedUser is what leaves the service in an export; password hashes and
+// canonical fields are never included
+type exportedUser struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Status    string    `json:"status"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	//profile and custom attributes are exported as stored
+	Profile    *models.Profile   `json:"profile,omitempty"`
+	Attributes models.Attributes `json:"attributes,omitempty"`
+}
+
+var exportColumns = map[string]func(*models.User) string{
+	"id":         func(u *models.User) string { return u.ID },
+	"username":   func(u *models.User) string { return u.Username },
+	"email":      func(u *models.User) string { return u.Email },
+	"status":     func(u *models.User) string { return u.Status },
+	"role":       func(u *models.User) string { return u.Role },
+	"created_at": func(u *models.User) string { return u.CreatedAt.UTC().Format(time.RFC3339) },
+	"display_name": func(u *models.User) string {
+		if u.Profile == nil {
+			return ""
+		}
+		return u.Profile.DisplayName
+	},
+}
+
+var defaultExportColumns = []string{"id", "username", "email", "status", "role", "created_at"}
+
+// ------------------ EXPORT USERS ------------------
+// streams users straight from the database cursor; the format comes from
+// ?format=json|ndjson|csv or the Accept header, CSV columns from ?columns=
+func (h *Handler) ExportUsers(w http.ResponseWriter, r *http.Request) {
+
+	format := exportFormat(r)
+	if format == "" {
+		http.Error(w, "Supported formats are application/json, application/x-ndjson and text/csv", http.StatusNotAcceptable)
+		return
+	}
+	columns := defaultExportColumns
+	if v := r.URL.Query().Get("columns"); v != "" {
+		columns = strings.Split(v, ",")
+		for _, c := range columns {
+			if _, ok := exportColumns[c]; !ok {
+				http.Error(w, "Unknown export column "+c, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	filter := models.UserFilter{
+		Status: r.URL.Query().Get("status"),
+		Role:   r.URL.Query().Get("role"),
+	}
+
+	w.Header().Set("Content-Type", format)
+	w.Header().Set("Content-Disposition", `attachment; filename="users`+exportExtension(format)+`"`)
+	buf := bufio.NewWriter(w)
+
+	var err error
+	switch format {
+	case exportCSV:
+		cw := csv.NewWriter(buf)
+		cw.Write(columns)
+		record := make([]string, len(columns))
+		err = h.Users.StreamUsers(r.Context(), filter, func(u *models.User) error {
+			for i, c := range columns {
+				record[i] = exportColumns[c](u)
+			}
+			return cw.Write(record)
+		})
+		cw.Flush()
+	case exportNDJSON:
+		enc := json.NewEncoder(buf)
+		err = h.Users.StreamUsers(r.Context(), filter, func(u *models.User) error {
+			return enc.Encode(toExported(u))
+		})
+	default:
+		enc := json.NewEncoder(buf)
+		buf.WriteString("[")
+		first := true
+		err = h.Users.StreamUsers(r.Context(), filter, func(u *models.User) error {
+			if !first {
+				buf.WriteString(",")
+			}
+			first = false
+			return enc.Encode(toExported(u))
+		})
+		buf.WriteString("]\n")
+	}
+	if err != nil {
+		//headers are already sent, so the truncated body is all we can do
+		h.Logger.Error("could not stream user export", slog.Any("error", err))
+		return
+	}
+	buf.Flush()
+}
+
+func toExported(u *models.User) exportedUser {
+	return exportedUser{
+		ID:         u.ID,
+		Username:   u.Username,
+		Email:      u.Email,
+		Status:     u.Status,
+		Role:       u.Role,
+		CreatedAt:  u.CreatedAt,
+		Profile:    u.Profile,
+		Attributes: u.Attributes,
+	}
+}
+
+func exportFormat(r *http.Request) string {
+	switch r.URL.Query().Get("format") {
+	case "json":
+		return exportJSON
+	case "ndjson":
+		return exportNDJSON
+	case "csv":
+		return exportCSV
+	case "":
+	default:
+		return ""
+	}
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return exportJSON
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case exportJSON, "*/*", "application/*":
+			return exportJSON
+		case exportNDJSON, "application/ndjson", "application/jsonl":
+			return exportNDJSON
+		case exportCSV, "text/*":
+			return exportCSV
+		}
+	}
+	return ""
+}
+
+func exportExtension(format string) string {
+	switch format {
+	case exportNDJSON:
+		return ".ndjson"
+	case exportCSV:
+		return ".csv"
+	}
+	return ".json"
+}
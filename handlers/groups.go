@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// writeGroupError maps group service errors to responses
+func writeGroupError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, repository.ErrGroupNotFound):
+		http.Error(w, "Group not found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrGroupExists):
+		http.Error(w, "Group already exists", http.StatusConflict)
+	case errors.Is(err, services.ErrGroupCycle):
+		http.Error(w, "Group would contain itself", http.StatusConflict)
+	case errors.Is(err, repository.ErrUserNotFound), errors.Is(err, repository.ErrInvalidUserID):
+		http.Error(w, "User not found", http.StatusNotFound)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
+
+// ------------------ GROUPS ------------------
+// GET lists the groups, POST creates one from {"name", "description"}
+func (h *Handler) GroupsEndpoint(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method == http.MethodPost {
+		var group models.Group
+		if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+			http.Error(w, "Error decoding request body", http.StatusBadRequest)
+			return
+		}
+		if err := h.Groups.CreateGroup(&group); err != nil {
+			writeGroupError(w, err, "Could not create group")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(group)
+		return
+	}
+	groups, err := h.Groups.ListGroups()
+	if err != nil {
+		writeGroupError(w, err, "Error fetching groups")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// ------------------ GROUP ------------------
+// GET returns a group, PUT renames it from {"name"}, DELETE removes it
+func (h *Handler) GroupEndpoint(w http.ResponseWriter, r *http.Request) {
+
+	id := r.PathValue("id")
+	var group *models.Group
+	var err error
+	switch r.Method {
+	case http.MethodDelete:
+		if err := h.Groups.DeleteGroup(id); err != nil {
+			writeGroupError(w, err, "Error deleting group")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.Message{Message: "Group deleted successfully"})
+		return
+	case http.MethodPut:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Error decoding request body", http.StatusBadRequest)
+			return
+		}
+		group, err = h.Groups.RenameGroup(id, req.Name)
+	default:
+		group, err = h.Groups.Group(id)
+	}
+	if err != nil {
+		writeGroupError(w, err, "Error fetching group")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+// ------------------ GROUP MEMBERSHIP ------------------
+// /api/groups/{id}/members/{member} adds (PUT) or removes (DELETE) a user,
+// /api/groups/{id}/groups/{member} does the same for a nested group
+func (h *Handler) GroupMembership(w http.ResponseWriter, r *http.Request) {
+
+	id, member := r.PathValue("id"), r.PathValue("member")
+	add := r.Method == http.MethodPut
+	var err error
+	switch r.PathValue("kind") {
+	case "members":
+		if add {
+			err = h.Groups.AddMember(id, member)
+		} else {
+			err = h.Groups.RemoveMember(id, member)
+		}
+	case "groups":
+		if add {
+			err = h.Groups.AddSubgroup(id, member)
+		} else {
+			err = h.Groups.RemoveSubgroup(id, member)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeGroupError(w, err, "Error updating group")
+		return
+	}
+	group, err := h.Groups.Group(id)
+	if err != nil {
+		writeGroupError(w, err, "Error fetching group")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+// ------------------ USER GROUPS ------------------
+// the groups a user belongs to, directly or through nested groups
+func (h *Handler) userGroups(w http.ResponseWriter, r *http.Request) {
+
+	id := r.PathValue("id")
+	if _, err := h.Users.FetchUserByID(id); err != nil {
+		writeGroupError(w, err, "Error fetching user")
+		return
+	}
+	groups, err := h.Groups.UserGroups(id)
+	if err != nil {
+		writeGroupError(w, err, "Error fetching groups")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
@@ -1,23 +1,41 @@
 package handlers
 
 import (
+	"Users/middleware"
 	"Users/models"
-	"Users/utils"
-	"context"
+	"Users/repository"
+	"Users/services"
+	"Users/validation"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"log/slog"
 	"net/http"
-	"net/mail"
-	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Handler struct {
-	Client *mongo.Client
+	Users       repository.UserRepository
+	Create      services.CreateInterface
+	Update      services.UpdateInterface
+	Auth        services.AuthInterface
+	Import      services.ImportInterface
+	Bulk        services.BulkInterface
+	Search      services.SearchInterface
+	Attributes  services.AttributeInterface
+	Avatars     services.AvatarInterface
+	Groups      services.GroupInterface
+	Invitations services.InvitationInterface
+	TwoFactor   services.TwoFactorInterface
+	Passkeys    services.PasskeyInterface
+	Identities  services.IdentityInterface
+	Lockout     services.LockoutInterface
+	APIKeys     services.APIKeyInterface
+	Sessions    services.SessionInterface
+	OAuth       services.OAuthInterface
+	Accounts    services.AccountInterface
+	Access      Access
+	Logger      *slog.Logger
 }
 
 func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
@@ -28,38 +46,24 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error decoding request body", http.StatusBadRequest)
 		return
 	}
-	if _, err := mail.ParseAddress(user.Email); err != nil {
-		http.Error(w, "Invalid Email", http.StatusBadRequest)
-		return
-	}
-	user.Password, err = utils.HashPassword(user.Password)
-	if err != nil {
-		http.Error(w, "failed to hash password", http.StatusInternalServerError)
+
+	// the service validates, canonicalizes and hashes; duplicates are
+	// rejected by the unique indexes on the canonical fields
+	err = h.Create.CreateUser(&user)
+	if errors.Is(err, services.ErrInvalidInput) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	user.CreatedAt = time.Now()
-	user.Status = "active"
-
-	collection := h.Client.Database("usersdb").Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// check if user exists
-	existing := collection.FindOne(ctx, bson.M{"email": user.Email})
-	if existing.Err() == nil {
+	if errors.Is(err, repository.ErrUserExists) {
 		http.Error(w, "User already exists", http.StatusConflict)
 		return
 	}
-
-	// insert user
-	result, err := collection.InsertOne(ctx, user)
 	if err != nil {
 		http.Error(w, "Could not create user", http.StatusInternalServerError)
 		return
 	}
 
-	fmt.Println(" User created with ID:", result.InsertedID)
+	h.Logger.Info("user created", slog.String("user", user.ID))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(models.Message{Message: "User created successfully"})
@@ -75,70 +79,40 @@ func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	objID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
+	if _, err := primitive.ObjectIDFromHex(userIDStr); err != nil {
 		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
 		return
 	}
 
 	var userUpdateData models.User
-	err = json.NewDecoder(r.Body).Decode(&userUpdateData)
+	err := json.NewDecoder(r.Body).Decode(&userUpdateData)
 	if err != nil {
 		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
 		return
 	}
+	userUpdateData.ID = userIDStr
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	collection := h.Client.Database("usersdb").Collection("users")
-	if userUpdateData.Email != "" {
-		filter := bson.M{"email": userUpdateData.Email,
-			"_id": bson.M{"$ne": objID},
-		}
-		count, err := collection.CountDocuments(ctx, filter)
-		if err != nil {
-			http.Error(w, "Error checking email uniqueness", http.StatusInternalServerError)
-			return
-		}
-		if count > 0 {
-			http.Error(w, "Invalid value for email", http.StatusBadRequest)
-			return
-		}
-
-	}
-	updateFields := bson.M{}
-	if userUpdateData.Email != "" {
-		updateFields["email"] = userUpdateData.Email
-
-	}
-	if userUpdateData.Username != "" {
-		updateFields["username"] = userUpdateData.Username
-	}
-	if userUpdateData.Password != "" {
-		pass_hash, err := utils.HashPassword(userUpdateData.Password)
-		if err != nil {
-			http.Error(w, "failed to hash password", http.StatusInternalServerError)
-			return
-		}
-		updateFields["password"] = pass_hash
+	err = h.Update.UpdateUser(&userUpdateData)
+	if errors.Is(err, services.ErrInvalidInput) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if len(updateFields) == 0 {
+	if errors.Is(err, repository.ErrNoFieldsToUpdate) {
 		http.Error(w, " No fields to update", http.StatusBadRequest)
 		return
 	}
-	filter := bson.M{"_id": objID}
-	update := bson.M{"$set": updateFields}
-
-	result, err := collection.UpdateOne(ctx, filter, update)
-	if err != nil {
-		http.Error(w, "Error updating user", http.StatusInternalServerError)
+	if errors.Is(err, repository.ErrUserExists) {
+		http.Error(w, "Email or username already in use", http.StatusConflict)
 		return
 	}
-
-	if result.MatchedCount == 0 {
+	if errors.Is(err, repository.ErrUserNotFound) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, "Error updating user", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(models.Message{Message: "User updated successfully"})
@@ -147,31 +121,11 @@ func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 // --------------FETCH ALL  USERS ------------------
 func (h *Handler) FetchAllUsers(w http.ResponseWriter, r *http.Request) {
 
-	//connecting to the mongo db collection
-	collection := h.Client.Database("usersdb").Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	results, err := collection.Find(ctx, bson.M{})
+	users, err := h.Users.FetchAllUsers()
 	if err != nil {
 		http.Error(w, "could not fetch users", http.StatusInternalServerError)
 		return
 	}
-	defer results.Close(ctx)
-	//decoding the json from
-	var users []models.User
-	for results.Next(ctx) {
-		var user models.User
-		if err = results.Decode(&user); err != nil {
-			http.Error(w, "error decoding user data", http.StatusInternalServerError)
-			return
-		}
-		users = append(users, user)
-	}
-	if err = results.Err(); err != nil {
-		http.Error(w, "error fectching users", http.StatusInternalServerError)
-		return
-	}
 	w.Header().Set("content-type", "application/json")
 	json.NewEncoder(w).Encode(users)
 
@@ -180,37 +134,18 @@ func (h *Handler) FetchAllUsers(w http.ResponseWriter, r *http.Request) {
 // -----------------FETCH ALL EMAILS-----------------
 func (h *Handler) FetchAllEmails(w http.ResponseWriter, r *http.Request) {
 
-	//getting the collection'
-	collection := h.Client.Database("usersdb").Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	projection := bson.M{
-		"email": 1, "_id": 0,
-	}
-	findOptions := options.Find().SetProjection(projection)
-	results, err := collection.Find(ctx, bson.M{}, findOptions)
-	if err != nil {
-		http.Error(w, "Error fetching emails", http.StatusInternalServerError)
-		return
-	}
-
 	type email struct {
-		Email string `bson:"email" json:"email"`
+		Email string `json:"email"`
 	}
-	//decoding the JSON from the DB
 	var emails []email
-	for results.Next(ctx) {
-		var email email
-		results.Decode(&email)
-		emails = append(emails, email)
-	}
-
-	if err = results.All(ctx, &emails); err != nil {
+	err := h.Users.StreamUsers(r.Context(), models.UserFilter{}, func(u *models.User) error {
+		emails = append(emails, email{Email: u.Email})
+		return nil
+	})
+	if err != nil {
 		http.Error(w, "Error fetching emails", http.StatusInternalServerError)
 		return
 	}
-	defer results.Close(ctx)
 	w.Header().Set("content-type", "application/json")
 	json.NewEncoder(w).Encode(emails)
 }
@@ -223,23 +158,8 @@ func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "user Id is missing", http.StatusBadRequest)
 		return
 	}
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		http.Error(w, "invalid user ID ", http.StatusBadRequest)
-		return
-	}
-	collection := h.Client.Database("usersdb").Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	filter := bson.M{"_id": userID}
-	result, err := collection.DeleteOne(ctx, filter)
-	if err != nil {
-		http.Error(w, "Error deleting user", http.StatusInternalServerError)
-		return
-	}
-	if result.DeletedCount == 0 {
-		http.Error(w, "user not found", http.StatusNotFound)
+	if err := h.Accounts.DeleteUser(userIDStr); err != nil {
+		writeDeleteError(w, err)
 		return
 	}
 	w.Header().Set("content-type", "application/json")
@@ -249,6 +169,17 @@ func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+func writeDeleteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrInvalidUserID):
+		http.Error(w, "invalid user ID ", http.StatusBadRequest)
+	case errors.Is(err, repository.ErrUserNotFound):
+		http.Error(w, "user not found", http.StatusNotFound)
+	default:
+		http.Error(w, "Error deleting user", http.StatusInternalServerError)
+	}
+}
+
 // ------UPDATE STATUS---------------------
 func (h *Handler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 
@@ -258,39 +189,110 @@ func (h *Handler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	objID, err := primitive.ObjectIDFromHex(userIDStr)
+	var user models.User
+	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
-		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := validation.ValidateStatus(user.Status); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var user models.User
-	err = json.NewDecoder(r.Body).Decode(&user)
+	//suspending a user also signs them out everywhere; a failure fails the
+	//request, which can be repeated
+	err = h.Accounts.SetStatus(userIDStr, user.Status)
+	if errors.Is(err, repository.ErrInvalidUserID) {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, repository.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		http.Error(w, "Error updating user status ", http.StatusInternalServerError)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Message{Message: "User updated successfully"})
+}
+
+// ------------------ LOGIN ------------------
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 
-	filter := bson.M{"_id": objID}
-	update := bson.M{"$set": bson.M{
-		"status": user.Status,
-	}}
+	var req models.LoginRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	identifier := req.Email
+	if identifier == "" {
+		identifier = req.Username
+	}
 
-	collection := h.Client.Database("usersdb").Collection("users")
-	result, err := collection.UpdateOne(ctx, filter, update)
+	user, err := h.Auth.Authenticate(identifier, req.Password, middleware.ClientIPFrom(r.Context()))
+	if writeRetry(w, err) {
+		return
+	}
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, services.ErrAccountInactive) {
+		http.Error(w, "Account is not active", http.StatusForbidden)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Error updating user status ", http.StatusInternalServerError)
+		http.Error(w, "Error logging in", http.StatusInternalServerError)
 		return
 	}
+	h.challengeOrLogin(w, r, user)
+}
 
-	if result.MatchedCount == 0 {
-		http.Error(w, "User not found", http.StatusNotFound)
+// challengeOrLogin asks for the second factor of users who enabled one, as
+// the first factor alone is not enough then, and logs the others in
+func (h *Handler) challengeOrLogin(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if h.TwoFactor.Required(user) {
+		token, err := h.TwoFactor.Challenge(user)
+		if err != nil {
+			http.Error(w, "Error logging in", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(models.SecondFactorChallenge{Message: "Second factor required", MFARequired: true, Token: token})
 		return
 	}
+	h.loginSucceeded(w, r, user)
+}
 
+// loginSucceeded answers every completed login, whichever way the user
+// authenticated, with a new session for the device
+func (h *Handler) loginSucceeded(w http.ResponseWriter, r *http.Request, user *models.User) {
+	session, token, err := h.startSession(r, user)
+	if err != nil {
+		http.Error(w, "Error creating session", http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models.Message{Message: "User updated successfully"})
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(models.LoginResponse{
+		Message:   "Login successful",
+		Token:     token,
+		SessionID: session.ID,
+		ExpiresAt: session.ExpiresAt,
+	})
+}
+
+// startSession forgives the user's failed logins and opens a session for
+// the requesting device
+func (h *Handler) startSession(r *http.Request, user *models.User) (*models.Session, string, error) {
+	if err := h.Lockout.RecordSuccess(user); err != nil {
+		h.Logger.Warn("could not clear failed logins", slog.String("user", user.ID), slog.Any("error", err))
+	}
+	return h.Sessions.CreateSession(middleware.TenantFrom(r.Context()), user, r.UserAgent(), middleware.ClientIPFrom(r.Context()))
 }
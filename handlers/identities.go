@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+func writeIdentityError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, repository.ErrUserNotFound), errors.Is(err, repository.ErrInvalidUserID):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrProviderNotFound):
+		http.Error(w, "Identity provider not found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrIdentityNotFound):
+		http.Error(w, "Identity not found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrIdentityExists):
+		http.Error(w, "Identity is already linked", http.StatusConflict)
+	case errors.Is(err, services.ErrLastIdentity):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, repository.ErrStateNotFound):
+		http.Error(w, "Sign in session is invalid or expired", http.StatusBadRequest)
+	case errors.Is(err, services.ErrFederationRejected):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrProviderUnavailable):
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+	default:
+		http.Error(w, "Error updating identities", http.StatusInternalServerError)
+	}
+}
+
+// ------------------ FEDERATED LOGIN ------------------
+// GET /api/login/providers lists the providers of the tenant;
+// POST /api/login/federated/{provider} returns where to send the user, and
+// POST /api/login/federated finishes with the {"state", "code"} or
+// {"state", "error"} the provider redirected back with
+func (h *Handler) LoginProviders(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Identities.Providers())
+}
+
+func (h *Handler) BeginFederatedLogin(w http.ResponseWriter, r *http.Request) {
+
+	start, err := h.Identities.Begin(r.PathValue("provider"), "")
+	if err != nil {
+		writeIdentityError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(start)
+}
+
+func (h *Handler) LoginFederated(w http.ResponseWriter, r *http.Request) {
+
+	var req models.FederatedCallback
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	user, err := h.Identities.FinishLogin(req)
+	if writeRetry(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, repository.ErrStateNotFound):
+		http.Error(w, "Sign in session is invalid or expired", http.StatusUnauthorized)
+		return
+	case errors.Is(err, services.ErrFederationRejected):
+		http.Error(w, "Sign in was rejected by the identity provider", http.StatusUnauthorized)
+		return
+	case errors.Is(err, services.ErrProviderNotFound):
+		http.Error(w, "Identity provider not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrProviderUnavailable):
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return
+	case errors.Is(err, services.ErrIdentityNotLinked):
+		http.Error(w, "No account is linked to this identity", http.StatusForbidden)
+		return
+	case errors.Is(err, services.ErrAccountExists), errors.Is(err, repository.ErrIdentityExists):
+		http.Error(w, "An account with this email already exists, sign in and link the provider instead", http.StatusConflict)
+		return
+	case errors.Is(err, services.ErrAccountInactive):
+		http.Error(w, "Account is not active", http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, "Error logging in", http.StatusInternalServerError)
+		return
+	}
+	h.challengeOrLogin(w, r, user)
+}
+
+// ------------------ LINKED IDENTITIES ------------------
+// POST /api/users/{id}/identities/{provider} starts linking the provider,
+// and POST /api/users/{id}/identities finishes like a federated login;
+// DELETE /api/users/{id}/identities/{provider} unlinks it
+func (h *Handler) UserIdentity(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case http.MethodPost:
+		RequireSelf((*Handler).beginLink)(h, w, r)
+	case http.MethodDelete:
+		RequireSelfOrAdmin((*Handler).unlinkIdentity)(h, w, r)
+	default:
+		http.Error(w, "Invalid Method", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) beginLink(w http.ResponseWriter, r *http.Request) {
+
+	start, err := h.Identities.Begin(r.PathValue("provider"), r.PathValue("id"))
+	if err != nil {
+		writeIdentityError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(start)
+}
+
+func (h *Handler) finishLink(w http.ResponseWriter, r *http.Request) {
+
+	var req models.FederatedCallback
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	identity, err := h.Identities.FinishLink(r.PathValue("id"), req)
+	if err != nil {
+		writeIdentityError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(identity)
+}
+
+func (h *Handler) listIdentities(w http.ResponseWriter, r *http.Request) {
+
+	identities, err := h.Identities.ListIdentities(r.PathValue("id"))
+	if err != nil {
+		writeIdentityError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identities)
+}
+
+func (h *Handler) unlinkIdentity(w http.ResponseWriter, r *http.Request) {
+
+	if err := h.Identities.Unlink(r.PathValue("id"), r.PathValue("provider")); err != nil {
+		writeIdentityError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Message{Message: "Identity unlinked"})
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"Users/services"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+)
+
+const maxImportBody = 20 << 20
+
+// ------------------ IMPORT USERS ------------------
+// accepts text/csv or application/x-ndjson; ?on_conflict=skip|update and
+// ?dry_run=true control how rows are written
+func (h *Handler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "text/csv":
+			format = services.ImportFormatCSV
+		case "application/x-ndjson", "application/ndjson", "application/jsonl":
+			format = services.ImportFormatNDJSON
+		default:
+			http.Error(w, "Content-Type must be text/csv or application/x-ndjson", http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+
+	var opts services.ImportOptions
+	switch r.URL.Query().Get("on_conflict") {
+	case "", "skip":
+	case "update":
+		opts.UpdateExisting = true
+	default:
+		http.Error(w, "on_conflict must be skip or update", http.StatusBadRequest)
+		return
+	}
+	opts.DryRun = r.URL.Query().Get("dry_run") == "true"
+
+	rows, err := services.ParseImport(http.MaxBytesReader(w, r.Body, maxImportBody), format)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Import file too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(rows) == 0 {
+		http.Error(w, "Import file has no rows", http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.Import.ImportUsers(rows, opts)
+	if errors.Is(err, services.ErrInvalidInput) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error importing users", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"Users/middleware"
+	"Users/models"
+	"Users/repository"
+	"Users/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ------------------ CREATE INVITATION ------------------
+// body is {"email", "role", "groups"}; the response carries the signed link,
+// which is only shown once
+func (h *Handler) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+
+	var inv models.Invitation
+	if err := json.NewDecoder(r.Body).Decode(&inv); err != nil {
+		http.Error(w, "Error decoding request body", http.StatusBadRequest)
+		return
+	}
+	inv.InvitedBy = middleware.UserFrom(r.Context())
+	link, err := h.Invitations.Invite(&inv)
+	if errors.Is(err, services.ErrInvalidInput) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, repository.ErrUserExists) {
+		http.Error(w, "User already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not create invitation", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(link)
+}
+
+// ------------------ ACCEPT INVITATION ------------------
+// body is {"username", "password", "profile"}; the email is the invited one
+func (h *Handler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+
+	var user models.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		http.Error(w, "Error decoding request body", http.StatusBadRequest)
+		return
+	}
+	created, err := h.Invitations.Accept(r.PathValue("token"), &user)
+	switch {
+	case errors.Is(err, services.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrInvitationInvalid), errors.Is(err, repository.ErrInvitationNotFound):
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrInvitationExpired):
+		http.Error(w, "Invitation has expired", http.StatusGone)
+		return
+	case errors.Is(err, repository.ErrInvitationUsed), errors.Is(err, repository.ErrUserExists):
+		http.Error(w, "Invitation has already been accepted", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Could not accept invitation", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
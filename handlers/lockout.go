@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"Users/services"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// writeRetry answers attempts refused by the lockout, telling the client
+// when to try again, and reports whether err was such a refusal
+func writeRetry(w http.ResponseWriter, err error) bool {
+	var retry *services.RetryError
+	if !errors.As(err, &retry) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.RetryAfter.Seconds()))))
+	if errors.Is(err, services.ErrAccountLocked) {
+		http.Error(w, "Account is temporarily locked", http.StatusLocked)
+		return true
+	}
+	http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+	return true
+}
@@ -0,0 +1,533 @@
+package handlers
+
+import (
+	"Users/middleware"
+	"Users/models"
+	"Users/repository"
+	"Users/services"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sessionCookie keeps the browser signed in across authorization requests;
+// it holds the bearer token of a session created at login
+const sessionCookie = "users_session"
+
+// writeOAuthError answers the token, introspection and revocation endpoints
+// with the JSON error body of RFC 6749 section 5.2
+func writeOAuthError(w http.ResponseWriter, err error) {
+	var oauthErr *services.OAuthError
+	if !errors.As(err, &oauthErr) {
+		oauthErr = &services.OAuthError{Code: "server_error"}
+	}
+	status := http.StatusBadRequest
+	if oauthErr.Code == "invalid_client" {
+		status = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	if oauthErr.Code == "server_error" {
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(oauthErr)
+}
+
+// clientCredentials reads the client ID and secret from HTTP Basic
+// authentication, or else from the form (RFC 6749 section 2.3.1)
+func clientCredentials(r *http.Request) (string, string) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		//both parts are form encoded before being joined
+		if unescaped, err := url.QueryUnescape(id); err == nil {
+			id = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(secret); err == nil {
+			secret = unescaped
+		}
+		return id, secret
+	}
+	return r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+}
+
+// authenticateClient resolves the calling client and writes the error
+// response when it fails
+func (h *Handler) authenticateClient(w http.ResponseWriter, r *http.Request) (*models.OAuthClient, bool) {
+	client, err := h.OAuth.AuthenticateClient(clientCredentials(r))
+	if err != nil {
+		writeOAuthError(w, err)
+		return nil, false
+	}
+	return client, true
+}
+
+func authorizationRequest(form url.Values) models.AuthorizationRequest {
+	return models.AuthorizationRequest{
+		ResponseType:        form.Get("response_type"),
+		ClientID:            form.Get("client_id"),
+		RedirectURI:         form.Get("redirect_uri"),
+		Scope:               form.Get("scope"),
+		State:               form.Get("state"),
+		CodeChallenge:       form.Get("code_challenge"),
+		CodeChallengeMethod: form.Get("code_challenge_method"),
+		Nonce:               form.Get("nonce"),
+		Prompt:              form.Get("prompt"),
+		MaxAge:              form.Get("max_age"),
+	}
+}
+
+// redirectToClient answers an authorization request on the client's
+// redirect URI with params and the request's state
+func redirectToClient(w http.ResponseWriter, r *http.Request, redirectURI string, state string, params url.Values) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		renderOAuthPage(w, "error", http.StatusBadRequest, oauthPage{Error: "The redirect URI is invalid."})
+		return
+	}
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	target.RawQuery = query.Encode()
+	status := http.StatusFound
+	if r.Method == http.MethodPost {
+		status = http.StatusSeeOther
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target.String(), status)
+}
+
+// consentCSRF ties the consent form to the browser's session, which a
+// cross-site page cannot read
+func consentCSRF(sessionToken string) string {
+	sum := sha256.Sum256([]byte("consent:" + sessionToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// browserLogin is the user signed in on this browser, with the session
+// their cookie holds the token of
+type browserLogin struct {
+	user    *models.User
+	session *models.Session
+	token   string
+}
+
+// browserSession returns the login of this browser for the request's
+// tenant, or nil
+func (h *Handler) browserSession(r *http.Request) *browserLogin {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	session, err := h.Sessions.AuthenticateSession(cookie.Value)
+	if err != nil || session.TenantID != middleware.TenantFrom(r.Context()) {
+		return nil
+	}
+	user, err := h.Users.FetchUserByID(session.UserID)
+	if err != nil || user.Status != models.StatusActive {
+		return nil
+	}
+	return &browserLogin{user: user, session: session, token: cookie.Value}
+}
+
+// loginTooOld tells whether the request demands a more recent login than
+// the browser's, through prompt=login or max_age
+func loginTooOld(req models.AuthorizationRequest, login *browserLogin) bool {
+	if slices.Contains(strings.Fields(req.Prompt), "login") {
+		return true
+	}
+	if req.MaxAge == "" {
+		return false
+	}
+	maxAge, _ := strconv.Atoi(req.MaxAge)
+	return time.Since(login.session.CreatedAt) > time.Duration(maxAge)*time.Second
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string, session *models.Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/oauth/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ------------------ OAUTH AUTHORIZE ------------------
+// GET starts an authorization code request; the login, second factor and
+// consent forms POST back here until the user is sent to the client's
+// redirect URI with a code or an error
+func (h *Handler) Authorize(w http.ResponseWriter, r *http.Request) {
+
+	if err := r.ParseForm(); err != nil {
+		renderOAuthPage(w, "error", http.StatusBadRequest, oauthPage{Error: "The request is malformed."})
+		return
+	}
+	req := authorizationRequest(r.Form)
+	client, redirectURI, scopes, err := h.OAuth.ValidateAuthorization(req)
+	var oauthErr *services.OAuthError
+	if err != nil && (redirectURI == "" || !errors.As(err, &oauthErr)) {
+		//without a trusted redirect URI the user is told instead
+		message := "The application sent an invalid request."
+		if errors.As(err, &oauthErr) && oauthErr.Description != "" {
+			message = "The application sent an invalid request: " + oauthErr.Description + "."
+		}
+		renderOAuthPage(w, "error", http.StatusBadRequest, oauthPage{Error: message})
+		return
+	}
+	if err != nil {
+		redirectToClient(w, r, redirectURI, req.State, url.Values{"error": {oauthErr.Code}, "error_description": {oauthErr.Description}})
+		return
+	}
+	page := oauthPage{Client: client.Name, Request: req, Scopes: scopes}
+	prompts := strings.Fields(req.Prompt)
+	//the forms that follow carry the prompt on, only the request that
+	//arrives from the client may demand a new login or consent
+	action := ""
+	if r.Method == http.MethodPost {
+		action = r.PostFormValue("action")
+	}
+
+	login := h.browserSession(r)
+	if action == "" && login != nil && loginTooOld(req, login) {
+		login = nil
+	}
+	if slices.Contains(prompts, "none") && login == nil {
+		redirectToClient(w, r, redirectURI, req.State, url.Values{"error": {"login_required"}})
+		return
+	}
+	if r.Method == http.MethodPost {
+		written := false
+		switch action {
+		case "login":
+			login, written = h.authorizeLogin(w, r, page)
+		case "second_factor":
+			login, written = h.authorizeSecondFactor(w, r, page)
+		case "consent":
+			if login == nil {
+				break
+			}
+			if subtle.ConstantTimeCompare([]byte(r.PostFormValue("csrf")), []byte(consentCSRF(login.token))) != 1 {
+				renderOAuthPage(w, "error", http.StatusForbidden, oauthPage{Error: "The form has expired, please start again."})
+				return
+			}
+			if r.PostFormValue("decision") != "allow" {
+				redirectToClient(w, r, redirectURI, req.State, url.Values{"error": {"access_denied"}, "error_description": {"the user denied the request"}})
+				return
+			}
+			if err := h.OAuth.GrantConsent(client.TenantID, login.user.ID, client.ID, scopes); err != nil {
+				renderOAuthPage(w, "error", http.StatusInternalServerError, oauthPage{Error: "Something went wrong, please try again."})
+				return
+			}
+		}
+		if written {
+			return
+		}
+	}
+	if login == nil {
+		renderOAuthPage(w, "login", http.StatusOK, page)
+		return
+	}
+
+	consented, err := h.OAuth.HasConsent(client.TenantID, login.user.ID, client.ID, scopes)
+	if err != nil {
+		renderOAuthPage(w, "error", http.StatusInternalServerError, oauthPage{Error: "Something went wrong, please try again."})
+		return
+	}
+	if action != "consent" && slices.Contains(prompts, "consent") {
+		consented = false
+	}
+	if !consented && slices.Contains(prompts, "none") {
+		redirectToClient(w, r, redirectURI, req.State, url.Values{"error": {"consent_required"}})
+		return
+	}
+	if !consented {
+		page.CSRF = consentCSRF(login.token)
+		renderOAuthPage(w, "consent", http.StatusOK, page)
+		return
+	}
+	code, err := h.OAuth.IssueCode(client, login.user.ID, login.session.CreatedAt, req, scopes)
+	if err != nil {
+		renderOAuthPage(w, "error", http.StatusInternalServerError, oauthPage{Error: "Something went wrong, please try again."})
+		return
+	}
+	redirectToClient(w, r, redirectURI, req.State, url.Values{"code": {code}})
+}
+
+// authorizeLogin checks the login form. It returns the user with their new
+// browser session, or reports that it wrote the next page.
+func (h *Handler) authorizeLogin(w http.ResponseWriter, r *http.Request, page oauthPage) (*browserLogin, bool) {
+	user, err := h.Auth.Authenticate(r.PostFormValue("identifier"), r.PostFormValue("password"), middleware.ClientIPFrom(r.Context()))
+	if err == nil && h.TwoFactor.Required(user) {
+		if page.Challenge, err = h.TwoFactor.Challenge(user); err == nil {
+			renderOAuthPage(w, "second_factor", http.StatusOK, page)
+			return nil, true
+		}
+	}
+	return h.authorizeSession(w, r, page, user, err)
+}
+
+func (h *Handler) authorizeSecondFactor(w http.ResponseWriter, r *http.Request, page oauthPage) (*browserLogin, bool) {
+	req := models.SecondFactorRequest{Token: r.PostFormValue("mfa_token")}
+	//codes from the app are digits only, recovery codes are not
+	code := strings.TrimSpace(r.PostFormValue("code"))
+	if strings.Trim(code, "0123456789") == "" {
+		req.Code = code
+	} else {
+		req.RecoveryCode = code
+	}
+	user, err := h.TwoFactor.CompleteLogin(req, middleware.ClientIPFrom(r.Context()))
+	if errors.Is(err, services.ErrInvalidCode) {
+		page.Challenge = req.Token
+		page.Error = "That code is not valid."
+		renderOAuthPage(w, "second_factor", http.StatusUnauthorized, page)
+		return nil, true
+	}
+	return h.authorizeSession(w, r, page, user, err)
+}
+
+// authorizeSession finishes a login step: on failure it shows the login
+// page again with the reason, on success it signs the browser in
+func (h *Handler) authorizeSession(w http.ResponseWriter, r *http.Request, page oauthPage, user *models.User, err error) (*browserLogin, bool) {
+	var retryErr *services.RetryError
+	status := http.StatusUnauthorized
+	switch {
+	case errors.As(err, &retryErr):
+		status = http.StatusTooManyRequests
+		page.Error = fmt.Sprintf("Too many failed attempts, try again in %v.", retryErr.RetryAfter.Round(time.Second))
+	case errors.Is(err, services.ErrInvalidCredentials):
+		page.Error = "Invalid email, username or password."
+	case errors.Is(err, services.ErrChallengeInvalid):
+		page.Error = "The sign in took too long, please start again."
+	case errors.Is(err, services.ErrAccountInactive):
+		status = http.StatusForbidden
+		page.Error = "This account is not active."
+	case err != nil:
+		status = http.StatusInternalServerError
+		page.Error = "Something went wrong, please try again."
+	}
+	if err == nil {
+		session, token, err := h.startSession(r, user)
+		if err == nil {
+			setSessionCookie(w, r, token, session)
+			return &browserLogin{user: user, session: session, token: token}, false
+		}
+		status = http.StatusInternalServerError
+		page.Error = "Something went wrong, please try again."
+	}
+	renderOAuthPage(w, "login", status, page)
+	return nil, true
+}
+
+// ------------------ OAUTH TOKEN ------------------
+// exchanges an authorization code, or the client's own credentials, for an
+// access token
+func (h *Handler) Token(w http.ResponseWriter, r *http.Request) {
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, &services.OAuthError{Code: "invalid_request", Description: "the body must be form encoded"})
+		return
+	}
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+	var token *models.TokenResponse
+	var err error
+	switch r.PostFormValue("grant_type") {
+	case models.GrantAuthorizationCode:
+		var grant *models.AuthorizationCode
+		token, grant, err = h.OAuth.ExchangeCode(client, r.PostFormValue("code"), r.PostFormValue("redirect_uri"), r.PostFormValue("code_verifier"))
+		if err == nil && slices.Contains(grant.Scopes, models.ScopeOpenID) {
+			token.IDToken, err = h.idToken(client, grant, token.AccessToken)
+		}
+	case models.GrantClientCredentials:
+		token, err = h.OAuth.ClientCredentials(client, r.PostFormValue("scope"))
+	default:
+		err = &services.OAuthError{Code: "unsupported_grant_type"}
+	}
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	json.NewEncoder(w).Encode(token)
+}
+
+// idToken signs the ID token of an OpenID Connect code grant. The access
+// token issued with it is withdrawn when the user is gone or no longer
+// active.
+func (h *Handler) idToken(client *models.OAuthClient, grant *models.AuthorizationCode, accessToken string) (string, error) {
+	user, err := h.Users.FetchUserByID(grant.UserID)
+	if err == nil && user.Status != models.StatusActive {
+		err = &services.OAuthError{Code: "invalid_grant", Description: "the user is not active"}
+	}
+	if errors.Is(err, repository.ErrUserNotFound) || errors.Is(err, repository.ErrInvalidUserID) {
+		err = &services.OAuthError{Code: "invalid_grant", Description: "the user no longer exists"}
+	}
+	if err != nil {
+		h.OAuth.Revoke(client, accessToken)
+		return "", err
+	}
+	return h.OAuth.IDToken(client, user, grant, accessToken)
+}
+
+// ------------------ OAUTH INTROSPECT ------------------
+// tells a resource server whether a token is active (RFC 7662); tokens of
+// users who were since suspended, locked or deleted are not
+func (h *Handler) Introspect(w http.ResponseWriter, r *http.Request) {
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, &services.OAuthError{Code: "invalid_request", Description: "the body must be form encoded"})
+		return
+	}
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+	info, token, err := h.OAuth.Introspect(client, r.PostFormValue("token"))
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+	if token != nil && token.UserID != "" {
+		user, err := h.Users.FetchUserByID(token.UserID)
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound), errors.Is(err, repository.ErrInvalidUserID):
+			info = &models.Introspection{Active: false}
+		case err != nil:
+			writeOAuthError(w, err)
+			return
+		case user.Status != models.StatusActive:
+			info = &models.Introspection{Active: false}
+		default:
+			info.Username = user.Username
+			if info.Username == "" {
+				info.Username = user.Email
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(info)
+}
+
+// ------------------ OAUTH REVOKE ------------------
+// revokes a token the calling client holds (RFC 7009)
+func (h *Handler) Revoke(w http.ResponseWriter, r *http.Request) {
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, &services.OAuthError{Code: "invalid_request", Description: "the body must be form encoded"})
+		return
+	}
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+	if err := h.OAuth.Revoke(client, r.PostFormValue("token")); err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ------------------ OAUTH CLIENTS ------------------
+// GET lists the tenant's OAuth clients; POST registers one and returns it
+// with its secret, which is shown only once
+func (h *Handler) OAuthClients(w http.ResponseWriter, r *http.Request) {
+
+	tenantID := middleware.TenantFrom(r.Context())
+	if r.Method == http.MethodGet {
+		clients, err := h.OAuth.ListClients(tenantID)
+		if err != nil {
+			http.Error(w, "Error fetching OAuth clients", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(clients)
+		return
+	}
+
+	var req models.OAuthClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	client, err := h.OAuth.RegisterClient(tenantID, req, middleware.UserFrom(r.Context()))
+	if errors.Is(err, services.ErrInvalidInput) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error registering OAuth client", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(client)
+}
+
+// ------------------ DELETE OAUTH CLIENT ------------------
+// also revokes every token and consent the client holds
+func (h *Handler) DeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+
+	err := h.OAuth.DeleteClient(middleware.TenantFrom(r.Context()), r.PathValue("id"))
+	if errors.Is(err, repository.ErrOAuthClientNotFound) {
+		http.Error(w, "OAuth client not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error deleting OAuth client", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Message{Message: "OAuth client deleted"})
+}
+
+// ------------------ LIST CONSENTS ------------------
+// reached through UserResource
+func (h *Handler) listConsents(w http.ResponseWriter, r *http.Request) {
+
+	consents, err := h.OAuth.ListConsents(middleware.TenantFrom(r.Context()), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Error fetching consents", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(consents)
+}
+
+// ------------------ REVOKE CONSENT ------------------
+// also revokes the tokens the client holds for the user
+func (h *Handler) RevokeConsent(w http.ResponseWriter, r *http.Request) {
+
+	err := h.OAuth.RevokeConsent(middleware.TenantFrom(r.Context()), r.PathValue("id"), r.PathValue("client"))
+	if errors.Is(err, repository.ErrConsentNotFound) {
+		http.Error(w, "Consent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error revoking consent", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Message{Message: "Consent revoked"})
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"Users/models"
+	"html/template"
+	"net/http"
+)
+
+// oauthPage is what the login, second factor, consent and error pages of
+// the authorization endpoint render from
+type oauthPage struct {
+	Client    string
+	Request   models.AuthorizationRequest
+	Scopes    []string
+	Error     string
+	Challenge string
+	CSRF      string
+}
+
+// the pages carry the authorization request along in hidden fields, so every
+// step posts back to the authorization endpoint with it
+var oauthPages = template.Must(template.New("oauth").Parse(`
+{{define "top"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+<style>
+body{font-family:system-ui,sans-serif;background:#f4f5f7;margin:0}
+main{max-width:22rem;margin:4rem auto;background:#fff;padding:2rem;border-radius:8px;box-shadow:0 1px 4px rgba(0,0,0,.1)}
+h1{font-size:1.25rem;margin-top:0}
+label{display:block;margin:.75rem 0 .25rem}
+input[type=text],input[type=password]{width:100%;box-sizing:border-box;padding:.5rem}
+button{margin-top:1rem;padding:.5rem 1rem}
+.error{color:#b00020}
+</style>
+</head>
+<body><main>{{end}}
+
+{{define "bottom"}}</main></body>
+</html>{{end}}
+
+{{define "params"}}
+<input type="hidden" name="response_type" value="{{.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="state" value="{{.State}}">
+<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+<input type="hidden" name="nonce" value="{{.Nonce}}">
+<input type="hidden" name="prompt" value="{{.Prompt}}">
+<input type="hidden" name="max_age" value="{{.MaxAge}}">
+{{end}}
+
+{{define "login"}}{{template "top" "Sign in"}}
+<h1>Sign in to continue to {{.Client}}</h1>
+{{with .Error}}<p class="error">{{.}}</p>{{end}}
+<form method="post" action="/oauth/authorize">
+{{template "params" .Request}}
+<input type="hidden" name="action" value="login">
+<label for="identifier">Email or username</label>
+<input type="text" id="identifier" name="identifier" autocomplete="username" required autofocus>
+<label for="password">Password</label>
+<input type="password" id="password" name="password" autocomplete="current-password" required>
+<button type="submit">Sign in</button>
+</form>
+{{template "bottom"}}{{end}}
+
+{{define "second_factor"}}{{template "top" "Verify it's you"}}
+<h1>Enter the code from your authenticator app</h1>
+{{with .Error}}<p class="error">{{.}}</p>{{end}}
+<form method="post" action="/oauth/authorize">
+{{template "params" .Request}}
+<input type="hidden" name="action" value="second_factor">
+<input type="hidden" name="mfa_token" value="{{.Challenge}}">
+<label for="code">Code, or a recovery code</label>
+<input type="text" id="code" name="code" autocomplete="one-time-code" inputmode="numeric" required autofocus>
+<button type="submit">Verify</button>
+</form>
+{{template "bottom"}}{{end}}
+
+{{define "consent"}}{{template "top" "Allow access"}}
+<h1>{{.Client}} wants to access your account</h1>
+{{with .Scopes}}<p>It asks for:</p>
+<ul>{{range .}}<li>{{.}}</li>{{end}}</ul>{{end}}
+<form method="post" action="/oauth/authorize">
+{{template "params" .Request}}
+<input type="hidden" name="action" value="consent">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<button type="submit" name="decision" value="allow">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+{{template "bottom"}}{{end}}
+
+{{define "error"}}{{template "top" "Sign in failed"}}
+<h1>This sign in request can't be completed</h1>
+<p class="error">{{.Error}}</p>
+{{template "bottom"}}{{end}}
+`))
+
+// renderOAuthPage writes one of the authorization endpoint's pages. They
+// must not be cached or framed by other sites.
+func renderOAuthPage(w http.ResponseWriter, name string, status int, page oauthPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+	if err := oauthPages.ExecuteTemplate(w, name, page); err != nil {
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+	}
+}
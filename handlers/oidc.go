@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/services"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// bearerToken reads an access token from the Authorization header, or else
+// from a form encoded body (RFC 6750 section 2)
+func bearerToken(r *http.Request) string {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	if r.Method == http.MethodPost {
+		return r.PostFormValue("access_token")
+	}
+	return ""
+}
+
+// writeBearerError answers a resource request whose access token is missing,
+// invalid or short of scope (RFC 6750 section 3)
+func writeBearerError(w http.ResponseWriter, status int, oauthErr *services.OAuthError) {
+	challenge := fmt.Sprintf(`Bearer error=%q`, oauthErr.Code)
+	if oauthErr.Description != "" {
+		challenge += fmt.Sprintf(`, error_description=%q`, oauthErr.Description)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(oauthErr)
+}
+
+// ------------------ OPENID CONFIGURATION ------------------
+// serves the discovery document, the same for every tenant
+func Discovery(oauth services.OAuthInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		json.NewEncoder(w).Encode(oauth.Discovery())
+	})
+}
+
+// ------------------ JWKS ------------------
+// publishes the keys ID tokens are verified with; clients that meet an
+// unknown key ID fetch it again, so it is cached only briefly
+func JWKS(oauth services.OAuthInterface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys, err := oauth.JWKS()
+		if err != nil {
+			http.Error(w, "Error fetching signing keys", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(keys)
+	})
+}
+
+// ------------------ USERINFO ------------------
+// returns the claims about the token's user that its scopes release; the
+// token must carry the openid scope
+func (h *Handler) UserInfo(w http.ResponseWriter, r *http.Request) {
+
+	token, err := h.OAuth.AccessToken(bearerToken(r))
+	var oauthErr *services.OAuthError
+	if errors.As(err, &oauthErr) {
+		writeBearerError(w, http.StatusUnauthorized, oauthErr)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error checking credentials", http.StatusInternalServerError)
+		return
+	}
+	if token.UserID == "" || !slices.Contains(token.Scopes, models.ScopeOpenID) {
+		writeBearerError(w, http.StatusForbidden, &services.OAuthError{Code: "insufficient_scope", Description: "the token was not issued with the openid scope"})
+		return
+	}
+	user, err := h.Users.FetchUserByID(token.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) || errors.Is(err, repository.ErrInvalidUserID) || (err == nil && user.Status != models.StatusActive) {
+		writeBearerError(w, http.StatusUnauthorized, &services.OAuthError{Code: "invalid_token", Description: "the user is no longer active"})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error fetching user", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(h.OAuth.UserInfo(user, token.Scopes))
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// writePasskeyError maps passkey service errors to responses; login
+// failures are reported as 401 by the login handler itself
+func writePasskeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, repository.ErrUserNotFound), errors.Is(err, repository.ErrInvalidUserID):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrPasskeyNotFound):
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrPasskeyExists):
+		http.Error(w, "Passkey is already registered", http.StatusConflict)
+	case errors.Is(err, repository.ErrCeremonyNotFound):
+		http.Error(w, "Passkey session is invalid or expired", http.StatusBadRequest)
+	case errors.Is(err, services.ErrPasskeyRejected):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Error updating passkeys", http.StatusInternalServerError)
+	}
+}
+
+// ------------------ PASSKEY REGISTRATION ------------------
+// POST /api/users/{id}/passkey-options returns the options for
+// navigator.credentials.create; POST /api/users/{id}/passkeys finishes with
+// {"session", "name", "credential"}
+func (h *Handler) passkeyCreationOptions(w http.ResponseWriter, r *http.Request) {
+
+	options, err := h.Passkeys.BeginRegistration(r.PathValue("id"))
+	if err != nil {
+		writePasskeyError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(options)
+}
+
+func (h *Handler) registerPasskey(w http.ResponseWriter, r *http.Request) {
+
+	var req models.PasskeyRegistration
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	passkey, err := h.Passkeys.FinishRegistration(r.PathValue("id"), req)
+	if err != nil {
+		writePasskeyError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(passkey)
+}
+
+func (h *Handler) listPasskeys(w http.ResponseWriter, r *http.Request) {
+
+	passkeys, err := h.Passkeys.ListPasskeys(r.PathValue("id"))
+	if err != nil {
+		writePasskeyError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(passkeys)
+}
+
+// ------------------ DELETE PASSKEY ------------------
+func (h *Handler) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+
+	if err := h.Passkeys.DeletePasskey(r.PathValue("id"), r.PathValue("credential")); err != nil {
+		writePasskeyError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Message{Message: "Passkey deleted"})
+}
+
+// ------------------ PASSKEY LOGIN ------------------
+// POST /api/login/passkey-options takes an optional {"email"} or
+// {"username"} and returns the options for navigator.credentials.get;
+// POST /api/login/passkey finishes with {"session", "credential"}
+func (h *Handler) PasskeyRequestOptions(w http.ResponseWriter, r *http.Request) {
+
+	var req models.PasskeyLoginStart
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+	options, err := h.Passkeys.BeginLogin(req)
+	if err != nil {
+		http.Error(w, "Error logging in", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(options)
+}
+
+func (h *Handler) LoginPasskey(w http.ResponseWriter, r *http.Request) {
+
+	var req models.PasskeyLogin
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	user, err := h.Passkeys.FinishLogin(req)
+	if writeRetry(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, repository.ErrCeremonyNotFound):
+		http.Error(w, "Passkey session is invalid or expired", http.StatusUnauthorized)
+		return
+	case errors.Is(err, services.ErrPasskeyRejected):
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	case errors.Is(err, services.ErrAccountInactive):
+		http.Error(w, "Account is not active", http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, "Error logging in", http.StatusInternalServerError)
+		return
+	}
+	//a user-verified passkey already proves two factors
+	h.loginSucceeded(w, r, user)
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"Users/patch"
+	"Users/repository"
+	"Users/services"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+)
+
+const maxPatchBytes = 64 << 10
+
+// ------------------ PATCH USER ------------------
+// accepts application/merge-patch+json (RFC 7396) and
+// application/json-patch+json (RFC 6902) and responds with the patched user
+func (h *Handler) PatchUser(w http.ResponseWriter, r *http.Request) {
+
+	userIDStr := r.PathValue("id")
+	if userIDStr == "" {
+		http.Error(w, "Missing user ID ", http.StatusBadRequest)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != patch.MediaTypeMergePatch && mediaType != patch.MediaTypeJSONPatch {
+		w.Header().Set("Accept-Patch", patch.MediaTypeMergePatch+", "+patch.MediaTypeJSONPatch)
+		http.Error(w, "Unsupported patch format", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchBytes))
+	if err != nil {
+		http.Error(w, "Patch body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	user, err := h.Update.PatchUser(userIDStr, mediaType, body)
+	if errors.Is(err, services.ErrInvalidInput) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, patch.ErrTestFailed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, repository.ErrInvalidUserID) {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, repository.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, repository.ErrUserExists) {
+		http.Error(w, "Email or username already in use", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error patching user", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
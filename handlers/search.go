@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"Users/models"
+	"Users/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// ------------------ SEARCH USERS ------------------
+func (h *Handler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	hits, err := h.Search.SearchUsers(r.URL.Query().Get("q"), limit)
+	if errors.Is(err, services.ErrInvalidInput) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error searching users", http.StatusInternalServerError)
+		return
+	}
+	if hits == nil {
+		hits = []models.SearchHit{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hits)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"Users/middleware"
+	"Users/models"
+	"Users/repository"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ------------------ LIST SESSIONS ------------------
+// reached through UserResource; the caller's own session is marked current
+func (h *Handler) listSessions(w http.ResponseWriter, r *http.Request) {
+
+	sessions, err := h.Sessions.ListSessions(middleware.TenantFrom(r.Context()), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Error fetching sessions", http.StatusInternalServerError)
+		return
+	}
+	current := middleware.SessionFrom(r.Context())
+	for i := range sessions {
+		sessions[i].Current = current != "" && sessions[i].ID == current
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// ------------------ REVOKE ALL SESSIONS ------------------
+// signs the user out on every device, including the caller's own session
+func (h *Handler) revokeSessions(w http.ResponseWriter, r *http.Request) {
+
+	if err := h.Sessions.RevokeUserSessions(middleware.TenantFrom(r.Context()), r.PathValue("id")); err != nil {
+		http.Error(w, "Error revoking sessions", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Message{Message: "Sessions revoked"})
+}
+
+// ------------------ REVOKE SESSION ------------------
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+
+	err := h.Sessions.RevokeSession(middleware.TenantFrom(r.Context()), r.PathValue("id"), r.PathValue("session"))
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error revoking session", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Message{Message: "Session revoked"})
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"Users/middleware"
+	"Users/models"
+	"Users/repository"
+	"Users/services"
+	"Users/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// TenantHandlers routes each request to the Handler bound to its tenant.
+// Handlers only ever see their own tenant's repositories, so a query cannot
+// reach another tenant's users whatever its filter.
+type TenantHandlers struct {
+	Tenants services.TenantInterface
+	//Build wires the repositories and services of one tenant
+	Build func(tenantID string) (*Handler, error)
+
+	mu       sync.Mutex
+	handlers map[string]*Handler
+}
+
+// Scoped adapts a Handler method, e.g. (*Handler).CreateUser, to run against
+// the handler of the request's tenant.
+func (t *TenantHandlers) Scoped(fn Endpoint) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID := middleware.TenantFrom(r.Context())
+		if tenantID == "" {
+			http.Error(w, "Missing tenant", http.StatusBadRequest)
+			return
+		}
+		tenant, err := t.Tenants.Tenant(tenantID)
+		if errors.Is(err, repository.ErrTenantNotFound) {
+			http.Error(w, "Unknown tenant", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error fetching tenant", http.StatusInternalServerError)
+			return
+		}
+		if tenant.Status != models.StatusActive {
+			http.Error(w, "Tenant is suspended", http.StatusForbidden)
+			return
+		}
+		h, err := t.handler(tenantID)
+		if err != nil {
+			http.Error(w, "Error preparing tenant", http.StatusInternalServerError)
+			return
+		}
+		fn(h, w, r)
+	})
+}
+
+// Operator guards the tenant registry: only admins of the default tenant
+// may list or create tenants.
+func (t *TenantHandlers) Operator(next http.HandlerFunc) http.Handler {
+	return t.Scoped(func(h *Handler, w http.ResponseWriter, r *http.Request) {
+		if middleware.TenantFrom(r.Context()) != models.DefaultTenant {
+			http.Error(w, "Not allowed", http.StatusForbidden)
+			return
+		}
+		if h.authorize(w, r, "", nil) {
+			next(w, r)
+		}
+	})
+}
+
+// FromPath runs fn for the tenant named by the {tenant} path value, for
+// links followed without credentials or headers, such as avatar images.
+func (t *TenantHandlers) FromPath(fn Endpoint) http.Handler {
+	scoped := t.Scoped(fn)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scoped.ServeHTTP(w, r.WithContext(middleware.WithTenant(r.Context(), r.PathValue("tenant"))))
+	})
+}
+
+// FromInvitation runs fn for the tenant an invitation link was issued for,
+// taken from the signed {token} rather than from the request, so invitees
+// need no tenant header.
+func (t *TenantHandlers) FromInvitation(signer *utils.Signer, fn Endpoint) http.Handler {
+	scoped := t.Scoped(fn)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID, err := services.InvitationTenant(signer, r.PathValue("token"))
+		if errors.Is(err, services.ErrInvitationExpired) {
+			http.Error(w, "Invitation has expired", http.StatusGone)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+			return
+		}
+		scoped.ServeHTTP(w, r.WithContext(middleware.WithTenant(r.Context(), tenantID)))
+	})
+}
+
+// FromOAuthClient runs fn for the tenant of the OAuth client named by the
+// request, through client_id or HTTP Basic authentication, so the apps
+// delegating login need no tenant header.
+func (t *TenantHandlers) FromOAuthClient(oauth services.OAuthInterface, fn Endpoint) http.Handler {
+	scoped := t.Scoped(fn)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, _ := clientCredentials(r)
+		if clientID == "" {
+			clientID = r.FormValue("client_id")
+		}
+		client, err := oauth.Client(clientID)
+		if err != nil {
+			writeOAuthError(w, err)
+			return
+		}
+		scoped.ServeHTTP(w, r.WithContext(middleware.WithTenant(r.Context(), client.TenantID)))
+	})
+}
+
+// FromAccessToken runs fn for the tenant an OAuth access token was issued
+// in, answering invalid tokens as RFC 6750 prescribes.
+func (t *TenantHandlers) FromAccessToken(oauth services.OAuthInterface, fn Endpoint) http.Handler {
+	scoped := t.Scoped(fn)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := oauth.AccessToken(bearerToken(r))
+		var oauthErr *services.OAuthError
+		if errors.As(err, &oauthErr) {
+			writeBearerError(w, http.StatusUnauthorized, oauthErr)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error checking credentials", http.StatusInternalServerError)
+			return
+		}
+		scoped.ServeHTTP(w, r.WithContext(middleware.WithTenant(r.Context(), token.TenantID)))
+	})
+}
+
+// handler returns the tenant's Handler, building it on first use. Building
+// happens under the lock so in-memory stores are only created once.
+func (t *TenantHandlers) handler(tenantID string) (*Handler, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if h, ok := t.handlers[tenantID]; ok {
+		return h, nil
+	}
+	h, err := t.Build(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if t.handlers == nil {
+		t.handlers = map[string]*Handler{}
+	}
+	t.handlers[tenantID] = h
+	return h, nil
+}
+
+// ------------------ TENANTS ------------------
+// POST registers a tenant and provisions its storage, GET lists tenants
+func (t *TenantHandlers) TenantsEndpoint(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method == http.MethodPost {
+		t.createTenant(w, r)
+		return
+	}
+	tenants, err := t.Tenants.ListTenants()
+	if err != nil {
+		http.Error(w, "Error fetching tenants", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tenants)
+}
+
+func (t *TenantHandlers) createTenant(w http.ResponseWriter, r *http.Request) {
+
+	var tenant models.Tenant
+	if err := json.NewDecoder(r.Body).Decode(&tenant); err != nil {
+		http.Error(w, "Error decoding request body", http.StatusBadRequest)
+		return
+	}
+	err := t.Tenants.CreateTenant(&tenant)
+	if errors.Is(err, services.ErrInvalidInput) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, repository.ErrTenantExists) {
+		http.Error(w, "Tenant already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not create tenant", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tenant)
+}
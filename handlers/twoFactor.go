@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"Users/middleware"
+	"Users/models"
+	"Users/repository"
+	"Users/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// writeTwoFactorError maps two-factor service errors to responses
+func writeTwoFactorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrTwoFactorUnavailable):
+		http.Error(w, "Two-factor authentication is not configured", http.StatusServiceUnavailable)
+	case errors.Is(err, repository.ErrUserNotFound), errors.Is(err, repository.ErrInvalidUserID):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, services.ErrTwoFactorEnabled):
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+	case errors.Is(err, services.ErrTwoFactorNotEnabled):
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+	case errors.Is(err, services.ErrNoPendingEnrollment):
+		http.Error(w, "No two-factor enrollment in progress", http.StatusConflict)
+	case errors.Is(err, services.ErrInvalidCode):
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+	default:
+		http.Error(w, "Error updating two-factor authentication", http.StatusInternalServerError)
+	}
+}
+
+// ------------------ TWO FACTOR ACTIONS ------------------
+// /api/users/{id}/2fa/enroll starts enrollment, /confirm enables the factor
+// with a first code and /recovery-codes replaces the recovery codes; the
+// last two take {"code"}
+func (h *Handler) TwoFactorAction(w http.ResponseWriter, r *http.Request) {
+
+	id := r.PathValue("id")
+	action := r.PathValue("action")
+	if action == "enroll" {
+		enrollment, err := h.TwoFactor.Enroll(id)
+		if err != nil {
+			writeTwoFactorError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(enrollment)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Error decoding request body", http.StatusBadRequest)
+		return
+	}
+	var codes []string
+	var err error
+	switch action {
+	case "confirm":
+		codes, err = h.TwoFactor.Confirm(id, req.Code)
+	case "recovery-codes":
+		codes, err = h.TwoFactor.RegenerateRecoveryCodes(id, req.Code)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(models.RecoveryCodes{Codes: codes})
+}
+
+func (h *Handler) twoFactorStatus(w http.ResponseWriter, r *http.Request) {
+
+	status, err := h.TwoFactor.Status(r.PathValue("id"))
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// admin reset for users locked out of their second factor
+func (h *Handler) resetTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	if err := h.TwoFactor.Reset(r.PathValue("id")); err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Message{Message: "Two-factor authentication reset"})
+}
+
+// ------------------ LOGIN SECOND FACTOR ------------------
+// completes a login answered with mfa_required, taking {"mfa_token"} and a
+// "code" or "recovery_code"
+func (h *Handler) LoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+
+	var req models.SecondFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	user, err := h.TwoFactor.CompleteLogin(req, middleware.ClientIPFrom(r.Context()))
+	if writeRetry(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrChallengeInvalid):
+		http.Error(w, "Login challenge is invalid or expired", http.StatusUnauthorized)
+		return
+	case errors.Is(err, services.ErrInvalidCode):
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	case errors.Is(err, services.ErrAccountInactive):
+		http.Error(w, "Account is not active", http.StatusForbidden)
+		return
+	case err != nil:
+		writeTwoFactorError(w, err)
+		return
+	}
+	h.loginSucceeded(w, r, user)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// ------------------ USER SUBRESOURCES ------------------
+// /api/users/{id}/{resource}; a literal /api/users/{id}/avatar pattern would
+// conflict with /api/users/bulk/{id} in the mux
+func (h *Handler) UserResource(w http.ResponseWriter, r *http.Request) {
+	//avatars are referenced from profiles and fetched without credentials,
+	//so only changing one needs authorization
+	switch r.PathValue("resource") {
+	case "avatar":
+		switch r.Method {
+		case http.MethodPut:
+			RequireSelfOrAdmin((*Handler).uploadAvatar)(h, w, r)
+		case http.MethodGet:
+			h.ServeAvatar(w, r)
+		default:
+			http.Error(w, "Invalid Method", http.StatusMethodNotAllowed)
+		}
+	case "groups":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Invalid Method", http.StatusMethodNotAllowed)
+			return
+		}
+		RequireSelfOrAdmin((*Handler).userGroups)(h, w, r)
+	case "2fa":
+		//the status is visible to admins, and resetting is theirs alone
+		if r.Method == http.MethodDelete {
+			RequireAdmin((*Handler).resetTwoFactor)(h, w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Invalid Method", http.StatusMethodNotAllowed)
+			return
+		}
+		RequireSelfOrAdmin((*Handler).twoFactorStatus)(h, w, r)
+	case "passkey-options":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid Method", http.StatusMethodNotAllowed)
+			return
+		}
+		RequireSelf((*Handler).passkeyCreationOptions)(h, w, r)
+	case "passkeys":
+		//only the user can register a passkey, but admins can review them
+		switch r.Method {
+		case http.MethodPost:
+			RequireSelf((*Handler).registerPasskey)(h, w, r)
+		case http.MethodGet:
+			RequireSelfOrAdmin((*Handler).listPasskeys)(h, w, r)
+		default:
+			http.Error(w, "Invalid Method", http.StatusMethodNotAllowed)
+		}
+	case "identities":
+		//linking finishes the round trip the user started; admins can
+		//review the links
+		switch r.Method {
+		case http.MethodPost:
+			RequireSelf((*Handler).finishLink)(h, w, r)
+		case http.MethodGet:
+			RequireSelfOrAdmin((*Handler).listIdentities)(h, w, r)
+		default:
+			http.Error(w, "Invalid Method", http.StatusMethodNotAllowed)
+		}
+	case "consents":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Invalid Method", http.StatusMethodNotAllowed)
+			return
+		}
+		RequireSelfOrAdmin((*Handler).listConsents)(h, w, r)
+	case "sessions":
+		switch r.Method {
+		case http.MethodGet:
+			RequireSelfOrAdmin((*Handler).listSessions)(h, w, r)
+		case http.MethodDelete:
+			RequireSelfOrAdmin((*Handler).revokeSessions)(h, w, r)
+		default:
+			http.Error(w, "Invalid Method", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,163 @@
+// Package imaging decodes uploaded images and renders the square thumbnails
+// served as avatars. Re-encoding drops every ancillary chunk, so EXIF, XMP
+// and comments never leave the service.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatGIF  = "gif"
+)
+
+// MaxPixels bounds the decoded size of an upload; a small compressed file
+// can otherwise expand into gigabytes of pixels.
+const MaxPixels = 40_000_000
+
+var (
+	ErrUnsupportedFormat = errors.New("image must be PNG, JPEG or GIF")
+	ErrTooLarge          = errors.New("image dimensions are too large")
+	ErrInvalidImage      = errors.New("image could not be decoded")
+)
+
+// Sniff returns the format of data from its content, ignoring whatever type
+// the client declared.
+func Sniff(data []byte) (string, error) {
+	switch http.DetectContentType(data) {
+	case "image/png":
+		return FormatPNG, nil
+	case "image/jpeg":
+		return FormatJPEG, nil
+	case "image/gif":
+		return FormatGIF, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Decode sniffs and decodes data, checking the dimensions before decoding
+// the pixels. JPEGs are rotated upright from their EXIF orientation, since
+// the tag itself is dropped on re-encoding. Animated GIFs keep their first
+// frame.
+func Decode(data []byte) (image.Image, string, error) {
+	format, err := Sniff(data)
+	if err != nil {
+		return nil, "", err
+	}
+	var cfg image.Config
+	switch format {
+	case FormatPNG:
+		cfg, err = png.DecodeConfig(bytes.NewReader(data))
+	case FormatJPEG:
+		cfg, err = jpeg.DecodeConfig(bytes.NewReader(data))
+	case FormatGIF:
+		cfg, err = gif.DecodeConfig(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, "", ErrInvalidImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, "", ErrInvalidImage
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxPixels {
+		return nil, "", ErrTooLarge
+	}
+
+	var img image.Image
+	switch format {
+	case FormatPNG:
+		img, err = png.Decode(bytes.NewReader(data))
+	case FormatJPEG:
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case FormatGIF:
+		img, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, "", ErrInvalidImage
+	}
+	if format == FormatJPEG {
+		img = orient(img, jpegOrientation(data))
+	}
+	return img, format, nil
+}
+
+// Thumbnail center-crops img to a square and scales it to size×size.
+func Thumbnail(img image.Image, size int) *image.NRGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2))
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	scaler := draw.Scaler(draw.CatmullRom)
+	if side < size {
+		//upscaling tiny images smoothly only blurs them
+		scaler = draw.ApproxBiLinear
+	}
+	scaler.Scale(dst, dst.Bounds(), img, crop, draw.Src, nil)
+	return dst
+}
+
+// Encode writes img as PNG when it has transparency and as JPEG otherwise,
+// returning the bytes and their content type.
+func Encode(img *image.NRGBA) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if img.Opaque() {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := enc.Encode(&buf, img); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+// orient applies an EXIF orientation (1-8) to img
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	//orientations 5-8 swap width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)))
+		}
+	}
+	return dst
+}
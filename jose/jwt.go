@@ -0,0 +1,175 @@
+// Package jose signs and verifies compact JSON Web Tokens (RFC 7519) and
+// publishes their keys as JSON Web Keys (RFC 7517), with the RS256
+// algorithm OpenID Connect requires and EdDSA over Ed25519 (RFC 8037).
+package jose
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// signature algorithms
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+var Algorithms = []string{RS256, EdDSA}
+
+var (
+	ErrMalformed    = errors.New("malformed token")
+	ErrUnknownKey   = errors.New("unknown signing key")
+	ErrBadSignature = errors.New("invalid token signature")
+	ErrExpired      = errors.New("token has expired")
+)
+
+// Key is a private signing key named by its key ID.
+type Key struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+	Type      string `json:"typ,omitempty"`
+}
+
+// Sign serializes claims as the payload of a JWT signed with key.
+func Sign(key *Key, claims any) (string, error) {
+	head, err := json.Marshal(header{Algorithm: key.Algorithm, KeyID: key.ID, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(head) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	var sig []byte
+	switch key.Algorithm {
+	case RS256:
+		digest := sha256.Sum256([]byte(input))
+		sig, err = key.Private.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case EdDSA:
+		//Ed25519 hashes the message itself
+		sig, err = key.Private.Sign(rand.Reader, []byte(input), crypto.Hash(0))
+	default:
+		return "", fmt.Errorf("unsupported algorithm %q", key.Algorithm)
+	}
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Verify checks the signature of a JWT with the public key lookup returns
+// for its key ID and algorithm, and returns the payload. Checking the
+// claims is left to the caller.
+func Verify(token string, lookup func(kid string, alg string) (crypto.PublicKey, error)) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var head header
+	if err := json.Unmarshal(rawHeader, &head); err != nil {
+		return nil, ErrMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	public, err := lookup(head.KeyID, head.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	input := parts[0] + "." + parts[1]
+	//the algorithm is taken from the header only once the key has vouched
+	//for it, so "none" or a swapped algorithm is never accepted
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		if head.Algorithm != RS256 {
+			return nil, ErrBadSignature
+		}
+		digest := sha256.Sum256([]byte(input))
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) != nil {
+			return nil, ErrBadSignature
+		}
+	case ed25519.PublicKey:
+		if head.Algorithm != EdDSA || !ed25519.Verify(key, []byte(input), sig) {
+			return nil, ErrBadSignature
+		}
+	default:
+		return nil, ErrUnknownKey
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return payload, nil
+}
+
+// Claims are the registered claims of RFC 7519 section 4.1 that Validate
+// checks.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  Audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+}
+
+// Validate checks the issuer, audience and expiry of verified claims,
+// allowing leeway for clock skew.
+func (c *Claims) Validate(issuer string, audience string, now time.Time, leeway time.Duration) error {
+	if c.Issuer != issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrMalformed, c.Issuer)
+	}
+	if !c.Audience.Contains(audience) {
+		return fmt.Errorf("%w: token is not meant for %q", ErrMalformed, audience)
+	}
+	if c.ExpiresAt == 0 || now.Add(-leeway).Unix() >= c.ExpiresAt {
+		return ErrExpired
+	}
+	return nil
+}
+
+// Audience is the aud claim, which is either one string or an array.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = Audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a Audience) Contains(audience string) bool {
+	for _, aud := range a {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
@@ -3,47 +3,481 @@ package main
 import (
 	"Users/database"
 	"Users/handlers"
+	"Users/jose"
 	"Users/middleware"
+	"Users/migrations"
+	"Users/models"
+	"Users/repository"
+	"Users/services"
+	"Users/utils"
+	"Users/webauthn"
 	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // ------------------ MAIN ------------------
 
 func main() {
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
-	client := database.ConnectDB()
-	//
-	h := &handlers.Handler{Client: client}
-	//logger using slog to log in json format
+	//STORAGE_BACKEND=memory runs without mongo, for local development; the
+	//in-memory store starts empty and is lost on exit
+	memoryBackend := os.Getenv("STORAGE_BACKEND") == "memory"
+	var client *mongo.Client
+	if !memoryBackend {
+		client = database.ConnectDB()
+	}
+	//password hashing settings come from the environment loaded by ConnectDB
+	passwordConfig, err := utils.PasswordConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid password hashing configuration:", err)
+	}
+	hasher, err := utils.NewPasswordHasher(passwordConfig)
+	if err != nil {
+		log.Fatal("Invalid password hashing configuration:", err)
+	}
+	canon := utils.NewCanonicalizer(utils.EmailPolicyFromEnv())
+
+	//TENANT_ISOLATION=database gives every tenant its own mongo database;
+	//by default tenants share one and every query is filtered by tenant
+	perTenant := os.Getenv("TENANT_ISOLATION") == "database"
+	//DEFAULT_TENANT serves requests that name no tenant; setting it empty
+	//makes the tenant header mandatory
+	defaultTenant, ok := os.LookupEnv("DEFAULT_TENANT")
+	if !ok {
+		defaultTenant = models.DefaultTenant
+	}
 
-	defer func() {
-		if err := client.Disconnect(context.TODO()); err != nil {
+	var tenantRepo repository.TenantRepository
+	var apiKeyRepo repository.APIKeyRepository
+	var sessionRepo repository.SessionRepository
+	var oauthRepo repository.OAuthRepository
+	var signingKeyRepo repository.SigningKeyRepository
+	if memoryBackend {
+		logger.Warn("Using the in-memory storage backend; data is not persisted")
+		tenantRepo = repository.NewMemoryTenants()
+		apiKeyRepo = repository.NewMemoryAPIKeys()
+		sessionRepo = repository.NewMemorySessions()
+		oauthRepo = repository.NewMemoryOAuth()
+		signingKeyRepo = repository.NewMemorySigningKeys()
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		err := prepareDatabase(ctx, logger, client, database.DatabaseName, canon)
+		cancel()
+		if err != nil {
+			log.Fatal("Error preparing the database:", err)
+		}
+		tenantRepo = repository.NewMongoTenants(client, database.DatabaseName)
+		apiKeyRepo = repository.NewMongoAPIKeys(client, database.DatabaseName)
+		sessionRepo = repository.NewMongoSessions(client, database.DatabaseName)
+		oauthRepo = repository.NewMongoOAuth(client, database.DatabaseName)
+		signingKeyRepo = repository.NewMongoSigningKeys(client, database.DatabaseName)
+		defer func() {
+			if err := client.Disconnect(context.TODO()); err != nil {
 
-			logger.Error("Error disconnecting from mongoDb", "error", err)
+				logger.Error("Error disconnecting from mongoDb", "error", err)
+			}
+		}()
+	}
+	//new tenants get their schema and migrations before they are registered;
+	//their database is empty, so this only waits long for the migration lock
+	provision := func(tenantID string) error {
+		db := database.TenantDatabase(tenantID, perTenant)
+		if memoryBackend || db == database.DatabaseName {
+			return nil
 		}
-	}()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		return prepareDatabase(ctx, logger, client, db, canon)
+	}
+	tenants := services.NewTenantService(tenantRepo, provision)
+	//API keys are shared by all tenants so a key resolves its own tenant
+	apiKeys := services.NewAPIKeyService(apiKeyRepo, logger)
+	//sessions likewise, so a bearer token resolves its user and tenant
+	sessionOptions := services.DefaultSessionOptions()
+	if v := os.Getenv("SESSION_TTL"); v != "" {
+		if sessionOptions.TTL, err = time.ParseDuration(v); err != nil || sessionOptions.TTL <= 0 {
+			log.Fatal("Invalid SESSION_TTL:", v)
+		}
+	}
+	if v := os.Getenv("SESSION_IDLE_TIMEOUT"); v != "" {
+		if sessionOptions.IdleTimeout, err = time.ParseDuration(v); err != nil || sessionOptions.IdleTimeout < 0 {
+			log.Fatal("Invalid SESSION_IDLE_TIMEOUT:", v)
+		}
+	}
+	sessions := services.NewSessionService(sessionRepo, sessionOptions, logger)
+	//OAUTH_ISSUER is the public base URL of this server as OAuth clients
+	//reach it
+	oauthOptions := services.DefaultOAuthOptions()
+	if v := os.Getenv("OAUTH_ISSUER"); v != "" {
+		oauthOptions.Issuer = strings.TrimSuffix(v, "/")
+	}
+	if v := os.Getenv("OAUTH_ACCESS_TOKEN_TTL"); v != "" {
+		if oauthOptions.AccessTokenTTL, err = time.ParseDuration(v); err != nil || oauthOptions.AccessTokenTTL <= 0 {
+			log.Fatal("Invalid OAUTH_ACCESS_TOKEN_TTL:", v)
+		}
+	}
+	if v := os.Getenv("OIDC_ID_TOKEN_TTL"); v != "" {
+		if oauthOptions.IDTokenTTL, err = time.ParseDuration(v); err != nil || oauthOptions.IDTokenTTL <= 0 {
+			log.Fatal("Invalid OIDC_ID_TOKEN_TTL:", v)
+		}
+	}
+	//ID tokens are signed with OIDC_SIGNING_ALG keys that sign for
+	//OIDC_KEY_ROTATION each, are published OIDC_KEY_PREPUBLISH before and
+	//OIDC_KEY_OVERLAP after; SIGNING_KEY_SECRET seals them in the database
+	//and every replica must share it
+	keyOptions := services.DefaultKeyRingOptions()
+	if v := os.Getenv("OIDC_SIGNING_ALG"); v != "" {
+		if !slices.Contains(jose.Algorithms, v) {
+			log.Fatal("Invalid OIDC_SIGNING_ALG:", v)
+		}
+		keyOptions.Algorithm = v
+	}
+	if v := os.Getenv("OIDC_KEY_ROTATION"); v != "" {
+		if keyOptions.RotateEvery, err = time.ParseDuration(v); err != nil || keyOptions.RotateEvery <= 0 {
+			log.Fatal("Invalid OIDC_KEY_ROTATION:", v)
+		}
+	}
+	if v := os.Getenv("OIDC_KEY_PREPUBLISH"); v != "" {
+		if keyOptions.Prepublish, err = time.ParseDuration(v); err != nil || keyOptions.Prepublish < 0 {
+			log.Fatal("Invalid OIDC_KEY_PREPUBLISH:", v)
+		}
+	}
+	if v := os.Getenv("OIDC_KEY_OVERLAP"); v != "" {
+		if keyOptions.Overlap, err = time.ParseDuration(v); err != nil || keyOptions.Overlap <= 0 {
+			log.Fatal("Invalid OIDC_KEY_OVERLAP:", v)
+		}
+	}
+	if keyOptions.Prepublish >= keyOptions.RotateEvery {
+		log.Fatal("OIDC_KEY_PREPUBLISH must be shorter than OIDC_KEY_ROTATION")
+	}
+	if keyOptions.Overlap < oauthOptions.IDTokenTTL {
+		log.Fatal("OIDC_KEY_OVERLAP must be at least OIDC_ID_TOKEN_TTL")
+	}
+	keySecret := []byte(os.Getenv("SIGNING_KEY_SECRET"))
+	if len(keySecret) == 0 {
+		logger.Warn("SIGNING_KEY_SECRET is not set; signing keys will not survive a restart")
+		keySecret = make([]byte, 32)
+		if _, err := rand.Read(keySecret); err != nil {
+			log.Fatal("Error generating signing key secret:", err)
+		}
+	}
+	keySealer, err := utils.NewSealer(utils.DeriveKey(keySecret, "signing-keys"))
+	if err != nil {
+		log.Fatal("Invalid SIGNING_KEY_SECRET:", err)
+	}
+	keyRing := services.NewKeyRing(signingKeyRepo, keySealer, keyOptions)
+	oauth := services.NewOAuthService(oauthRepo, keyRing, oauthOptions)
+	if err := tenants.CreateTenant(&models.Tenant{ID: models.DefaultTenant, Name: "Default"}); err != nil && !errors.Is(err, repository.ErrTenantExists) {
+		log.Fatal("Error registering the default tenant:", err)
+	}
+	if perTenant && !memoryBackend {
+		registered, err := tenants.ListTenants()
+		if err != nil {
+			log.Fatal("Error listing tenants:", err)
+		}
+		for _, tenant := range registered {
+			if err := provision(tenant.ID); err != nil {
+				log.Fatal("Error preparing the database of tenant "+tenant.ID+":", err)
+			}
+		}
+	}
+
+	//avatars go to GridFS with mongo, or the filesystem with AVATAR_STORAGE=local
+	localAvatars := memoryBackend || os.Getenv("AVATAR_STORAGE") == "local"
+	avatarDir := os.Getenv("AVATAR_DIR")
+	if avatarDir == "" {
+		avatarDir = "data/avatars"
+	}
+
+	//user management needs an admin: the admin role or membership of one of
+	//ADMIN_GROUPS (comma separated, default admins). ENFORCE_AUTHZ=false opens
+	//every endpoint to anyone, so it is only accepted for the in-memory backend
+	access := handlers.Access{Enforce: true, AdminGroups: []string{"admins"}}
+	if os.Getenv("ENFORCE_AUTHZ") == "false" {
+		if !memoryBackend {
+			log.Fatal("ENFORCE_AUTHZ=false is only allowed with STORAGE_BACKEND=memory")
+		}
+		logger.Warn("Authorization is not enforced; every request is allowed")
+		access.Enforce = false
+	}
+	if v, ok := os.LookupEnv("ADMIN_GROUPS"); ok {
+		access.AdminGroups = nil
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				access.AdminGroups = append(access.AdminGroups, name)
+			}
+		}
+	}
+
+	//invitation links are signed with INVITATION_SECRET, which every replica
+	//must share; links last INVITATION_TTL and point to INVITATION_URL
+	inviteSecret := []byte(os.Getenv("INVITATION_SECRET"))
+	if len(inviteSecret) == 0 {
+		logger.Warn("INVITATION_SECRET is not set; invitation links will not survive a restart")
+		inviteSecret = make([]byte, 32)
+		if _, err := rand.Read(inviteSecret); err != nil {
+			log.Fatal("Error generating invitation secret:", err)
+		}
+	}
+	inviteSigner := utils.NewSigner(inviteSecret)
+	inviteOptions := services.DefaultInvitationOptions()
+	if v := os.Getenv("INVITATION_TTL"); v != "" {
+		if inviteOptions.TTL, err = time.ParseDuration(v); err != nil || inviteOptions.TTL <= 0 {
+			log.Fatal("Invalid INVITATION_TTL:", v)
+		}
+	}
+	if v := os.Getenv("INVITATION_URL"); v != "" {
+		inviteOptions.URL = v
+	}
+
+	//TOTP_SECRET_KEY encrypts TOTP secrets at rest and signs login
+	//challenges; without it two-factor enrollment is unavailable
+	twoFactorOptions := services.DefaultTwoFactorOptions()
+	if v := os.Getenv("TOTP_ISSUER"); v != "" {
+		twoFactorOptions.Issuer = v
+	}
+	var totpSealer *utils.Sealer
+	challengeKey := make([]byte, 32)
+	if secret := os.Getenv("TOTP_SECRET_KEY"); secret != "" {
+		if totpSealer, err = utils.NewSealer(utils.DeriveKey([]byte(secret), "totp-secrets")); err != nil {
+			log.Fatal("Invalid TOTP_SECRET_KEY:", err)
+		}
+		challengeKey = utils.DeriveKey([]byte(secret), "login-challenges")
+	} else if _, err := rand.Read(challengeKey); err != nil {
+		log.Fatal("Error generating login challenge key:", err)
+	}
+	challengeSigner := utils.NewSigner(challengeKey)
+
+	//passkeys are scoped to WEBAUTHN_RP_ID and may only be used from the
+	//comma separated WEBAUTHN_ORIGINS
+	rpConfig := webauthn.Config{RPID: "localhost", RPName: "Users", Origins: []string{"http://localhost:8080"}}
+	if v := os.Getenv("WEBAUTHN_RP_ID"); v != "" {
+		rpConfig.RPID = v
+	}
+	if v := os.Getenv("WEBAUTHN_RP_NAME"); v != "" {
+		rpConfig.RPName = v
+	}
+	if v := os.Getenv("WEBAUTHN_ORIGINS"); v != "" {
+		rpConfig.Origins = strings.Split(v, ",")
+	}
+	relyingParty := webauthn.New(rpConfig)
+
+	//users may sign in with the upstream OpenID Connect providers listed
+	//as a JSON array in OIDC_PROVIDERS_FILE
+	var providers []models.IdentityProvider
+	if v := os.Getenv("OIDC_PROVIDERS_FILE"); v != "" {
+		data, err := os.ReadFile(v)
+		if err != nil {
+			log.Fatal("Error reading OIDC_PROVIDERS_FILE:", err)
+		}
+		if err := json.Unmarshal(data, &providers); err != nil {
+			log.Fatal("Invalid OIDC_PROVIDERS_FILE:", err)
+		}
+	}
+	federation, err := services.NewFederationService(providers, &http.Client{Timeout: 10 * time.Second})
+	if err != nil {
+		log.Fatal("Invalid OIDC_PROVIDERS_FILE:", err)
+	}
+
+	//failed logins slow down and then lock an account for a while; the
+	//user is told through the notifier
+	lockoutOptions := services.DefaultLockoutOptions()
+	if v := os.Getenv("LOCKOUT_THRESHOLD"); v != "" {
+		if lockoutOptions.Threshold, err = strconv.Atoi(v); err != nil || lockoutOptions.Threshold < 0 {
+			log.Fatal("Invalid LOCKOUT_THRESHOLD:", v)
+		}
+	}
+	if v := os.Getenv("LOCKOUT_IP_THRESHOLD"); v != "" {
+		if lockoutOptions.IPThreshold, err = strconv.Atoi(v); err != nil || lockoutOptions.IPThreshold < 0 {
+			log.Fatal("Invalid LOCKOUT_IP_THRESHOLD:", v)
+		}
+	}
+	if v := os.Getenv("LOCKOUT_DURATION"); v != "" {
+		if lockoutOptions.LockDuration, err = time.ParseDuration(v); err != nil || lockoutOptions.LockDuration <= 0 {
+			log.Fatal("Invalid LOCKOUT_DURATION:", v)
+		}
+	}
+	notifier := services.NewLogNotifier(logger)
+	//work outliving requests, stopped before the server exits
+	background := services.NewBackground()
+	//X-Forwarded-For is only believed from TRUSTED_PROXIES
+	proxies, err := middleware.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+
+	//build wires one tenant's repositories and services; the result is
+	//cached, so in-memory stores live as long as the process
+	build := func(tenantID string) (*handlers.Handler, error) {
+		var userRepo repository.UserRepository
+		var jobRepo repository.JobRepository
+		var schemaRepo repository.AttributeSchemaRepository
+		var groupRepo repository.GroupRepository
+		var invitationRepo repository.InvitationRepository
+		var passkeyRepo repository.PasskeyRepository
+		var identityRepo repository.IdentityRepository
+		var attemptRepo repository.AttemptRepository
+		var blobs repository.BlobStore
+		db := database.TenantDatabase(tenantID, perTenant)
+		if memoryBackend {
+			userRepo = repository.NewMemory(tenantID)
+			jobRepo = repository.NewMemoryJobs()
+			schemaRepo = repository.NewMemoryAttributeSchemas()
+			groupRepo = repository.NewMemoryGroups(tenantID)
+			invitationRepo = repository.NewMemoryInvitations(tenantID)
+			passkeyRepo = repository.NewMemoryPasskeys(tenantID)
+			identityRepo = repository.NewMemoryIdentities(tenantID)
+			attemptRepo = repository.NewMemoryAttempts()
+		} else {
+			userRepo = repository.NewMongo(client, db, tenantID)
+			jobRepo = repository.NewMongoJobs(client, db, tenantID)
+			schemaRepo = repository.NewMongoAttributeSchemas(client, db)
+			groupRepo = repository.NewMongoGroups(client, db, tenantID)
+			invitationRepo = repository.NewMongoInvitations(client, db, tenantID)
+			passkeyRepo = repository.NewMongoPasskeys(client, db, tenantID)
+			identityRepo = repository.NewMongoIdentities(client, db, tenantID)
+			attemptRepo = repository.NewMongoAttempts(client, db, tenantID)
+		}
+		if localAvatars {
+			var err error
+			blobs, err = repository.NewLocalBlobs(filepath.Join(avatarDir, tenantID))
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			blobs = repository.NewGridFSBlobs(client, db, "avatars")
+		}
+		attributes := services.NewAttributeService(schemaRepo, tenantID)
+		create := services.NewCreateService(userRepo, hasher, canon, attributes)
+		groups := services.NewGroupService(groupRepo, userRepo, canon)
+		tenantLogger := logger.With(slog.String("tenant", tenantID))
+		lockout := services.NewLockoutService(attemptRepo, userRepo, notifier, lockoutOptions, tenantLogger)
+		avatars := services.NewAvatarService(userRepo, blobs, tenantID)
+		passkeys := services.NewPasskeyService(passkeyRepo, userRepo, lockout, relyingParty, canon)
+		identities := services.NewIdentityService(identityRepo, userRepo, create, lockout, federation, canon, tenantID)
+		accounts := services.NewAccountService(userRepo, sessions, oauth, lockout, groups, passkeys, avatars, identities, tenantID, tenantLogger)
+		return &handlers.Handler{
+			Users:       userRepo,
+			Create:      create,
+			Update:      services.NewUpdateService(userRepo, hasher, canon, attributes),
+			Auth:        services.NewAuthService(userRepo, hasher, canon, lockout, tenantLogger),
+			Import:      services.NewImportService(userRepo, hasher, canon, attributes),
+			Bulk:        services.NewBulkService(userRepo, jobRepo, accounts, background, tenantLogger),
+			Search:      services.NewSearchService(userRepo),
+			Attributes:  attributes,
+			Avatars:     avatars,
+			Groups:      groups,
+			Invitations: services.NewInvitationService(invitationRepo, userRepo, create, groups, canon, inviteSigner, tenantID, inviteOptions),
+			TwoFactor:   services.NewTwoFactorService(userRepo, lockout, totpSealer, challengeSigner, tenantID, twoFactorOptions, tenantLogger),
+			Passkeys:    passkeys,
+			Identities:  identities,
+			Lockout:     lockout,
+			APIKeys:     apiKeys,
+			Sessions:    sessions,
+			OAuth:       oauth,
+			Accounts:    accounts,
+			Access:      access,
+			Logger:      tenantLogger,
+		}, nil
+	}
+	t := &handlers.TenantHandlers{Tenants: tenants, Build: build}
+	//logger using slog to log in json format
 
 	//using a server mux to map the requests to the handlers
+	//rate limits per caller: strict on account creation and logins, looser
+	//on reads; counts are kept in mongo so replicas share them
+	var rateStore repository.RateLimitRepository
+	if memoryBackend {
+		rateStore = repository.NewMemoryRateLimits()
+	} else {
+		rateStore = repository.NewMongoRateLimits(client, database.DatabaseName)
+	}
+	limiter := middleware.NewRateLimiter(rateStore, logger)
+	ratePolicy := func(name string, env string, spec string) middleware.RatePolicy {
+		if v := os.Getenv(env); v != "" {
+			spec = v
+		}
+		policy, err := middleware.ParseRatePolicy(name, spec)
+		if err != nil {
+			log.Fatal("Invalid "+env+":", err)
+		}
+		return policy
+	}
+	readPolicy := ratePolicy("read", "RATE_LIMIT_READ", "300/1m")
+	writePolicy := ratePolicy("write", "RATE_LIMIT_WRITE", "60/1m")
+	creates := limiter.Policy(ratePolicy("create-user", "RATE_LIMIT_CREATE_USER", "10/1m"))
+	logins := limiter.Policy(ratePolicy("login", "RATE_LIMIT_LOGIN", "10/1m"))
+	reads := limiter.Policy(readPolicy)
+	writes := limiter.Policy(writePolicy)
+	mixed := limiter.ByMethod(readPolicy, writePolicy)
+
 	mux := http.NewServeMux()
 
 	//applying method check middleware to the mux handlers
-	mux.Handle("/api/create-user", middleware.MethodChecker([]string{http.MethodPost}, http.HandlerFunc(h.CreateUser)))
-	mux.Handle("/api/update-user/{id}", middleware.MethodChecker([]string{http.MethodPut}, http.HandlerFunc(h.UpdateUser)))
-	mux.Handle("/api/users", middleware.MethodChecker([]string{http.MethodGet}, http.HandlerFunc(h.FetchAllUsers)))
-	mux.Handle("/api/delete-user/{id}", middleware.MethodChecker([]string{http.MethodDelete}, http.HandlerFunc(h.DeleteUser)))
-	mux.Handle("/api/update-status/{id}", middleware.MethodChecker([]string{http.MethodPut}, http.HandlerFunc(h.UpdateStatus)))
-	mux.Handle("/api/emails", middleware.MethodChecker([]string{http.MethodGet}, http.HandlerFunc(h.FetchAllEmails)))
+	mux.Handle("/api/create-user", middleware.MethodChecker([]string{http.MethodPost}, creates(t.Scoped(handlers.RequireAdmin((*handlers.Handler).CreateUser, models.ScopeUsersWrite)))))
+	mux.Handle("/api/update-user/{id}", middleware.MethodChecker([]string{http.MethodPut}, writes(t.Scoped(handlers.RequireSelfOrAdmin((*handlers.Handler).UpdateUser)))))
+	mux.Handle("/api/users", middleware.MethodChecker([]string{http.MethodGet}, reads(t.Scoped(handlers.RequireAdmin((*handlers.Handler).FetchAllUsers, models.ScopeUsersRead)))))
+	mux.Handle("/api/users/{id}", middleware.MethodChecker([]string{http.MethodPatch}, writes(t.Scoped(handlers.RequireSelfOrAdmin((*handlers.Handler).PatchUser)))))
+	mux.Handle("/api/users/{id}/{resource}", middleware.MethodChecker([]string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}, mixed(t.Scoped((*handlers.Handler).UserResource))))
+	mux.Handle("/api/users/{id}/passkeys/{credential}", middleware.MethodChecker([]string{http.MethodDelete}, writes(t.Scoped(handlers.RequireSelfOrAdmin((*handlers.Handler).DeletePasskey)))))
+	mux.Handle("/api/users/{id}/identities/{provider}", middleware.MethodChecker([]string{http.MethodPost, http.MethodDelete}, writes(t.Scoped((*handlers.Handler).UserIdentity))))
+	mux.Handle("/api/users/{id}/sessions/{session}", middleware.MethodChecker([]string{http.MethodDelete}, writes(t.Scoped(handlers.RequireSelfOrAdmin((*handlers.Handler).RevokeSession)))))
+	mux.Handle("/api/users/{id}/consents/{client}", middleware.MethodChecker([]string{http.MethodDelete}, writes(t.Scoped(handlers.RequireSelfOrAdmin((*handlers.Handler).RevokeConsent)))))
+	mux.Handle("/api/users/{id}/2fa/{action}", middleware.MethodChecker([]string{http.MethodPost}, writes(t.Scoped(handlers.RequireSelf((*handlers.Handler).TwoFactorAction)))))
+	mux.Handle("/api/users/search", middleware.MethodChecker([]string{http.MethodGet}, reads(t.Scoped(handlers.RequireAdmin((*handlers.Handler).SearchUsers, models.ScopeUsersRead)))))
+	mux.Handle("/api/users/export", middleware.MethodChecker([]string{http.MethodGet}, reads(t.Scoped(handlers.RequireAdmin((*handlers.Handler).ExportUsers, models.ScopeUsersRead)))))
+	mux.Handle("/api/users/bulk", middleware.MethodChecker([]string{http.MethodPost}, writes(t.Scoped(handlers.RequireAdmin((*handlers.Handler).BulkUsers)))))
+	mux.Handle("/api/users/bulk/{id}", middleware.MethodChecker([]string{http.MethodGet}, reads(t.Scoped(handlers.RequireAdmin((*handlers.Handler).BulkJob)))))
+	mux.Handle("/api/users/import", middleware.MethodChecker([]string{http.MethodPost}, writes(t.Scoped(handlers.RequireAdmin((*handlers.Handler).ImportUsers)))))
+	mux.Handle("/api/delete-user/{id}", middleware.MethodChecker([]string{http.MethodDelete}, writes(t.Scoped(handlers.RequireAdmin((*handlers.Handler).DeleteUser, models.ScopeUsersWrite)))))
+	mux.Handle("/api/update-status/{id}", middleware.MethodChecker([]string{http.MethodPut}, writes(t.Scoped(handlers.RequireAdmin((*handlers.Handler).UpdateStatus, models.ScopeUsersWrite)))))
+	mux.Handle("/api/attribute-schema", middleware.MethodChecker([]string{http.MethodGet, http.MethodPut}, mixed(t.Scoped((*handlers.Handler).AttributeSchema))))
+	mux.Handle("/api/login", middleware.MethodChecker([]string{http.MethodPost}, logins(t.Scoped((*handlers.Handler).Login))))
+	mux.Handle("/api/login/2fa", middleware.MethodChecker([]string{http.MethodPost}, logins(t.Scoped((*handlers.Handler).LoginSecondFactor))))
+	mux.Handle("/api/login/passkey-options", middleware.MethodChecker([]string{http.MethodPost}, logins(t.Scoped((*handlers.Handler).PasskeyRequestOptions))))
+	mux.Handle("/api/login/passkey", middleware.MethodChecker([]string{http.MethodPost}, logins(t.Scoped((*handlers.Handler).LoginPasskey))))
+	mux.Handle("/api/login/providers", middleware.MethodChecker([]string{http.MethodGet}, reads(t.Scoped((*handlers.Handler).LoginProviders))))
+	mux.Handle("/api/login/federated/{provider}", middleware.MethodChecker([]string{http.MethodPost}, logins(t.Scoped((*handlers.Handler).BeginFederatedLogin))))
+	mux.Handle("/api/login/federated", middleware.MethodChecker([]string{http.MethodPost}, logins(t.Scoped((*handlers.Handler).LoginFederated))))
+	mux.Handle("/api/groups", middleware.MethodChecker([]string{http.MethodGet, http.MethodPost}, mixed(t.Scoped(handlers.RequireAdmin((*handlers.Handler).GroupsEndpoint)))))
+	mux.Handle("/api/groups/{id}", middleware.MethodChecker([]string{http.MethodGet, http.MethodPut, http.MethodDelete}, mixed(t.Scoped(handlers.RequireAdmin((*handlers.Handler).GroupEndpoint)))))
+	mux.Handle("/api/groups/{id}/{kind}/{member}", middleware.MethodChecker([]string{http.MethodPut, http.MethodDelete}, writes(t.Scoped(handlers.RequireAdmin((*handlers.Handler).GroupMembership)))))
+	mux.Handle("/api/invitations", middleware.MethodChecker([]string{http.MethodPost}, writes(t.Scoped(handlers.RequireAdmin((*handlers.Handler).CreateInvitation)))))
+	mux.Handle("/api/avatars/{tenant}/{id}", middleware.MethodChecker([]string{http.MethodGet}, reads(t.FromPath((*handlers.Handler).ServeAvatar))))
+	mux.Handle("/api/invitations/{token}/accept", middleware.MethodChecker([]string{http.MethodPost}, logins(t.FromInvitation(inviteSigner, (*handlers.Handler).AcceptInvitation))))
+	mux.Handle("/api/tenants", middleware.MethodChecker([]string{http.MethodGet, http.MethodPost}, mixed(t.Operator(t.TenantsEndpoint))))
+	mux.Handle("/api/api-keys", middleware.MethodChecker([]string{http.MethodGet, http.MethodPost}, mixed(t.Scoped(handlers.RequireAdmin((*handlers.Handler).APIKeysEndpoint)))))
+	mux.Handle("/api/api-keys/{id}", middleware.MethodChecker([]string{http.MethodDelete}, writes(t.Scoped(handlers.RequireAdmin((*handlers.Handler).RevokeAPIKey)))))
+	mux.Handle("/api/oauth/clients", middleware.MethodChecker([]string{http.MethodGet, http.MethodPost}, mixed(t.Scoped(handlers.RequireAdmin((*handlers.Handler).OAuthClients)))))
+	mux.Handle("/api/oauth/clients/{id}", middleware.MethodChecker([]string{http.MethodDelete}, writes(t.Scoped(handlers.RequireAdmin((*handlers.Handler).DeleteOAuthClient)))))
+	mux.Handle("/oauth/authorize", middleware.MethodChecker([]string{http.MethodGet, http.MethodPost}, logins(t.FromOAuthClient(oauth, (*handlers.Handler).Authorize))))
+	mux.Handle("/oauth/token", middleware.MethodChecker([]string{http.MethodPost}, writes(t.FromOAuthClient(oauth, (*handlers.Handler).Token))))
+	mux.Handle("/oauth/introspect", middleware.MethodChecker([]string{http.MethodPost}, reads(t.FromOAuthClient(oauth, (*handlers.Handler).Introspect))))
+	mux.Handle("/oauth/revoke", middleware.MethodChecker([]string{http.MethodPost}, writes(t.FromOAuthClient(oauth, (*handlers.Handler).Revoke))))
+	mux.Handle("/oauth/userinfo", middleware.MethodChecker([]string{http.MethodGet, http.MethodPost}, reads(t.FromAccessToken(oauth, (*handlers.Handler).UserInfo))))
+	mux.Handle("/oauth/jwks", middleware.MethodChecker([]string{http.MethodGet}, reads(handlers.JWKS(oauth))))
+	mux.Handle("/.well-known/openid-configuration", middleware.MethodChecker([]string{http.MethodGet}, reads(handlers.Discovery(oauth))))
+	mux.Handle("/api/emails", middleware.MethodChecker([]string{http.MethodGet}, reads(t.Scoped(handlers.RequireAdmin((*handlers.Handler).FetchAllEmails, models.ScopeEmailsRead)))))
 
 	//Wrapping the mux around the panic middleware
 
-	handlerforPanicRecovery := middleware.PanicMiddleware(logger)(mux)
+	handlerforPanicRecovery := middleware.PanicMiddleware(logger)(middleware.ClientIP(proxies, middleware.Authenticate(apiKeys, sessions, middleware.Tenant(defaultTenant, mux))))
 	server := &http.Server{
 		Addr:    ":8080",
 		Handler: handlerforPanicRecovery,
@@ -65,5 +499,45 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatal("Server has been shutdown...")
 	}
+	//async bulk jobs stop after their current batch and record the interruption
+	if err := background.Shutdown(ctx); err != nil {
+		logger.Warn("Background jobs did not stop in time", "error", err)
+	}
 	logger.Info("Server exited...")
 }
+
+// ------------------ PREPARE DATABASE ------------------
+// creates collections, indexes and validators and runs pending migrations;
+// at startup before serving traffic, and for tenants as they are created
+func prepareDatabase(ctx context.Context, logger *slog.Logger, client *mongo.Client, db string, canon *utils.Canonicalizer) error {
+	schemaReport, err := database.EnsureSchema(client, db)
+	if err != nil {
+		return fmt.Errorf("applying database schema: %w", err)
+	}
+	logger.Info("Database schema applied", "database", db,
+		"createdCollections", schemaReport.CreatedCollections,
+		"createdIndexes", schemaReport.CreatedIndexes,
+		"updatedValidators", schemaReport.UpdatedValidators)
+	if schemaReport.HasDrift() {
+		logger.Warn("Database schema drift detected",
+			"driftedIndexes", schemaReport.DriftedIndexes,
+			"unexpectedIndexes", schemaReport.UnexpectedIndexes)
+	}
+	//replicas starting together serialize on the migration lock
+	if os.Getenv("MIGRATE_ON_START") == "false" {
+		return nil
+	}
+	hostname, _ := os.Hostname()
+	runner, err := migrations.NewRunner(client.Database(db), migrations.All(canon), fmt.Sprintf("%s-%d", hostname, os.Getpid()))
+	if err != nil {
+		return fmt.Errorf("invalid migrations: %w", err)
+	}
+	results, err := runner.Up(ctx, migrations.Options{})
+	if err != nil {
+		return fmt.Errorf("running migrations: %w", err)
+	}
+	for _, result := range results {
+		logger.Info("Migration applied", "database", db, "version", result.Version, "name", result.Name, "affected", result.Affected)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"Users/services"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Authenticate resolves the Authorization header. "ApiKey <key>" makes the
+// key the caller, and "Bearer <token>" the user of the session created at
+// login; either way the credential's tenant becomes the request's tenant.
+// Requests without credentials continue anonymously and are left to the
+// authorization of each route, as do OAuth access tokens, which only the
+// endpoints serving OAuth clients accept.
+func Authenticate(apiKeys services.APIKeyInterface, sessions services.SessionInterface, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, credentials, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		credentials = strings.TrimSpace(credentials)
+		switch {
+		case strings.EqualFold(scheme, "ApiKey"):
+			key, err := apiKeys.AuthenticateAPIKey(credentials)
+			if errors.Is(err, services.ErrInvalidAPIKey) {
+				w.Header().Set("WWW-Authenticate", "ApiKey")
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Error checking credentials", http.StatusInternalServerError)
+				return
+			}
+			ctx := WithTenant(WithAPIKey(r.Context(), key.ID, key.Scopes), key.TenantID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		case strings.EqualFold(scheme, "Bearer") && services.IsOAuthAccessToken(credentials):
+			next.ServeHTTP(w, r)
+		case strings.EqualFold(scheme, "Bearer"):
+			session, err := sessions.AuthenticateSession(credentials)
+			if errors.Is(err, services.ErrInvalidSession) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Invalid or expired session", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Error checking credentials", http.StatusInternalServerError)
+				return
+			}
+			ctx := WithTenant(WithSession(WithUser(r.Context(), session.UserID), session.ID), session.TenantID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import "context"
+
+type userKey struct{}
+
+// WithUser records the authenticated caller's user ID. Authentication
+// middleware sets it; handlers read it to make authorization decisions.
+func WithUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userKey{}, userID)
+}
+
+// UserFrom returns the authenticated caller's user ID, or "" for anonymous
+// requests.
+func UserFrom(ctx context.Context) string {
+	userID, _ := ctx.Value(userKey{}).(string)
+	return userID
+}
+
+type apiKeyKey struct{}
+
+type apiKeyCaller struct {
+	id     string
+	scopes []string
+}
+
+// WithAPIKey records the API key that authenticated the request and the
+// scopes it was granted.
+func WithAPIKey(ctx context.Context, keyID string, scopes []string) context.Context {
+	return context.WithValue(ctx, apiKeyKey{}, apiKeyCaller{id: keyID, scopes: scopes})
+}
+
+// APIKeyFrom returns the authenticating API key's ID, or "" when the caller
+// did not use one.
+func APIKeyFrom(ctx context.Context) string {
+	caller, _ := ctx.Value(apiKeyKey{}).(apiKeyCaller)
+	return caller.id
+}
+
+// ScopesFrom returns the scopes of the authenticating API key.
+func ScopesFrom(ctx context.Context) []string {
+	caller, _ := ctx.Value(apiKeyKey{}).(apiKeyCaller)
+	return caller.scopes
+}
+
+type sessionKey struct{}
+
+// WithSession records the session whose bearer token authenticated the
+// request.
+func WithSession(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, sessionID)
+}
+
+// SessionFrom returns the authenticating session's ID, or "" when the
+// caller did not use one.
+func SessionFrom(ctx context.Context) string {
+	sessionID, _ := ctx.Value(sessionKey{}).(string)
+	return sessionID
+}
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"Users/repository"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RatePolicy allows Limit requests per Window for each caller. Routes
+// sharing a policy share its budget; a zero Limit turns limiting off.
+type RatePolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// ParseRatePolicy reads a policy written as "limit/window", such as
+// "10/1m", or "off".
+func ParseRatePolicy(name string, spec string) (RatePolicy, error) {
+	if spec == "off" {
+		return RatePolicy{Name: name}, nil
+	}
+	limit, window, ok := strings.Cut(spec, "/")
+	if !ok {
+		return RatePolicy{}, fmt.Errorf("rate limit %q must look like 10/1m", spec)
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 0 {
+		return RatePolicy{}, fmt.Errorf("invalid request count in rate limit %q", spec)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d < time.Second {
+		return RatePolicy{}, fmt.Errorf("invalid window in rate limit %q", spec)
+	}
+	return RatePolicy{Name: name, Limit: n, Window: d}, nil
+}
+
+// RateLimiter enforces policies with a sliding window: the count of the
+// current fixed window plus the previous one's, weighted by how much of it
+// still overlaps. Refused requests count too, so a client has to back off
+// to recover.
+type RateLimiter struct {
+	store  repository.RateLimitRepository
+	logger *slog.Logger
+}
+
+func NewRateLimiter(store repository.RateLimitRepository, logger *slog.Logger) *RateLimiter {
+	return &RateLimiter{store: store, logger: logger}
+}
+
+// identity is who a request is counted against: the API key or user that
+// authenticated it, otherwise the client address
+func identity(r *http.Request) string {
+	if keyID := APIKeyFrom(r.Context()); keyID != "" {
+		return "key:" + keyID
+	}
+	if userID := UserFrom(r.Context()); userID != "" {
+		return "user:" + TenantFrom(r.Context()) + "/" + userID
+	}
+	if ip := ClientIPFrom(r.Context()); ip != "" {
+		return "ip:" + ip
+	}
+	return "ip:" + r.RemoteAddr
+}
+
+// Policy limits every request to next by p.
+func (l *RateLimiter) Policy(p RatePolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if p.Limit <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if l.allow(p, w, r) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// ByMethod limits reads by read and everything else by write, for routes
+// serving both.
+func (l *RateLimiter) ByMethod(read RatePolicy, write RatePolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		reads := l.Policy(read)(next)
+		writes := l.Policy(write)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				reads.ServeHTTP(w, r)
+				return
+			}
+			writes.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allow counts the request, sets the RateLimit headers and answers 429
+// when the caller is over the limit
+func (l *RateLimiter) allow(p RatePolicy, w http.ResponseWriter, r *http.Request) bool {
+	now := time.Now()
+	start := now.Truncate(p.Window)
+	current, previous, err := l.store.Hit(p.Name+"|"+identity(r), start, p.Window)
+	if err != nil {
+		//an unavailable store must not take the whole API down with it
+		l.logger.Error("rate limit store failed", slog.String("policy", p.Name), slog.Any("error", err))
+		return true
+	}
+	left := p.Window - now.Sub(start)
+	weight := float64(left) / float64(p.Window)
+	used := float64(previous)*weight + float64(current)
+	limit := float64(p.Limit)
+
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", p.Limit, int(p.Window.Seconds())))
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(p.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(max(0, math.Floor(limit-used)))))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(left.Seconds()))))
+	if used <= limit {
+		return true
+	}
+
+	//the next request fits once the weighted count allows one more
+	var wait time.Duration
+	if float64(current)+1 <= limit && previous > 0 {
+		wait = left - time.Duration((limit-float64(current)-1)/float64(previous)*float64(p.Window))
+	} else {
+		wait = left + time.Duration(max(0, 1-(limit-1)/float64(current))*float64(p.Window))
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(wait.Seconds())))))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	return false
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+// TenantHeader names the tenant of a request made without a token.
+const TenantHeader = "X-Tenant-ID"
+
+type tenantKey struct{}
+
+// WithTenant stores the request's tenant. Authentication sets it from the
+// token before Tenant runs, and the token's tenant always wins.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant resolved for the request, or "".
+func TenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// Tenant resolves the request's tenant: a tenant already on the context,
+// then the X-Tenant-ID header, then defaultTenant. A header naming another
+// tenant than the token's is rejected rather than ignored. With an empty
+// defaultTenant requests must name their tenant.
+func Tenant(defaultTenant string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(TenantHeader)
+		if tenant := TenantFrom(r.Context()); tenant != "" {
+			if header != "" && header != tenant {
+				http.Error(w, "Tenant header does not match the token", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		tenant := header
+		if tenant == "" {
+			tenant = defaultTenant
+		}
+		if tenant == "" {
+			http.Error(w, "Missing "+TenantHeader+" header", http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), tenant)))
+	})
+}
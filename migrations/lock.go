@@ -0,0 +1,89 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	locksCollection = "migration_locks"
+	lockID          = "migrations"
+	lockLease       = 2 * time.Minute
+	lockRetry       = 2 * time.Second
+)
+
+var ErrLockHeld = errors.New("migration lock is held by another process")
+
+// ErrLockLost aborts a run whose lease could not be refreshed.
+var ErrLockLost = errors.New("migration lock was lost")
+
+// Lock is a lease stored in a single document. A replica owns the lock while
+// the document carries its owner ID and an unexpired lease; a crashed owner
+// therefore blocks others for at most one lease period.
+type Lock struct {
+	db    *mongo.Database
+	owner string
+}
+
+func NewLock(db *mongo.Database, owner string) *Lock {
+	return &Lock{db: db, owner: owner}
+}
+
+// Acquire waits until the lock is free or the context is done.
+func (l *Lock) Acquire(ctx context.Context) error {
+	for {
+		err := l.TryAcquire(ctx)
+		if !errors.Is(err, ErrLockHeld) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ErrLockHeld
+		case <-time.After(lockRetry):
+		}
+	}
+}
+
+// TryAcquire takes the lock if it is free, expired or already ours.
+func (l *Lock) TryAcquire(ctx context.Context) error {
+	now := time.Now()
+	filter := bson.M{
+		"_id": lockID,
+		"$or": bson.A{
+			bson.M{"expiresAt": bson.M{"$lt": now}},
+			bson.M{"owner": l.owner},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": l.owner, "acquiredAt": now, "expiresAt": now.Add(lockLease)}}
+	//the upsert inserts the lock document the first time; when another owner
+	//holds it the filter misses and the insert hits the duplicate _id
+	_, err := l.db.Collection(locksCollection).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrLockHeld
+	}
+	return err
+}
+
+// Refresh extends the lease of a lock we hold.
+func (l *Lock) Refresh(ctx context.Context) error {
+	filter := bson.M{"_id": lockID, "owner": l.owner}
+	update := bson.M{"$set": bson.M{"expiresAt": time.Now().Add(lockLease)}}
+	result, err := l.db.Collection(locksCollection).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrLockHeld
+	}
+	return nil
+}
+
+func (l *Lock) Release(ctx context.Context) error {
+	_, err := l.db.Collection(locksCollection).DeleteOne(ctx, bson.M{"_id": lockID, "owner": l.owner})
+	return err
+}
@@ -0,0 +1,257 @@
+package migrations
+
+import (
+	"Users/models"
+	"Users/search"
+	"Users/utils"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// All returns the service's migrations. Versions are never reused or
+// reordered once released; add new migrations at the end.
+func All(canon *utils.Canonicalizer) []Migration {
+	return []Migration{
+		{
+			Version: 1,
+			Name:    "backfill_user_status",
+			Up:      backfillStatus,
+		},
+		{
+			Version: 2,
+			Name:    "split_canonical_identifiers",
+			Up:      backfillCanonical(canon),
+			Down:    dropCanonical,
+		},
+		{
+			Version: 3,
+			Name:    "backfill_search_grams",
+			Up:      backfillSearchGrams,
+			Down:    dropSearchGrams,
+		},
+		{
+			//irreversible: once tenants share identifiers the old global
+			//unique indexes cannot be rebuilt
+			Version: 4,
+			Name:    "assign_default_tenant",
+			Up:      assignDefaultTenant,
+		},
+		{
+			Version: 5,
+			Name:    "text_index_display_name",
+			Up:      replaceTextIndex(oldTextIndex, newTextIndex),
+			Down:    replaceTextIndex(newTextIndex, oldTextIndex),
+		},
+	}
+}
+
+// ------BACKFILL STATUS------
+// users created before statuses existed have no status field
+func backfillStatus(ctx context.Context, db *mongo.Database, dryRun bool) (int64, error) {
+	collection := db.Collection("users")
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": bson.M{"$exists": false}},
+		bson.M{"status": ""},
+	}}
+	if dryRun {
+		return collection.CountDocuments(ctx, filter)
+	}
+	result, err := collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"status": "active"}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// ------CANONICAL IDENTIFIERS------
+// splits username and email into the display form users typed and the
+// canonical form uniqueness is enforced on
+func backfillCanonical(canon *utils.Canonicalizer) Step {
+	return func(ctx context.Context, db *mongo.Database, dryRun bool) (int64, error) {
+		collection := db.Collection("users")
+		filter := bson.M{"$or": bson.A{
+			bson.M{"emailCanonical": bson.M{"$exists": false}},
+			bson.M{"username": bson.M{"$nin": bson.A{"", nil}}, "usernameCanonical": bson.M{"$exists": false}},
+		}}
+		if dryRun {
+			return collection.CountDocuments(ctx, filter)
+		}
+		projection := options.Find().SetProjection(bson.M{"email": 1, "username": 1})
+		cursor, err := collection.Find(ctx, filter, projection)
+		if err != nil {
+			return 0, err
+		}
+		defer cursor.Close(ctx)
+
+		var updated int64
+		var conflicts []string
+		for cursor.Next(ctx) {
+			var doc struct {
+				ID       primitive.ObjectID `bson:"_id"`
+				Email    string             `bson:"email"`
+				Username string             `bson:"username"`
+			}
+			if err := cursor.Decode(&doc); err != nil {
+				return updated, err
+			}
+			set := bson.M{
+				"email":          canon.Display(doc.Email),
+				"emailCanonical": canon.Email(doc.Email),
+			}
+			if doc.Username != "" {
+				set["username"] = canon.Display(doc.Username)
+				set["usernameCanonical"] = canon.Username(doc.Username)
+			}
+			_, err := collection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{"$set": set})
+			if mongo.IsDuplicateKeyError(err) {
+				//two legacy accounts that only differ in case; leave both
+				//untouched for an operator to merge and fail the migration
+				conflicts = append(conflicts, doc.ID.Hex())
+				continue
+			}
+			if err != nil {
+				return updated, err
+			}
+			updated++
+		}
+		if err := cursor.Err(); err != nil {
+			return updated, err
+		}
+		if len(conflicts) > 0 {
+			return updated, fmt.Errorf("duplicate canonical identifiers for users %s", strings.Join(conflicts, ", "))
+		}
+		return updated, nil
+	}
+}
+
+func dropCanonical(ctx context.Context, db *mongo.Database, dryRun bool) (int64, error) {
+	collection := db.Collection("users")
+	filter := bson.M{"$or": bson.A{
+		bson.M{"emailCanonical": bson.M{"$exists": true}},
+		bson.M{"usernameCanonical": bson.M{"$exists": true}},
+	}}
+	if dryRun {
+		return collection.CountDocuments(ctx, filter)
+	}
+	result, err := collection.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{"emailCanonical": "", "usernameCanonical": ""}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// ------SEARCH GRAMS------
+func backfillSearchGrams(ctx context.Context, db *mongo.Database, dryRun bool) (int64, error) {
+	collection := db.Collection("users")
+	filter := bson.M{"searchGrams": bson.M{"$exists": false}}
+	if dryRun {
+		return collection.CountDocuments(ctx, filter)
+	}
+	projection := options.Find().SetProjection(bson.M{"email": 1, "username": 1})
+	cursor, err := collection.Find(ctx, filter, projection)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+	var updated int64
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			Email    string             `bson:"email"`
+			Username string             `bson:"username"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return updated, err
+		}
+		grams := search.UserTrigrams(&models.User{Email: doc.Email, Username: doc.Username})
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{"searchGrams": grams}}); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, cursor.Err()
+}
+
+func dropSearchGrams(ctx context.Context, db *mongo.Database, dryRun bool) (int64, error) {
+	collection := db.Collection("users")
+	filter := bson.M{"searchGrams": bson.M{"$exists": true}}
+	if dryRun {
+		return collection.CountDocuments(ctx, filter)
+	}
+	result, err := collection.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{"searchGrams": ""}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// ------DEFAULT TENANT------
+// users and jobs written before tenants existed belong to the default
+// tenant; the global unique indexes are replaced by the per-tenant ones
+// declared in the schema
+func assignDefaultTenant(ctx context.Context, db *mongo.Database, dryRun bool) (int64, error) {
+	filter := bson.M{"tenantId": bson.M{"$exists": false}}
+	users := db.Collection("users")
+	if dryRun {
+		return users.CountDocuments(ctx, filter)
+	}
+	result, err := users.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"tenantId": models.DefaultTenant}})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := db.Collection("jobs").UpdateMany(ctx, filter, bson.M{"$set": bson.M{"tenantId": models.DefaultTenant}}); err != nil {
+		return result.ModifiedCount, err
+	}
+	for _, name := range []string{"uniq_email_canonical", "uniq_username_canonical"} {
+		_, err := users.Indexes().DropOne(ctx, name)
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Name == "IndexNotFound" {
+			continue
+		}
+		if err != nil {
+			return result.ModifiedCount, err
+		}
+	}
+	return result.ModifiedCount, nil
+}
+
+// ------TEXT INDEX------
+// mongo allows one text index per collection, so the schema cannot add the
+// display name index next to the old one; it reports the old one as drift
+// until this migration swaps them
+var (
+	oldTextIndex = mongo.IndexModel{
+		Keys:    bson.D{{Key: "username", Value: "text"}, {Key: "email", Value: "text"}},
+		Options: options.Index().SetName("text_username_email"),
+	}
+	newTextIndex = mongo.IndexModel{
+		Keys:    bson.D{{Key: "username", Value: "text"}, {Key: "email", Value: "text"}, {Key: "profile.displayName", Value: "text"}},
+		Options: options.Index().SetName("text_username_email_displayName"),
+	}
+)
+
+func replaceTextIndex(from, to mongo.IndexModel) Step {
+	return func(ctx context.Context, db *mongo.Database, dryRun bool) (int64, error) {
+		users := db.Collection("users")
+		if dryRun {
+			return 0, nil
+		}
+		_, err := users.Indexes().DropOne(ctx, *from.Options.Name)
+		var cmdErr mongo.CommandError
+		if err != nil && !(errors.As(err, &cmdErr) && cmdErr.Name == "IndexNotFound") {
+			return 0, err
+		}
+		//a fresh database already has the index from the schema
+		if _, err := users.Indexes().CreateOne(ctx, to); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+}
@@ -0,0 +1,224 @@
+// Package migrations runs versioned, Go-defined data migrations against the
+// service database and records which ones have been applied.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const versionsCollection = "schema_migrations"
+
+// Step applies or reverts a migration. In dry-run mode it must not write and
+// should return how many documents it would have touched.
+type Step func(ctx context.Context, db *mongo.Database, dryRun bool) (int64, error)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      Step
+	Down    Step
+}
+
+// AppliedMigration is the record kept in schema_migrations.
+type AppliedMigration struct {
+	Version    int       `bson:"_id" json:"version"`
+	Name       string    `bson:"name" json:"name"`
+	AppliedAt  time.Time `bson:"appliedAt" json:"applied_at"`
+	DurationMs int64     `bson:"durationMs" json:"duration_ms"`
+}
+
+// Result describes one migration handled by a run.
+type Result struct {
+	Version  int
+	Name     string
+	Affected int64
+	DryRun   bool
+}
+
+type Options struct {
+	DryRun bool
+	//stop after this version when migrating up, 0 means latest
+	Target int
+}
+
+type Runner struct {
+	db         *mongo.Database
+	migrations []Migration
+	lock       *Lock
+}
+
+func NewRunner(db *mongo.Database, migrations []Migration, owner string) (*Runner, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version <= 0 || m.Up == nil {
+			return nil, fmt.Errorf("migration %d %q is incomplete", m.Version, m.Name)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("duplicate migration version %d", m.Version)
+		}
+	}
+	return &Runner{db: db, migrations: sorted, lock: NewLock(db, owner)}, nil
+}
+
+// Applied returns the recorded migrations in version order.
+func (r *Runner) Applied(ctx context.Context) ([]AppliedMigration, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := r.db.Collection(versionsCollection).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var applied []AppliedMigration
+	if err := cursor.All(ctx, &applied); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// Pending returns the known migrations that have not been applied yet.
+func (r *Runner) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := r.appliedSet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, m := range r.migrations {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies pending migrations in order while holding the migration lock.
+func (r *Runner) Up(ctx context.Context, opts Options) ([]Result, error) {
+	var results []Result
+	err := r.withLock(ctx, opts.DryRun, func(ctx context.Context) error {
+		pending, err := r.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range pending {
+			if opts.Target > 0 && m.Version > opts.Target {
+				break
+			}
+			started := time.Now()
+			affected, err := m.Up(ctx, r.db, opts.DryRun)
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+			}
+			results = append(results, Result{Version: m.Version, Name: m.Name, Affected: affected, DryRun: opts.DryRun})
+			if opts.DryRun {
+				continue
+			}
+			record := AppliedMigration{
+				Version:    m.Version,
+				Name:       m.Name,
+				AppliedAt:  time.Now(),
+				DurationMs: time.Since(started).Milliseconds(),
+			}
+			if _, err := r.db.Collection(versionsCollection).InsertOne(ctx, record); err != nil {
+				return fmt.Errorf("recording migration %d: %w", m.Version, err)
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+// Down reverts the most recently applied migrations, newest first.
+func (r *Runner) Down(ctx context.Context, steps int, opts Options) ([]Result, error) {
+	if steps <= 0 {
+		return nil, errors.New("rollback needs at least one step")
+	}
+	byVersion := map[int]Migration{}
+	for _, m := range r.migrations {
+		byVersion[m.Version] = m
+	}
+	var results []Result
+	err := r.withLock(ctx, opts.DryRun, func(ctx context.Context) error {
+		applied, err := r.Applied(ctx)
+		if err != nil {
+			return err
+		}
+		for i := len(applied) - 1; i >= 0 && len(results) < steps; i-- {
+			m, ok := byVersion[applied[i].Version]
+			if !ok {
+				return fmt.Errorf("migration %d is applied but unknown to this build", applied[i].Version)
+			}
+			if m.Down == nil {
+				return fmt.Errorf("migration %d %s cannot be rolled back", m.Version, m.Name)
+			}
+			affected, err := m.Down(ctx, r.db, opts.DryRun)
+			if err != nil {
+				return fmt.Errorf("rolling back migration %d %s: %w", m.Version, m.Name, err)
+			}
+			results = append(results, Result{Version: m.Version, Name: m.Name, Affected: affected, DryRun: opts.DryRun})
+			if opts.DryRun {
+				continue
+			}
+			if _, err := r.db.Collection(versionsCollection).DeleteOne(ctx, bson.M{"_id": m.Version}); err != nil {
+				return fmt.Errorf("removing migration record %d: %w", m.Version, err)
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+// dry runs only read, so they don't need to wait for the lock. fn gets a
+// context that is cancelled once the lease cannot be refreshed, as another
+// replica may take the lock from then on and must not run alongside.
+func (r *Runner) withLock(ctx context.Context, dryRun bool, fn func(ctx context.Context) error) error {
+	if dryRun {
+		return fn(ctx)
+	}
+	if err := r.lock.Acquire(ctx); err != nil {
+		return err
+	}
+	defer r.lock.Release(context.Background())
+
+	//keeping the lease alive while long migrations run
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		ticker := time.NewTicker(lockLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.lock.Refresh(ctx); err != nil {
+					cancel(fmt.Errorf("%w: %w", ErrLockLost, err))
+					return
+				}
+			}
+		}
+	}()
+	err := fn(ctx)
+	if cause := context.Cause(ctx); errors.Is(cause, ErrLockLost) {
+		return cause
+	}
+	return err
+}
+
+func (r *Runner) appliedSet(ctx context.Context) (map[int]bool, error) {
+	applied, err := r.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	set := map[int]bool{}
+	for _, a := range applied {
+		set[a.Version] = true
+	}
+	return set, nil
+}
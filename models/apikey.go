@@ -0,0 +1,49 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// scopes an API key can be granted; each route names the ones it accepts
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeEmailsRead = "emails:read"
+)
+
+var APIKeyScopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeEmailsRead}
+
+// APIKey is a credential for services calling the API on their own behalf.
+// Only the hash of its secret is kept; the prefix identifies it in listings
+// and lookups.
+type APIKey struct {
+	ID         string     `bson:"_id,omitempty" json:"id"`
+	TenantID   string     `bson:"tenantId" json:"-"`
+	Name       string     `bson:"name" json:"name"`
+	Prefix     string     `bson:"prefix" json:"prefix"`
+	SecretHash string     `bson:"secretHash" json:"-"`
+	Scopes     []string   `bson:"scopes" json:"scopes"`
+	CreatedBy  string     `bson:"createdBy,omitempty" json:"created_by,omitempty"`
+	CreatedAt  time.Time  `bson:"createdAt" json:"created_at"`
+	ExpiresAt  *time.Time `bson:"expiresAt,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `bson:"lastUsedAt,omitempty" json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `bson:"revokedAt,omitempty" json:"revoked_at,omitempty"`
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// APIKeyRequest creates an API key; without ExpiresAt it never expires.
+type APIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyCreated carries the full key, shown only once at creation.
+type APIKeyCreated struct {
+	APIKey
+	Key string `json:"key"`
+}
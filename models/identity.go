@@ -0,0 +1,92 @@
+package models
+
+import "time"
+
+// IdentityProvider is an upstream OpenID Connect provider users may sign in
+// with, as configured in OIDC_PROVIDERS_FILE.
+type IdentityProvider struct {
+	ID           string       `json:"id"`
+	Name         string       `json:"name"`
+	Issuer       string       `json:"issuer"`
+	ClientID     string       `json:"client_id"`
+	ClientSecret string       `json:"client_secret"`
+	RedirectURI  string       `json:"redirect_uri"`
+	Scopes       []string     `json:"scopes"`
+	Claims       ClaimMapping `json:"claims"`
+	//TrustEmail treats every email the provider sends as verified, for
+	//providers that only hand out addresses they own but omit
+	//email_verified
+	TrustEmail bool `json:"trust_email"`
+	//AllowSignup creates an account for identities that match none
+	AllowSignup bool `json:"allow_signup"`
+	//Tenants limits the provider to some tenants; empty allows all
+	Tenants []string `json:"tenants"`
+}
+
+// ClaimMapping names the upstream claims user fields are taken from.
+type ClaimMapping struct {
+	Email         string `json:"email"`
+	EmailVerified string `json:"email_verified"`
+	Username      string `json:"username"`
+	Name          string `json:"name"`
+}
+
+// IdentityProviderInfo is what clients are told about a provider.
+type IdentityProviderInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ExternalIdentity is a user as an upstream provider vouched for them, with
+// their claims mapped.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	Name          string
+}
+
+// Identity links a user to their account at an upstream provider. Users
+// created at their first sign in are Provisioned by the identity.
+type Identity struct {
+	ID          string     `bson:"_id,omitempty" json:"-"`
+	TenantID    string     `bson:"tenantId,omitempty" json:"-"`
+	UserID      string     `bson:"userId" json:"-"`
+	Provider    string     `bson:"provider" json:"provider"`
+	Subject     string     `bson:"subject" json:"subject"`
+	Email       string     `bson:"email,omitempty" json:"email,omitempty"`
+	Provisioned bool       `bson:"provisioned" json:"provisioned"`
+	LinkedAt    time.Time  `bson:"linkedAt" json:"linked_at"`
+	LastLoginAt *time.Time `bson:"lastLoginAt,omitempty" json:"last_login_at,omitempty"`
+}
+
+// FederationState is the server side of a sign in or link at an upstream
+// provider in progress; it is consumed by the first attempt to finish it.
+// UserID is set when linking.
+type FederationState struct {
+	ID        string    `bson:"_id"`
+	TenantID  string    `bson:"tenantId"`
+	Provider  string    `bson:"provider"`
+	UserID    string    `bson:"userId,omitempty"`
+	Nonce     string    `bson:"nonce"`
+	Verifier  string    `bson:"verifier"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// FederatedLoginStart sends the user to AuthorizationURL; the provider
+// redirects back with State, which the client checks before finishing.
+type FederatedLoginStart struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+}
+
+// FederatedCallback carries the parameters the provider redirected back
+// with, passed on unchanged by the client.
+type FederatedCallback struct {
+	State            string `json:"state"`
+	Code             string `json:"code"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// AttemptCounter tracks recent failed logins for one account or address.
+type AttemptCounter struct {
+	Key           string    `bson:"key" json:"-"`
+	Count         int       `bson:"count" json:"count"`
+	LastFailureAt time.Time `bson:"lastFailureAt" json:"last_failure_at"`
+	ExpiresAt     time.Time `bson:"expiresAt" json:"-"`
+}
@@ -0,0 +1,123 @@
+package models
+
+import "time"
+
+// grant types a client can be registered for
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+)
+
+var OAuthGrantTypes = []string{GrantAuthorizationCode, GrantClientCredentials}
+
+// OAuthClient is an application that delegates login to this service or
+// calls resource servers on its own behalf. Public clients, such as single
+// page and native apps, cannot keep a secret and may only use the
+// authorization code flow with PKCE.
+type OAuthClient struct {
+	ID           string    `bson:"_id" json:"client_id"`
+	TenantID     string    `bson:"tenantId" json:"-"`
+	Name         string    `bson:"name" json:"name"`
+	SecretHash   string    `bson:"secretHash,omitempty" json:"-"`
+	Public       bool      `bson:"public" json:"public"`
+	RedirectURIs []string  `bson:"redirectUris" json:"redirect_uris"`
+	GrantTypes   []string  `bson:"grantTypes" json:"grant_types"`
+	Scopes       []string  `bson:"scopes" json:"scopes"`
+	CreatedBy    string    `bson:"createdBy,omitempty" json:"created_by,omitempty"`
+	CreatedAt    time.Time `bson:"createdAt" json:"created_at"`
+}
+
+// OAuthClientRequest registers a client. Without GrantTypes it gets the
+// authorization code grant.
+type OAuthClientRequest struct {
+	Name         string   `json:"name"`
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types"`
+	Scopes       []string `json:"scopes"`
+}
+
+// OAuthClientCreated carries the client secret, shown only once at
+// registration.
+type OAuthClientCreated struct {
+	OAuthClient
+	Secret string `json:"client_secret,omitempty"`
+}
+
+// AuthorizationRequest holds the parameters of a request to the
+// authorization endpoint. Nonce, Prompt and MaxAge are the OpenID Connect
+// additions.
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	Prompt              string
+	MaxAge              string
+}
+
+// AuthorizationCode is a one time code handed to the client's redirect URI;
+// it is stored under the hash of the code. AuthTime is when the user logged
+// in, which may predate the code.
+type AuthorizationCode struct {
+	ID            string    `bson:"_id"`
+	TenantID      string    `bson:"tenantId"`
+	ClientID      string    `bson:"clientId"`
+	UserID        string    `bson:"userId"`
+	RedirectURI   string    `bson:"redirectUri,omitempty"`
+	Scopes        []string  `bson:"scopes"`
+	CodeChallenge string    `bson:"codeChallenge"`
+	Nonce         string    `bson:"nonce,omitempty"`
+	AuthTime      time.Time `bson:"authTime"`
+	ExpiresAt     time.Time `bson:"expiresAt"`
+}
+
+// OAuthToken is an issued access token, stored under the hash of the token.
+// UserID is empty for tokens a client obtained for itself.
+type OAuthToken struct {
+	ID        string    `bson:"_id"`
+	TenantID  string    `bson:"tenantId"`
+	ClientID  string    `bson:"clientId"`
+	UserID    string    `bson:"userId,omitempty"`
+	Scopes    []string  `bson:"scopes"`
+	IssuedAt  time.Time `bson:"issuedAt"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// TokenResponse answers a successful token request (RFC 6749 section 5.1).
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+	IDToken     string `json:"id_token,omitempty"`
+}
+
+// Introspection answers a token introspection request (RFC 7662); inactive
+// tokens carry nothing but Active.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+}
+
+// Consent records the scopes a user granted a client, so later logins to
+// the same client skip the consent page.
+type Consent struct {
+	ID        string    `bson:"_id,omitempty" json:"-"`
+	TenantID  string    `bson:"tenantId" json:"-"`
+	UserID    string    `bson:"userId" json:"-"`
+	ClientID  string    `bson:"clientId" json:"client_id"`
+	Scopes    []string  `bson:"scopes" json:"scopes"`
+	GrantedAt time.Time `bson:"grantedAt" json:"granted_at"`
+}
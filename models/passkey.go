@@ -0,0 +1,71 @@
+package models
+
+import (
+	"Users/webauthn"
+	"time"
+)
+
+// Passkey is a WebAuthn credential registered by a user. The credential ID
+// is the base64url form used by browsers.
+type Passkey struct {
+	ID             string     `bson:"_id,omitempty" json:"-"`
+	TenantID       string     `bson:"tenantId,omitempty" json:"-"`
+	UserID         string     `bson:"userId" json:"-"`
+	CredentialID   string     `bson:"credentialId" json:"id"`
+	Name           string     `bson:"name" json:"name"`
+	PublicKey      []byte     `bson:"publicKey" json:"-"`
+	Algorithm      int64      `bson:"algorithm" json:"algorithm"`
+	SignCount      uint32     `bson:"signCount" json:"-"`
+	AAGUID         string     `bson:"aaguid,omitempty" json:"aaguid,omitempty"`
+	Transports     []string   `bson:"transports,omitempty" json:"transports,omitempty"`
+	BackupEligible bool       `bson:"backupEligible" json:"backup_eligible"`
+	BackupState    bool       `bson:"backupState" json:"synced"`
+	CreatedAt      time.Time  `bson:"createdAt" json:"created_at"`
+	LastUsedAt     *time.Time `bson:"lastUsedAt,omitempty" json:"last_used_at,omitempty"`
+}
+
+const (
+	CeremonyRegistration = "registration"
+	CeremonyLogin        = "login"
+)
+
+// WebAuthnCeremony is the server side of a registration or login in
+// progress; it is consumed by the first attempt to finish it.
+type WebAuthnCeremony struct {
+	ID        string    `bson:"_id"`
+	TenantID  string    `bson:"tenantId"`
+	Purpose   string    `bson:"purpose"`
+	UserID    string    `bson:"userId,omitempty"`
+	Challenge []byte    `bson:"challenge"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// PasskeyCreationOptions starts a registration; Session is passed back with
+// the created credential.
+type PasskeyCreationOptions struct {
+	Session   string                   `json:"session"`
+	PublicKey webauthn.CreationOptions `json:"publicKey"`
+}
+
+type PasskeyRequestOptions struct {
+	Session   string                  `json:"session"`
+	PublicKey webauthn.RequestOptions `json:"publicKey"`
+}
+
+type PasskeyRegistration struct {
+	Session    string                       `json:"session"`
+	Name       string                       `json:"name"`
+	Credential webauthn.AttestationResponse `json:"credential"`
+}
+
+// PasskeyLoginStart optionally names the account, to offer only its
+// passkeys; without it any discoverable passkey may be used.
+type PasskeyLoginStart struct {
+	Email    string `json:"email"`
+	Username string `json:"username"`
+}
+
+type PasskeyLogin struct {
+	Session    string                     `json:"session"`
+	Credential webauthn.AssertionResponse `json:"credential"`
+}
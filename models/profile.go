@@ -0,0 +1,123 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Profile holds the optional, user-editable details shown about a user.
+type Profile struct {
+	DisplayName string `bson:"displayName,omitempty" json:"display_name,omitempty"`
+	Locale      string `bson:"locale,omitempty" json:"locale,omitempty"`
+	Timezone    string `bson:"timezone,omitempty" json:"timezone,omitempty"`
+	Phone       string `bson:"phone,omitempty" json:"phone,omitempty"`
+	AvatarURL   string `bson:"avatarUrl,omitempty" json:"avatar_url,omitempty"`
+}
+
+// IsZero reports whether no profile field is set.
+func (p *Profile) IsZero() bool {
+	return p == nil || *p == Profile{}
+}
+
+// Attributes are custom fields described by the stored attribute schema.
+// They hold plain JSON values and are stored as an embedded document.
+type Attributes map[string]any
+
+// ErrAttributeName is returned for attribute names mongo would read as
+// operators or paths.
+var ErrAttributeName = errors.New("attribute names cannot start with '$' or contain '.'")
+
+// CheckNames rejects names, at any depth, that cannot be stored as given.
+func (a Attributes) CheckNames() error {
+	_, err := bsonValue(map[string]any(a))
+	return err
+}
+
+// MarshalBSON converts the JSON values to their BSON counterparts directly,
+// so what is stored is what the schema validated: numbers become int64 when
+// integral and doubles otherwise, and nothing is read as extended JSON.
+func (a Attributes) MarshalBSON() ([]byte, error) {
+	doc, err := bsonValue(map[string]any(a))
+	if err != nil {
+		return nil, err
+	}
+	return bson.Marshal(doc)
+}
+
+// bsonValue converts a decoded JSON value for the driver
+func bsonValue(value any) (any, error) {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case float64:
+		//plain decoding turns every number into a float64
+		if v == math.Trunc(v) && math.Abs(v) <= maxExactInteger {
+			return int64(v), nil
+		}
+		return v, nil
+	case map[string]any:
+		doc := bson.M{}
+		for key, item := range v {
+			if strings.HasPrefix(key, "$") || strings.Contains(key, ".") {
+				return nil, fmt.Errorf("%w: %q", ErrAttributeName, key)
+			}
+			converted, err := bsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			doc[key] = converted
+		}
+		return doc, nil
+	case []any:
+		list := make(bson.A, len(v))
+		for i, item := range v {
+			converted, err := bsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = converted
+		}
+		return list, nil
+	}
+	return value, nil
+}
+
+// maxExactInteger is the largest integer every float64 below holds exactly
+const maxExactInteger = 1 << 53
+
+// UnmarshalBSON decodes the attributes back into plain JSON values instead
+// of the driver's bson.D and primitive types.
+func (a *Attributes) UnmarshalBSON(data []byte) error {
+	ext, err := bson.MarshalExtJSON(bson.Raw(data), false, false)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(ext))
+	dec.UseNumber()
+	var values map[string]any
+	if err := dec.Decode(&values); err != nil {
+		return err
+	}
+	*a = values
+	return nil
+}
+
+// AttributeSchema is the JSON Schema custom attributes are validated
+// against. Scope is the tenant owning the schema; Version increases on
+// every change so compiled schemas can be cached.
+type AttributeSchema struct {
+	Scope     string          `json:"scope"`
+	Schema    json.RawMessage `json:"schema"`
+	Version   int             `json:"version"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
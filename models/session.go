@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Session is a signed-in device. Login creates one and hands out its token;
+// only the hash of the token's secret is kept. Sessions end when revoked,
+// after IdleTimeout without use or at ExpiresAt, whichever comes first.
+type Session struct {
+	ID         string    `bson:"_id" json:"id"`
+	TenantID   string    `bson:"tenantId" json:"-"`
+	UserID     string    `bson:"userId" json:"user_id"`
+	SecretHash string    `bson:"secretHash" json:"-"`
+	UserAgent  string    `bson:"userAgent,omitempty" json:"user_agent,omitempty"`
+	Browser    string    `bson:"browser,omitempty" json:"browser,omitempty"`
+	OS         string    `bson:"os,omitempty" json:"os,omitempty"`
+	IP         string    `bson:"ip,omitempty" json:"ip,omitempty"`
+	CreatedAt  time.Time `bson:"createdAt" json:"created_at"`
+	LastSeenAt time.Time `bson:"lastSeenAt" json:"last_seen_at"`
+	ExpiresAt  time.Time `bson:"expiresAt" json:"expires_at"`
+	//Current marks the session the listing was requested with
+	Current bool `bson:"-" json:"current,omitempty"`
+}
+
+// LoginResponse answers a completed login with the new session's bearer
+// token.
+type LoginResponse struct {
+	Message   string    `json:"message"`
+	Token     string    `json:"token"`
+	SessionID string    `json:"session_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// signing key states, derived from a key's schedule
+const (
+	KeyPending = "pending"
+	KeyActive  = "active"
+	KeyRetired = "retired"
+	KeyExpired = "expired"
+	KeyRevoked = "revoked"
+)
+
+// SigningKey is a key ID tokens are signed with, named by its key ID. The
+// private key is stored sealed and the public key in the clear, so the key
+// can be published by replicas that cannot open it.
+//
+// A key is published from creation, signs from ActivatesAt until RetiresAt,
+// and stays published until ExpiresAt so tokens signed with it verify until
+// they expire. Revoking a key unpublishes it at once.
+type SigningKey struct {
+	ID          string     `bson:"_id" json:"kid"`
+	Algorithm   string     `bson:"algorithm" json:"alg"`
+	PrivateKey  string     `bson:"privateKey" json:"-"`
+	PublicKey   []byte     `bson:"publicKey" json:"-"`
+	CreatedBy   string     `bson:"createdBy,omitempty" json:"created_by,omitempty"`
+	CreatedAt   time.Time  `bson:"createdAt" json:"created_at"`
+	ActivatesAt time.Time  `bson:"activatesAt" json:"activates_at"`
+	RetiresAt   time.Time  `bson:"retiresAt" json:"retires_at"`
+	ExpiresAt   time.Time  `bson:"expiresAt" json:"expires_at"`
+	RevokedAt   *time.Time `bson:"revokedAt,omitempty" json:"revoked_at,omitempty"`
+}
+
+// Status tells where the key is in its schedule at now.
+func (k *SigningKey) Status(now time.Time) string {
+	switch {
+	case k.RevokedAt != nil:
+		return KeyRevoked
+	case !now.Before(k.ExpiresAt):
+		return KeyExpired
+	case !now.Before(k.RetiresAt):
+		return KeyRetired
+	case now.Before(k.ActivatesAt):
+		return KeyPending
+	}
+	return KeyActive
+}
+
+// Published reports whether tokens signed with the key still verify at now.
+func (k *SigningKey) Published(now time.Time) bool {
+	return k.RevokedAt == nil && now.Before(k.ExpiresAt)
+}
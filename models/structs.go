@@ -7,13 +7,187 @@ import (
 
 type User struct {
 	ID        string    `bson:"_id,omitempty" json:"id"`
+	TenantID  string    `bson:"tenantId,omitempty" json:"tenant_id,omitempty"`
 	Username  string    `bson:"username" json:"username"`
 	Email     string    `bson:"email" json:"email"`
 	Password  string    `bson:"password" json:"password"`
 	Status    string    `bson:"status" json:"status"`
+	Role      string    `bson:"role,omitempty" json:"role"`
 	CreatedAt time.Time `bson:"createdAt" json:"created_at"`
+	//optional details and schema-described custom attributes
+	Profile    *Profile   `bson:"profile,omitempty" json:"profile,omitempty"`
+	Attributes Attributes `bson:"attributes,omitempty" json:"attributes,omitempty"`
+	//end of a temporary lockout, set only while the status is locked
+	LockedUntil *time.Time `bson:"lockedUntil,omitempty" json:"locked_until,omitempty"`
+	//second factor, managed through the 2fa endpoints only
+	TwoFactor *TwoFactor `bson:"twoFactor,omitempty" json:"-"`
+	//canonical forms used for uniqueness and lookups
+	UsernameCanonical string `bson:"usernameCanonical,omitempty" json:"-"`
+	EmailCanonical    string `bson:"emailCanonical,omitempty" json:"-"`
+	//trigrams of the identifiers, used for typo tolerant search
+	SearchGrams []string `bson:"searchGrams,omitempty" json:"-"`
 }
 
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	//StatusLocked is set by the lockout after repeated failed logins and
+	//lifts itself at LockedUntil
+	StatusLocked = "locked"
+
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// UserFilter narrows ListUsers. Query matches the start of the canonical
+// email or username.
+type UserFilter struct {
+	Query  string `json:"query"`
+	Status string `json:"status"`
+	Role   string `json:"role"`
+	Limit  int64  `json:"limit"`
+}
+
+// ImportReport summarizes a bulk import. Rows lists every row that was not
+// written cleanly plus, in dry-run mode, what would happen to each row.
+type ImportReport struct {
+	DryRun  bool              `json:"dry_run"`
+	Total   int               `json:"total"`
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Skipped int               `json:"skipped"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows,omitempty"`
+}
+
+type ImportRowResult struct {
+	Line   int    `json:"line"`
+	Email  string `json:"email"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+const (
+	BulkSuspend    = "suspend"
+	BulkActivate   = "activate"
+	BulkDelete     = "delete"
+	BulkAssignRole = "assign_role"
+)
+
+// BulkRequest targets users either by ID or by filter, never both.
+type BulkRequest struct {
+	IDs    []string    `json:"ids"`
+	Filter *UserFilter `json:"filter"`
+	Action string      `json:"action"`
+	Role   string      `json:"role"`
+	Async  bool        `json:"async"`
+}
+
+type BulkItem struct {
+	ID     string `bson:"id" json:"id"`
+	Result string `bson:"result" json:"result"`
+	Error  string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+type BulkResult struct {
+	Action    string     `bson:"action" json:"action"`
+	Total     int        `bson:"total" json:"total"`
+	Succeeded int        `bson:"succeeded" json:"succeeded"`
+	Failed    int        `bson:"failed" json:"failed"`
+	Items     []BulkItem `bson:"items" json:"items"`
+}
+
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// BulkJob tracks an asynchronous bulk operation.
+type BulkJob struct {
+	ID         string      `bson:"_id,omitempty" json:"id"`
+	TenantID   string      `bson:"tenantId,omitempty" json:"-"`
+	Status     string      `bson:"status" json:"status"`
+	Action     string      `bson:"action" json:"action"`
+	Result     *BulkResult `bson:"result,omitempty" json:"result,omitempty"`
+	Error      string      `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt  time.Time   `bson:"createdAt" json:"created_at"`
+	FinishedAt *time.Time  `bson:"finishedAt,omitempty" json:"finished_at,omitempty"`
+	ExpiresAt  time.Time   `bson:"expiresAt" json:"-"`
+}
+
+// SearchHit is one ranked search result. Highlights hold HTML-escaped field
+// values with the matched part wrapped in <em>.
+type SearchHit struct {
+	User       User              `json:"user"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// Tenant is an organization whose users are isolated from every other
+// tenant's. The ID is a short slug used in headers and database names.
+type Tenant struct {
+	ID        string    `bson:"_id" json:"id"`
+	Name      string    `bson:"name" json:"name"`
+	Status    string    `bson:"status" json:"status"`
+	CreatedAt time.Time `bson:"createdAt" json:"created_at"`
+}
+
+// DefaultTenant owns the users created before tenants existed.
+const DefaultTenant = "default"
+
 type Message struct {
 	Message string `json:"message"`
 }
+
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Group is a named set of users and other groups of one tenant. Members of
+// a subgroup are effective members of every group containing it.
+type Group struct {
+	ID          string    `bson:"_id,omitempty" json:"id"`
+	TenantID    string    `bson:"tenantId,omitempty" json:"-"`
+	Name        string    `bson:"name" json:"name"`
+	Description string    `bson:"description,omitempty" json:"description,omitempty"`
+	Members     []string  `bson:"members" json:"members"`
+	Subgroups   []string  `bson:"subgroups" json:"subgroups"`
+	CreatedAt   time.Time `bson:"createdAt" json:"created_at"`
+	UpdatedAt   time.Time `bson:"updatedAt" json:"updated_at"`
+	//lowercased name, unique per tenant
+	NameCanonical string `bson:"nameCanonical" json:"-"`
+}
+
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+)
+
+// Invitation lets the owner of Email create an account with a role and
+// group memberships chosen by an admin.
+type Invitation struct {
+	ID             string     `bson:"_id,omitempty" json:"id"`
+	TenantID       string     `bson:"tenantId,omitempty" json:"-"`
+	Email          string     `bson:"email" json:"email"`
+	EmailCanonical string     `bson:"emailCanonical" json:"-"`
+	Role           string     `bson:"role" json:"role"`
+	Groups         []string   `bson:"groups,omitempty" json:"groups,omitempty"`
+	InvitedBy      string     `bson:"invitedBy,omitempty" json:"invited_by,omitempty"`
+	Status         string     `bson:"status" json:"status"`
+	CreatedAt      time.Time  `bson:"createdAt" json:"created_at"`
+	ExpiresAt      time.Time  `bson:"expiresAt" json:"expires_at"`
+	AcceptedAt     *time.Time `bson:"acceptedAt,omitempty" json:"accepted_at,omitempty"`
+	UserID         string     `bson:"userId,omitempty" json:"user_id,omitempty"`
+}
+
+// InvitationLink is returned once when an invitation is created; the token
+// is not stored and cannot be retrieved later.
+type InvitationLink struct {
+	Invitation *Invitation `json:"invitation"`
+	Token      string      `json:"token"`
+	URL        string      `json:"url"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// TwoFactor is a user's TOTP second factor. Secrets are sealed with the
+// server key and recovery codes are stored as hashes; neither is ever
+// serialized to clients.
+type TwoFactor struct {
+	Enabled bool `bson:"enabled"`
+	//Secret is the confirmed secret, PendingSecret one awaiting its first code
+	Secret        string     `bson:"secret,omitempty"`
+	PendingSecret string     `bson:"pendingSecret,omitempty"`
+	RecoveryCodes []string   `bson:"recoveryCodes,omitempty"`
+	EnabledAt     *time.Time `bson:"enabledAt,omitempty"`
+	//LastStep is the last accepted time step; codes are single use
+	LastStep int64 `bson:"lastStep,omitempty"`
+}
+
+// TwoFactorStatus is what clients may know about a user's second factor.
+type TwoFactorStatus struct {
+	Enabled                bool       `json:"enabled"`
+	Pending                bool       `json:"pending"`
+	EnabledAt              *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
+
+// TOTPEnrollment is returned when enrollment starts; the secret is shown
+// once, as text and as a QR code PNG data URL.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+	QRCode string `json:"qr_code"`
+}
+
+// RecoveryCodes are returned once when generated.
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// SecondFactorRequest completes a login that needs a second factor, with a
+// current code or one of the recovery codes.
+type SecondFactorRequest struct {
+	Token        string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// SecondFactorChallenge answers a correct password when the account has a
+// second factor; the token is passed back with the code.
+type SecondFactorChallenge struct {
+	Message     string `json:"message"`
+	MFARequired bool   `json:"mfa_required"`
+	Token       string `json:"mfa_token"`
+}
@@ -4,13 +4,314 @@ package repository
 
 import (
 	"Users/models"
+	"context"
+	"errors"
+	"time"
 )
 
+var (
+	ErrInvalidUserID    = errors.New("invalid user ID")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrUserExists       = errors.New("user already exists")
+	ErrNoFieldsToUpdate = errors.New("no fields to update")
+)
+
+// BulkItemResult is the outcome of one user in a bulk write.
+type BulkItemResult struct {
+	Created bool
+	Err     error
+}
+
 type UserRepository interface {
 	CreateUser(user *models.User) error
 	UpdateUser(user *models.User) error
+	//PatchUser stores the patchable fields of user exactly as given, so an
+	//empty username removes it; an empty password keeps the stored one
+	PatchUser(user *models.User) error
 	DeleteUser(user *models.User) error
 	FetchAllUsers() ([]models.User, error)
+	//StreamUsers calls fn for each matching user straight from the cursor,
+	//stopping at the first error fn returns
+	StreamUsers(ctx context.Context, filter models.UserFilter, fn func(*models.User) error) error
 	FetchUserByID(id string) (*models.User, error)
+	//lookups take the canonical form of the email or username
+	FetchUserByEmail(email string) (*models.User, error)
+	FetchUserByUsername(username string) (*models.User, error)
 	UpdateUserStatus(id string, status string) error
+	UpdateUserRole(id string, role string) error
+	ListUsers(filter models.UserFilter) ([]models.User, error)
+	//RestoreUser inserts a previously exported user as-is, keeping its ID,
+	//password hash, status and creation time
+	RestoreUser(user *models.User) error
+	//FetchUsersByCanonical returns users owning any of the canonical emails
+	//or usernames
+	FetchUsersByCanonical(emails []string, usernames []string) ([]models.User, error)
+	//BulkWriteUsers stores prepared users in one unordered batch. With
+	//updateExisting, a user whose canonical email already exists is updated
+	//instead of rejected. Results are aligned with the input.
+	BulkWriteUsers(users []*models.User, updateExisting bool) ([]BulkItemResult, error)
+	//BulkChangeUsers applies one change to every ID in a single unordered
+	//batch. Results are aligned with ids; missing users get ErrUserNotFound.
+	BulkChangeUsers(ids []string, change BulkUserChange) ([]BulkItemResult, error)
+	//FetchUserIDs returns the IDs of users matching filter, at most limit
+	FetchUserIDs(filter models.UserFilter, limit int64) ([]string, error)
+	//SearchUsers returns users matching query by prefix, substring or with
+	//small typos, best match first
+	SearchUsers(query string, limit int) ([]models.SearchHit, error)
+	//SetTwoFactor replaces the user's second factor; nil removes it
+	SetTwoFactor(id string, twoFactor *models.TwoFactor) error
+	//UseTOTPStep records step as used, failing with ErrCodeReused unless it
+	//is later than the last accepted one
+	UseTOTPStep(id string, step int64) error
+	//ConsumeRecoveryCode removes a recovery code hash, failing with
+	//ErrCodeReused when the user does not have it
+	ConsumeRecoveryCode(id string, hash string) error
+	//LockUser locks an active user until the given time; users in any other
+	//status are left as they are
+	LockUser(id string, until time.Time) error
+	//UnlockUser reactivates a user whose status is still locked
+	UnlockUser(id string) error
+}
+
+var ErrCodeReused = errors.New("code already used")
+
+// searchCandidates caps how many users a search ranks in memory
+const searchCandidates = 200
+
+// BulkUserChange is the change applied by BulkChangeUsers: either a delete
+// or new values for the non-empty fields.
+type BulkUserChange struct {
+	Delete bool
+	Status string
+	Role   string
+}
+
+var ErrJobNotFound = errors.New("job not found")
+
+type JobRepository interface {
+	CreateJob(job *models.BulkJob) error
+	UpdateJob(job *models.BulkJob) error
+	FetchJob(id string) (*models.BulkJob, error)
+}
+
+var ErrSchemaNotFound = errors.New("attribute schema not found")
+
+type AttributeSchemaRepository interface {
+	FetchAttributeSchema(scope string) (*models.AttributeSchema, error)
+	//SaveAttributeSchema replaces the scope's schema and bumps its version
+	SaveAttributeSchema(schema *models.AttributeSchema) error
+}
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// Blob is a stored binary object with the metadata needed to serve it.
+type Blob struct {
+	Data        []byte
+	ContentType string
+	ModTime     time.Time
+}
+
+// BlobStore keeps small binary objects such as avatar thumbnails. Keys are
+// slash separated relative paths chosen by the services, never by clients.
+type BlobStore interface {
+	PutBlob(key string, contentType string, data []byte) error
+	GetBlob(key string) (*Blob, error)
+	//DeleteBlob succeeds when the blob does not exist
+	DeleteBlob(key string) error
+}
+
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+	ErrTenantExists   = errors.New("tenant already exists")
+)
+
+// TenantRepository is the registry of tenants. It is global: it lives in
+// the service database whatever the tenant isolation mode.
+type TenantRepository interface {
+	CreateTenant(tenant *models.Tenant) error
+	FetchTenant(id string) (*models.Tenant, error)
+	ListTenants() ([]models.Tenant, error)
+}
+
+var (
+	ErrGroupNotFound = errors.New("group not found")
+	ErrGroupExists   = errors.New("group already exists")
+)
+
+// GroupRepository stores one tenant's groups. Membership changes are
+// idempotent: adding a present member or removing an absent one succeeds.
+type GroupRepository interface {
+	CreateGroup(group *models.Group) error
+	FetchGroup(id string) (*models.Group, error)
+	ListGroups() ([]models.Group, error)
+	RenameGroup(id string, name string, nameCanonical string) error
+	//DeleteGroup also removes the group from the groups containing it
+	DeleteGroup(id string) error
+	AddMember(groupID string, userID string) error
+	RemoveMember(groupID string, userID string) error
+	AddSubgroup(groupID string, childID string) error
+	RemoveSubgroup(groupID string, childID string) error
+	//RemoveUserFromGroups drops a deleted user from every group
+	RemoveUserFromGroups(userID string) error
+}
+
+var (
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvitationUsed     = errors.New("invitation already accepted")
+)
+
+type InvitationRepository interface {
+	CreateInvitation(inv *models.Invitation) error
+	FetchInvitation(id string) (*models.Invitation, error)
+	//MarkInvitationAccepted fails with ErrInvitationUsed unless the
+	//invitation is still pending, so each one is accepted once
+	MarkInvitationAccepted(id string, userID string) error
+}
+
+var (
+	ErrPasskeyNotFound  = errors.New("passkey not found")
+	ErrPasskeyExists    = errors.New("passkey already registered")
+	ErrStaleSignCount   = errors.New("passkey was used concurrently")
+	ErrCeremonyNotFound = errors.New("webauthn ceremony not found")
+)
+
+// PasskeyRepository stores one tenant's WebAuthn credentials and the
+// ceremonies in progress.
+type PasskeyRepository interface {
+	CreatePasskey(passkey *models.Passkey) error
+	FetchPasskey(credentialID string) (*models.Passkey, error)
+	ListPasskeys(userID string) ([]models.Passkey, error)
+	//RecordPasskeyUse stores the new counter only if the stored one is still
+	//oldCount, failing with ErrStaleSignCount otherwise
+	RecordPasskeyUse(credentialID string, oldCount uint32, newCount uint32, backupState bool) error
+	DeletePasskey(userID string, credentialID string) error
+	DeleteUserPasskeys(userID string) error
+	SaveCeremony(ceremony *models.WebAuthnCeremony) error
+	//TakeCeremony returns and removes a ceremony; expired ones are not found
+	TakeCeremony(id string) (*models.WebAuthnCeremony, error)
+}
+
+// AttemptRepository counts failed logins per key, such as an account or a
+// client address, shared by every replica using the same store.
+type AttemptRepository interface {
+	//RecordFailure adds a failure to key and returns the counter. A counter
+	//idle for longer than window starts again from one.
+	RecordFailure(key string, window time.Duration) (*models.AttemptCounter, error)
+	//FetchAttempts returns the live counter for key, or a zero counter
+	FetchAttempts(key string) (*models.AttemptCounter, error)
+	ResetAttempts(key string) error
+}
+
+// RateLimitRepository counts requests in fixed windows for the rate
+// limiter; a shared store makes limits hold across replicas.
+type RateLimitRepository interface {
+	//Hit counts a request for key in the window starting at start and
+	//returns the count of that window and of the window before it
+	Hit(key string, start time.Time, window time.Duration) (current int64, previous int64, err error)
+}
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKeyRepository stores API keys of every tenant in one place, since a
+// key has to be found before its tenant is known.
+type APIKeyRepository interface {
+	CreateAPIKey(key *models.APIKey) error
+	//FetchAPIKeyByPrefix finds a key of any tenant by its public prefix
+	FetchAPIKeyByPrefix(prefix string) (*models.APIKey, error)
+	ListAPIKeys(tenantID string) ([]models.APIKey, error)
+	//RevokeAPIKey fails with ErrAPIKeyNotFound unless the key belongs to
+	//the tenant and is not revoked yet
+	RevokeAPIKey(tenantID string, id string) error
+	TouchAPIKey(id string, at time.Time) error
+}
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionRepository stores the sessions of every tenant in one place, since
+// a bearer token has to be resolved before its tenant is known.
+type SessionRepository interface {
+	CreateSession(session *models.Session) error
+	//FetchSession finds a session of any tenant by ID; expired ones are
+	//not found
+	FetchSession(id string) (*models.Session, error)
+	//ListSessions returns a user's live sessions, most recently used first
+	ListSessions(tenantID string, userID string) ([]models.Session, error)
+	TouchSession(id string, at time.Time) error
+	//DeleteSession fails with ErrSessionNotFound unless the session belongs
+	//to the user of the tenant
+	DeleteSession(tenantID string, userID string, id string) error
+	DeleteUserSessions(tenantID string, userID string) (int64, error)
+}
+
+var ErrOAuthClientNotFound = errors.New("oauth client not found")
+var ErrOAuthCodeNotFound = errors.New("authorization code not found")
+var ErrOAuthTokenNotFound = errors.New("oauth token not found")
+var ErrConsentNotFound = errors.New("consent not found")
+
+// OAuthRepository stores OAuth clients, codes, tokens and consents of every
+// tenant in one place; the client named by a request decides its tenant.
+type OAuthRepository interface {
+	CreateClient(client *models.OAuthClient) error
+	//FetchClient finds a client of any tenant by its client ID
+	FetchClient(id string) (*models.OAuthClient, error)
+	ListClients(tenantID string) ([]models.OAuthClient, error)
+	DeleteClient(tenantID string, id string) error
+	SaveCode(code *models.AuthorizationCode) error
+	//TakeCode returns and removes a code; expired ones are not found
+	TakeCode(id string) (*models.AuthorizationCode, error)
+	CreateToken(token *models.OAuthToken) error
+	//FetchToken returns a token by ID; expired ones are not found
+	FetchToken(id string) (*models.OAuthToken, error)
+	DeleteToken(id string) error
+	//DeleteTokens removes the tokens of a client, narrowed to one user
+	//unless userID is empty
+	DeleteTokens(tenantID string, clientID string, userID string) error
+	DeleteUserTokens(tenantID string, userID string) error
+	//SaveConsent replaces the user's consent for the client
+	SaveConsent(consent *models.Consent) error
+	FetchConsent(tenantID string, userID string, clientID string) (*models.Consent, error)
+	ListConsents(tenantID string, userID string) ([]models.Consent, error)
+	//DeleteConsents removes consents to a client, narrowed to one user
+	//unless userID is empty
+	DeleteConsents(tenantID string, clientID string, userID string) error
+	DeleteUserConsents(tenantID string, userID string) error
+}
+
+var ErrSigningKeyNotFound = errors.New("signing key not found")
+
+// SigningKeyRepository stores the keys tokens are signed with, shared by
+// every tenant and replica.
+type SigningKeyRepository interface {
+	CreateSigningKey(key *models.SigningKey) error
+	//ListSigningKeys returns every key, latest to activate first
+	ListSigningKeys() ([]models.SigningKey, error)
+	//RetireSigningKey moves the end of a key's signing period, and when it
+	//stops being published
+	RetireSigningKey(id string, retiresAt time.Time, expiresAt time.Time) error
+	//RevokeSigningKey fails with ErrSigningKeyNotFound for keys that are
+	//missing or already revoked
+	RevokeSigningKey(id string) error
+}
+
+var (
+	ErrIdentityNotFound = errors.New("identity not found")
+	ErrIdentityExists   = errors.New("identity already linked")
+	ErrStateNotFound    = errors.New("federation state not found")
+)
+
+// IdentityRepository stores one tenant's links to upstream identity
+// providers and the sign ins in progress.
+type IdentityRepository interface {
+	//CreateIdentity fails with ErrIdentityExists when the upstream account
+	//is linked already, or the user has an identity at the provider
+	CreateIdentity(identity *models.Identity) error
+	FetchIdentity(provider string, subject string) (*models.Identity, error)
+	ListIdentities(userID string) ([]models.Identity, error)
+	//TouchIdentity records a sign in and the email the provider reported
+	TouchIdentity(id string, email string, at time.Time) error
+	DeleteIdentity(userID string, provider string) error
+	DeleteUserIdentities(userID string) error
+	SaveState(state *models.FederationState) error
+	//TakeState returns and removes a state; expired ones are not found
+	TakeState(id string) (*models.FederationState, error)
 }
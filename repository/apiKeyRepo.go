@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"Users/models"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoAPIKeys struct {
+	client *mongo.Client
+	db     string
+}
+
+func NewMongoAPIKeys(client *mongo.Client, db string) APIKeyRepository {
+	return &mongoAPIKeys{client: client, db: db}
+}
+
+func (m *mongoAPIKeys) keys() *mongo.Collection {
+	return m.client.Database(m.db).Collection("api_keys")
+}
+
+func (m *mongoAPIKeys) CreateAPIKey(key *models.APIKey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	key.ID = primitive.NewObjectID().Hex()
+	_, err := m.keys().InsertOne(ctx, key)
+	return err
+}
+
+func (m *mongoAPIKeys) FetchAPIKeyByPrefix(prefix string) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var key models.APIKey
+	err := m.keys().FindOne(ctx, bson.M{"prefix": prefix}).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (m *mongoAPIKeys) ListAPIKeys(tenantID string) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := m.keys().Find(ctx, bson.M{"tenantId": tenantID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	keys := []models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (m *mongoAPIKeys) RevokeAPIKey(tenantID string, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{"_id": id, "tenantId": tenantID, "revokedAt": bson.M{"$exists": false}}
+	result, err := m.keys().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revokedAt": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (m *mongoAPIKeys) TouchAPIKey(id string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.keys().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"lastUsedAt": at}})
+	return err
+}
+
+// in-memory API keys used with the memory user repository
+type memoryAPIKeys struct {
+	mu   sync.Mutex
+	keys map[string]models.APIKey
+}
+
+func NewMemoryAPIKeys() APIKeyRepository {
+	return &memoryAPIKeys{keys: map[string]models.APIKey{}}
+}
+
+func (m *memoryAPIKeys) CreateAPIKey(key *models.APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key.ID = primitive.NewObjectID().Hex()
+	m.keys[key.ID] = *key
+	return nil
+}
+
+func (m *memoryAPIKeys) FetchAPIKeyByPrefix(prefix string) (*models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range m.keys {
+		if key.Prefix == prefix {
+			return &key, nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+func (m *memoryAPIKeys) ListAPIKeys(tenantID string) ([]models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []models.APIKey{}
+	for _, key := range m.keys {
+		if key.TenantID == tenantID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (m *memoryAPIKeys) RevokeAPIKey(tenantID string, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[id]
+	if !ok || key.TenantID != tenantID || key.RevokedAt != nil {
+		return ErrAPIKeyNotFound
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	m.keys[id] = key
+	return nil
+}
+
+func (m *memoryAPIKeys) TouchAPIKey(id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key, ok := m.keys[id]; ok {
+		key.LastUsedAt = &at
+		m.keys[id] = key
+	}
+	return nil
+}
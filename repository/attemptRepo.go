@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"Users/models"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoAttempts struct {
+	client *mongo.Client
+	db     string
+	tenant string
+}
+
+func NewMongoAttempts(client *mongo.Client, db string, tenant string) AttemptRepository {
+	return &mongoAttempts{client: client, db: db, tenant: tenant}
+}
+
+func (m *mongoAttempts) attempts() *mongo.Collection {
+	return m.client.Database(m.db).Collection("login_attempts")
+}
+
+func (m *mongoAttempts) RecordFailure(key string, window time.Duration) (*models.AttemptCounter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	now := time.Now()
+	//a pipeline update restarts an expired counter in the same atomic write,
+	//so replicas never lose each other's increments
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"tenantId": m.tenant,
+			"key":      key,
+			"count": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$expiresAt", now}},
+				bson.M{"$add": bson.A{"$count", 1}},
+				1,
+			}},
+			"lastFailureAt": now,
+			"expiresAt":     now.Add(window),
+		}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var counter models.AttemptCounter
+	err := m.attempts().FindOneAndUpdate(ctx, bson.M{"tenantId": m.tenant, "key": key}, update, opts).Decode(&counter)
+	if err != nil {
+		return nil, err
+	}
+	return &counter, nil
+}
+
+func (m *mongoAttempts) FetchAttempts(key string) (*models.AttemptCounter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	//the TTL monitor runs about once a minute, so expiry is checked here too
+	filter := bson.M{"tenantId": m.tenant, "key": key, "expiresAt": bson.M{"$gt": time.Now()}}
+	var counter models.AttemptCounter
+	err := m.attempts().FindOne(ctx, filter).Decode(&counter)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &models.AttemptCounter{Key: key}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &counter, nil
+}
+
+func (m *mongoAttempts) ResetAttempts(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.attempts().DeleteOne(ctx, bson.M{"tenantId": m.tenant, "key": key})
+	return err
+}
+
+// in-memory counters for a single process
+type memoryAttempts struct {
+	mu       sync.Mutex
+	counters map[string]models.AttemptCounter
+}
+
+func NewMemoryAttempts() AttemptRepository {
+	return &memoryAttempts{counters: map[string]models.AttemptCounter{}}
+}
+
+func (m *memoryAttempts) RecordFailure(key string, window time.Duration) (*models.AttemptCounter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	counter, ok := m.counters[key]
+	if !ok || !now.Before(counter.ExpiresAt) {
+		counter = models.AttemptCounter{Key: key}
+	}
+	counter.Count++
+	counter.LastFailureAt = now
+	counter.ExpiresAt = now.Add(window)
+	m.counters[key] = counter
+	return &counter, nil
+}
+
+func (m *memoryAttempts) FetchAttempts(key string) (*models.AttemptCounter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counter, ok := m.counters[key]
+	if !ok || !time.Now().Before(counter.ExpiresAt) {
+		delete(m.counters, key)
+		return &models.AttemptCounter{Key: key}, nil
+	}
+	return &counter, nil
+}
+
+func (m *memoryAttempts) ResetAttempts(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.counters, key)
+	return nil
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"Users/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoAttributeSchemas struct {
+	client *mongo.Client
+	db     string
+}
+
+func NewMongoAttributeSchemas(client *mongo.Client, db string) AttributeSchemaRepository {
+	return &mongoAttributeSchemas{client: client, db: db}
+}
+
+// the schema is stored as JSON text; schema keywords start with $, which
+// mongo field names should not
+type attributeSchemaDocument struct {
+	Scope     string    `bson:"_id"`
+	Schema    string    `bson:"schema"`
+	Version   int       `bson:"version"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+func (d *attributeSchemaDocument) model() *models.AttributeSchema {
+	return &models.AttributeSchema{
+		Scope:     d.Scope,
+		Schema:    json.RawMessage(d.Schema),
+		Version:   d.Version,
+		UpdatedAt: d.UpdatedAt,
+	}
+}
+
+func (m *mongoAttributeSchemas) FetchAttributeSchema(scope string) (*models.AttributeSchema, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.client.Database(m.db).Collection("attribute_schemas")
+	var doc attributeSchemaDocument
+	err := collection.FindOne(ctx, bson.M{"_id": scope}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrSchemaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.model(), nil
+}
+
+func (m *mongoAttributeSchemas) SaveAttributeSchema(schema *models.AttributeSchema) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.client.Database(m.db).Collection("attribute_schemas")
+	update := bson.M{
+		"$set": bson.M{"schema": string(schema.Schema), "updatedAt": time.Now()},
+		"$inc": bson.M{"version": 1},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var doc attributeSchemaDocument
+	if err := collection.FindOneAndUpdate(ctx, bson.M{"_id": schema.Scope}, update, opts).Decode(&doc); err != nil {
+		return err
+	}
+	*schema = *doc.model()
+	return nil
+}
+
+// in-memory schema store used with the memory user repository
+type memoryAttributeSchemas struct {
+	mu      sync.Mutex
+	schemas map[string]models.AttributeSchema
+}
+
+func NewMemoryAttributeSchemas() AttributeSchemaRepository {
+	return &memoryAttributeSchemas{schemas: map[string]models.AttributeSchema{}}
+}
+
+func (m *memoryAttributeSchemas) FetchAttributeSchema(scope string) (*models.AttributeSchema, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	schema, ok := m.schemas[scope]
+	if !ok {
+		return nil, ErrSchemaNotFound
+	}
+	return &schema, nil
+}
+
+func (m *memoryAttributeSchemas) SaveAttributeSchema(schema *models.AttributeSchema) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	schema.Version = m.schemas[schema.Scope].Version + 1
+	schema.UpdatedAt = time.Now()
+	m.schemas[schema.Scope] = *schema
+	return nil
+}
@@ -0,0 +1,230 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// validBlobKey rejects keys that would escape the store's root
+func validBlobKey(key string) error {
+	if key == "" || path.IsAbs(key) || path.Clean(key) != key || strings.HasPrefix(key, "../") || key == ".." {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	return nil
+}
+
+// -----------LOCAL FILESYSTEM-----------
+
+type localBlobs struct {
+	root string
+}
+
+// NewLocalBlobs stores blobs as files under root. The content type is
+// sniffed from the data when a blob is read.
+func NewLocalBlobs(root string) (BlobStore, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, err
+	}
+	return &localBlobs{root: root}, nil
+}
+
+func (l *localBlobs) PutBlob(key string, contentType string, data []byte) error {
+	if err := validBlobKey(key); err != nil {
+		return err
+	}
+	name := filepath.Join(l.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(name), 0o750); err != nil {
+		return err
+	}
+	//writing to a temporary file and renaming, so readers never see a
+	//partially written blob
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (l *localBlobs) GetBlob(key string) (*Blob, error) {
+	if err := validBlobKey(key); err != nil {
+		return nil, err
+	}
+	name := filepath.Join(l.root, filepath.FromSlash(key))
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Blob{Data: data, ContentType: http.DetectContentType(data), ModTime: info.ModTime()}, nil
+}
+
+func (l *localBlobs) DeleteBlob(key string) error {
+	if err := validBlobKey(key); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(l.root, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// -----------GRIDFS-----------
+
+type gridfsBlobs struct {
+	client *mongo.Client
+	db     string
+	bucket string
+}
+
+// NewGridFSBlobs stores blobs in the named GridFS bucket of db, with the key
+// as the file name.
+func NewGridFSBlobs(client *mongo.Client, db string, bucket string) BlobStore {
+	return &gridfsBlobs{client: client, db: db, bucket: bucket}
+}
+
+// open returns the bucket with the same 10 second budget as the other
+// repository calls; uploads and downloads take deadlines, not contexts
+func (g *gridfsBlobs) open() (*gridfs.Bucket, error) {
+	bucket, err := gridfs.NewBucket(g.client.Database(g.db), options.GridFSBucket().SetName(g.bucket))
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	if err := bucket.SetWriteDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if err := bucket.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	return bucket, nil
+}
+
+func (g *gridfsBlobs) PutBlob(key string, contentType string, data []byte) error {
+	if err := validBlobKey(key); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	bucket, err := g.open()
+	if err != nil {
+		return err
+	}
+	opts := options.GridFSUpload().SetMetadata(bson.M{"contentType": contentType})
+	id, err := bucket.UploadFromStream(key, bytes.NewReader(data), opts)
+	if err != nil {
+		return err
+	}
+	//older revisions are removed only after the new one is complete, so a
+	//concurrent read always finds a whole file
+	return g.deleteRevisions(ctx, bucket, key, id)
+}
+
+func (g *gridfsBlobs) GetBlob(key string) (*Blob, error) {
+	if err := validBlobKey(key); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	bucket, err := g.open()
+	if err != nil {
+		return nil, err
+	}
+	opts := options.GridFSFind().SetSort(bson.D{{Key: "uploadDate", Value: -1}}).SetLimit(1)
+	cursor, err := bucket.FindContext(ctx, bson.M{"filename": key}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	if !cursor.Next(ctx) {
+		if err := cursor.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrBlobNotFound
+	}
+	var file struct {
+		ID         primitive.ObjectID `bson:"_id"`
+		UploadDate time.Time          `bson:"uploadDate"`
+		Metadata   struct {
+			ContentType string `bson:"contentType"`
+		} `bson:"metadata"`
+	}
+	if err := cursor.Decode(&file); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	_, err = bucket.DownloadToStream(file.ID, &buf)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Blob{Data: buf.Bytes(), ContentType: file.Metadata.ContentType, ModTime: file.UploadDate}, nil
+}
+
+func (g *gridfsBlobs) DeleteBlob(key string) error {
+	if err := validBlobKey(key); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	bucket, err := g.open()
+	if err != nil {
+		return err
+	}
+	return g.deleteRevisions(ctx, bucket, key, nil)
+}
+
+// deleteRevisions removes every file named key except keep
+func (g *gridfsBlobs) deleteRevisions(ctx context.Context, bucket *gridfs.Bucket, key string, keep interface{}) error {
+	filter := bson.M{"filename": key}
+	if keep != nil {
+		filter["_id"] = bson.M{"$ne": keep}
+	}
+	cursor, err := bucket.FindContext(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var file struct {
+			ID interface{} `bson:"_id"`
+		}
+		if err := cursor.Decode(&file); err != nil {
+			return err
+		}
+		if err := bucket.DeleteContext(ctx, file.ID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return err
+		}
+	}
+	return cursor.Err()
+}
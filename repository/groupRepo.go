@@ -0,0 +1,335 @@
+package repository
+
+import (
+	"Users/models"
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoGroups struct {
+	client *mongo.Client
+	db     string
+	tenant string
+}
+
+func NewMongoGroups(client *mongo.Client, db string, tenant string) GroupRepository {
+	return &mongoGroups{client: client, db: db, tenant: tenant}
+}
+
+func (m *mongoGroups) groups() *mongo.Collection {
+	return m.client.Database(m.db).Collection("groups")
+}
+
+// byID filters on a group of this tenant; unparsable IDs match nothing
+func (m *mongoGroups) byID(id string) (bson.M, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrGroupNotFound
+	}
+	return bson.M{"_id": objID, "tenantId": m.tenant}, nil
+}
+
+func (m *mongoGroups) CreateGroup(group *models.Group) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	objID := primitive.NewObjectID()
+	group.TenantID = m.tenant
+	group.CreatedAt = time.Now()
+	group.UpdatedAt = group.CreatedAt
+	if group.Members == nil {
+		group.Members = []string{}
+	}
+	if group.Subgroups == nil {
+		group.Subgroups = []string{}
+	}
+	doc := bson.M{
+		"_id":           objID,
+		"tenantId":      m.tenant,
+		"name":          group.Name,
+		"nameCanonical": group.NameCanonical,
+		"description":   group.Description,
+		"members":       group.Members,
+		"subgroups":     group.Subgroups,
+		"createdAt":     group.CreatedAt,
+		"updatedAt":     group.UpdatedAt,
+	}
+	_, err := m.groups().InsertOne(ctx, doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrGroupExists
+	}
+	if err != nil {
+		return err
+	}
+	group.ID = objID.Hex()
+	return nil
+}
+
+func (m *mongoGroups) FetchGroup(id string) (*models.Group, error) {
+	filter, err := m.byID(id)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var group models.Group
+	err = m.groups().FindOne(ctx, filter).Decode(&group)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (m *mongoGroups) ListGroups() ([]models.Group, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "nameCanonical", Value: 1}})
+	cursor, err := m.groups().Find(ctx, bson.M{"tenantId": m.tenant}, opts)
+	if err != nil {
+		return nil, err
+	}
+	groups := []models.Group{}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (m *mongoGroups) RenameGroup(id string, name string, nameCanonical string) error {
+	return m.update(id, bson.M{"$set": bson.M{"name": name, "nameCanonical": nameCanonical}})
+}
+
+func (m *mongoGroups) DeleteGroup(id string) error {
+	filter, err := m.byID(id)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := m.groups().DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrGroupNotFound
+	}
+	_, err = m.groups().UpdateMany(ctx,
+		bson.M{"tenantId": m.tenant, "subgroups": id},
+		bson.M{"$pull": bson.M{"subgroups": id}, "$set": bson.M{"updatedAt": time.Now()}})
+	return err
+}
+
+func (m *mongoGroups) AddMember(groupID string, userID string) error {
+	return m.update(groupID, bson.M{"$addToSet": bson.M{"members": userID}})
+}
+
+func (m *mongoGroups) RemoveMember(groupID string, userID string) error {
+	return m.update(groupID, bson.M{"$pull": bson.M{"members": userID}})
+}
+
+func (m *mongoGroups) AddSubgroup(groupID string, childID string) error {
+	return m.update(groupID, bson.M{"$addToSet": bson.M{"subgroups": childID}})
+}
+
+func (m *mongoGroups) RemoveSubgroup(groupID string, childID string) error {
+	return m.update(groupID, bson.M{"$pull": bson.M{"subgroups": childID}})
+}
+
+func (m *mongoGroups) RemoveUserFromGroups(userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.groups().UpdateMany(ctx,
+		bson.M{"tenantId": m.tenant, "members": userID},
+		bson.M{"$pull": bson.M{"members": userID}, "$set": bson.M{"updatedAt": time.Now()}})
+	return err
+}
+
+// update applies change to one group and bumps updatedAt
+func (m *mongoGroups) update(id string, change bson.M) error {
+	filter, err := m.byID(id)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	set, _ := change["$set"].(bson.M)
+	if set == nil {
+		set = bson.M{}
+		change["$set"] = set
+	}
+	set["updatedAt"] = time.Now()
+	result, err := m.groups().UpdateOne(ctx, filter, change)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrGroupExists
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrGroupNotFound
+	}
+	return nil
+}
+
+// in-memory group store used with the memory user repository
+type memoryGroups struct {
+	tenant string
+	mu     sync.Mutex
+	groups map[string]models.Group
+}
+
+func NewMemoryGroups(tenant string) GroupRepository {
+	return &memoryGroups{tenant: tenant, groups: map[string]models.Group{}}
+}
+
+// nameTaken reports whether another group uses the canonical name; callers
+// hold the lock
+func (m *memoryGroups) nameTaken(nameCanonical string, except string) bool {
+	for id, g := range m.groups {
+		if id != except && g.NameCanonical == nameCanonical {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memoryGroups) CreateGroup(group *models.Group) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.nameTaken(group.NameCanonical, "") {
+		return ErrGroupExists
+	}
+	group.ID = primitive.NewObjectID().Hex()
+	group.TenantID = m.tenant
+	group.CreatedAt = time.Now()
+	group.UpdatedAt = group.CreatedAt
+	group.Members = append([]string{}, group.Members...)
+	group.Subgroups = append([]string{}, group.Subgroups...)
+	m.groups[group.ID] = *group
+	return nil
+}
+
+func (m *memoryGroups) FetchGroup(id string) (*models.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	group, ok := m.groups[id]
+	if !ok {
+		return nil, ErrGroupNotFound
+	}
+	return copyGroup(group), nil
+}
+
+func (m *memoryGroups) ListGroups() ([]models.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	groups := make([]models.Group, 0, len(m.groups))
+	for _, g := range m.groups {
+		groups = append(groups, *copyGroup(g))
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].NameCanonical < groups[j].NameCanonical })
+	return groups, nil
+}
+
+func (m *memoryGroups) RenameGroup(id string, name string, nameCanonical string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.nameTaken(nameCanonical, id) {
+		return ErrGroupExists
+	}
+	return m.change(id, func(g *models.Group) {
+		g.Name = name
+		g.NameCanonical = nameCanonical
+	})
+}
+
+func (m *memoryGroups) DeleteGroup(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.groups[id]; !ok {
+		return ErrGroupNotFound
+	}
+	delete(m.groups, id)
+	for gid, g := range m.groups {
+		if slices.Contains(g.Subgroups, id) {
+			m.change(gid, func(g *models.Group) { g.Subgroups = remove(g.Subgroups, id) })
+		}
+	}
+	return nil
+}
+
+func (m *memoryGroups) AddMember(groupID string, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.change(groupID, func(g *models.Group) { g.Members = addToSet(g.Members, userID) })
+}
+
+func (m *memoryGroups) RemoveMember(groupID string, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.change(groupID, func(g *models.Group) { g.Members = remove(g.Members, userID) })
+}
+
+func (m *memoryGroups) AddSubgroup(groupID string, childID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.change(groupID, func(g *models.Group) { g.Subgroups = addToSet(g.Subgroups, childID) })
+}
+
+func (m *memoryGroups) RemoveSubgroup(groupID string, childID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.change(groupID, func(g *models.Group) { g.Subgroups = remove(g.Subgroups, childID) })
+}
+
+func (m *memoryGroups) RemoveUserFromGroups(userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for gid, g := range m.groups {
+		if slices.Contains(g.Members, userID) {
+			m.change(gid, func(g *models.Group) { g.Members = remove(g.Members, userID) })
+		}
+	}
+	return nil
+}
+
+// change edits a stored group in place; callers hold the lock
+func (m *memoryGroups) change(id string, fn func(g *models.Group)) error {
+	group, ok := m.groups[id]
+	if !ok {
+		return ErrGroupNotFound
+	}
+	group = *copyGroup(group)
+	fn(&group)
+	group.UpdatedAt = time.Now()
+	m.groups[id] = group
+	return nil
+}
+
+// copyGroup copies the member slices so callers never share them with the store
+func copyGroup(g models.Group) *models.Group {
+	g.Members = append([]string{}, g.Members...)
+	g.Subgroups = append([]string{}, g.Subgroups...)
+	return &g
+}
+
+func addToSet(list []string, value string) []string {
+	if slices.Contains(list, value) {
+		return list
+	}
+	return append(list, value)
+}
+
+func remove(list []string, value string) []string {
+	return slices.DeleteFunc(list, func(v string) bool { return v == value })
+}
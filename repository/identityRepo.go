@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"Users/models"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoIdentities struct {
+	client *mongo.Client
+	db     string
+	tenant string
+}
+
+func NewMongoIdentities(client *mongo.Client, db string, tenant string) IdentityRepository {
+	return &mongoIdentities{client: client, db: db, tenant: tenant}
+}
+
+func (m *mongoIdentities) identities() *mongo.Collection {
+	return m.client.Database(m.db).Collection("identities")
+}
+
+func (m *mongoIdentities) states() *mongo.Collection {
+	return m.client.Database(m.db).Collection("federation_states")
+}
+
+func (m *mongoIdentities) CreateIdentity(identity *models.Identity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	identity.ID = primitive.NewObjectID().Hex()
+	identity.TenantID = m.tenant
+	_, err := m.identities().InsertOne(ctx, identity)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrIdentityExists
+	}
+	return err
+}
+
+func (m *mongoIdentities) FetchIdentity(provider string, subject string) (*models.Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var identity models.Identity
+	err := m.identities().FindOne(ctx, bson.M{"tenantId": m.tenant, "provider": provider, "subject": subject}).Decode(&identity)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (m *mongoIdentities) ListIdentities(userID string) ([]models.Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "linkedAt", Value: 1}})
+	cursor, err := m.identities().Find(ctx, bson.M{"tenantId": m.tenant, "userId": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	identities := []models.Identity{}
+	if err := cursor.All(ctx, &identities); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (m *mongoIdentities) TouchIdentity(id string, email string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.identities().UpdateOne(ctx, bson.M{"_id": id, "tenantId": m.tenant}, bson.M{"$set": bson.M{"email": email, "lastLoginAt": at}})
+	return err
+}
+
+func (m *mongoIdentities) DeleteIdentity(userID string, provider string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := m.identities().DeleteOne(ctx, bson.M{"tenantId": m.tenant, "userId": userID, "provider": provider})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
+
+func (m *mongoIdentities) DeleteUserIdentities(userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.identities().DeleteMany(ctx, bson.M{"tenantId": m.tenant, "userId": userID})
+	return err
+}
+
+func (m *mongoIdentities) SaveState(state *models.FederationState) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	state.TenantID = m.tenant
+	_, err := m.states().InsertOne(ctx, state)
+	return err
+}
+
+func (m *mongoIdentities) TakeState(id string) (*models.FederationState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	//the TTL monitor runs about once a minute, so expiry is checked here too
+	filter := bson.M{"_id": id, "tenantId": m.tenant, "expiresAt": bson.M{"$gt": time.Now()}}
+	var state models.FederationState
+	err := m.states().FindOneAndDelete(ctx, filter).Decode(&state)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrStateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// in-memory identity store used with the memory user repository
+type memoryIdentities struct {
+	tenant     string
+	mu         sync.Mutex
+	identities map[string]models.Identity
+	states     map[string]models.FederationState
+}
+
+func NewMemoryIdentities(tenant string) IdentityRepository {
+	return &memoryIdentities{
+		tenant:     tenant,
+		identities: map[string]models.Identity{},
+		states:     map[string]models.FederationState{},
+	}
+}
+
+func (m *memoryIdentities) CreateIdentity(identity *models.Identity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.identities {
+		if existing.Provider != identity.Provider {
+			continue
+		}
+		if existing.Subject == identity.Subject || existing.UserID == identity.UserID {
+			return ErrIdentityExists
+		}
+	}
+	identity.ID = primitive.NewObjectID().Hex()
+	identity.TenantID = m.tenant
+	m.identities[identity.ID] = *identity
+	return nil
+}
+
+func (m *memoryIdentities) FetchIdentity(provider string, subject string) (*models.Identity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, identity := range m.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, ErrIdentityNotFound
+}
+
+func (m *memoryIdentities) ListIdentities(userID string) ([]models.Identity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	identities := []models.Identity{}
+	for _, identity := range m.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].LinkedAt.Before(identities[j].LinkedAt) })
+	return identities, nil
+}
+
+func (m *memoryIdentities) TouchIdentity(id string, email string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if identity, ok := m.identities[id]; ok {
+		identity.Email = email
+		identity.LastLoginAt = &at
+		m.identities[id] = identity
+	}
+	return nil
+}
+
+func (m *memoryIdentities) DeleteIdentity(userID string, provider string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, identity := range m.identities {
+		if identity.UserID == userID && identity.Provider == provider {
+			delete(m.identities, id)
+			return nil
+		}
+	}
+	return ErrIdentityNotFound
+}
+
+func (m *memoryIdentities) DeleteUserIdentities(userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, identity := range m.identities {
+		if identity.UserID == userID {
+			delete(m.identities, id)
+		}
+	}
+	return nil
+}
+
+func (m *memoryIdentities) SaveState(state *models.FederationState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state.TenantID = m.tenant
+	m.states[state.ID] = *state
+	return nil
+}
+
+func (m *memoryIdentities) TakeState(id string) (*models.FederationState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.states[id]
+	if !ok {
+		return nil, ErrStateNotFound
+	}
+	delete(m.states, id)
+	if !time.Now().Before(state.ExpiresAt) {
+		return nil, ErrStateNotFound
+	}
+	return &state, nil
+}
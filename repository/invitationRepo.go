@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"Users/models"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type mongoInvitations struct {
+	client *mongo.Client
+	db     string
+	tenant string
+}
+
+func NewMongoInvitations(client *mongo.Client, db string, tenant string) InvitationRepository {
+	return &mongoInvitations{client: client, db: db, tenant: tenant}
+}
+
+func (m *mongoInvitations) CreateInvitation(inv *models.Invitation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.client.Database(m.db).Collection("invitations")
+	objID := primitive.NewObjectID()
+	inv.TenantID = m.tenant
+	doc := bson.M{
+		"_id":            objID,
+		"tenantId":       m.tenant,
+		"email":          inv.Email,
+		"emailCanonical": inv.EmailCanonical,
+		"role":           inv.Role,
+		"groups":         inv.Groups,
+		"invitedBy":      inv.InvitedBy,
+		"status":         inv.Status,
+		"createdAt":      inv.CreatedAt,
+		"expiresAt":      inv.ExpiresAt,
+	}
+	if _, err := collection.InsertOne(ctx, doc); err != nil {
+		return err
+	}
+	inv.ID = objID.Hex()
+	return nil
+}
+
+func (m *mongoInvitations) FetchInvitation(id string) (*models.Invitation, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvitationNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.client.Database(m.db).Collection("invitations")
+	var inv models.Invitation
+	err = collection.FindOne(ctx, bson.M{"_id": objID, "tenantId": m.tenant}).Decode(&inv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (m *mongoInvitations) MarkInvitationAccepted(id string, userID string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvitationNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.client.Database(m.db).Collection("invitations")
+	filter := bson.M{"_id": objID, "tenantId": m.tenant, "status": models.InvitationPending}
+	update := bson.M{"$set": bson.M{
+		"status":     models.InvitationAccepted,
+		"acceptedAt": time.Now(),
+		"userId":     userID,
+	}}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := m.FetchInvitation(id); err != nil {
+			return err
+		}
+		return ErrInvitationUsed
+	}
+	return nil
+}
+
+// in-memory invitation store used with the memory user repository
+type memoryInvitations struct {
+	tenant      string
+	mu          sync.Mutex
+	invitations map[string]models.Invitation
+}
+
+func NewMemoryInvitations(tenant string) InvitationRepository {
+	return &memoryInvitations{tenant: tenant, invitations: map[string]models.Invitation{}}
+}
+
+func (m *memoryInvitations) CreateInvitation(inv *models.Invitation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inv.ID = primitive.NewObjectID().Hex()
+	inv.TenantID = m.tenant
+	m.invitations[inv.ID] = *inv
+	return nil
+}
+
+func (m *memoryInvitations) FetchInvitation(id string) (*models.Invitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inv, ok := m.invitations[id]
+	if !ok {
+		return nil, ErrInvitationNotFound
+	}
+	return &inv, nil
+}
+
+func (m *memoryInvitations) MarkInvitationAccepted(id string, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inv, ok := m.invitations[id]
+	if !ok {
+		return ErrInvitationNotFound
+	}
+	if inv.Status != models.InvitationPending {
+		return ErrInvitationUsed
+	}
+	now := time.Now()
+	inv.Status = models.InvitationAccepted
+	inv.AcceptedAt = &now
+	inv.UserID = userID
+	m.invitations[id] = inv
+	return nil
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"Users/models"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type mongoJobs struct {
+	client *mongo.Client
+	db     string
+	tenant string
+}
+
+func NewMongoJobs(client *mongo.Client, db string, tenant string) JobRepository {
+	return &mongoJobs{client: client, db: db, tenant: tenant}
+}
+
+func (m *mongoJobs) CreateJob(job *models.BulkJob) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.client.Database(m.db).Collection("jobs")
+	objID := primitive.NewObjectID()
+	job.ID = ""
+	doc := bson.M{
+		"_id":       objID,
+		"tenantId":  m.tenant,
+		"status":    job.Status,
+		"action":    job.Action,
+		"createdAt": job.CreatedAt,
+		"expiresAt": job.ExpiresAt,
+	}
+	if _, err := collection.InsertOne(ctx, doc); err != nil {
+		return err
+	}
+	job.ID = objID.Hex()
+	job.TenantID = m.tenant
+	return nil
+}
+
+func (m *mongoJobs) UpdateJob(job *models.BulkJob) error {
+	objID, err := primitive.ObjectIDFromHex(job.ID)
+	if err != nil {
+		return ErrJobNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.client.Database(m.db).Collection("jobs")
+	update := bson.M{"$set": bson.M{
+		"status":     job.Status,
+		"result":     job.Result,
+		"error":      job.Error,
+		"finishedAt": job.FinishedAt,
+		"expiresAt":  job.ExpiresAt,
+	}}
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objID, "tenantId": m.tenant}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+func (m *mongoJobs) FetchJob(id string) (*models.BulkJob, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrJobNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.client.Database(m.db).Collection("jobs")
+	var job models.BulkJob
+	err = collection.FindOne(ctx, bson.M{"_id": objID, "tenantId": m.tenant}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// in-memory job store used with the memory user repository
+type memoryJobs struct {
+	mu   sync.Mutex
+	jobs map[string]models.BulkJob
+}
+
+func NewMemoryJobs() JobRepository {
+	return &memoryJobs{jobs: map[string]models.BulkJob{}}
+}
+
+func (m *memoryJobs) CreateJob(job *models.BulkJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job.ID = primitive.NewObjectID().Hex()
+	m.jobs[job.ID] = *job
+	return nil
+}
+
+func (m *memoryJobs) UpdateJob(job *models.BulkJob) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.jobs[job.ID]; !ok {
+		return ErrJobNotFound
+	}
+	m.jobs[job.ID] = *job
+	return nil
+}
+
+func (m *memoryJobs) FetchJob(id string) (*models.BulkJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok || time.Now().After(job.ExpiresAt) {
+		return nil, ErrJobNotFound
+	}
+	return &job, nil
+}
@@ -0,0 +1,554 @@
+package repository
+
+import (
+	"Users/models"
+	"Users/search"
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+in-memory implementation of the repository, for local development and
+tooling; it enforces the same uniqueness rules as the mongo indexes
+*/
+type memoryStore struct {
+	tenant     string
+	mu         sync.RWMutex
+	users      map[string]models.User
+	byEmail    map[string]string
+	byUsername map[string]string
+	index      *search.Index
+}
+
+func NewMemory(tenant string) UserRepository {
+	return &memoryStore{
+		tenant:     tenant,
+		users:      map[string]models.User{},
+		byEmail:    map[string]string{},
+		byUsername: map[string]string{},
+		index:      search.NewIndex(),
+	}
+}
+
+//-----CREATE USER FUNCTION-----
+
+func (m *memoryStore) CreateUser(user *models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user.CreatedAt = time.Now()
+	user.Status = models.StatusActive
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	user.TenantID = m.tenant
+	created := *user
+	created.ID = primitive.NewObjectID().Hex()
+	if err := m.insert(created); err != nil {
+		return err
+	}
+	user.ID = created.ID
+	return nil
+}
+
+// insert stores a user after checking the canonical fields are free;
+// callers hold the write lock
+func (m *memoryStore) insert(user models.User) error {
+	if _, taken := m.users[user.ID]; taken {
+		return ErrUserExists
+	}
+	if m.taken(user.EmailCanonical, user.UsernameCanonical, "") {
+		return ErrUserExists
+	}
+	m.put(user)
+	return nil
+}
+
+func (m *memoryStore) taken(emailCanonical, usernameCanonical, exceptID string) bool {
+	if id, ok := m.byEmail[emailCanonical]; ok && emailCanonical != "" && id != exceptID {
+		return true
+	}
+	if id, ok := m.byUsername[usernameCanonical]; ok && usernameCanonical != "" && id != exceptID {
+		return true
+	}
+	return false
+}
+
+func (m *memoryStore) put(user models.User) {
+	if old, ok := m.users[user.ID]; ok {
+		delete(m.byEmail, old.EmailCanonical)
+		delete(m.byUsername, old.UsernameCanonical)
+	}
+	m.users[user.ID] = user
+	if user.EmailCanonical != "" {
+		m.byEmail[user.EmailCanonical] = user.ID
+	}
+	if user.UsernameCanonical != "" {
+		m.byUsername[user.UsernameCanonical] = user.ID
+	}
+	m.index.Put(user.ID, user.Username, user.Email, search.DisplayName(&user))
+}
+
+func (m *memoryStore) remove(id string) {
+	old := m.users[id]
+	delete(m.byEmail, old.EmailCanonical)
+	delete(m.byUsername, old.UsernameCanonical)
+	delete(m.users, id)
+	m.index.Remove(id)
+}
+
+// -----UPDATE USER FUNCTION-----
+func (m *memoryStore) UpdateUser(user *models.User) error {
+	if _, err := primitive.ObjectIDFromHex(user.ID); err != nil {
+		return ErrInvalidUserID
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user.Email == "" && user.Username == "" && user.Password == "" && user.Profile.IsZero() && user.Attributes == nil {
+		return ErrNoFieldsToUpdate
+	}
+	stored, ok := m.users[user.ID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if user.Email != "" {
+		stored.Email = user.Email
+		stored.EmailCanonical = user.EmailCanonical
+	}
+	if user.Username != "" {
+		stored.Username = user.Username
+		stored.UsernameCanonical = user.UsernameCanonical
+	}
+	if user.Password != "" {
+		stored.Password = user.Password
+	}
+	if !user.Profile.IsZero() {
+		stored.Profile = mergeProfile(stored.Profile, user.Profile)
+	}
+	if user.Attributes != nil {
+		stored.Attributes = user.Attributes
+	}
+	if m.taken(stored.EmailCanonical, stored.UsernameCanonical, stored.ID) {
+		return ErrUserExists
+	}
+	m.put(stored)
+	return nil
+}
+
+// mergeProfile sets the non-empty fields of changes on a copy of stored
+func mergeProfile(stored, changes *models.Profile) *models.Profile {
+	merged := models.Profile{}
+	if stored != nil {
+		merged = *stored
+	}
+	for _, f := range []struct{ dst, src *string }{
+		{&merged.DisplayName, &changes.DisplayName},
+		{&merged.Locale, &changes.Locale},
+		{&merged.Timezone, &changes.Timezone},
+		{&merged.Phone, &changes.Phone},
+		{&merged.AvatarURL, &changes.AvatarURL},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+	return &merged
+}
+
+// -----------PATCH USER FUNCTION---------------
+func (m *memoryStore) PatchUser(user *models.User) error {
+	if _, err := primitive.ObjectIDFromHex(user.ID); err != nil {
+		return ErrInvalidUserID
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.users[user.ID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	stored.Email = user.Email
+	stored.EmailCanonical = user.EmailCanonical
+	stored.Username = user.Username
+	stored.UsernameCanonical = user.UsernameCanonical
+	if user.Password != "" {
+		stored.Password = user.Password
+	}
+	stored.Profile = nil
+	if !user.Profile.IsZero() {
+		stored.Profile = user.Profile
+	}
+	stored.Attributes = nil
+	if len(user.Attributes) > 0 {
+		stored.Attributes = user.Attributes
+	}
+	if m.taken(stored.EmailCanonical, stored.UsernameCanonical, stored.ID) {
+		return ErrUserExists
+	}
+	m.put(stored)
+	return nil
+}
+
+// -----------DELETE USER FUNCTION---------------
+func (m *memoryStore) DeleteUser(user *models.User) error {
+	if _, err := primitive.ObjectIDFromHex(user.ID); err != nil {
+		return ErrInvalidUserID
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[user.ID]; !ok {
+		return ErrUserNotFound
+	}
+	m.remove(user.ID)
+	return nil
+}
+
+// -----------FETCH ALL USERS FUNCTION--------
+func (m *memoryStore) FetchAllUsers() ([]models.User, error) {
+	return m.ListUsers(models.UserFilter{})
+}
+
+func (m *memoryStore) StreamUsers(ctx context.Context, filter models.UserFilter, fn func(*models.User) error) error {
+	//copying under the lock so fn can call back into the store
+	m.mu.RLock()
+	users := m.matching(filter)
+	m.mu.RUnlock()
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if filter.Limit > 0 && int64(len(users)) > filter.Limit {
+		users = users[:filter.Limit]
+	}
+	for i := range users {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&users[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) FetchUserByID(id string) (*models.User, error) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return nil, ErrInvalidUserID
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	user, ok := m.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (m *memoryStore) FetchUserByEmail(email string) (*models.User, error) {
+	m.mu.RLock()
+	id, ok := m.byEmail[email]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return m.FetchUserByID(id)
+}
+
+func (m *memoryStore) FetchUserByUsername(username string) (*models.User, error) {
+	m.mu.RLock()
+	id, ok := m.byUsername[username]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return m.FetchUserByID(id)
+}
+
+func (m *memoryStore) UpdateUserStatus(id string, status string) error {
+	return m.changeOne(id, BulkUserChange{Status: status})
+}
+
+func (m *memoryStore) UpdateUserRole(id string, role string) error {
+	return m.changeOne(id, BulkUserChange{Role: role})
+}
+
+// changeOne applies a change to a single user
+func (m *memoryStore) changeOne(id string, change BulkUserChange) error {
+	results, err := m.BulkChangeUsers([]string{id}, change)
+	if err != nil {
+		return err
+	}
+	return results[0].Err
+}
+
+// -----------LIST USERS FUNCTION--------
+func (m *memoryStore) ListUsers(filter models.UserFilter) ([]models.User, error) {
+	m.mu.RLock()
+	users := m.matching(filter)
+	m.mu.RUnlock()
+	sort.Slice(users, func(i, j int) bool { return users[i].CreatedAt.After(users[j].CreatedAt) })
+	if filter.Limit > 0 && int64(len(users)) > filter.Limit {
+		users = users[:filter.Limit]
+	}
+	return users, nil
+}
+
+// matching returns copies of the users matching filter; callers hold a lock
+func (m *memoryStore) matching(filter models.UserFilter) []models.User {
+	query := strings.ToLower(filter.Query)
+	var users []models.User
+	for _, u := range m.users {
+		if filter.Status != "" && u.Status != filter.Status {
+			continue
+		}
+		if filter.Role != "" && u.Role != filter.Role {
+			continue
+		}
+		if query != "" && !strings.HasPrefix(u.EmailCanonical, query) && !strings.HasPrefix(u.UsernameCanonical, query) {
+			continue
+		}
+		users = append(users, u)
+	}
+	return users
+}
+
+// -----------RESTORE USER FUNCTION--------
+func (m *memoryStore) RestoreUser(user *models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user.TenantID = m.tenant
+	restored := *user
+	if restored.ID == "" {
+		restored.ID = primitive.NewObjectID().Hex()
+	} else if _, err := primitive.ObjectIDFromHex(restored.ID); err != nil {
+		return ErrInvalidUserID
+	}
+	return m.insert(restored)
+}
+
+func (m *memoryStore) FetchUsersByCanonical(emails []string, usernames []string) ([]models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	seen := map[string]bool{}
+	var users []models.User
+	collect := func(index map[string]string, keys []string) {
+		for _, key := range keys {
+			if id, ok := index[key]; ok && !seen[id] {
+				seen[id] = true
+				users = append(users, m.users[id])
+			}
+		}
+	}
+	collect(m.byEmail, emails)
+	collect(m.byUsername, usernames)
+	return users, nil
+}
+
+// -----------BULK WRITE USERS FUNCTION--------
+func (m *memoryStore) BulkWriteUsers(users []*models.User, updateExisting bool) ([]BulkItemResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	results := make([]BulkItemResult, len(users))
+	now := time.Now()
+	for i, user := range users {
+		if id, exists := m.byEmail[user.EmailCanonical]; exists && updateExisting {
+			stored := m.users[id]
+			stored.Email = user.Email
+			stored.Password = user.Password
+			if user.Username != "" {
+				stored.Username = user.Username
+				stored.UsernameCanonical = user.UsernameCanonical
+			}
+			if user.Profile != nil {
+				stored.Profile = user.Profile
+			}
+			if user.Attributes != nil {
+				stored.Attributes = user.Attributes
+			}
+			if m.taken(stored.EmailCanonical, stored.UsernameCanonical, stored.ID) {
+				results[i].Err = ErrUserExists
+				continue
+			}
+			m.put(stored)
+			continue
+		}
+		created := *user
+		created.ID = primitive.NewObjectID().Hex()
+		created.TenantID = m.tenant
+		created.CreatedAt = now
+		created.Status = models.StatusActive
+		if created.Role == "" {
+			created.Role = models.RoleUser
+		}
+		if err := m.insert(created); err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Created = true
+	}
+	return results, nil
+}
+
+// -----------BULK CHANGE USERS FUNCTION--------
+func (m *memoryStore) BulkChangeUsers(ids []string, change BulkUserChange) ([]BulkItemResult, error) {
+	if !change.Delete && change.Status == "" && change.Role == "" {
+		return nil, ErrNoFieldsToUpdate
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	results := make([]BulkItemResult, len(ids))
+	for i, id := range ids {
+		if _, err := primitive.ObjectIDFromHex(id); err != nil {
+			results[i].Err = ErrInvalidUserID
+			continue
+		}
+		stored, ok := m.users[id]
+		if !ok {
+			results[i].Err = ErrUserNotFound
+			continue
+		}
+		if change.Delete {
+			m.remove(id)
+			continue
+		}
+		if change.Status != "" {
+			stored.Status = change.Status
+			stored.LockedUntil = nil
+		}
+		if change.Role != "" {
+			stored.Role = change.Role
+		}
+		m.put(stored)
+	}
+	return results, nil
+}
+
+func (m *memoryStore) FetchUserIDs(filter models.UserFilter, limit int64) ([]string, error) {
+	m.mu.RLock()
+	users := m.matching(filter)
+	m.mu.RUnlock()
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	sort.Strings(ids)
+	if limit > 0 && int64(len(ids)) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+// -----------SEARCH USERS FUNCTION--------
+func (m *memoryStore) SearchUsers(query string, limit int) ([]models.SearchHit, error) {
+	ids := m.index.Candidates(query, searchCandidates)
+	m.mu.RLock()
+	candidates := make([]models.User, 0, len(ids))
+	for _, id := range ids {
+		if u, ok := m.users[id]; ok {
+			candidates = append(candidates, u)
+		}
+	}
+	m.mu.RUnlock()
+	return search.RankUsers(query, candidates, limit), nil
+}
+
+// -----------TWO FACTOR FUNCTIONS--------
+func (m *memoryStore) SetTwoFactor(id string, twoFactor *models.TwoFactor) error {
+	return m.changeTwoFactor(id, func(stored *models.TwoFactor) (*models.TwoFactor, error) {
+		if twoFactor == nil {
+			return nil, nil
+		}
+		return copyTwoFactor(twoFactor), nil
+	})
+}
+
+func (m *memoryStore) UseTOTPStep(id string, step int64) error {
+	return m.changeTwoFactor(id, func(stored *models.TwoFactor) (*models.TwoFactor, error) {
+		if stored == nil || stored.LastStep >= step {
+			return nil, ErrCodeReused
+		}
+		stored.LastStep = step
+		return stored, nil
+	})
+}
+
+func (m *memoryStore) ConsumeRecoveryCode(id string, hash string) error {
+	return m.changeTwoFactor(id, func(stored *models.TwoFactor) (*models.TwoFactor, error) {
+		if stored == nil {
+			return nil, ErrCodeReused
+		}
+		i := slices.Index(stored.RecoveryCodes, hash)
+		if i < 0 {
+			return nil, ErrCodeReused
+		}
+		stored.RecoveryCodes = slices.Delete(stored.RecoveryCodes, i, i+1)
+		return stored, nil
+	})
+}
+
+// changeTwoFactor replaces a user's second factor with what fn returns from
+// a copy of the stored one
+func (m *memoryStore) changeTwoFactor(id string, fn func(stored *models.TwoFactor) (*models.TwoFactor, error)) error {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return ErrInvalidUserID
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	var current *models.TwoFactor
+	if stored.TwoFactor != nil {
+		current = copyTwoFactor(stored.TwoFactor)
+	}
+	changed, err := fn(current)
+	if err != nil {
+		return err
+	}
+	stored.TwoFactor = changed
+	m.users[id] = stored
+	return nil
+}
+
+func copyTwoFactor(tf *models.TwoFactor) *models.TwoFactor {
+	c := *tf
+	c.RecoveryCodes = slices.Clone(tf.RecoveryCodes)
+	return &c
+}
+
+func (m *memoryStore) LockUser(id string, until time.Time) error {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return ErrInvalidUserID
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if stored.Status != models.StatusActive && stored.Status != models.StatusLocked {
+		return nil
+	}
+	stored.Status = models.StatusLocked
+	stored.LockedUntil = &until
+	m.users[id] = stored
+	return nil
+}
+
+func (m *memoryStore) UnlockUser(id string) error {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return ErrInvalidUserID
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.users[id]
+	if !ok || stored.Status != models.StatusLocked {
+		return nil
+	}
+	stored.Status = models.StatusActive
+	stored.LockedUntil = nil
+	m.users[id] = stored
+	return nil
+}
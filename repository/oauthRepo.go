@@ -0,0 +1,413 @@
+package repository
+
+import (
+	"Users/models"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoOAuth struct {
+	client *mongo.Client
+	db     string
+}
+
+func NewMongoOAuth(client *mongo.Client, db string) OAuthRepository {
+	return &mongoOAuth{client: client, db: db}
+}
+
+func (m *mongoOAuth) clients() *mongo.Collection {
+	return m.client.Database(m.db).Collection("oauth_clients")
+}
+
+func (m *mongoOAuth) codes() *mongo.Collection {
+	return m.client.Database(m.db).Collection("oauth_codes")
+}
+
+func (m *mongoOAuth) tokens() *mongo.Collection {
+	return m.client.Database(m.db).Collection("oauth_tokens")
+}
+
+func (m *mongoOAuth) consents() *mongo.Collection {
+	return m.client.Database(m.db).Collection("oauth_consents")
+}
+
+// narrowed filters on the tenant and client, and on the user when given
+func narrowed(tenantID string, clientID string, userID string) bson.M {
+	filter := bson.M{"tenantId": tenantID, "clientId": clientID}
+	if userID != "" {
+		filter["userId"] = userID
+	}
+	return filter
+}
+
+func (m *mongoOAuth) CreateClient(client *models.OAuthClient) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.clients().InsertOne(ctx, client)
+	return err
+}
+
+func (m *mongoOAuth) FetchClient(id string) (*models.OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var client models.OAuthClient
+	err := m.clients().FindOne(ctx, bson.M{"_id": id}).Decode(&client)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrOAuthClientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (m *mongoOAuth) ListClients(tenantID string) ([]models.OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := m.clients().Find(ctx, bson.M{"tenantId": tenantID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	clients := []models.OAuthClient{}
+	if err := cursor.All(ctx, &clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (m *mongoOAuth) DeleteClient(tenantID string, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := m.clients().DeleteOne(ctx, bson.M{"_id": id, "tenantId": tenantID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrOAuthClientNotFound
+	}
+	return nil
+}
+
+func (m *mongoOAuth) SaveCode(code *models.AuthorizationCode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.codes().InsertOne(ctx, code)
+	return err
+}
+
+func (m *mongoOAuth) TakeCode(id string) (*models.AuthorizationCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	//deleting on read makes a code single use even across replicas
+	var code models.AuthorizationCode
+	err := m.codes().FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&code)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrOAuthCodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(code.ExpiresAt) {
+		return nil, ErrOAuthCodeNotFound
+	}
+	return &code, nil
+}
+
+func (m *mongoOAuth) CreateToken(token *models.OAuthToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.tokens().InsertOne(ctx, token)
+	return err
+}
+
+func (m *mongoOAuth) FetchToken(id string) (*models.OAuthToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{"_id": id, "expiresAt": bson.M{"$gt": time.Now()}}
+	var token models.OAuthToken
+	err := m.tokens().FindOne(ctx, filter).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrOAuthTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (m *mongoOAuth) DeleteToken(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.tokens().DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (m *mongoOAuth) DeleteTokens(tenantID string, clientID string, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.tokens().DeleteMany(ctx, narrowed(tenantID, clientID, userID))
+	return err
+}
+
+func (m *mongoOAuth) DeleteUserTokens(tenantID string, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.tokens().DeleteMany(ctx, bson.M{"tenantId": tenantID, "userId": userID})
+	return err
+}
+
+func (m *mongoOAuth) SaveConsent(consent *models.Consent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := narrowed(consent.TenantID, consent.ClientID, consent.UserID)
+	_, err := m.consents().ReplaceOne(ctx, filter, consent, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *mongoOAuth) FetchConsent(tenantID string, userID string, clientID string) (*models.Consent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var consent models.Consent
+	err := m.consents().FindOne(ctx, narrowed(tenantID, clientID, userID)).Decode(&consent)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrConsentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &consent, nil
+}
+
+func (m *mongoOAuth) ListConsents(tenantID string, userID string) ([]models.Consent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "grantedAt", Value: -1}})
+	cursor, err := m.consents().Find(ctx, bson.M{"tenantId": tenantID, "userId": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	consents := []models.Consent{}
+	if err := cursor.All(ctx, &consents); err != nil {
+		return nil, err
+	}
+	return consents, nil
+}
+
+func (m *mongoOAuth) DeleteConsents(tenantID string, clientID string, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := m.consents().DeleteMany(ctx, narrowed(tenantID, clientID, userID))
+	if err != nil {
+		return err
+	}
+	if userID != "" && result.DeletedCount == 0 {
+		return ErrConsentNotFound
+	}
+	return nil
+}
+
+func (m *mongoOAuth) DeleteUserConsents(tenantID string, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.consents().DeleteMany(ctx, bson.M{"tenantId": tenantID, "userId": userID})
+	return err
+}
+
+// in-memory OAuth store used with the memory user repository
+type memoryOAuth struct {
+	mu       sync.Mutex
+	clients  map[string]models.OAuthClient
+	codes    map[string]models.AuthorizationCode
+	tokens   map[string]models.OAuthToken
+	consents map[string]models.Consent
+}
+
+func NewMemoryOAuth() OAuthRepository {
+	return &memoryOAuth{
+		clients:  map[string]models.OAuthClient{},
+		codes:    map[string]models.AuthorizationCode{},
+		tokens:   map[string]models.OAuthToken{},
+		consents: map[string]models.Consent{},
+	}
+}
+
+func consentKey(tenantID string, userID string, clientID string) string {
+	return tenantID + "/" + userID + "/" + clientID
+}
+
+func (m *memoryOAuth) CreateClient(client *models.OAuthClient) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clients[client.ID] = *client
+	return nil
+}
+
+func (m *memoryOAuth) FetchClient(id string) (*models.OAuthClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	client, ok := m.clients[id]
+	if !ok {
+		return nil, ErrOAuthClientNotFound
+	}
+	return &client, nil
+}
+
+func (m *memoryOAuth) ListClients(tenantID string) ([]models.OAuthClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clients := []models.OAuthClient{}
+	for _, client := range m.clients {
+		if client.TenantID == tenantID {
+			clients = append(clients, client)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].CreatedAt.After(clients[j].CreatedAt) })
+	return clients, nil
+}
+
+func (m *memoryOAuth) DeleteClient(tenantID string, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	client, ok := m.clients[id]
+	if !ok || client.TenantID != tenantID {
+		return ErrOAuthClientNotFound
+	}
+	delete(m.clients, id)
+	return nil
+}
+
+func (m *memoryOAuth) SaveCode(code *models.AuthorizationCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[code.ID] = *code
+	return nil
+}
+
+func (m *memoryOAuth) TakeCode(id string) (*models.AuthorizationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.codes[id]
+	if !ok {
+		return nil, ErrOAuthCodeNotFound
+	}
+	delete(m.codes, id)
+	if !time.Now().Before(code.ExpiresAt) {
+		return nil, ErrOAuthCodeNotFound
+	}
+	return &code, nil
+}
+
+func (m *memoryOAuth) CreateToken(token *models.OAuthToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[token.ID] = *token
+	return nil
+}
+
+func (m *memoryOAuth) FetchToken(id string) (*models.OAuthToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.tokens[id]
+	if !ok {
+		return nil, ErrOAuthTokenNotFound
+	}
+	if !time.Now().Before(token.ExpiresAt) {
+		delete(m.tokens, id)
+		return nil, ErrOAuthTokenNotFound
+	}
+	return &token, nil
+}
+
+func (m *memoryOAuth) DeleteToken(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tokens, id)
+	return nil
+}
+
+func (m *memoryOAuth) DeleteTokens(tenantID string, clientID string, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, token := range m.tokens {
+		if token.TenantID == tenantID && token.ClientID == clientID && (userID == "" || token.UserID == userID) {
+			delete(m.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (m *memoryOAuth) DeleteUserTokens(tenantID string, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, token := range m.tokens {
+		if token.TenantID == tenantID && token.UserID == userID {
+			delete(m.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (m *memoryOAuth) SaveConsent(consent *models.Consent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.consents[consentKey(consent.TenantID, consent.UserID, consent.ClientID)] = *consent
+	return nil
+}
+
+func (m *memoryOAuth) FetchConsent(tenantID string, userID string, clientID string) (*models.Consent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	consent, ok := m.consents[consentKey(tenantID, userID, clientID)]
+	if !ok {
+		return nil, ErrConsentNotFound
+	}
+	return &consent, nil
+}
+
+func (m *memoryOAuth) ListConsents(tenantID string, userID string) ([]models.Consent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	consents := []models.Consent{}
+	for _, consent := range m.consents {
+		if consent.TenantID == tenantID && consent.UserID == userID {
+			consents = append(consents, consent)
+		}
+	}
+	sort.Slice(consents, func(i, j int) bool { return consents[i].GrantedAt.After(consents[j].GrantedAt) })
+	return consents, nil
+}
+
+func (m *memoryOAuth) DeleteConsents(tenantID string, clientID string, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for key, consent := range m.consents {
+		if consent.TenantID == tenantID && consent.ClientID == clientID && (userID == "" || consent.UserID == userID) {
+			delete(m.consents, key)
+			deleted++
+		}
+	}
+	if userID != "" && deleted == 0 {
+		return ErrConsentNotFound
+	}
+	return nil
+}
+
+func (m *memoryOAuth) DeleteUserConsents(tenantID string, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, consent := range m.consents {
+		if consent.TenantID == tenantID && consent.UserID == userID {
+			delete(m.consents, key)
+		}
+	}
+	return nil
+}
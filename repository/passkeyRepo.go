@@ -0,0 +1,271 @@
+package repository
+
+import (
+	"Users/models"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoPasskeys struct {
+	client *mongo.Client
+	db     string
+	tenant string
+}
+
+func NewMongoPasskeys(client *mongo.Client, db string, tenant string) PasskeyRepository {
+	return &mongoPasskeys{client: client, db: db, tenant: tenant}
+}
+
+func (m *mongoPasskeys) passkeys() *mongo.Collection {
+	return m.client.Database(m.db).Collection("passkeys")
+}
+
+func (m *mongoPasskeys) ceremonies() *mongo.Collection {
+	return m.client.Database(m.db).Collection("webauthn_ceremonies")
+}
+
+func (m *mongoPasskeys) CreatePasskey(passkey *models.Passkey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	objID := primitive.NewObjectID()
+	_, err := m.passkeys().InsertOne(ctx, bson.M{
+		"_id":            objID,
+		"tenantId":       m.tenant,
+		"userId":         passkey.UserID,
+		"credentialId":   passkey.CredentialID,
+		"name":           passkey.Name,
+		"publicKey":      passkey.PublicKey,
+		"algorithm":      passkey.Algorithm,
+		"signCount":      int64(passkey.SignCount),
+		"aaguid":         passkey.AAGUID,
+		"transports":     passkey.Transports,
+		"backupEligible": passkey.BackupEligible,
+		"backupState":    passkey.BackupState,
+		"createdAt":      passkey.CreatedAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrPasskeyExists
+	}
+	if err != nil {
+		return err
+	}
+	passkey.ID = objID.Hex()
+	passkey.TenantID = m.tenant
+	return nil
+}
+
+func (m *mongoPasskeys) FetchPasskey(credentialID string) (*models.Passkey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var passkey models.Passkey
+	err := m.passkeys().FindOne(ctx, bson.M{"tenantId": m.tenant, "credentialId": credentialID}).Decode(&passkey)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrPasskeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &passkey, nil
+}
+
+func (m *mongoPasskeys) ListPasskeys(userID string) ([]models.Passkey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := m.passkeys().Find(ctx, bson.M{"tenantId": m.tenant, "userId": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	passkeys := []models.Passkey{}
+	if err := cursor.All(ctx, &passkeys); err != nil {
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+func (m *mongoPasskeys) RecordPasskeyUse(credentialID string, oldCount uint32, newCount uint32, backupState bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{"tenantId": m.tenant, "credentialId": credentialID, "signCount": int64(oldCount)}
+	update := bson.M{"$set": bson.M{
+		"signCount":   int64(newCount),
+		"backupState": backupState,
+		"lastUsedAt":  time.Now(),
+	}}
+	result, err := m.passkeys().UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := m.FetchPasskey(credentialID); err != nil {
+			return err
+		}
+		return ErrStaleSignCount
+	}
+	return nil
+}
+
+func (m *mongoPasskeys) DeletePasskey(userID string, credentialID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := m.passkeys().DeleteOne(ctx, bson.M{"tenantId": m.tenant, "userId": userID, "credentialId": credentialID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+func (m *mongoPasskeys) DeleteUserPasskeys(userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.passkeys().DeleteMany(ctx, bson.M{"tenantId": m.tenant, "userId": userID})
+	return err
+}
+
+func (m *mongoPasskeys) SaveCeremony(ceremony *models.WebAuthnCeremony) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ceremony.TenantID = m.tenant
+	_, err := m.ceremonies().InsertOne(ctx, ceremony)
+	return err
+}
+
+func (m *mongoPasskeys) TakeCeremony(id string) (*models.WebAuthnCeremony, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	//the TTL monitor runs about once a minute, so expiry is checked here too
+	filter := bson.M{"_id": id, "tenantId": m.tenant, "expiresAt": bson.M{"$gt": time.Now()}}
+	var ceremony models.WebAuthnCeremony
+	err := m.ceremonies().FindOneAndDelete(ctx, filter).Decode(&ceremony)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrCeremonyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ceremony, nil
+}
+
+// in-memory passkey store used with the memory user repository
+type memoryPasskeys struct {
+	tenant     string
+	mu         sync.Mutex
+	passkeys   map[string]models.Passkey
+	ceremonies map[string]models.WebAuthnCeremony
+}
+
+func NewMemoryPasskeys(tenant string) PasskeyRepository {
+	return &memoryPasskeys{
+		tenant:     tenant,
+		passkeys:   map[string]models.Passkey{},
+		ceremonies: map[string]models.WebAuthnCeremony{},
+	}
+}
+
+func (m *memoryPasskeys) CreatePasskey(passkey *models.Passkey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.passkeys[passkey.CredentialID]; ok {
+		return ErrPasskeyExists
+	}
+	passkey.ID = primitive.NewObjectID().Hex()
+	passkey.TenantID = m.tenant
+	m.passkeys[passkey.CredentialID] = *passkey
+	return nil
+}
+
+func (m *memoryPasskeys) FetchPasskey(credentialID string) (*models.Passkey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	passkey, ok := m.passkeys[credentialID]
+	if !ok {
+		return nil, ErrPasskeyNotFound
+	}
+	return &passkey, nil
+}
+
+func (m *memoryPasskeys) ListPasskeys(userID string) ([]models.Passkey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	passkeys := []models.Passkey{}
+	for _, p := range m.passkeys {
+		if p.UserID == userID {
+			passkeys = append(passkeys, p)
+		}
+	}
+	sort.Slice(passkeys, func(i, j int) bool { return passkeys[i].CreatedAt.Before(passkeys[j].CreatedAt) })
+	return passkeys, nil
+}
+
+func (m *memoryPasskeys) RecordPasskeyUse(credentialID string, oldCount uint32, newCount uint32, backupState bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	passkey, ok := m.passkeys[credentialID]
+	if !ok {
+		return ErrPasskeyNotFound
+	}
+	if passkey.SignCount != oldCount {
+		return ErrStaleSignCount
+	}
+	now := time.Now()
+	passkey.SignCount = newCount
+	passkey.BackupState = backupState
+	passkey.LastUsedAt = &now
+	m.passkeys[credentialID] = passkey
+	return nil
+}
+
+func (m *memoryPasskeys) DeletePasskey(userID string, credentialID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	passkey, ok := m.passkeys[credentialID]
+	if !ok || passkey.UserID != userID {
+		return ErrPasskeyNotFound
+	}
+	delete(m.passkeys, credentialID)
+	return nil
+}
+
+func (m *memoryPasskeys) DeleteUserPasskeys(userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, p := range m.passkeys {
+		if p.UserID == userID {
+			delete(m.passkeys, id)
+		}
+	}
+	return nil
+}
+
+func (m *memoryPasskeys) SaveCeremony(ceremony *models.WebAuthnCeremony) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ceremony.TenantID = m.tenant
+	m.ceremonies[ceremony.ID] = *ceremony
+	return nil
+}
+
+func (m *memoryPasskeys) TakeCeremony(id string) (*models.WebAuthnCeremony, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ceremony, ok := m.ceremonies[id]
+	if !ok {
+		return nil, ErrCeremonyNotFound
+	}
+	delete(m.ceremonies, id)
+	if !time.Now().Before(ceremony.ExpiresAt) {
+		return nil, ErrCeremonyNotFound
+	}
+	return &ceremony, nil
+}
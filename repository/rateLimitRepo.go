@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func windowID(key string, start time.Time) string {
+	return key + "@" + strconv.FormatInt(start.Unix(), 10)
+}
+
+type mongoRateLimits struct {
+	client *mongo.Client
+	db     string
+}
+
+func NewMongoRateLimits(client *mongo.Client, db string) RateLimitRepository {
+	return &mongoRateLimits{client: client, db: db}
+}
+
+func (m *mongoRateLimits) windows() *mongo.Collection {
+	return m.client.Database(m.db).Collection("rate_limits")
+}
+
+func (m *mongoRateLimits) Hit(key string, start time.Time, window time.Duration) (int64, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var current struct {
+		Count int64 `bson:"count"`
+	}
+	//a window is still read as the previous one during the next, so it
+	//is kept for two
+	update := bson.M{
+		"$inc":         bson.M{"count": 1},
+		"$setOnInsert": bson.M{"expiresAt": start.Add(2 * window)},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := m.windows().FindOneAndUpdate(ctx, bson.M{"_id": windowID(key, start)}, update, opts).Decode(&current)
+	if err != nil {
+		return 0, 0, err
+	}
+	var previous struct {
+		Count int64 `bson:"count"`
+	}
+	err = m.windows().FindOne(ctx, bson.M{"_id": windowID(key, start.Add(-window))}).Decode(&previous)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, 0, err
+	}
+	return current.Count, previous.Count, nil
+}
+
+type rateWindow struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// in-memory windows for a single process
+type memoryRateLimits struct {
+	mu      sync.Mutex
+	windows map[string]rateWindow
+	hits    int
+}
+
+func NewMemoryRateLimits() RateLimitRepository {
+	return &memoryRateLimits{windows: map[string]rateWindow{}}
+}
+
+func (m *memoryRateLimits) Hit(key string, start time.Time, window time.Duration) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	//sweeping now and then keeps idle keys from piling up
+	m.hits++
+	if m.hits%1024 == 0 {
+		for id, w := range m.windows {
+			if !now.Before(w.expiresAt) {
+				delete(m.windows, id)
+			}
+		}
+	}
+	id := windowID(key, start)
+	current, ok := m.windows[id]
+	if !ok {
+		current = rateWindow{expiresAt: start.Add(2 * window)}
+	}
+	current.count++
+	m.windows[id] = current
+	return current.count, m.windows[windowID(key, start.Add(-window))].count, nil
+}
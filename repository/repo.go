@@ -2,13 +2,17 @@ package repository
 
 import (
 	"Users/models"
+	"Users/search"
 	"context"
 	"errors"
+	"regexp"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 /*
@@ -16,14 +20,28 @@ bringing the mongodb functions into the repository layer
 */
 type mongoClient struct {
 	client *mongo.Client
+	//every query is confined to one tenant, in the database that tenant
+	//lives in
+	db     string
+	tenant string
 }
 
 /*
 function that sends the functions in this repo.go layer
 to a an interface called UserRepository in interfaces.go
 */
-func NewMongo(client *mongo.Client) UserRepository {
-	return &mongoClient{client: client}
+func NewMongo(client *mongo.Client, db string, tenant string) UserRepository {
+	return &mongoClient{client: client, db: db, tenant: tenant}
+}
+
+func (m *mongoClient) users() *mongo.Collection {
+	return m.client.Database(m.db).Collection("users")
+}
+
+// scoped adds the tenant condition to filter; no query may run without it
+func (m *mongoClient) scoped(filter bson.M) bson.M {
+	filter["tenantId"] = m.tenant
+	return filter
 }
 
 //-----CREATE USER FUNCTION-----
@@ -31,15 +49,20 @@ func NewMongo(client *mongo.Client) UserRepository {
 // users *models.User is a pointer to the user struct
 func (m *mongoClient) CreateUser(user *models.User) error {
 	user.CreatedAt = time.Now()
-	user.Status = "active"
+	user.Status = models.StatusActive
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	user.TenantID = m.tenant
+	user.SearchGrams = search.UserTrigrams(user)
 	//database actions for creating user
-	collection := m.client.Database("usersdb").Collection("users")
+	collection := m.users()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	result, err := collection.InsertOne(ctx, user)
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
-			return errors.New("user already exists")
+			return ErrUserExists
 		}
 		return err
 
@@ -56,54 +79,141 @@ func (m *mongoClient) UpdateUser(user *models.User) error {
 	//update user logic
 	objID, err := primitive.ObjectIDFromHex(user.ID)
 	if err != nil {
-		return errors.New("invalid user ID")
+		return ErrInvalidUserID
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	collection := m.client.Database("usersdb").Collection("users")
+	collection := m.users()
 	updateFields := bson.M{}
 	if user.Email != "" {
 		updateFields["email"] = user.Email
+		updateFields["emailCanonical"] = user.EmailCanonical
 	}
 	if user.Username != "" {
 		updateFields["username"] = user.Username
+		updateFields["usernameCanonical"] = user.UsernameCanonical
 	}
 	if user.Password != "" {
 		updateFields["password"] = user.Password
 	}
+	for field, value := range profileFields(user.Profile) {
+		updateFields["profile."+field] = value
+	}
+	if user.Attributes != nil {
+		updateFields["attributes"] = user.Attributes
+	}
 	if len(updateFields) == 0 {
-		return errors.New("no fields to update")
+		return ErrNoFieldsToUpdate
 	}
-	filter := bson.M{"_id": objID}
+	filter := m.scoped(bson.M{"_id": objID})
 	update := bson.M{"$set": updateFields}
 	result, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrUserExists
+		}
 		return err
 	}
 	if result.MatchedCount == 0 {
-		return errors.New("user not found")
+		return ErrUserNotFound
+	}
+	//the grams depend on both identifiers, so they are rebuilt from the stored document
+	if user.Email != "" || user.Username != "" || (user.Profile != nil && user.Profile.DisplayName != "") {
+		return m.rebuildGrams(ctx, bson.M{"_id": objID})
 	}
 	return nil
 
 }
 
+// profileFields returns the non-empty profile fields by their bson name
+func profileFields(p *models.Profile) bson.M {
+	fields := bson.M{}
+	if p == nil {
+		return fields
+	}
+	for field, value := range map[string]string{
+		"displayName": p.DisplayName,
+		"locale":      p.Locale,
+		"timezone":    p.Timezone,
+		"phone":       p.Phone,
+		"avatarUrl":   p.AvatarURL,
+	} {
+		if value != "" {
+			fields[field] = value
+		}
+	}
+	return fields
+}
+
+// -----------PATCH USER FUNCTION---------------
+func (m *mongoClient) PatchUser(user *models.User) error {
+	objID, err := primitive.ObjectIDFromHex(user.ID)
+	if err != nil {
+		return ErrInvalidUserID
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.users()
+	set := bson.M{
+		"email":          user.Email,
+		"emailCanonical": user.EmailCanonical,
+		"searchGrams":    search.UserTrigrams(user),
+	}
+	unset := bson.M{}
+	if user.Username != "" {
+		set["username"] = user.Username
+		set["usernameCanonical"] = user.UsernameCanonical
+	} else {
+		unset["username"] = ""
+		unset["usernameCanonical"] = ""
+	}
+	if user.Password != "" {
+		set["password"] = user.Password
+	}
+	if user.Profile.IsZero() {
+		unset["profile"] = ""
+	} else {
+		set["profile"] = user.Profile
+	}
+	if len(user.Attributes) == 0 {
+		unset["attributes"] = ""
+	} else {
+		set["attributes"] = user.Attributes
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	result, err := collection.UpdateOne(ctx, m.scoped(bson.M{"_id": objID}), update)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrUserExists
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
 // -----------DELETE USER FUNCTION---------------
 func (m *mongoClient) DeleteUser(user *models.User) error {
 	//delete user logic
 	objID, err := primitive.ObjectIDFromHex(user.ID)
 	if err != nil {
-		return errors.New("invalid user ID")
+		return ErrInvalidUserID
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	collection := m.client.Database("usersdb").Collection("users")
-	filter := bson.M{"_id": objID}
+	collection := m.users()
+	filter := m.scoped(bson.M{"_id": objID})
 	result, err := collection.DeleteOne(ctx, filter)
 	if err != nil {
 		return err
 	}
 	if result.DeletedCount == 0 {
-		return errors.New("user not found")
+		return ErrUserNotFound
 	}
 	return nil
 }
@@ -113,8 +223,8 @@ func (m *mongoClient) FetchAllUsers() ([]models.User, error) {
 	//fetch all users logic
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	collection := m.client.Database("usersdb").Collection("users")
-	filter := bson.M{}
+	collection := m.users()
+	filter := m.scoped(bson.M{})
 	cursor, err := collection.Find(ctx, filter)
 	if err != nil {
 		return nil, err
@@ -135,38 +245,580 @@ func (m *mongoClient) FetchUserByID(id string) (*models.User, error) {
 	//fetch user by id logic
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return nil, errors.New("invalid user ID")
+		return nil, ErrInvalidUserID
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	collection := m.client.Database("usersdb").Collection("users")
-	filter := bson.M{"_id": objID}
+	collection := m.users()
+	filter := m.scoped(bson.M{"_id": objID})
 	var user models.User
 	err = collection.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUserNotFound
+		}
 		return nil, err
 	}
 	return &user, nil
 
 }
+
+// documents written before canonical fields existed only have the display
+// form, so lookups fall back to an exact match on it
+func (m *mongoClient) FetchUserByEmail(email string) (*models.User, error) {
+	return m.fetchUserBy(bson.M{"$or": bson.A{
+		bson.M{"emailCanonical": email},
+		bson.M{"emailCanonical": bson.M{"$exists": false}, "email": email},
+	}})
+}
+func (m *mongoClient) FetchUserByUsername(username string) (*models.User, error) {
+	return m.fetchUserBy(bson.M{"$or": bson.A{
+		bson.M{"usernameCanonical": username},
+		bson.M{"usernameCanonical": bson.M{"$exists": false}, "username": username},
+	}})
+}
+func (m *mongoClient) fetchUserBy(filter bson.M) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.users()
+	var user models.User
+	err := collection.FindOne(ctx, m.scoped(filter)).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
 func (m *mongoClient) UpdateUserStatus(id string, status string) error {
 	//update user status logic
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return errors.New("invalid user ID")
+		return ErrInvalidUserID
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	collection := m.client.Database("usersdb").Collection("users")
-	filter := bson.M{"_id": objID}
-	update := bson.M{"$set": bson.M{"status": status}}
+	collection := m.users()
+	filter := m.scoped(bson.M{"_id": objID})
+	//any explicit status ends a temporary lockout
+	update := bson.M{"$set": bson.M{"status": status}, "$unset": bson.M{"lockedUntil": ""}}
 	result, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
 	if result.MatchedCount == 0 {
-		return errors.New("user not found")
+		return ErrUserNotFound
 	}
 	return nil
 
 }
+
+func (m *mongoClient) UpdateUserRole(id string, role string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidUserID
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.users()
+	filter := m.scoped(bson.M{"_id": objID})
+	update := bson.M{"$set": bson.M{"role": role}}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// -----------LIST USERS FUNCTION--------
+func (m *mongoClient) ListUsers(userFilter models.UserFilter) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.users()
+	filter := m.scoped(userFilterQuery(userFilter))
+	findOptions := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	if userFilter.Limit > 0 {
+		findOptions.SetLimit(userFilter.Limit)
+	}
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// -----------STREAM USERS FUNCTION--------
+func (m *mongoClient) StreamUsers(ctx context.Context, userFilter models.UserFilter, fn func(*models.User) error) error {
+	collection := m.users()
+	//small batches keep memory flat however large the collection is
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetBatchSize(500)
+	if userFilter.Limit > 0 {
+		findOptions.SetLimit(userFilter.Limit)
+	}
+	cursor, err := collection.Find(ctx, m.scoped(userFilterQuery(userFilter)), findOptions)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		if err := fn(&user); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func userFilterQuery(userFilter models.UserFilter) bson.M {
+	filter := bson.M{}
+	if userFilter.Status != "" {
+		filter["status"] = userFilter.Status
+	}
+	if userFilter.Role != "" {
+		filter["role"] = userFilter.Role
+	}
+	if userFilter.Query != "" {
+		prefix := bson.M{"$regex": "^" + regexp.QuoteMeta(strings.ToLower(userFilter.Query))}
+		filter["$or"] = bson.A{
+			bson.M{"emailCanonical": prefix},
+			bson.M{"usernameCanonical": prefix},
+		}
+	}
+	return filter
+}
+
+// -----------RESTORE USER FUNCTION--------
+func (m *mongoClient) RestoreUser(user *models.User) error {
+	user.TenantID = m.tenant
+	doc, err := userDocument(user)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.users()
+	_, err = collection.InsertOne(ctx, doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrUserExists
+	}
+	return err
+}
+
+// userDocument marshals a user keeping its hex ID as an ObjectID _id
+func userDocument(user *models.User) (bson.M, error) {
+	user.SearchGrams = search.UserTrigrams(user)
+	raw, err := bson.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	delete(doc, "_id")
+	if user.ID != "" {
+		objID, err := primitive.ObjectIDFromHex(user.ID)
+		if err != nil {
+			return nil, ErrInvalidUserID
+		}
+		doc["_id"] = objID
+	}
+	return doc, nil
+}
+
+func (m *mongoClient) FetchUsersByCanonical(emails []string, usernames []string) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.users()
+	filter := m.scoped(bson.M{"$or": bson.A{
+		bson.M{"emailCanonical": bson.M{"$in": emails}},
+		bson.M{"usernameCanonical": bson.M{"$in": usernames}},
+	}})
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// -----------BULK WRITE USERS FUNCTION--------
+func (m *mongoClient) BulkWriteUsers(users []*models.User, updateExisting bool) ([]BulkItemResult, error) {
+	results := make([]BulkItemResult, len(users))
+	if len(users) == 0 {
+		return results, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	collection := m.users()
+
+	now := time.Now()
+	writes := make([]mongo.WriteModel, len(users))
+	for i, user := range users {
+		user.CreatedAt = now
+		user.Status = models.StatusActive
+		if user.Role == "" {
+			user.Role = models.RoleUser
+		}
+		user.TenantID = m.tenant
+		user.SearchGrams = search.UserTrigrams(user)
+		if !updateExisting {
+			writes[i] = mongo.NewInsertOneModel().SetDocument(user)
+			continue
+		}
+		//grams of the row are right for new users; existing ones get theirs
+		//rebuilt from the merged document below
+		set := bson.M{"email": user.Email, "password": user.Password, "searchGrams": user.SearchGrams}
+		if user.Username != "" {
+			set["username"] = user.Username
+			set["usernameCanonical"] = user.UsernameCanonical
+		}
+		if user.Profile != nil {
+			set["profile"] = user.Profile
+		}
+		if user.Attributes != nil {
+			set["attributes"] = user.Attributes
+		}
+		writes[i] = mongo.NewUpdateOneModel().
+			SetFilter(m.scoped(bson.M{"emailCanonical": user.EmailCanonical})).
+			SetUpdate(bson.M{
+				"$set":         set,
+				"$setOnInsert": bson.M{"status": user.Status, "role": user.Role, "createdAt": user.CreatedAt},
+			}).
+			SetUpsert(true)
+	}
+
+	result, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bulkErr) {
+		return nil, err
+	}
+	failed := map[int]bool{}
+	for _, writeErr := range bulkErr.WriteErrors {
+		failed[writeErr.Index] = true
+		if mongo.IsDuplicateKeyError(writeErr) {
+			results[writeErr.Index].Err = ErrUserExists
+		} else {
+			results[writeErr.Index].Err = errors.New(writeErr.Message)
+		}
+	}
+	var merged []string
+	for i, user := range users {
+		if failed[i] {
+			continue
+		}
+		if !updateExisting {
+			results[i].Created = true
+			continue
+		}
+		if result != nil {
+			_, results[i].Created = result.UpsertedIDs[int64(i)]
+		}
+		if !results[i].Created {
+			merged = append(merged, user.EmailCanonical)
+		}
+	}
+	if len(merged) > 0 {
+		if err := m.rebuildGrams(ctx, bson.M{"emailCanonical": bson.M{"$in": merged}}); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// rebuildGrams recomputes the search grams of the users matching filter
+// from their stored documents, after writes that merged fields into them
+func (m *mongoClient) rebuildGrams(ctx context.Context, filter bson.M) error {
+	collection := m.users()
+	cursor, err := collection.Find(ctx, m.scoped(filter))
+	if err != nil {
+		return err
+	}
+	var stored []models.User
+	if err := cursor.All(ctx, &stored); err != nil {
+		return err
+	}
+	if len(stored) == 0 {
+		return nil
+	}
+	writes := make([]mongo.WriteModel, len(stored))
+	for i := range stored {
+		objID, err := primitive.ObjectIDFromHex(stored[i].ID)
+		if err != nil {
+			return err
+		}
+		writes[i] = mongo.NewUpdateOneModel().
+			SetFilter(m.scoped(bson.M{"_id": objID})).
+			SetUpdate(bson.M{"$set": bson.M{"searchGrams": search.UserTrigrams(&stored[i])}})
+	}
+	_, err = collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// -----------BULK CHANGE USERS FUNCTION--------
+func (m *mongoClient) BulkChangeUsers(ids []string, change BulkUserChange) ([]BulkItemResult, error) {
+	results := make([]BulkItemResult, len(ids))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	collection := m.users()
+
+	//resolving which IDs exist first so each one gets its own result
+	objIDs := make([]primitive.ObjectID, 0, len(ids))
+	for i, id := range ids {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			results[i].Err = ErrInvalidUserID
+			continue
+		}
+		objIDs = append(objIDs, objID)
+	}
+	cursor, err := collection.Find(ctx, m.scoped(bson.M{"_id": bson.M{"$in": objIDs}}), options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var found []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	exists := map[string]bool{}
+	for _, f := range found {
+		exists[f.ID.Hex()] = true
+	}
+
+	set := bson.M{}
+	if change.Status != "" {
+		set["status"] = change.Status
+	}
+	if change.Role != "" {
+		set["role"] = change.Role
+	}
+	if !change.Delete && len(set) == 0 {
+		return nil, ErrNoFieldsToUpdate
+	}
+	var writes []mongo.WriteModel
+	var positions []int
+	for i, id := range ids {
+		if results[i].Err != nil {
+			continue
+		}
+		if !exists[id] {
+			results[i].Err = ErrUserNotFound
+			continue
+		}
+		objID, _ := primitive.ObjectIDFromHex(id)
+		if change.Delete {
+			writes = append(writes, mongo.NewDeleteOneModel().SetFilter(m.scoped(bson.M{"_id": objID})))
+		} else {
+			update := bson.M{"$set": set}
+			if change.Status != "" {
+				update["$unset"] = bson.M{"lockedUntil": ""}
+			}
+			writes = append(writes, mongo.NewUpdateOneModel().SetFilter(m.scoped(bson.M{"_id": objID})).SetUpdate(update))
+		}
+		positions = append(positions, i)
+	}
+	if len(writes) == 0 {
+		return results, nil
+	}
+
+	_, err = collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bulkErr) {
+		return nil, err
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		results[positions[writeErr.Index]].Err = errors.New(writeErr.Message)
+	}
+	return results, nil
+}
+
+func (m *mongoClient) FetchUserIDs(userFilter models.UserFilter, limit int64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	collection := m.users()
+	findOptions := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(limit)
+	cursor, err := collection.Find(ctx, m.scoped(userFilterQuery(userFilter)), findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var ids []string
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		ids = append(ids, doc.ID.Hex())
+	}
+	return ids, cursor.Err()
+}
+
+// -----------SEARCH USERS FUNCTION--------
+// candidates come from the text index (whole words) and the searchGrams
+// multikey index (prefixes and typos); ranking is shared with the memory store
+func (m *mongoClient) SearchUsers(query string, limit int) ([]models.SearchHit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.users()
+
+	candidates := map[string]models.User{}
+	textOptions := options.Find().
+		SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}}).
+		SetSort(bson.M{"score": bson.M{"$meta": "textScore"}}).
+		SetLimit(searchCandidates)
+	cursor, err := collection.Find(ctx, m.scoped(bson.M{"$text": bson.M{"$search": query}}), textOptions)
+	if err != nil {
+		return nil, err
+	}
+	var textMatches []models.User
+	if err := cursor.All(ctx, &textMatches); err != nil {
+		return nil, err
+	}
+	for _, u := range textMatches {
+		candidates[u.ID] = u
+	}
+
+	if grams := search.Trigrams(query); len(grams) > 0 {
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: m.scoped(bson.M{"searchGrams": bson.M{"$in": grams}})}},
+			{{Key: "$addFields", Value: bson.M{"overlap": bson.M{"$size": bson.M{"$setIntersection": bson.A{"$searchGrams", grams}}}}}},
+			{{Key: "$sort", Value: bson.D{{Key: "overlap", Value: -1}, {Key: "_id", Value: 1}}}},
+			{{Key: "$limit", Value: searchCandidates}},
+		}
+		cursor, err := collection.Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, err
+		}
+		var gramMatches []models.User
+		if err := cursor.All(ctx, &gramMatches); err != nil {
+			return nil, err
+		}
+		for _, u := range gramMatches {
+			candidates[u.ID] = u
+		}
+	}
+
+	users := make([]models.User, 0, len(candidates))
+	for _, u := range candidates {
+		users = append(users, u)
+	}
+	return search.RankUsers(query, users, limit), nil
+}
+
+// -----------TWO FACTOR FUNCTIONS--------
+func (m *mongoClient) SetTwoFactor(id string, twoFactor *models.TwoFactor) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidUserID
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	update := bson.M{"$unset": bson.M{"twoFactor": ""}}
+	if twoFactor != nil {
+		update = bson.M{"$set": bson.M{"twoFactor": twoFactor}}
+	}
+	result, err := m.users().UpdateOne(ctx, m.scoped(bson.M{"_id": objID}), update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (m *mongoClient) UseTOTPStep(id string, step int64) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidUserID
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	//the condition makes concurrent logins with the same code race safely
+	filter := m.scoped(bson.M{
+		"_id": objID,
+		"$or": bson.A{
+			bson.M{"twoFactor.lastStep": bson.M{"$exists": false}},
+			bson.M{"twoFactor.lastStep": bson.M{"$lt": step}},
+		},
+	})
+	result, err := m.users().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"twoFactor.lastStep": step}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrCodeReused
+	}
+	return nil
+}
+
+func (m *mongoClient) ConsumeRecoveryCode(id string, hash string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidUserID
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := m.scoped(bson.M{"_id": objID, "twoFactor.recoveryCodes": hash})
+	result, err := m.users().UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"twoFactor.recoveryCodes": hash}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrCodeReused
+	}
+	return nil
+}
+
+func (m *mongoClient) LockUser(id string, until time.Time) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidUserID
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	//a suspended user must not be reactivated when the lock expires
+	filter := m.scoped(bson.M{"_id": objID, "status": bson.M{"$in": bson.A{models.StatusActive, models.StatusLocked}}})
+	update := bson.M{"$set": bson.M{"status": models.StatusLocked, "lockedUntil": until}}
+	result, err := m.users().UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := m.FetchUserByID(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mongoClient) UnlockUser(id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidUserID
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := m.scoped(bson.M{"_id": objID, "status": models.StatusLocked})
+	update := bson.M{"$set": bson.M{"status": models.StatusActive}, "$unset": bson.M{"lockedUntil": ""}}
+	_, err = m.users().UpdateOne(ctx, filter, update)
+	return err
+}
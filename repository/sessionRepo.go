@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"Users/models"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoSessions struct {
+	client *mongo.Client
+	db     string
+}
+
+func NewMongoSessions(client *mongo.Client, db string) SessionRepository {
+	return &mongoSessions{client: client, db: db}
+}
+
+func (m *mongoSessions) sessions() *mongo.Collection {
+	return m.client.Database(m.db).Collection("sessions")
+}
+
+func (m *mongoSessions) CreateSession(session *models.Session) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.sessions().InsertOne(ctx, session)
+	return err
+}
+
+func (m *mongoSessions) FetchSession(id string) (*models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	//the TTL monitor only runs once a minute, so filter on expiry as well
+	filter := bson.M{"_id": id, "expiresAt": bson.M{"$gt": time.Now()}}
+	var session models.Session
+	err := m.sessions().FindOne(ctx, filter).Decode(&session)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (m *mongoSessions) ListSessions(tenantID string, userID string) ([]models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{"tenantId": tenantID, "userId": userID, "expiresAt": bson.M{"$gt": time.Now()}}
+	opts := options.Find().SetSort(bson.D{{Key: "lastSeenAt", Value: -1}})
+	cursor, err := m.sessions().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []models.Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (m *mongoSessions) TouchSession(id string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.sessions().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"lastSeenAt": at}})
+	return err
+}
+
+func (m *mongoSessions) DeleteSession(tenantID string, userID string, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := m.sessions().DeleteOne(ctx, bson.M{"_id": id, "tenantId": tenantID, "userId": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (m *mongoSessions) DeleteUserSessions(tenantID string, userID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := m.sessions().DeleteMany(ctx, bson.M{"tenantId": tenantID, "userId": userID})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// in-memory sessions used with the memory user repository
+type memorySessions struct {
+	mu       sync.Mutex
+	sessions map[string]models.Session
+}
+
+func NewMemorySessions() SessionRepository {
+	return &memorySessions{sessions: map[string]models.Session{}}
+}
+
+func (m *memorySessions) CreateSession(session *models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[session.ID] = *session
+	return nil
+}
+
+func (m *memorySessions) FetchSession(id string) (*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok || !time.Now().Before(session.ExpiresAt) {
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (m *memorySessions) ListSessions(tenantID string, userID string) ([]models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	sessions := []models.Session{}
+	for id, session := range m.sessions {
+		if !now.Before(session.ExpiresAt) {
+			delete(m.sessions, id)
+			continue
+		}
+		if session.TenantID == tenantID && session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions, nil
+}
+
+func (m *memorySessions) TouchSession(id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if session, ok := m.sessions[id]; ok {
+		session.LastSeenAt = at
+		m.sessions[id] = session
+	}
+	return nil
+}
+
+func (m *memorySessions) DeleteSession(tenantID string, userID string, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok || session.TenantID != tenantID || session.UserID != userID {
+		return ErrSessionNotFound
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *memorySessions) DeleteUserSessions(tenantID string, userID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for id, session := range m.sessions {
+		if session.TenantID == tenantID && session.UserID == userID {
+			delete(m.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
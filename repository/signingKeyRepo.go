@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"Users/models"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoSigningKeys struct {
+	client *mongo.Client
+	db     string
+}
+
+func NewMongoSigningKeys(client *mongo.Client, db string) SigningKeyRepository {
+	return &mongoSigningKeys{client: client, db: db}
+}
+
+func (m *mongoSigningKeys) keys() *mongo.Collection {
+	return m.client.Database(m.db).Collection("signing_keys")
+}
+
+func (m *mongoSigningKeys) CreateSigningKey(key *models.SigningKey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.keys().InsertOne(ctx, key)
+	return err
+}
+
+func (m *mongoSigningKeys) ListSigningKeys() ([]models.SigningKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "activatesAt", Value: -1}, {Key: "createdAt", Value: -1}})
+	cursor, err := m.keys().Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	keys := []models.SigningKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (m *mongoSigningKeys) RetireSigningKey(id string, retiresAt time.Time, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := m.keys().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"retiresAt": retiresAt, "expiresAt": expiresAt}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSigningKeyNotFound
+	}
+	return nil
+}
+
+func (m *mongoSigningKeys) RevokeSigningKey(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	filter := bson.M{"_id": id, "revokedAt": bson.M{"$exists": false}}
+	result, err := m.keys().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revokedAt": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSigningKeyNotFound
+	}
+	return nil
+}
+
+// in-memory signing keys used with the memory user repository
+type memorySigningKeys struct {
+	mu   sync.Mutex
+	keys map[string]models.SigningKey
+}
+
+func NewMemorySigningKeys() SigningKeyRepository {
+	return &memorySigningKeys{keys: map[string]models.SigningKey{}}
+}
+
+func (m *memorySigningKeys) CreateSigningKey(key *models.SigningKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[key.ID] = *key
+	return nil
+}
+
+func (m *memorySigningKeys) ListSigningKeys() ([]models.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	keys := []models.SigningKey{}
+	for id, key := range m.keys {
+		//expired keys go, as the TTL index drops them in mongo
+		if !now.Before(key.ExpiresAt) {
+			delete(m.keys, id)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ActivatesAt.Equal(keys[j].ActivatesAt) {
+			return keys[i].CreatedAt.After(keys[j].CreatedAt)
+		}
+		return keys[i].ActivatesAt.After(keys[j].ActivatesAt)
+	})
+	return keys, nil
+}
+
+func (m *memorySigningKeys) RetireSigningKey(id string, retiresAt time.Time, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[id]
+	if !ok {
+		return ErrSigningKeyNotFound
+	}
+	key.RetiresAt = retiresAt
+	key.ExpiresAt = expiresAt
+	m.keys[id] = key
+	return nil
+}
+
+func (m *memorySigningKeys) RevokeSigningKey(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[id]
+	if !ok || key.RevokedAt != nil {
+		return ErrSigningKeyNotFound
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	m.keys[id] = key
+	return nil
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"Users/models"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoTenants struct {
+	client *mongo.Client
+	db     string
+}
+
+func NewMongoTenants(client *mongo.Client, db string) TenantRepository {
+	return &mongoTenants{client: client, db: db}
+}
+
+func (m *mongoTenants) CreateTenant(tenant *models.Tenant) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.client.Database(m.db).Collection("tenants")
+	tenant.CreatedAt = time.Now()
+	_, err := collection.InsertOne(ctx, tenant)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrTenantExists
+	}
+	return err
+}
+
+func (m *mongoTenants) FetchTenant(id string) (*models.Tenant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.client.Database(m.db).Collection("tenants")
+	var tenant models.Tenant
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&tenant)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+func (m *mongoTenants) ListTenants() ([]models.Tenant, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	collection := m.client.Database(m.db).Collection("tenants")
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var tenants []models.Tenant
+	if err := cursor.All(ctx, &tenants); err != nil {
+		return nil, err
+	}
+	return tenants, nil
+}
+
+// in-memory tenant registry used with the memory user repository
+type memoryTenants struct {
+	mu      sync.Mutex
+	tenants map[string]models.Tenant
+}
+
+func NewMemoryTenants() TenantRepository {
+	return &memoryTenants{tenants: map[string]models.Tenant{}}
+}
+
+func (m *memoryTenants) CreateTenant(tenant *models.Tenant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tenants[tenant.ID]; ok {
+		return ErrTenantExists
+	}
+	tenant.CreatedAt = time.Now()
+	m.tenants[tenant.ID] = *tenant
+	return nil
+}
+
+func (m *memoryTenants) FetchTenant(id string) (*models.Tenant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tenant, ok := m.tenants[id]
+	if !ok {
+		return nil, ErrTenantNotFound
+	}
+	return &tenant, nil
+}
+
+func (m *memoryTenants) ListTenants() ([]models.Tenant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tenants := make([]models.Tenant, 0, len(m.tenants))
+	for _, t := range m.tenants {
+		tenants = append(tenants, t)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+	return tenants, nil
+}
@@ -0,0 +1,230 @@
+// Package search holds the matching and ranking shared by the user search
+// backends: trigram extraction for fuzzy candidate lookup, scoring and
+// highlighting.
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Trigrams returns the distinct trigrams of the lowercased words in s. Words
+// are padded so short terms and word starts still produce grams.
+func Trigrams(values ...string) []string {
+	seen := map[string]bool{}
+	var grams []string
+	for _, value := range values {
+		for _, word := range words(value) {
+			padded := []rune("  " + word + " ")
+			for i := 0; i+3 <= len(padded); i++ {
+				g := string(padded[i : i+3])
+				if !seen[g] {
+					seen[g] = true
+					grams = append(grams, g)
+				}
+			}
+		}
+	}
+	return grams
+}
+
+// words splits on anything that is not a letter or digit, so email
+// addresses and snake_case usernames index per part
+func words(value string) []string {
+	return strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > utf8.RuneSelf)
+	})
+}
+
+// Field is a named searchable value with a weight applied to its score.
+type Field struct {
+	Name   string
+	Value  string
+	Weight float64
+}
+
+// Match is the best way a query matched a set of fields.
+type Match struct {
+	Score      float64
+	Highlights map[string]string
+}
+
+// Score ranks how well query matches fields: exact beats prefix beats
+// substring beats fuzzy. ok is false when nothing matched well enough.
+func Score(query string, fields []Field) (Match, bool) {
+	q := strings.ToLower(strings.TrimSpace(query))
+	match := Match{Highlights: map[string]string{}}
+	if q == "" {
+		return match, false
+	}
+	for _, f := range fields {
+		if f.Value == "" {
+			continue
+		}
+		value := strings.ToLower(f.Value)
+		var score float64
+		switch idx := strings.Index(value, q); {
+		case value == q:
+			score = 1
+		case idx == 0:
+			score = 0.9
+		case idx > 0 && isWordStart(value, idx):
+			score = 0.8
+		case idx > 0:
+			score = 0.6
+		default:
+			score = 0.5 * fuzzy(q, value)
+		}
+		if score == 0 {
+			continue
+		}
+		if idx := strings.Index(value, q); idx >= 0 && len(value) == len(f.Value) {
+			match.Highlights[f.Name] = highlight(f.Value, idx, len(q))
+		}
+		weighted := score * f.Weight
+		if weighted > match.Score {
+			match.Score = weighted
+		}
+	}
+	return match, match.Score > 0
+}
+
+func isWordStart(value string, idx int) bool {
+	r, _ := utf8.DecodeLastRuneInString(value[:idx])
+	return len(words(string(r))) == 0
+}
+
+// fuzzy compares the query with each word of value, and with the word's
+// prefix of the same length so partially typed names match too. It returns
+// 0 when the best match needs more edits than the query length allows.
+func fuzzy(q, value string) float64 {
+	query := []rune(q)
+	allowed := 2
+	switch {
+	case len(query) < 4:
+		return 0
+	case len(query) < 8:
+		allowed = 1
+	}
+	best := 0.0
+	for _, word := range words(value) {
+		w := []rune(word)
+		candidates := [][]rune{w}
+		if len(w) > len(query) {
+			candidates = append(candidates, w[:len(query)])
+		}
+		for _, c := range candidates {
+			if d := editDistance(query, c); d <= allowed {
+				best = max(best, 1-float64(d)/float64(max(len(query), len(c))))
+			}
+		}
+	}
+	return best
+}
+
+// editDistance is the optimal string alignment distance: insertions,
+// deletions, substitutions and adjacent transpositions cost one each
+func editDistance(a, b []rune) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// highlight wraps value[start:start+n] in <em> and escapes the rest, so the
+// result is safe to insert as HTML
+func highlight(value string, start, n int) string {
+	if start+n > len(value) {
+		return html.EscapeString(value)
+	}
+	return html.EscapeString(value[:start]) + "<em>" + html.EscapeString(value[start:start+n]) + "</em>" + html.EscapeString(value[start+n:])
+}
+
+// Index is an in-process inverted index from trigrams to document IDs.
+type Index struct {
+	mu    sync.RWMutex
+	grams map[string]map[string]struct{}
+	docs  map[string][]string
+}
+
+func NewIndex() *Index {
+	return &Index{grams: map[string]map[string]struct{}{}, docs: map[string][]string{}}
+}
+
+// Put replaces the indexed values of a document.
+func (x *Index) Put(id string, values ...string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(id)
+	grams := Trigrams(values...)
+	for _, g := range grams {
+		if x.grams[g] == nil {
+			x.grams[g] = map[string]struct{}{}
+		}
+		x.grams[g][id] = struct{}{}
+	}
+	x.docs[id] = grams
+}
+
+func (x *Index) Remove(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(id)
+}
+
+func (x *Index) remove(id string) {
+	for _, g := range x.docs[id] {
+		delete(x.grams[g], id)
+		if len(x.grams[g]) == 0 {
+			delete(x.grams, g)
+		}
+	}
+	delete(x.docs, id)
+}
+
+// Candidates returns IDs sharing at least one trigram with the query, the
+// ones sharing the most first, capped at limit.
+func (x *Index) Candidates(query string, limit int) []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	counts := map[string]int{}
+	for _, g := range Trigrams(query) {
+		for id := range x.grams[g] {
+			counts[id]++
+		}
+	}
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if counts[ids[i]] != counts[ids[j]] {
+			return counts[ids[i]] > counts[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids
+}
@@ -0,0 +1,55 @@
+package search
+
+import (
+	"Users/models"
+	"sort"
+	"strings"
+)
+
+// UserFields are the searchable fields of a user and their weights.
+func UserFields(u *models.User) []Field {
+	return []Field{
+		{Name: "username", Value: u.Username, Weight: 1},
+		{Name: "display_name", Value: DisplayName(u), Weight: 0.95},
+		{Name: "email", Value: u.Email, Weight: 0.9},
+	}
+}
+
+// DisplayName is the user's profile display name, if any.
+func DisplayName(u *models.User) string {
+	if u.Profile == nil {
+		return ""
+	}
+	return u.Profile.DisplayName
+}
+
+// UserTrigrams are the grams stored for a user's fuzzy lookup.
+func UserTrigrams(u *models.User) []string {
+	local := u.Email
+	if at := strings.LastIndex(local, "@"); at >= 0 {
+		local = local[:at]
+	}
+	return Trigrams(u.Username, local, DisplayName(u))
+}
+
+// RankUsers scores candidates against query and returns the best limit hits.
+func RankUsers(query string, candidates []models.User, limit int) []models.SearchHit {
+	var hits []models.SearchHit
+	for _, u := range candidates {
+		match, ok := Score(query, UserFields(&u))
+		if !ok {
+			continue
+		}
+		hits = append(hits, models.SearchHit{User: u, Score: match.Score, Highlights: match.Highlights})
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].User.Username < hits[j].User.Username
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"fmt"
+	"log/slog"
+)
+
+// accountServiceImpl carries out the side effects of suspending, reactivating
+// and deleting users, so single and bulk requests treat them alike
+type accountServiceImpl struct {
+	users      repository.UserRepository
+	sessions   SessionInterface
+	oauth      OAuthInterface
+	lockout    LockoutInterface
+	groups     GroupInterface
+	passkeys   PasskeyInterface
+	avatars    AvatarInterface
+	identities IdentityInterface
+	tenant     string
+	logger     *slog.Logger
+}
+
+func NewAccountService(users repository.UserRepository, sessions SessionInterface, oauth OAuthInterface, lockout LockoutInterface, groups GroupInterface, passkeys PasskeyInterface, avatars AvatarInterface, identities IdentityInterface, tenant string, logger *slog.Logger) AccountInterface {
+	return &accountServiceImpl{
+		users:      users,
+		sessions:   sessions,
+		oauth:      oauth,
+		lockout:    lockout,
+		groups:     groups,
+		passkeys:   passkeys,
+		avatars:    avatars,
+		identities: identities,
+		tenant:     tenant,
+		logger:     logger,
+	}
+}
+
+// DeleteUser signs the user out first, so a failure leaves an account that
+// can be deleted again rather than a deleted one with live credentials.
+func (a *accountServiceImpl) DeleteUser(userID string) error {
+	if _, err := a.users.FetchUserByID(userID); err != nil {
+		return err
+	}
+	if err := a.SignOut(userID); err != nil {
+		return err
+	}
+	if err := a.users.DeleteUser(&models.User{ID: userID}); err != nil {
+		return err
+	}
+	a.RemoveUser(userID)
+	return nil
+}
+
+// SetStatus changes the status, then runs StatusChanged; a failure there
+// fails the call, which can be repeated.
+func (a *accountServiceImpl) SetStatus(userID string, status string) error {
+	if err := a.users.UpdateUserStatus(userID, status); err != nil {
+		return err
+	}
+	return a.StatusChanged(userID, status)
+}
+
+func (a *accountServiceImpl) SignOut(userID string) error {
+	if err := a.sessions.RevokeUserSessions(a.tenant, userID); err != nil {
+		return fmt.Errorf("revoking sessions: %w", err)
+	}
+	if err := a.oauth.RemoveUser(a.tenant, userID); err != nil {
+		return fmt.Errorf("revoking OAuth grants: %w", err)
+	}
+	return nil
+}
+
+// StatusChanged forgives the failed logins of a reactivated user and signs
+// out a suspended one. Sessions are revoked after the suspension is stored
+// so no login slips in between.
+func (a *accountServiceImpl) StatusChanged(userID string, status string) error {
+	switch status {
+	case models.StatusActive:
+		if err := a.lockout.Unlock(userID); err != nil {
+			a.logger.Warn("could not clear failed logins", slog.String("user", userID), slog.Any("error", err))
+		}
+	case models.StatusSuspended:
+		if err := a.sessions.RevokeUserSessions(a.tenant, userID); err != nil {
+			return fmt.Errorf("revoking sessions: %w", err)
+		}
+	}
+	return nil
+}
+
+// RemoveUser drops what a deleted user leaves behind. The account is gone
+// already, so failures are only logged; an identity left linked would keep
+// its upstream subject from signing in again, so it is removed first.
+func (a *accountServiceImpl) RemoveUser(userID string) {
+	if err := a.identities.RemoveUser(userID); err != nil {
+		a.logger.Warn("could not remove deleted user's identities", slog.String("user", userID), slog.Any("error", err))
+	}
+	if err := a.passkeys.RemoveUser(userID); err != nil {
+		a.logger.Warn("could not remove deleted user's passkeys", slog.String("user", userID), slog.Any("error", err))
+	}
+	//a stale membership would be harmless, but keep the groups tidy
+	if err := a.groups.RemoveUser(userID); err != nil {
+		a.logger.Warn("could not remove deleted user from groups", slog.String("user", userID), slog.Any("error", err))
+	}
+	if err := a.avatars.RemoveUser(userID); err != nil {
+		a.logger.Warn("could not remove deleted user's avatar", slog.String("user", userID), slog.Any("error", err))
+	}
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+const (
+	//keys read uk_<prefix>_<secret>; the prefix is hex, so the first
+	//underscore after it ends it even though the secret is base64url
+	apiKeyMarker = "uk_"
+	apiKeyPrefix = 8
+	apiKeySecret = 32
+	maxKeyName   = 100
+	//last use is recorded at most this often per key
+	apiKeyTouchEvery = time.Minute
+)
+
+type apiKeyServiceImpl struct {
+	keys   repository.APIKeyRepository
+	logger *slog.Logger
+}
+
+func NewAPIKeyService(keys repository.APIKeyRepository, logger *slog.Logger) APIKeyInterface {
+	return &apiKeyServiceImpl{keys: keys, logger: logger}
+}
+
+// API key and session secrets are 256 random bits, so a fast hash is
+// enough to store them
+func hashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (a *apiKeyServiceImpl) CreateAPIKey(tenantID string, req models.APIKeyRequest, createdBy string) (*models.APIKeyCreated, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxKeyName {
+		return nil, fmt.Errorf("%w: name is required and must be at most %d characters", ErrInvalidInput, maxKeyName)
+	}
+	if len(req.Scopes) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidInput)
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(models.APIKeyScopes, scope) {
+			return nil, fmt.Errorf("%w: unknown scope %q, expected one of %s", ErrInvalidInput, scope, strings.Join(models.APIKeyScopes, ", "))
+		}
+	}
+	now := time.Now()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return nil, fmt.Errorf("%w: expires_at must be in the future", ErrInvalidInput)
+	}
+
+	prefix := make([]byte, apiKeyPrefix)
+	secret := make([]byte, apiKeySecret)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+	key := models.APIKey{
+		TenantID:   tenantID,
+		Name:       name,
+		Prefix:     hex.EncodeToString(prefix),
+		SecretHash: hashTokenSecret(encodedSecret),
+		Scopes:     slices.Compact(slices.Sorted(slices.Values(req.Scopes))),
+		CreatedBy:  createdBy,
+		CreatedAt:  now,
+		ExpiresAt:  req.ExpiresAt,
+	}
+	if err := a.keys.CreateAPIKey(&key); err != nil {
+		return nil, err
+	}
+	return &models.APIKeyCreated{APIKey: key, Key: apiKeyMarker + key.Prefix + "_" + encodedSecret}, nil
+}
+
+func (a *apiKeyServiceImpl) ListAPIKeys(tenantID string) ([]models.APIKey, error) {
+	return a.keys.ListAPIKeys(tenantID)
+}
+
+func (a *apiKeyServiceImpl) RevokeAPIKey(tenantID string, id string) error {
+	return a.keys.RevokeAPIKey(tenantID, id)
+}
+
+// AuthenticateAPIKey resolves a presented key. Unknown, revoked and expired
+// keys all fail with ErrInvalidAPIKey.
+func (a *apiKeyServiceImpl) AuthenticateAPIKey(token string) (*models.APIKey, error) {
+	rest, ok := strings.CutPrefix(token, apiKeyMarker)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(prefix) != 2*apiKeyPrefix || secret == "" {
+		return nil, ErrInvalidAPIKey
+	}
+	key, err := a.keys.FetchAPIKeyByPrefix(prefix)
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashTokenSecret(secret)), []byte(key.SecretHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchEvery {
+		if err := a.keys.TouchAPIKey(key.ID, now); err != nil {
+			a.logger.Warn("could not record api key use", slog.String("tenant", key.TenantID), slog.String("key", key.ID), slog.Any("error", err))
+		}
+	}
+	return key, nil
+}
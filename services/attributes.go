@@ -0,0 +1,184 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/utils"
+	"Users/validation"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+)
+
+// MaxAttributeSchemaBytes caps the size of a stored attribute schema.
+const MaxAttributeSchemaBytes = 64 << 10
+
+type attributeServiceImpl struct {
+	schemas repository.AttributeSchemaRepository
+	//the tenant the schema belongs to
+	scope string
+	mu    sync.Mutex
+	//reused until the stored version changes
+	compiled *compiledSchema
+}
+
+type compiledSchema struct {
+	version int
+	schema  *jsonschema.Schema
+}
+
+func NewAttributeService(schemas repository.AttributeSchemaRepository, scope string) AttributeInterface {
+	return &attributeServiceImpl{schemas: schemas, scope: scope}
+}
+
+func (a *attributeServiceImpl) Schema() (*models.AttributeSchema, error) {
+	return a.schemas.FetchAttributeSchema(a.scope)
+}
+
+// SetSchema stores a new schema after checking it compiles. The
+// schema must describe an object; existing users are not revalidated, their
+// attributes are checked against it the next time they change.
+func (a *attributeServiceImpl) SetSchema(raw []byte) (*models.AttributeSchema, error) {
+	if len(raw) > MaxAttributeSchemaBytes {
+		return nil, fmt.Errorf("%w: attribute schema is limited to %d bytes", ErrInvalidInput, MaxAttributeSchemaBytes)
+	}
+	compiled, err := compileAttributeSchema(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	schema := &models.AttributeSchema{Scope: a.scope, Schema: json.RawMessage(raw)}
+	if err := a.schemas.SaveAttributeSchema(schema); err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	a.compiled = &compiledSchema{version: schema.Version, schema: compiled}
+	a.mu.Unlock()
+	return schema, nil
+}
+
+// ValidateAttributes checks attrs against the stored schema. Without a
+// schema no custom attributes are accepted.
+func (a *attributeServiceImpl) ValidateAttributes(attrs models.Attributes) error {
+	if len(attrs) == 0 {
+		return nil
+	}
+	if err := attrs.CheckNames(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	stored, err := a.schemas.FetchAttributeSchema(a.scope)
+	if errors.Is(err, repository.ErrSchemaNotFound) {
+		return fmt.Errorf("%w: no custom attributes are defined", ErrInvalidInput)
+	}
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	cached := a.compiled
+	a.mu.Unlock()
+	if cached == nil || cached.version != stored.Version {
+		schema, err := compileAttributeSchema(stored.Schema)
+		if err != nil {
+			return fmt.Errorf("stored attribute schema %s v%d: %w", a.scope, stored.Version, err)
+		}
+		cached = &compiledSchema{version: stored.Version, schema: schema}
+		a.mu.Lock()
+		a.compiled = cached
+		a.mu.Unlock()
+	}
+	//validating the JSON form so values decoded from any source compare alike
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	if err := cached.schema.Validate(doc); err != nil {
+		var verr *jsonschema.ValidationError
+		if errors.As(err, &verr) {
+			return fmt.Errorf("%w: attributes %s", ErrInvalidInput, attributeErrors(verr))
+		}
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	return nil
+}
+
+// attributeErrors flattens a validation error into "at /path: message"
+// parts, which reads better in a 400 response than the nested tree
+func attributeErrors(verr *jsonschema.ValidationError) string {
+	var parts []string
+	for _, unit := range verr.BasicOutput().Errors {
+		if unit.Error == nil || unit.Error.Kind == nil {
+			continue
+		}
+		location := unit.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		parts = append(parts, fmt.Sprintf("at %s: %s", location, unit.Error.String()))
+	}
+	if len(parts) == 0 {
+		return verr.Error()
+	}
+	return strings.Join(parts, "; ")
+}
+
+func compileAttributeSchema(raw []byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("attribute schema is not valid JSON: %v", err)
+	}
+	obj, ok := doc.(map[string]any)
+	if !ok || obj["type"] != "object" {
+		return nil, errors.New(`attribute schema must have "type": "object"`)
+	}
+	c := jsonschema.NewCompiler()
+	c.DefaultDraft(jsonschema.Draft2020)
+	c.AssertFormat()
+	//schemas are self-contained; no $ref is resolved from files or the network
+	c.UseLoader(noLoader{})
+	const location = "attributes.json"
+	if err := c.AddResource(location, doc); err != nil {
+		return nil, err
+	}
+	return c.Compile(location)
+}
+
+type noLoader struct{}
+
+func (noLoader) Load(url string) (any, error) {
+	return nil, fmt.Errorf("external schema %s is not allowed", url)
+}
+
+// prepareProfile normalizes and validates a profile; an empty profile is
+// dropped
+func prepareProfile(canon *utils.Canonicalizer, profile *models.Profile) (*models.Profile, error) {
+	if profile.IsZero() {
+		return nil, nil
+	}
+	p := *profile
+	p.DisplayName = canon.Display(p.DisplayName)
+	p.Locale = strings.TrimSpace(p.Locale)
+	p.Timezone = strings.TrimSpace(p.Timezone)
+	p.Phone = strings.TrimSpace(p.Phone)
+	p.AvatarURL = strings.TrimSpace(p.AvatarURL)
+	if err := validation.ValidateProfile(&p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	if p.Locale != "" {
+		//storing the canonical tag, so en-us and en-US are the same locale
+		tag, _ := language.Parse(p.Locale)
+		p.Locale = tag.String()
+	}
+	if p.IsZero() {
+		return nil, nil
+	}
+	return &p, nil
+}
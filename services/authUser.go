@@ -0,0 +1,113 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/utils"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrAccountInactive    = errors.New("account is not active")
+)
+
+type authServiceImpl struct {
+	users   repository.UserRepository
+	hasher  utils.PasswordHasher
+	canon   *utils.Canonicalizer
+	lockout LockoutInterface
+	logger  *slog.Logger
+
+	//hash verified when the user does not exist so the response time
+	//does not reveal which accounts are registered
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+func NewAuthService(users repository.UserRepository, hasher utils.PasswordHasher, canon *utils.Canonicalizer, lockout LockoutInterface, logger *slog.Logger) AuthInterface {
+	return &authServiceImpl{users: users, hasher: hasher, canon: canon, lockout: lockout, logger: logger}
+}
+
+// Authenticate checks the password of the user identified by email or
+// username. Hashes made with outdated algorithms or parameters are replaced
+// after a successful check, so users migrate as they log in. Failures count
+// towards the lockout of both the account and the address.
+func (a *authServiceImpl) Authenticate(identifier string, password string, ip string) (*models.User, error) {
+	if identifier == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	if err := a.lockout.CheckAddress(ip); err != nil {
+		return nil, err
+	}
+	var user *models.User
+	var err error
+	if strings.Contains(identifier, "@") {
+		user, err = a.users.FetchUserByEmail(a.canon.Email(identifier))
+	} else {
+		user, err = a.users.FetchUserByUsername(a.canon.Username(identifier))
+	}
+	if errors.Is(err, repository.ErrUserNotFound) {
+		a.verifyDummy(password)
+		a.recordFailure(nil, ip)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	//checked before the password so a locked account cannot be guessed at
+	if err := a.lockout.CheckUser(user); err != nil {
+		return nil, err
+	}
+
+	ok, err := a.hasher.Verify(password, user.Password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		a.recordFailure(user, ip)
+		return nil, ErrInvalidCredentials
+	}
+	if user.Status != "active" {
+		return nil, ErrAccountInactive
+	}
+
+	//upgrading the stored hash now that we know the plaintext
+	if a.hasher.NeedsRehash(user.Password) {
+		if err := a.rehash(user, password); err != nil {
+			a.logger.Warn("could not upgrade password hash", slog.String("user", user.ID), slog.Any("error", err))
+		}
+	}
+	return user, nil
+}
+
+func (a *authServiceImpl) recordFailure(user *models.User, ip string) {
+	if err := a.lockout.RecordFailure(user, ip); err != nil {
+		a.logger.Warn("could not record failed login", slog.String("user", user.ID), slog.Any("error", err))
+	}
+}
+
+func (a *authServiceImpl) rehash(user *models.User, password string) error {
+	hashedPassword, err := a.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	err = a.users.UpdateUser(&models.User{ID: user.ID, Password: hashedPassword})
+	if err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+	return nil
+}
+
+func (a *authServiceImpl) verifyDummy(password string) {
+	a.dummyOnce.Do(func() {
+		a.dummyHash, _ = a.hasher.Hash("dummy-password")
+	})
+	if a.dummyHash != "" {
+		a.hasher.Verify(password, a.dummyHash)
+	}
+}
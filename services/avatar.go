@@ -0,0 +1,106 @@
+package services
+
+import (
+	"Users/imaging"
+	"Users/models"
+	"Users/repository"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"slices"
+)
+
+// MaxAvatarBytes caps the size of an uploaded avatar image.
+const MaxAvatarBytes = 5 << 20
+
+// AvatarSizes are the square thumbnail sizes rendered for every upload,
+// largest first.
+var AvatarSizes = []int{512, 256, 128, 64}
+
+type avatarServiceImpl struct {
+	users repository.UserRepository
+	blobs repository.BlobStore
+	//named in avatar URLs, which are fetched without a tenant header
+	tenant string
+}
+
+func NewAvatarService(users repository.UserRepository, blobs repository.BlobStore, tenant string) AvatarInterface {
+	return &avatarServiceImpl{users: users, blobs: blobs, tenant: tenant}
+}
+
+func avatarKey(userID string, size int) string {
+	return fmt.Sprintf("avatars/%s/%d", userID, size)
+}
+
+// SetAvatar renders and stores the thumbnails for an uploaded image and
+// points the user's profile avatar URL at them. The URL carries a content
+// hash so clients can cache it for long and still see a new upload, and
+// names the tenant so browsers can load it as an image.
+func (a *avatarServiceImpl) SetAvatar(userID string, data []byte) (string, error) {
+	if _, err := a.users.FetchUserByID(userID); err != nil {
+		return "", err
+	}
+	if len(data) > MaxAvatarBytes {
+		return "", fmt.Errorf("%w: avatar is limited to %d bytes", ErrInvalidInput, MaxAvatarBytes)
+	}
+	img, _, err := imaging.Decode(data)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	hash := sha256.New()
+	for _, size := range AvatarSizes {
+		thumb, contentType, err := imaging.Encode(imaging.Thumbnail(img, size))
+		if err != nil {
+			return "", err
+		}
+		if err := a.blobs.PutBlob(avatarKey(userID, size), contentType, thumb); err != nil {
+			return "", err
+		}
+		hash.Write(thumb)
+	}
+	link := fmt.Sprintf("/api/avatars/%s/%s?v=%s", url.PathEscape(a.tenant), userID, hex.EncodeToString(hash.Sum(nil))[:12])
+	err = a.users.UpdateUser(&models.User{ID: userID, Profile: &models.Profile{AvatarURL: link}})
+	if err != nil {
+		return "", err
+	}
+	return link, nil
+}
+
+// Avatar returns the smallest stored thumbnail at least size pixels wide,
+// or the largest one when size is 0 or bigger than every rendition, along
+// with the profile's current avatar URL.
+func (a *avatarServiceImpl) Avatar(userID string, size int) (*repository.Blob, string, error) {
+	user, err := a.users.FetchUserByID(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	chosen := AvatarSizes[0]
+	if size > 0 {
+		for _, s := range slices.Backward(AvatarSizes) {
+			if s >= size {
+				chosen = s
+				break
+			}
+		}
+	}
+	blob, err := a.blobs.GetBlob(avatarKey(userID, chosen))
+	if err != nil {
+		return nil, "", err
+	}
+	url := ""
+	if user.Profile != nil {
+		url = user.Profile.AvatarURL
+	}
+	return blob, url, nil
+}
+
+// RemoveUser drops the stored thumbnails of a deleted user.
+func (a *avatarServiceImpl) RemoveUser(userID string) error {
+	for _, size := range AvatarSizes {
+		if err := a.blobs.DeleteBlob(avatarKey(userID, size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
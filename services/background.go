@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+	"sync"
+)
+
+// Background runs work that outlives the request starting it, such as async
+// bulk jobs, under a context that is cancelled at shutdown.
+type Background struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewBackground() *Background {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Background{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in a goroutine; fn should return soon after ctx is done.
+func (b *Background) Go(fn func(ctx context.Context)) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		fn(b.ctx)
+	}()
+}
+
+// Shutdown cancels the running work and waits for it to wind down, or for
+// ctx to be done.
+func (b *Background) Shutdown(ctx context.Context) error {
+	b.cancel()
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
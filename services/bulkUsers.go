@@ -0,0 +1,225 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/validation"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	//upper bound on users touched by one bulk request
+	MaxBulkTargets = 10000
+	bulkBatch      = 1000
+	jobRetention   = 24 * time.Hour
+)
+
+// ErrJobInterrupted fails async jobs stopped by a server shutdown; the
+// result holds the batches done until then.
+var ErrJobInterrupted = errors.New("job interrupted by server shutdown")
+
+type bulkServiceImpl struct {
+	users      repository.UserRepository
+	jobs       repository.JobRepository
+	accounts   AccountInterface
+	background *Background
+	logger     *slog.Logger
+}
+
+func NewBulkService(users repository.UserRepository, jobs repository.JobRepository, accounts AccountInterface, background *Background, logger *slog.Logger) BulkInterface {
+	return &bulkServiceImpl{users: users, jobs: jobs, accounts: accounts, background: background, logger: logger}
+}
+
+// Run executes the request synchronously and reports per ID.
+func (b *bulkServiceImpl) Run(req models.BulkRequest) (*models.BulkResult, error) {
+	change, err := bulkChange(req)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := b.targets(req)
+	if err != nil {
+		return nil, err
+	}
+	return b.apply(context.Background(), req.Action, ids, change)
+}
+
+// Start validates the request, records a job and runs it in the background.
+func (b *bulkServiceImpl) Start(req models.BulkRequest) (*models.BulkJob, error) {
+	change, err := bulkChange(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTargets(req); err != nil {
+		return nil, err
+	}
+	job := &models.BulkJob{
+		Status:    models.JobPending,
+		Action:    req.Action,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(jobRetention),
+	}
+	if err := b.jobs.CreateJob(job); err != nil {
+		return nil, err
+	}
+
+	started := *job
+	b.background.Go(func(ctx context.Context) {
+		job := started
+		job.Status = models.JobRunning
+		if err := b.jobs.UpdateJob(&job); err != nil {
+			b.finish(&job, nil, fmt.Errorf("recording job start: %w", err))
+			return
+		}
+		ids, err := b.targets(req)
+		var result *models.BulkResult
+		if err == nil {
+			result, err = b.apply(ctx, req.Action, ids, change)
+		}
+		b.finish(&job, result, err)
+	})
+	return job, nil
+}
+
+// finish records the outcome of an async job
+func (b *bulkServiceImpl) finish(job *models.BulkJob, result *models.BulkResult, err error) {
+	finished := time.Now()
+	job.FinishedAt = &finished
+	job.ExpiresAt = finished.Add(jobRetention)
+	job.Result = result
+	job.Status = models.JobDone
+	if err != nil {
+		job.Status = models.JobFailed
+		job.Error = err.Error()
+	}
+	if err := b.jobs.UpdateJob(job); err != nil {
+		b.logger.Error("could not save bulk job", slog.String("job", job.ID), slog.Any("error", err))
+	}
+}
+
+func (b *bulkServiceImpl) Job(id string) (*models.BulkJob, error) {
+	return b.jobs.FetchJob(id)
+}
+
+func (b *bulkServiceImpl) apply(ctx context.Context, action string, ids []string, change repository.BulkUserChange) (*models.BulkResult, error) {
+	result := &models.BulkResult{Action: action, Total: len(ids), Items: make([]models.BulkItem, 0, len(ids))}
+	for start := 0; start < len(ids); start += bulkBatch {
+		//batches are not split, so shutdown waits for the one running
+		if ctx.Err() != nil {
+			return result, ErrJobInterrupted
+		}
+		batch := ids[start:min(start+bulkBatch, len(ids))]
+		//users to delete are signed out first, as a single delete does;
+		//those that cannot be are left in place and reported
+		failed := map[string]error{}
+		ready := batch
+		if change.Delete {
+			ready = make([]string, 0, len(batch))
+			for _, id := range batch {
+				if err := b.accounts.SignOut(id); err != nil {
+					failed[id] = err
+					continue
+				}
+				ready = append(ready, id)
+			}
+		}
+		written, err := b.users.BulkChangeUsers(ready, change)
+		if err != nil {
+			return nil, err
+		}
+		for i, w := range written {
+			if w.Err != nil {
+				failed[ready[i]] = w.Err
+				continue
+			}
+			switch {
+			case change.Delete:
+				b.accounts.RemoveUser(ready[i])
+			case change.Status != "":
+				if err := b.accounts.StatusChanged(ready[i], change.Status); err != nil {
+					failed[ready[i]] = err
+				}
+			}
+		}
+		for _, id := range batch {
+			item := models.BulkItem{ID: id, Result: "ok"}
+			err, ok := failed[id]
+			switch {
+			case !ok:
+				result.Succeeded++
+			case errors.Is(err, repository.ErrUserNotFound):
+				item.Result, item.Error = "not_found", err.Error()
+			default:
+				item.Result, item.Error = "failed", err.Error()
+			}
+			if ok {
+				result.Failed++
+			}
+			result.Items = append(result.Items, item)
+		}
+	}
+	return result, nil
+}
+
+// targets resolves the request to a de-duplicated list of user IDs
+func (b *bulkServiceImpl) targets(req models.BulkRequest) ([]string, error) {
+	if err := validateTargets(req); err != nil {
+		return nil, err
+	}
+	if req.Filter != nil {
+		ids, err := b.users.FetchUserIDs(*req.Filter, MaxBulkTargets+1)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) > MaxBulkTargets {
+			return nil, fmt.Errorf("%w: filter matches more than %d users", ErrInvalidInput, MaxBulkTargets)
+		}
+		return ids, nil
+	}
+	seen := map[string]bool{}
+	ids := make([]string, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func validateTargets(req models.BulkRequest) error {
+	if len(req.IDs) > 0 && req.Filter != nil {
+		return fmt.Errorf("%w: use either ids or filter, not both", ErrInvalidInput)
+	}
+	if len(req.IDs) == 0 && req.Filter == nil {
+		return fmt.Errorf("%w: ids or filter is required", ErrInvalidInput)
+	}
+	if len(req.IDs) > MaxBulkTargets {
+		return fmt.Errorf("%w: at most %d ids per request", ErrInvalidInput, MaxBulkTargets)
+	}
+	//an empty filter would match every user
+	if f := req.Filter; f != nil && f.Query == "" && f.Status == "" && f.Role == "" {
+		return fmt.Errorf("%w: filter needs at least one of query, status or role", ErrInvalidInput)
+	}
+	return nil
+}
+
+func bulkChange(req models.BulkRequest) (repository.BulkUserChange, error) {
+	switch req.Action {
+	case models.BulkSuspend:
+		return repository.BulkUserChange{Status: models.StatusSuspended}, nil
+	case models.BulkActivate:
+		return repository.BulkUserChange{Status: models.StatusActive}, nil
+	case models.BulkDelete:
+		return repository.BulkUserChange{Delete: true}, nil
+	case models.BulkAssignRole:
+		if err := validation.ValidateRole(req.Role); err != nil {
+			return repository.BulkUserChange{}, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+		return repository.BulkUserChange{Role: req.Role}, nil
+	}
+	return repository.BulkUserChange{}, fmt.Errorf("%w: action must be suspend, activate, delete or assign_role", ErrInvalidInput)
+}
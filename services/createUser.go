@@ -5,52 +5,89 @@ import (
 	"Users/repository"
 	"Users/utils"
 	"Users/validation"
+	"fmt"
 )
 
 type createServiceImpl struct {
 	createUser repository.UserRepository
+	hasher     utils.PasswordHasher
+	canon      *utils.Canonicalizer
+	attributes AttributeInterface
 }
 
-func NewCreateService(createUser repository.UserRepository) CreateInterface {
-	return &createServiceImpl{createUser: createUser}
+func NewCreateService(createUser repository.UserRepository, hasher utils.PasswordHasher, canon *utils.Canonicalizer, attributes AttributeInterface) CreateInterface {
+	return &createServiceImpl{createUser: createUser, hasher: hasher, canon: canon, attributes: attributes}
 
 }
 
 func (c *createServiceImpl) CreateUser(user *models.User) error {
-	//validating email
-	if user.Email != "" {
-		err := validation.ValidateEmail(user.Email)
-		if err != nil {
-			return err
-		}
-	}
-	if user.Password != "" {
-		err := validation.ValidatePassword(user.Password)
-		if err != nil {
-			return err
-		}
+	return c.CreateUserWithRole(user, models.RoleUser)
+}
+
+func (c *createServiceImpl) CreateUserWithRole(user *models.User, role string) error {
+	if err := validation.ValidateRole(role); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
 	}
-	if user.Username != "" {
-		err := validation.ValidateUsername(user.Username)
-		if err != nil {
-			return err
-		}
+	newUser, err := c.prepare(user)
+	if err != nil {
+		return err
 	}
+	newUser.Role = role
 	//hashing password
-	hashedPassword, err := utils.HashPassword(user.Password)
+	newUser.Password, err = c.hasher.Hash(newUser.Password)
 	if err != nil {
 		return err
 	}
-	user = &models.User{
-		Username: user.Username,
-		Email:    user.Email,
-		Password: hashedPassword,
-	}
 	//calling the repository layer to create user
-	err = c.createUser.CreateUser(user)
+	//uniqueness is enforced by the unique indexes on the canonical fields
+	err = c.createUser.CreateUser(newUser)
 	if err != nil {
 		return err
 
 	}
+	user.ID = newUser.ID
 	return nil
 }
+
+// prepare validates and normalizes the input and returns the user to store
+// with canonical fields set. The password is still in plaintext so callers
+// can skip the hashing cost when they only validate.
+func (c *createServiceImpl) prepare(user *models.User) (*models.User, error) {
+	//normalizing the identifiers before validating them
+	user.Email = c.canon.Display(user.Email)
+	user.Username = c.canon.Display(user.Username)
+	//validating email
+	err := validation.ValidateEmail(user.Email)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	err = validation.ValidatePassword(user.Password)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	if user.Username != "" {
+		err := validation.ValidateUsername(user.Username)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+	}
+	profile, err := prepareProfile(c.canon, user.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.attributes.ValidateAttributes(user.Attributes); err != nil {
+		return nil, err
+	}
+	newUser := &models.User{
+		Username:       user.Username,
+		Email:          user.Email,
+		Password:       user.Password,
+		EmailCanonical: c.canon.Email(user.Email),
+		Profile:        profile,
+		Attributes:     user.Attributes,
+	}
+	if user.Username != "" {
+		newUser.UsernameCanonical = c.canon.Username(user.Username)
+	}
+	return newUser, nil
+}
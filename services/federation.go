@@ -0,0 +1,398 @@
+package services
+
+import (
+	"Users/jose"
+	"Users/models"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrProviderNotFound is returned for providers that are not configured, or
+// not for the tenant.
+var ErrProviderNotFound = errors.New("identity provider not found")
+
+// ErrProviderUnavailable wraps failures to reach an upstream provider.
+var ErrProviderUnavailable = errors.New("identity provider unavailable")
+
+// ErrFederationRejected wraps sign ins the upstream provider refused, or
+// whose response did not check out.
+var ErrFederationRejected = errors.New("sign in rejected")
+
+const (
+	//upstream documents are refetched after this long, and the key set
+	//at most this often when a token names an unknown key
+	providerMetadataTTL = time.Hour
+	providerKeysRefetch = 10 * time.Second
+	idTokenLeeway       = 2 * time.Minute
+	maxProviderResponse = 1 << 20
+)
+
+// upstream is a configured provider with what was fetched from it
+type upstream struct {
+	config models.IdentityProvider
+
+	mu          sync.Mutex
+	metadata    *models.OpenIDConfiguration
+	metadataAt  time.Time
+	keys        *jose.JWKSet
+	keysFetched time.Time
+}
+
+type federationServiceImpl struct {
+	providers map[string]*upstream
+	order     []string
+	client    *http.Client
+}
+
+// NewFederationService checks the provider configuration and fills in the
+// default scopes and claim names. Upstream metadata is fetched on first use.
+func NewFederationService(providers []models.IdentityProvider, client *http.Client) (FederationInterface, error) {
+	f := &federationServiceImpl{providers: map[string]*upstream{}, client: client}
+	for _, p := range providers {
+		if p.ID == "" || p.Issuer == "" || p.ClientID == "" || p.RedirectURI == "" {
+			return nil, fmt.Errorf("identity provider %q: id, issuer, client_id and redirect_uri are required", p.ID)
+		}
+		if _, ok := f.providers[p.ID]; ok {
+			return nil, fmt.Errorf("identity provider %q is configured twice", p.ID)
+		}
+		if p.Name == "" {
+			p.Name = p.ID
+		}
+		p.Issuer = strings.TrimSuffix(p.Issuer, "/")
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{models.ScopeOpenID, models.ScopeEmail, models.ScopeProfile}
+		}
+		if !slices.Contains(p.Scopes, models.ScopeOpenID) {
+			p.Scopes = append([]string{models.ScopeOpenID}, p.Scopes...)
+		}
+		if p.Claims.Email == "" {
+			p.Claims.Email = "email"
+		}
+		if p.Claims.EmailVerified == "" {
+			p.Claims.EmailVerified = "email_verified"
+		}
+		if p.Claims.Username == "" {
+			p.Claims.Username = "preferred_username"
+		}
+		if p.Claims.Name == "" {
+			p.Claims.Name = "name"
+		}
+		f.providers[p.ID] = &upstream{config: p}
+		f.order = append(f.order, p.ID)
+	}
+	return f, nil
+}
+
+func (f *federationServiceImpl) Providers(tenantID string) []models.IdentityProviderInfo {
+	providers := []models.IdentityProviderInfo{}
+	for _, id := range f.order {
+		if p := f.providers[id].config; len(p.Tenants) == 0 || slices.Contains(p.Tenants, tenantID) {
+			providers = append(providers, models.IdentityProviderInfo{ID: p.ID, Name: p.Name})
+		}
+	}
+	return providers
+}
+
+func (f *federationServiceImpl) provider(tenantID string, id string) (*upstream, error) {
+	p, ok := f.providers[id]
+	if !ok || (len(p.config.Tenants) > 0 && !slices.Contains(p.config.Tenants, tenantID)) {
+		return nil, ErrProviderNotFound
+	}
+	return p, nil
+}
+
+// AllowsSignup reports whether identities of the provider that match no
+// account get one.
+func (f *federationServiceImpl) AllowsSignup(tenantID string, providerID string) bool {
+	p, err := f.provider(tenantID, providerID)
+	return err == nil && p.config.AllowSignup
+}
+
+// AuthorizationURL is where the user signs in at the provider, for a code
+// bound to nonce and the PKCE challenge.
+func (f *federationServiceImpl) AuthorizationURL(tenantID string, providerID string, state string, nonce string, challenge string) (string, error) {
+	p, err := f.provider(tenantID, providerID)
+	if err != nil {
+		return "", err
+	}
+	metadata, err := f.metadata(p)
+	if err != nil {
+		return "", err
+	}
+	target, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authorization endpoint", ErrProviderUnavailable)
+	}
+	query := target.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURI)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", challenge)
+	query.Set("code_challenge_method", "S256")
+	target.RawQuery = query.Encode()
+	return target.String(), nil
+}
+
+// Exchange redeems a code at the provider and returns the identity its ID
+// token vouches for, with claims missing from the token taken from the
+// userinfo endpoint.
+func (f *federationServiceImpl) Exchange(tenantID string, providerID string, code string, verifier string, nonce string) (*models.ExternalIdentity, error) {
+	p, err := f.provider(tenantID, providerID)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := f.metadata(p)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {models.GrantAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURI},
+		"code_verifier": {verifier},
+	}
+	//client_secret_basic is the default when the provider does not say
+	methods := metadata.TokenEndpointAuthMethodsSupported
+	basic := p.config.ClientSecret != "" && (len(methods) == 0 || slices.Contains(methods, "client_secret_basic"))
+	if !basic {
+		form.Set("client_id", p.config.ClientID)
+		if p.config.ClientSecret != "" {
+			form.Set("client_secret", p.config.ClientSecret)
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid token endpoint", ErrProviderUnavailable)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	status, err := f.do(req, &token)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || token.IDToken == "" {
+		if token.Error != "" {
+			return nil, fmt.Errorf("%w: %s %s", ErrFederationRejected, token.Error, token.Description)
+		}
+		return nil, fmt.Errorf("%w: the provider issued no ID token", ErrFederationRejected)
+	}
+
+	claims, err := f.verifyIDToken(p, token.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims[p.config.Claims.Email]; !ok && metadata.UserinfoEndpoint != "" && token.AccessToken != "" {
+		if err := f.mergeUserInfo(metadata.UserinfoEndpoint, token.AccessToken, claims); err != nil {
+			return nil, err
+		}
+	}
+	return mapClaims(p.config, claims), nil
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiry and nonce of
+// an ID token and returns its claims
+func (f *federationServiceImpl) verifyIDToken(p *upstream, token string, nonce string) (map[string]any, error) {
+	payload, err := jose.Verify(token, func(kid string, alg string) (crypto.PublicKey, error) {
+		return f.verificationKey(p, kid, alg)
+	})
+	if errors.Is(err, ErrProviderUnavailable) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFederationRejected, err)
+	}
+	var registered struct {
+		jose.Claims
+		Nonce           string `json:"nonce"`
+		AuthorizedParty string `json:"azp"`
+	}
+	if err := json.Unmarshal(payload, &registered); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFederationRejected, err)
+	}
+	if err := registered.Validate(p.config.Issuer, p.config.ClientID, time.Now(), idTokenLeeway); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFederationRejected, err)
+	}
+	if len(registered.Audience) > 1 && registered.AuthorizedParty != p.config.ClientID {
+		return nil, fmt.Errorf("%w: the token was issued to another party", ErrFederationRejected)
+	}
+	if registered.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrFederationRejected)
+	}
+	if registered.Subject == "" {
+		return nil, fmt.Errorf("%w: the token names no subject", ErrFederationRejected)
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFederationRejected, err)
+	}
+	return claims, nil
+}
+
+// mergeUserInfo adds the claims of the userinfo response that the ID token
+// lacks, provided both are about the same subject
+func (f *federationServiceImpl) mergeUserInfo(endpoint string, accessToken string, claims map[string]any) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("%w: invalid userinfo endpoint", ErrProviderUnavailable)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	var info map[string]any
+	status, err := f.do(req, &info)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK || info["sub"] != claims["sub"] {
+		return nil
+	}
+	for name, value := range info {
+		if _, ok := claims[name]; !ok {
+			claims[name] = value
+		}
+	}
+	return nil
+}
+
+// mapClaims takes the user's fields from the claims the provider's
+// configuration names
+func mapClaims(config models.IdentityProvider, claims map[string]any) *models.ExternalIdentity {
+	str := func(name string) string {
+		s, _ := claims[name].(string)
+		return strings.TrimSpace(s)
+	}
+	identity := &models.ExternalIdentity{
+		Provider: config.ID,
+		Subject:  str("sub"),
+		Email:    str(config.Claims.Email),
+		Username: str(config.Claims.Username),
+		Name:     str(config.Claims.Name),
+	}
+	//some providers send email_verified as a string
+	switch verified := claims[config.Claims.EmailVerified].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	if config.TrustEmail && identity.Email != "" {
+		identity.EmailVerified = true
+	}
+	return identity
+}
+
+// metadata returns the provider's discovery document, fetched at most once
+// an hour
+func (f *federationServiceImpl) metadata(p *upstream) (*models.OpenIDConfiguration, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil && time.Since(p.metadataAt) < providerMetadataTTL {
+		return p.metadata, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, p.config.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	var metadata models.OpenIDConfiguration
+	status, err := f.do(req, &metadata)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("%w: discovery answered %d", ErrProviderUnavailable, status)
+	}
+	if err != nil {
+		//a stale document beats none while the provider is down
+		if p.metadata != nil {
+			return p.metadata, nil
+		}
+		return nil, err
+	}
+	//OpenID Connect Discovery section 4.3: the document must be the
+	//issuer's own
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("%w: discovery names issuer %q", ErrProviderUnavailable, metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery lacks required endpoints", ErrProviderUnavailable)
+	}
+	p.metadata = &metadata
+	p.metadataAt = time.Now()
+	return p.metadata, nil
+}
+
+// verificationKey finds the provider's key kid, refetching the key set when
+// it is unknown, as providers publish new keys ahead of using them
+func (f *federationServiceImpl) verificationKey(p *upstream, kid string, alg string) (crypto.PublicKey, error) {
+	metadata, err := f.metadata(p)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	find := func() (jose.JWK, bool) {
+		if p.keys == nil {
+			return jose.JWK{}, false
+		}
+		//a key ID may be left out when the set has a single key
+		if kid == "" && len(p.keys.Keys) == 1 {
+			return p.keys.Keys[0], true
+		}
+		return p.keys.Find(kid)
+	}
+	key, ok := find()
+	stale := time.Since(p.keysFetched) > providerMetadataTTL
+	if (!ok || stale) && time.Since(p.keysFetched) > providerKeysRefetch {
+		req, err := http.NewRequest(http.MethodGet, metadata.JWKSURI, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid jwks_uri", ErrProviderUnavailable)
+		}
+		var keys jose.JWKSet
+		status, err := f.do(req, &keys)
+		if err == nil && status == http.StatusOK {
+			p.keys = &keys
+			p.keysFetched = time.Now()
+			key, ok = find()
+		} else if p.keys == nil {
+			if err == nil {
+				err = fmt.Errorf("%w: jwks_uri answered %d", ErrProviderUnavailable, status)
+			}
+			return nil, err
+		}
+	}
+	if !ok || (key.Algorithm != "" && key.Algorithm != alg) || (key.Use != "" && key.Use != "sig") {
+		return nil, jose.ErrUnknownKey
+	}
+	return key.PublicKey()
+}
+
+// do sends req and decodes a JSON response of at most 1 MiB into out,
+// whatever the status
+func (f *federationServiceImpl) do(req *http.Request, out any) (int, error) {
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxProviderResponse)).Decode(out); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("%w: invalid response: %v", ErrProviderUnavailable, err)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"Users/jose"
+	"Users/models"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	stubProviderID = "stub"
+	stubClientID   = "users-client"
+)
+
+// stubProvider is an OpenID provider serving discovery, its key set and a
+// token endpoint that answers every code with the next ID token
+type stubProvider struct {
+	server *httptest.Server
+	rsaKey *jose.Key
+	edKey  *jose.Key
+
+	mu      sync.Mutex
+	idToken string
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	t.Helper()
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubProvider{
+		rsaKey: &jose.Key{ID: "rsa-1", Algorithm: jose.RS256, Private: rsaPrivate},
+		edKey:  &jose.Key{ID: "ed-1", Algorithm: jose.EdDSA, Private: edPrivate},
+	}
+	var keys jose.JWKSet
+	for _, key := range []*jose.Key{s.rsaKey, s.edKey} {
+		jwk, err := jose.PublicJWK(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys.Keys = append(keys.Keys, jwk)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.OpenIDConfiguration{
+			Issuer:                s.server.URL,
+			AuthorizationEndpoint: s.server.URL + "/authorize",
+			TokenEndpoint:         s.server.URL + "/token",
+			JWKSURI:               s.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(keys)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != models.GrantAuthorizationCode || r.FormValue("code") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "id_token": s.idToken})
+	})
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// federation returns a federation service configured for the stub
+func (s *stubProvider) federation(t *testing.T, allowSignup bool) *federationServiceImpl {
+	t.Helper()
+	f, err := NewFederationService([]models.IdentityProvider{{
+		ID:          stubProviderID,
+		Issuer:      s.server.URL,
+		ClientID:    stubClientID,
+		RedirectURI: "https://app.example.com/callback",
+		AllowSignup: allowSignup,
+	}}, s.server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.(*federationServiceImpl)
+}
+
+// claims are valid ID token claims for subject, to be modified by a test
+func (s *stubProvider) claims(subject string, nonce string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":   s.server.URL,
+		"sub":   subject,
+		"aud":   stubClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+}
+
+func sign(t *testing.T, key *jose.Key, claims map[string]any) string {
+	t.Helper()
+	token, err := jose.Sign(key, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// issue makes the token endpoint answer the next code with claims
+func (s *stubProvider) issue(t *testing.T, claims map[string]any) {
+	t.Helper()
+	token := sign(t, s.rsaKey, claims)
+	s.mu.Lock()
+	s.idToken = token
+	s.mu.Unlock()
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	stub := newStubProvider(t)
+	f := stub.federation(t, false)
+	p := f.providers[stubProviderID]
+	for _, key := range []*jose.Key{stub.rsaKey, stub.edKey} {
+		claims, err := f.verifyIDToken(p, sign(t, key, stub.claims("subject", "nonce")), "nonce")
+		if err != nil {
+			t.Fatalf("%s token: %v", key.Algorithm, err)
+		}
+		if claims["sub"] != "subject" {
+			t.Fatalf("%s token: sub = %v", key.Algorithm, claims["sub"])
+		}
+	}
+}
+
+func TestVerifyIDTokenRejected(t *testing.T) {
+	stub := newStubProvider(t)
+	f := stub.federation(t, false)
+	p := f.providers[stubProviderID]
+	tests := []struct {
+		name  string
+		token func(claims map[string]any) string
+	}{
+		{"wrong issuer", func(claims map[string]any) string {
+			claims["iss"] = "https://evil.example.com"
+			return sign(t, stub.rsaKey, claims)
+		}},
+		{"wrong audience", func(claims map[string]any) string {
+			claims["aud"] = "another-client"
+			return sign(t, stub.rsaKey, claims)
+		}},
+		{"bad nonce", func(claims map[string]any) string {
+			claims["nonce"] = "replayed"
+			return sign(t, stub.rsaKey, claims)
+		}},
+		{"expired", func(claims map[string]any) string {
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			return sign(t, stub.rsaKey, claims)
+		}},
+		{"azp of another party with several audiences", func(claims map[string]any) string {
+			claims["aud"] = []string{stubClientID, "another-client"}
+			claims["azp"] = "another-client"
+			return sign(t, stub.rsaKey, claims)
+		}},
+		{"several audiences without azp", func(claims map[string]any) string {
+			claims["aud"] = []string{stubClientID, "another-client"}
+			return sign(t, stub.rsaKey, claims)
+		}},
+		{"unknown kid", func(claims map[string]any) string {
+			return sign(t, &jose.Key{ID: "rotated-away", Algorithm: jose.RS256, Private: stub.rsaKey.Private}, claims)
+		}},
+		{"alg swapped to a key of another type", func(claims map[string]any) string {
+			//EdDSA signed, but naming the RSA key
+			return sign(t, &jose.Key{ID: stub.rsaKey.ID, Algorithm: jose.EdDSA, Private: stub.edKey.Private}, claims)
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			token := tc.token(stub.claims("subject", "nonce"))
+			if _, err := f.verifyIDToken(p, token, "nonce"); !errors.Is(err, ErrFederationRejected) {
+				t.Fatalf("err = %v, want ErrFederationRejected", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/utils"
+	"Users/validation"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// ErrGroupCycle is returned when nesting a group would make it contain
+// itself.
+var ErrGroupCycle = errors.New("group would contain itself")
+
+type groupServiceImpl struct {
+	groups repository.GroupRepository
+	users  repository.UserRepository
+	canon  *utils.Canonicalizer
+	//serializes nesting changes within this process only; replicas
+	//nesting the same groups at once can still close a cycle, which the
+	//walks over subgroups tolerate
+	nesting sync.Mutex
+}
+
+func NewGroupService(groups repository.GroupRepository, users repository.UserRepository, canon *utils.Canonicalizer) GroupInterface {
+	return &groupServiceImpl{groups: groups, users: users, canon: canon}
+}
+
+func (g *groupServiceImpl) CreateGroup(group *models.Group) error {
+	group.Name = g.canon.Display(group.Name)
+	if err := validation.ValidateGroupName(group.Name); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	group.NameCanonical = g.canon.Username(group.Name)
+	//members and subgroups are managed through their own endpoints
+	group.Members = nil
+	group.Subgroups = nil
+	return g.groups.CreateGroup(group)
+}
+
+func (g *groupServiceImpl) Group(id string) (*models.Group, error) {
+	return g.groups.FetchGroup(id)
+}
+
+func (g *groupServiceImpl) ListGroups() ([]models.Group, error) {
+	return g.groups.ListGroups()
+}
+
+func (g *groupServiceImpl) RenameGroup(id string, name string) (*models.Group, error) {
+	name = g.canon.Display(name)
+	if err := validation.ValidateGroupName(name); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	if err := g.groups.RenameGroup(id, name, g.canon.Username(name)); err != nil {
+		return nil, err
+	}
+	return g.groups.FetchGroup(id)
+}
+
+func (g *groupServiceImpl) DeleteGroup(id string) error {
+	return g.groups.DeleteGroup(id)
+}
+
+func (g *groupServiceImpl) AddMember(groupID string, userID string) error {
+	if _, err := g.users.FetchUserByID(userID); err != nil {
+		return err
+	}
+	return g.groups.AddMember(groupID, userID)
+}
+
+func (g *groupServiceImpl) RemoveMember(groupID string, userID string) error {
+	return g.groups.RemoveMember(groupID, userID)
+}
+
+// AddSubgroup nests child in group, refusing when group is already reachable
+// from child. The check is not atomic across replicas, so readers must not
+// assume the nesting is acyclic.
+func (g *groupServiceImpl) AddSubgroup(groupID string, childID string) error {
+	g.nesting.Lock()
+	defer g.nesting.Unlock()
+	all, err := g.groups.ListGroups()
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]models.Group, len(all))
+	for _, group := range all {
+		byID[group.ID] = group
+	}
+	if _, ok := byID[groupID]; !ok {
+		return repository.ErrGroupNotFound
+	}
+	if _, ok := byID[childID]; !ok {
+		return repository.ErrGroupNotFound
+	}
+	if groupID == childID || descends(byID, childID, groupID) {
+		return ErrGroupCycle
+	}
+	return g.groups.AddSubgroup(groupID, childID)
+}
+
+func (g *groupServiceImpl) RemoveSubgroup(groupID string, childID string) error {
+	return g.groups.RemoveSubgroup(groupID, childID)
+}
+
+func (g *groupServiceImpl) RemoveUser(userID string) error {
+	return g.groups.RemoveUserFromGroups(userID)
+}
+
+// UserGroups returns the groups the user belongs to directly or through
+// nested groups, sorted by name.
+func (g *groupServiceImpl) UserGroups(userID string) ([]models.Group, error) {
+	all, err := g.groups.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+	//parents maps a group to the groups nesting it
+	parents := map[string][]string{}
+	byID := make(map[string]models.Group, len(all))
+	var queue []string
+	for _, group := range all {
+		byID[group.ID] = group
+		for _, child := range group.Subgroups {
+			parents[child] = append(parents[child], group.ID)
+		}
+		if slices.Contains(group.Members, userID) {
+			queue = append(queue, group.ID)
+		}
+	}
+	seen := map[string]bool{}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		queue = append(queue, parents[id]...)
+	}
+	//ListGroups is sorted by name, so the result is too
+	groups := []models.Group{}
+	for _, group := range all {
+		if seen[group.ID] {
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+// InAnyGroup reports whether the user effectively belongs to one of the
+// named groups.
+func (g *groupServiceImpl) InAnyGroup(userID string, names []string) (bool, error) {
+	if len(names) == 0 {
+		return false, nil
+	}
+	groups, err := g.UserGroups(userID)
+	if err != nil {
+		return false, err
+	}
+	for _, group := range groups {
+		for _, name := range names {
+			if group.NameCanonical == g.canon.Username(name) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// descends reports whether target is reachable from id through subgroups.
+// The walk tracks visited groups so an existing cycle cannot loop it.
+func descends(byID map[string]models.Group, id string, target string) bool {
+	seen := map[string]bool{}
+	stack := []string{id}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if current == target {
+			return true
+		}
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+		stack = append(stack, byID[current].Subgroups...)
+	}
+	return false
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/utils"
+	"Users/validation"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrIdentityNotLinked is returned for upstream identities no account is
+// linked to, at providers that do not allow sign up.
+var ErrIdentityNotLinked = errors.New("no account is linked to this identity")
+
+// ErrAccountExists is returned when an upstream identity would take over an
+// account by an email the provider did not verify; the user has to sign in
+// and link the provider instead.
+var ErrAccountExists = errors.New("an account with this email already exists")
+
+// ErrLastIdentity is returned when unlinking would leave an account created
+// by its provider with no way to sign in.
+var ErrLastIdentity = errors.New("the only identity of an account created by its provider cannot be unlinked")
+
+const federationStateTTL = 10 * time.Minute
+
+type identityServiceImpl struct {
+	identities repository.IdentityRepository
+	users      repository.UserRepository
+	create     CreateInterface
+	lockout    LockoutInterface
+	federation FederationInterface
+	canon      *utils.Canonicalizer
+	tenantID   string
+}
+
+func NewIdentityService(identities repository.IdentityRepository, users repository.UserRepository, create CreateInterface, lockout LockoutInterface, federation FederationInterface, canon *utils.Canonicalizer, tenantID string) IdentityInterface {
+	return &identityServiceImpl{identities: identities, users: users, create: create, lockout: lockout, federation: federation, canon: canon, tenantID: tenantID}
+}
+
+func (s *identityServiceImpl) Providers() []models.IdentityProviderInfo {
+	return s.federation.Providers(s.tenantID)
+}
+
+// Begin stores the state, nonce and PKCE verifier of a round trip to the
+// provider and returns where to send the user.
+func (s *identityServiceImpl) Begin(providerID string, userID string) (*models.FederatedLoginStart, error) {
+	if userID != "" {
+		if _, err := s.users.FetchUserByID(userID); err != nil {
+			return nil, err
+		}
+	}
+	state, err := randomToken(24)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomToken(24)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	link, err := s.federation.AuthorizationURL(s.tenantID, providerID, state, nonce, base64.RawURLEncoding.EncodeToString(sum[:]))
+	if err != nil {
+		return nil, err
+	}
+	err = s.identities.SaveState(&models.FederationState{
+		ID:        state,
+		Provider:  providerID,
+		UserID:    userID,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		ExpiresAt: time.Now().Add(federationStateTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.FederatedLoginStart{AuthorizationURL: link, State: state}, nil
+}
+
+// finish consumes the state of callback and redeems its code
+func (s *identityServiceImpl) finish(userID string, callback models.FederatedCallback) (*models.FederationState, *models.ExternalIdentity, error) {
+	if callback.State == "" {
+		return nil, nil, fmt.Errorf("%w: state is required", ErrInvalidInput)
+	}
+	state, err := s.identities.TakeState(callback.State)
+	if err != nil {
+		return nil, nil, err
+	}
+	//a link started for one user cannot finish as a sign in, or for another
+	if state.UserID != userID {
+		return nil, nil, repository.ErrStateNotFound
+	}
+	if callback.Error != "" {
+		return nil, nil, fmt.Errorf("%w: %s %s", ErrFederationRejected, callback.Error, callback.ErrorDescription)
+	}
+	if callback.Code == "" {
+		return nil, nil, fmt.Errorf("%w: code is required", ErrInvalidInput)
+	}
+	external, err := s.federation.Exchange(s.tenantID, state.Provider, callback.Code, state.Verifier, state.Nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	return state, external, nil
+}
+
+// FinishLogin signs in the user linked to the upstream identity. Unknown
+// identities are linked to the account with the same verified email, or
+// given a new account when the provider allows sign up.
+func (s *identityServiceImpl) FinishLogin(callback models.FederatedCallback) (*models.User, error) {
+	_, external, err := s.finish("", callback)
+	if err != nil {
+		return nil, err
+	}
+	var user *models.User
+	identity, err := s.identities.FetchIdentity(external.Provider, external.Subject)
+	switch {
+	case err == nil:
+		user, err = s.users.FetchUserByID(identity.UserID)
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrIdentityNotLinked
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := s.identities.TouchIdentity(identity.ID, external.Email, time.Now()); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, repository.ErrIdentityNotFound):
+		user, err = s.linkOrProvision(external)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	if err := s.lockout.Release(user); err != nil {
+		return nil, err
+	}
+	if user.Status != models.StatusActive {
+		return nil, ErrAccountInactive
+	}
+	return user, nil
+}
+
+// linkOrProvision finds or creates the account of an identity seen for the
+// first time and links it
+func (s *identityServiceImpl) linkOrProvision(external *models.ExternalIdentity) (*models.User, error) {
+	if external.Email != "" {
+		user, err := s.users.FetchUserByEmail(s.canon.Email(external.Email))
+		switch {
+		case err == nil && external.EmailVerified:
+			return user, s.link(user.ID, external, false)
+		case err == nil:
+			return nil, ErrAccountExists
+		case !errors.Is(err, repository.ErrUserNotFound):
+			return nil, err
+		}
+	}
+	if !s.federation.AllowsSignup(s.tenantID, external.Provider) {
+		return nil, ErrIdentityNotLinked
+	}
+	if external.Email == "" || !external.EmailVerified {
+		return nil, fmt.Errorf("%w: the provider did not vouch for an email to create the account with", ErrFederationRejected)
+	}
+	user, err := s.provision(external)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.link(user.ID, external, true); err != nil {
+		return nil, err
+	}
+	return s.users.FetchUserByID(user.ID)
+}
+
+// provision creates the account of a new identity. It gets a random
+// password nobody knows; the upstream username and name are kept when they
+// are valid here, and dropped otherwise.
+func (s *identityServiceImpl) provision(external *models.ExternalIdentity) (*models.User, error) {
+	secret, err := randomToken(24)
+	if err != nil {
+		return nil, err
+	}
+	user := &models.User{
+		Email: external.Email,
+		//the fixed suffix satisfies the password rules whatever the
+		//random part happens to contain
+		Password: secret + "Aa1",
+	}
+	if validation.ValidateUsername(external.Username) == nil {
+		user.Username = external.Username
+	}
+	if external.Name != "" {
+		user.Profile = &models.Profile{DisplayName: external.Name}
+	}
+	err = s.create.CreateUser(user)
+	if user.Username != "" && (errors.Is(err, repository.ErrUserExists) || errors.Is(err, ErrInvalidInput)) {
+		user.Username = ""
+		err = s.create.CreateUser(user)
+	}
+	if user.Profile != nil && errors.Is(err, ErrInvalidInput) {
+		user.Profile = nil
+		err = s.create.CreateUser(user)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *identityServiceImpl) link(userID string, external *models.ExternalIdentity, provisioned bool) error {
+	now := time.Now()
+	return s.identities.CreateIdentity(&models.Identity{
+		UserID:      userID,
+		Provider:    external.Provider,
+		Subject:     external.Subject,
+		Email:       external.Email,
+		Provisioned: provisioned,
+		LinkedAt:    now,
+		LastLoginAt: &now,
+	})
+}
+
+// FinishLink links the upstream identity to userID, who started the link
+// signed in. An identity already linked to any account is refused.
+func (s *identityServiceImpl) FinishLink(userID string, callback models.FederatedCallback) (*models.Identity, error) {
+	if _, err := s.users.FetchUserByID(userID); err != nil {
+		return nil, err
+	}
+	_, external, err := s.finish(userID, callback)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.link(userID, external, false); err != nil {
+		return nil, err
+	}
+	return s.identities.FetchIdentity(external.Provider, external.Subject)
+}
+
+func (s *identityServiceImpl) ListIdentities(userID string) ([]models.Identity, error) {
+	if _, err := s.users.FetchUserByID(userID); err != nil {
+		return nil, err
+	}
+	return s.identities.ListIdentities(userID)
+}
+
+func (s *identityServiceImpl) Unlink(userID string, providerID string) error {
+	identities, err := s.identities.ListIdentities(userID)
+	if err != nil {
+		return err
+	}
+	for _, identity := range identities {
+		if identity.Provider == providerID && identity.Provisioned && len(identities) == 1 {
+			return ErrLastIdentity
+		}
+	}
+	return s.identities.DeleteIdentity(userID, providerID)
+}
+
+func (s *identityServiceImpl) RemoveUser(userID string) error {
+	return s.identities.DeleteUserIdentities(userID)
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/utils"
+	"errors"
+	"io"
+	"log/slog"
+	"net/url"
+	"testing"
+)
+
+type identityFixture struct {
+	service    IdentityInterface
+	users      repository.UserRepository
+	identities repository.IdentityRepository
+	stub       *stubProvider
+}
+
+func newIdentityFixture(t *testing.T, allowSignup bool) *identityFixture {
+	t.Helper()
+	stub := newStubProvider(t)
+	users := repository.NewMemory(models.DefaultTenant)
+	identities := repository.NewMemoryIdentities(models.DefaultTenant)
+	canon := utils.NewCanonicalizer(utils.DefaultEmailPolicy())
+	hasher, err := utils.NewBcryptHasher(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := NewCreateService(users, hasher, canon, NewAttributeService(repository.NewMemoryAttributeSchemas(), models.DefaultTenant))
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	lockout := NewLockoutService(repository.NewMemoryAttempts(), users, NewLogNotifier(logger), DefaultLockoutOptions(), logger)
+	service := NewIdentityService(identities, users, create, lockout, stub.federation(t, allowSignup), canon, models.DefaultTenant)
+	return &identityFixture{service: service, users: users, identities: identities, stub: stub}
+}
+
+// existing stores an account for email
+func (f *identityFixture) existing(t *testing.T, email string) *models.User {
+	t.Helper()
+	user := &models.User{Username: "alice", UsernameCanonical: "alice", Email: email, EmailCanonical: email}
+	if err := f.users.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// login signs in at the stub as subject, with the given email claims
+func (f *identityFixture) login(t *testing.T, subject string, email string, verified bool) (*models.User, error) {
+	t.Helper()
+	start, err := f.service.Begin(stubProviderID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := url.Parse(start.AuthorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := f.stub.claims(subject, link.Query().Get("nonce"))
+	claims["email"] = email
+	claims["email_verified"] = verified
+	f.stub.issue(t, claims)
+	return f.service.FinishLogin(models.FederatedCallback{State: start.State, Code: "code"})
+}
+
+func TestFederatedLoginLinksVerifiedEmail(t *testing.T) {
+	f := newIdentityFixture(t, false)
+	existing := f.existing(t, "alice@example.com")
+	user, err := f.login(t, "upstream-alice", "alice@example.com", true)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if user.ID != existing.ID {
+		t.Fatalf("signed in %s, want the existing account %s", user.ID, existing.ID)
+	}
+	identity, err := f.identities.FetchIdentity(stubProviderID, "upstream-alice")
+	if err != nil {
+		t.Fatalf("identity was not linked: %v", err)
+	}
+	if identity.UserID != existing.ID || identity.Provisioned {
+		t.Fatalf("identity = %+v, want linked to %s and not provisioned", identity, existing.ID)
+	}
+}
+
+func TestFederatedLoginUnverifiedEmailOfExistingAccount(t *testing.T) {
+	f := newIdentityFixture(t, true)
+	f.existing(t, "alice@example.com")
+	if _, err := f.login(t, "upstream-alice", "alice@example.com", false); !errors.Is(err, ErrAccountExists) {
+		t.Fatalf("err = %v, want ErrAccountExists", err)
+	}
+	if _, err := f.identities.FetchIdentity(stubProviderID, "upstream-alice"); !errors.Is(err, repository.ErrIdentityNotFound) {
+		t.Fatalf("identity was linked: %v", err)
+	}
+}
+
+func TestFederatedLoginSignupDisabled(t *testing.T) {
+	f := newIdentityFixture(t, false)
+	if _, err := f.login(t, "upstream-bob", "bob@example.com", true); !errors.Is(err, ErrIdentityNotLinked) {
+		t.Fatalf("err = %v, want ErrIdentityNotLinked", err)
+	}
+	if _, err := f.users.FetchUserByEmail("bob@example.com"); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Fatalf("an account was created: %v", err)
+	}
+}
+
+func TestFederatedLoginSignup(t *testing.T) {
+	f := newIdentityFixture(t, true)
+	user, err := f.login(t, "upstream-bob", "bob@example.com", true)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	identity, err := f.identities.FetchIdentity(stubProviderID, "upstream-bob")
+	if err != nil {
+		t.Fatalf("identity was not linked: %v", err)
+	}
+	if identity.UserID != user.ID || !identity.Provisioned {
+		t.Fatalf("identity = %+v, want provisioned for %s", identity, user.ID)
+	}
+}
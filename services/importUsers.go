@@ -0,0 +1,317 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/utils"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+
+	//upper bound on rows per import so one request can't monopolize hashing
+	MaxImportRows = 10000
+	importBatch   = 500
+)
+
+const (
+	importCreated = "created"
+	importUpdated = "updated"
+	importSkipped = "skipped"
+	importInvalid = "invalid"
+	importFailed  = "failed"
+)
+
+// ImportRow is one parsed input record. Line is the 1-based line in the
+// source file, used in reports.
+type ImportRow struct {
+	Line int
+	User models.User
+}
+
+type ImportOptions struct {
+	//update users whose email already exists instead of skipping them
+	UpdateExisting bool
+	//validate and classify every row without writing anything
+	DryRun bool
+}
+
+type importServiceImpl struct {
+	users  repository.UserRepository
+	create *createServiceImpl
+}
+
+func NewImportService(users repository.UserRepository, hasher utils.PasswordHasher, canon *utils.Canonicalizer, attributes AttributeInterface) ImportInterface {
+	return &importServiceImpl{
+		users:  users,
+		create: &createServiceImpl{createUser: users, hasher: hasher, canon: canon, attributes: attributes},
+	}
+}
+
+// planned is a row that passed validation, with the action decided for it
+type planned struct {
+	index  int
+	user   *models.User
+	action string
+}
+
+func (s *importServiceImpl) ImportUsers(rows []ImportRow, opts ImportOptions) (*models.ImportReport, error) {
+	if len(rows) > MaxImportRows {
+		return nil, fmt.Errorf("%w: import is limited to %d rows", ErrInvalidInput, MaxImportRows)
+	}
+	report := &models.ImportReport{DryRun: opts.DryRun, Total: len(rows)}
+	results := make([]models.ImportRowResult, len(rows))
+
+	//validating every row through the same rules as single creates
+	var valid []planned
+	seenEmail := map[string]int{}
+	seenUsername := map[string]int{}
+	emails := []string{}
+	usernames := []string{}
+	for i, row := range rows {
+		results[i] = models.ImportRowResult{Line: row.Line, Email: row.User.Email}
+		user, err := s.create.prepare(&row.User)
+		if err != nil {
+			results[i].Result, results[i].Error = importInvalid, err.Error()
+			continue
+		}
+		if line, dup := seenEmail[user.EmailCanonical]; dup {
+			results[i].Result, results[i].Error = importInvalid, fmt.Sprintf("email duplicates line %d", line)
+			continue
+		}
+		if line, dup := seenUsername[user.UsernameCanonical]; dup && user.UsernameCanonical != "" {
+			results[i].Result, results[i].Error = importInvalid, fmt.Sprintf("username duplicates line %d", line)
+			continue
+		}
+		seenEmail[user.EmailCanonical] = row.Line
+		emails = append(emails, user.EmailCanonical)
+		if user.UsernameCanonical != "" {
+			seenUsername[user.UsernameCanonical] = row.Line
+			usernames = append(usernames, user.UsernameCanonical)
+		}
+		valid = append(valid, planned{index: i, user: user})
+	}
+
+	//classifying rows against the users already stored
+	existing, err := s.users.FetchUsersByCanonical(emails, usernames)
+	if err != nil {
+		return nil, err
+	}
+	byEmail := map[string]models.User{}
+	byUsername := map[string]models.User{}
+	for _, u := range existing {
+		byEmail[u.EmailCanonical] = u
+		if u.UsernameCanonical != "" {
+			byUsername[u.UsernameCanonical] = u
+		}
+	}
+	var toWrite []planned
+	for _, p := range valid {
+		owner, emailTaken := byEmail[p.user.EmailCanonical]
+		holder, usernameTaken := byUsername[p.user.UsernameCanonical]
+		switch {
+		case emailTaken && !opts.UpdateExisting:
+			results[p.index].Result = importSkipped
+		case usernameTaken && (!emailTaken || holder.ID != owner.ID):
+			results[p.index].Result, results[p.index].Error = importFailed, "username already in use"
+		case emailTaken:
+			p.action = importUpdated
+			toWrite = append(toWrite, p)
+		default:
+			p.action = importCreated
+			toWrite = append(toWrite, p)
+		}
+	}
+
+	if opts.DryRun {
+		for _, p := range toWrite {
+			results[p.index].Result = p.action
+		}
+		report.Rows = results
+		tally(report, results)
+		return report, nil
+	}
+
+	if err := s.hashAll(toWrite); err != nil {
+		return nil, err
+	}
+	for start := 0; start < len(toWrite); start += importBatch {
+		batch := toWrite[start:min(start+importBatch, len(toWrite))]
+		users := make([]*models.User, len(batch))
+		for i, p := range batch {
+			users[i] = p.user
+		}
+		written, err := s.users.BulkWriteUsers(users, opts.UpdateExisting)
+		if err != nil {
+			return nil, err
+		}
+		for i, w := range written {
+			r := &results[batch[i].index]
+			switch {
+			case errors.Is(w.Err, repository.ErrUserExists):
+				//lost a race with a concurrent create
+				r.Result, r.Error = importFailed, "email or username already in use"
+			case w.Err != nil:
+				r.Result, r.Error = importFailed, w.Err.Error()
+			case w.Created:
+				r.Result = importCreated
+			default:
+				r.Result = importUpdated
+			}
+		}
+	}
+
+	tally(report, results)
+	for _, r := range results {
+		if r.Result != importCreated && r.Result != importUpdated {
+			report.Rows = append(report.Rows, r)
+		}
+	}
+	return report, nil
+}
+
+// hashAll hashes passwords on a few workers; argon2id is memory hard so the
+// pool stays small
+func (s *importServiceImpl) hashAll(rows []planned) error {
+	workers := min(runtime.NumCPU(), 4)
+	jobs := make(chan *models.User)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for user := range jobs {
+				hashed, err := s.create.hasher.Hash(user.Password)
+				if err != nil {
+					once.Do(func() { firstErr = err })
+					continue
+				}
+				user.Password = hashed
+			}
+		}()
+	}
+	for _, p := range rows {
+		jobs <- p.user
+	}
+	close(jobs)
+	wg.Wait()
+	return firstErr
+}
+
+func tally(report *models.ImportReport, results []models.ImportRowResult) {
+	for _, r := range results {
+		switch r.Result {
+		case importCreated:
+			report.Created++
+		case importUpdated:
+			report.Updated++
+		case importSkipped:
+			report.Skipped++
+		default:
+			report.Failed++
+		}
+	}
+}
+
+// ParseImport reads CSV (with an email,username,password header) or NDJSON
+// into rows. Parsing stops at the first malformed record.
+func ParseImport(r io.Reader, format string) ([]ImportRow, error) {
+	switch format {
+	case ImportFormatCSV:
+		return parseCSV(r)
+	case ImportFormatNDJSON:
+		return parseNDJSON(r)
+	}
+	return nil, fmt.Errorf("%w: unsupported import format %q", ErrInvalidInput, format)
+}
+
+func parseCSV(r io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading CSV header: %w", ErrInvalidInput, err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, fmt.Errorf("%w: CSV header must include an email column", ErrInvalidInput)
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	var rows []ImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+		}
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, ImportRow{Line: line, User: models.User{
+			Email:    field(record, "email"),
+			Username: field(record, "username"),
+			Password: field(record, "password"),
+		}})
+		if len(rows) > MaxImportRows {
+			return nil, fmt.Errorf("%w: import is limited to %d rows", ErrInvalidInput, MaxImportRows)
+		}
+	}
+	return rows, nil
+}
+
+func parseNDJSON(r io.Reader) ([]ImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var rows []ImportRow
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var record struct {
+			Email    string `json:"email"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := json.Unmarshal([]byte(text), &record); err != nil {
+			//a failed read ends the scan with the partial line as a token
+			if readErr := scanner.Err(); readErr != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidInput, readErr)
+			}
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidInput, line, err)
+		}
+		rows = append(rows, ImportRow{Line: line, User: models.User{
+			Email:    record.Email,
+			Username: record.Username,
+			Password: record.Password,
+		}})
+		if len(rows) > MaxImportRows {
+			return nil, fmt.Errorf("%w: import is limited to %d rows", ErrInvalidInput, MaxImportRows)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	return rows, nil
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/utils"
+	"Users/validation"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvitationInvalid = errors.New("invalid invitation token")
+	ErrInvitationExpired = errors.New("invitation has expired")
+)
+
+// InvitationOptions configures invitation links. URL is the link sent to the
+// invitee, with {token} replaced by the signed token.
+type InvitationOptions struct {
+	TTL time.Duration
+	URL string
+}
+
+func DefaultInvitationOptions() InvitationOptions {
+	return InvitationOptions{TTL: 7 * 24 * time.Hour, URL: "/api/invitations/{token}/accept"}
+}
+
+// invitationClaims is the signed payload of an invitation token; the tenant
+// is carried so a link works without naming the tenant separately
+type invitationClaims struct {
+	Tenant  string `json:"t"`
+	ID      string `json:"i"`
+	Expires int64  `json:"e"`
+}
+
+type invitationServiceImpl struct {
+	invitations repository.InvitationRepository
+	users       repository.UserRepository
+	create      CreateInterface
+	groups      GroupInterface
+	canon       *utils.Canonicalizer
+	signer      *utils.Signer
+	tenant      string
+	opts        InvitationOptions
+}
+
+func NewInvitationService(invitations repository.InvitationRepository, users repository.UserRepository, create CreateInterface, groups GroupInterface, canon *utils.Canonicalizer, signer *utils.Signer, tenant string, opts InvitationOptions) InvitationInterface {
+	return &invitationServiceImpl{
+		invitations: invitations,
+		users:       users,
+		create:      create,
+		groups:      groups,
+		canon:       canon,
+		signer:      signer,
+		tenant:      tenant,
+		opts:        opts,
+	}
+}
+
+// Invite stores an invitation for inv.Email with its role and groups and
+// returns the signed link to send to the invitee.
+func (s *invitationServiceImpl) Invite(inv *models.Invitation) (*models.InvitationLink, error) {
+	inv.Email = s.canon.Display(inv.Email)
+	if err := validation.ValidateEmail(inv.Email); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	inv.EmailCanonical = s.canon.Email(inv.Email)
+	if inv.Role == "" {
+		inv.Role = models.RoleUser
+	}
+	if err := validation.ValidateRole(inv.Role); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	for _, groupID := range inv.Groups {
+		if _, err := s.groups.Group(groupID); err != nil {
+			if errors.Is(err, repository.ErrGroupNotFound) {
+				return nil, fmt.Errorf("%w: group %s does not exist", ErrInvalidInput, groupID)
+			}
+			return nil, err
+		}
+	}
+	_, err := s.users.FetchUserByEmail(inv.EmailCanonical)
+	if err == nil {
+		return nil, repository.ErrUserExists
+	}
+	if !errors.Is(err, repository.ErrUserNotFound) {
+		return nil, err
+	}
+
+	inv.Status = models.InvitationPending
+	inv.CreatedAt = time.Now()
+	inv.ExpiresAt = inv.CreatedAt.Add(s.opts.TTL)
+	if err := s.invitations.CreateInvitation(inv); err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(invitationClaims{Tenant: s.tenant, ID: inv.ID, Expires: inv.ExpiresAt.Unix()})
+	if err != nil {
+		return nil, err
+	}
+	token := s.signer.Sign(payload)
+	return &models.InvitationLink{
+		Invitation: inv,
+		Token:      token,
+		URL:        strings.ReplaceAll(s.opts.URL, "{token}", token),
+	}, nil
+}
+
+// Accept creates the invited user from the username, password and profile
+// in user; the email, role and groups come from the invitation.
+func (s *invitationServiceImpl) Accept(token string, user *models.User) (*models.User, error) {
+	claims, err := parseInvitationToken(s.signer, token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Tenant != s.tenant {
+		return nil, ErrInvitationInvalid
+	}
+	inv, err := s.invitations.FetchInvitation(claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Status != models.InvitationPending {
+		return nil, repository.ErrInvitationUsed
+	}
+
+	//a concurrent accept of the same invitation fails here on the unique
+	//email, so the invitation cannot create two accounts
+	newUser := &models.User{
+		Username:   user.Username,
+		Email:      inv.Email,
+		Password:   user.Password,
+		Profile:    user.Profile,
+		Attributes: user.Attributes,
+	}
+	if err := s.create.CreateUserWithRole(newUser, inv.Role); err != nil {
+		return nil, err
+	}
+	for _, groupID := range inv.Groups {
+		//groups deleted since the invitation was sent are skipped
+		if err := s.groups.AddMember(groupID, newUser.ID); err != nil && !errors.Is(err, repository.ErrGroupNotFound) {
+			return nil, s.discard(newUser, err)
+		}
+	}
+	if err := s.invitations.MarkInvitationAccepted(inv.ID, newUser.ID); err != nil {
+		return nil, s.discard(newUser, err)
+	}
+	created, err := s.users.FetchUserByID(newUser.ID)
+	if err != nil {
+		return nil, err
+	}
+	created.Password = ""
+	return created, nil
+}
+
+// discard removes an account whose invitation could not be completed, so
+// the invitation stays pending and can be accepted again. cause is returned,
+// joined with whatever prevented the removal.
+func (s *invitationServiceImpl) discard(user *models.User, cause error) error {
+	if err := s.groups.RemoveUser(user.ID); err != nil {
+		return errors.Join(cause, fmt.Errorf("removing the memberships of %s: %w", user.ID, err))
+	}
+	if err := s.users.DeleteUser(user); err != nil {
+		return errors.Join(cause, fmt.Errorf("removing account %s: %w", user.ID, err))
+	}
+	return cause
+}
+
+// InvitationTenant returns the tenant an invitation token was issued for,
+// after checking its signature and expiry.
+func InvitationTenant(signer *utils.Signer, token string) (string, error) {
+	claims, err := parseInvitationToken(signer, token)
+	if err != nil {
+		return "", err
+	}
+	return claims.Tenant, nil
+}
+
+func parseInvitationToken(signer *utils.Signer, token string) (*invitationClaims, error) {
+	payload, err := signer.Open(token)
+	if err != nil {
+		return nil, ErrInvitationInvalid
+	}
+	var claims invitationClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.ID == "" || claims.Tenant == "" {
+		return nil, ErrInvitationInvalid
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return nil, ErrInvitationExpired
+	}
+	return &claims, nil
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"Users/jose"
+	"Users/models"
+	"Users/repository"
+	"Users/utils"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+const rsaKeyBits = 2048
+
+var ErrNoKeySealer = errors.New("signing keys cannot be created without a sealing secret")
+var ErrNoSigningKey = errors.New("no signing key is active")
+
+type KeyRingOptions struct {
+	//Algorithm of the keys created on schedule, RS256 or EdDSA
+	Algorithm string
+	//RotateEvery is how long each key signs
+	RotateEvery time.Duration
+	//Prepublish is how long a key is published before it signs, so
+	//verifiers caching the key set know it by then
+	Prepublish time.Duration
+	//Overlap is how long a retired key stays published; it has to outlast
+	//the tokens signed with it
+	Overlap time.Duration
+	//Refresh is how often keys are reloaded to pick up those created or
+	//revoked by other replicas and the CLI
+	Refresh time.Duration
+}
+
+func DefaultKeyRingOptions() KeyRingOptions {
+	return KeyRingOptions{
+		Algorithm:   jose.RS256,
+		RotateEvery: 30 * 24 * time.Hour,
+		Prepublish:  24 * time.Hour,
+		Overlap:     24 * time.Hour,
+		Refresh:     time.Minute,
+	}
+}
+
+// keyRingImpl keeps the token signing keys in the database with their
+// private halves sealed, and rotates them on schedule: the next key is
+// created Prepublish ahead of the current one retiring, and the retired
+// key stays published for Overlap after. Replicas racing to create the
+// next key at most publish one key too many.
+type keyRingImpl struct {
+	repo   repository.SigningKeyRepository
+	sealer *utils.Sealer
+	opts   KeyRingOptions
+
+	mu       sync.Mutex
+	keys     []models.SigningKey
+	private  map[string]crypto.Signer
+	loadedAt time.Time
+}
+
+// NewKeyRing takes the sealer private keys are stored with; without one
+// keys can be listed and revoked but not created or used to sign.
+func NewKeyRing(repo repository.SigningKeyRepository, sealer *utils.Sealer, opts KeyRingOptions) KeyRingInterface {
+	return &keyRingImpl{repo: repo, sealer: sealer, opts: opts, private: map[string]crypto.Signer{}}
+}
+
+func (k *keyRingImpl) ActiveKey() (*jose.Key, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.load(false); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	key := k.signing(now)
+	if key == nil && k.sealer == nil {
+		return nil, ErrNoKeySealer
+	}
+	if key == nil {
+		return nil, ErrNoSigningKey
+	}
+	return &jose.Key{ID: key.ID, Algorithm: key.Algorithm, Private: k.private[key.ID]}, nil
+}
+
+func (k *keyRingImpl) PublicKeys() (*jose.JWKSet, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.load(false); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	set := &jose.JWKSet{Keys: []jose.JWK{}}
+	for _, key := range k.keys {
+		if !key.Published(now) {
+			continue
+		}
+		public, err := x509.ParsePKIXPublicKey(key.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", key.ID, err)
+		}
+		jwk, err := jose.NewJWK(key.ID, key.Algorithm, public)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+func (k *keyRingImpl) ListKeys() ([]models.SigningKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.load(true); err != nil {
+		return nil, err
+	}
+	return slices.Clone(k.keys), nil
+}
+
+// Rotate creates a key of algorithm that signs from activateIn on, and
+// retires the keys signing until then at that point. The new key keeps the
+// signing period and overlap of the key it replaces.
+func (k *keyRingImpl) Rotate(algorithm string, activateIn time.Duration) (*models.SigningKey, error) {
+	if !slices.Contains(jose.Algorithms, algorithm) {
+		return nil, fmt.Errorf("%w: unknown algorithm %q", ErrInvalidInput, algorithm)
+	}
+	if activateIn < 0 {
+		return nil, fmt.Errorf("%w: the key cannot activate in the past", ErrInvalidInput)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.sealer == nil {
+		return nil, ErrNoKeySealer
+	}
+	if err := k.load(true); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	rotateEvery, overlap := k.opts.RotateEvery, k.opts.Overlap
+	for _, key := range k.keys {
+		if key.Status(now) == models.KeyActive {
+			rotateEvery, overlap = key.RetiresAt.Sub(key.ActivatesAt), key.ExpiresAt.Sub(key.RetiresAt)
+			break
+		}
+	}
+	activatesAt := now.Add(activateIn)
+	created, err := k.create(algorithm, activatesAt, rotateEvery, overlap)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range k.keys {
+		if key.ID == created.ID || key.RevokedAt != nil || !key.RetiresAt.After(activatesAt) {
+			continue
+		}
+		expiresAt := activatesAt.Add(overlap)
+		if key.ExpiresAt.Before(expiresAt) {
+			expiresAt = key.ExpiresAt
+		}
+		if err := k.repo.RetireSigningKey(key.ID, activatesAt, expiresAt); err != nil {
+			return nil, err
+		}
+	}
+	if err := k.load(true); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// Revoke unpublishes a key at once, so tokens signed with it no longer
+// verify. A revoked signing key is replaced straight away.
+func (k *keyRingImpl) Revoke(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.repo.RevokeSigningKey(kid); err != nil {
+		return err
+	}
+	delete(k.private, kid)
+	return k.load(true)
+}
+
+// load reloads the keys once they are Refresh old, or when forced, and
+// then creates the next key when it is due; the caller holds the lock
+func (k *keyRingImpl) load(force bool) error {
+	if !force && !k.loadedAt.IsZero() && time.Since(k.loadedAt) < k.opts.Refresh {
+		return nil
+	}
+	keys, err := k.repo.ListSigningKeys()
+	if err != nil {
+		return err
+	}
+	k.keys = keys
+	k.loadedAt = time.Now()
+	if k.sealer == nil {
+		return nil
+	}
+	now := time.Now()
+	current := k.signing(now)
+	if current == nil {
+		//nothing to sign with: first start, revocation or a key this
+		//replica cannot open
+		_, err = k.create(k.opts.Algorithm, now, k.opts.RotateEvery, k.opts.Overlap)
+		return err
+	}
+	if now.Before(current.RetiresAt.Add(-k.opts.Prepublish)) || k.hasSuccessor(current) {
+		return nil
+	}
+	_, err = k.create(k.opts.Algorithm, current.RetiresAt, k.opts.RotateEvery, k.opts.Overlap)
+	return err
+}
+
+// signing returns the key to sign with at now: of the active keys this
+// replica can open, the last to activate
+func (k *keyRingImpl) signing(now time.Time) *models.SigningKey {
+	for i := range k.keys {
+		key := &k.keys[i]
+		if key.Status(now) == models.KeyActive && k.open(key) {
+			return key
+		}
+	}
+	return nil
+}
+
+func (k *keyRingImpl) hasSuccessor(current *models.SigningKey) bool {
+	for _, key := range k.keys {
+		if key.RevokedAt == nil && key.ActivatesAt.After(current.ActivatesAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// open unseals a key's private half into the cache; keys sealed under
+// another secret stay closed
+func (k *keyRingImpl) open(key *models.SigningKey) bool {
+	if _, ok := k.private[key.ID]; ok {
+		return true
+	}
+	if k.sealer == nil {
+		return false
+	}
+	der, err := k.sealer.Open(key.PrivateKey, []byte(key.ID))
+	if err != nil {
+		return false
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return false
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if ok {
+		k.private[key.ID] = signer
+	}
+	return ok
+}
+
+// create generates and stores a key signing from activatesAt; the caller
+// holds the lock
+func (k *keyRingImpl) create(algorithm string, activatesAt time.Time, rotateEvery time.Duration, overlap time.Duration) (*models.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case jose.RS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case jose.EdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	kid := hex.EncodeToString(id)
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	//the key ID is bound in so a sealed key cannot be passed off as another
+	sealed, err := k.sealer.Seal(der, []byte(kid))
+	if err != nil {
+		return nil, err
+	}
+	public, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	key := models.SigningKey{
+		ID:          kid,
+		Algorithm:   algorithm,
+		PrivateKey:  sealed,
+		PublicKey:   public,
+		CreatedAt:   time.Now(),
+		ActivatesAt: activatesAt,
+		RetiresAt:   activatesAt.Add(rotateEvery),
+		ExpiresAt:   activatesAt.Add(rotateEvery + overlap),
+	}
+	if err := k.repo.CreateSigningKey(&key); err != nil {
+		return nil, err
+	}
+	k.private[kid] = private
+	k.keys = append(k.keys, key)
+	slices.SortStableFunc(k.keys, func(a, b models.SigningKey) int {
+		return b.ActivatesAt.Compare(a.ActivatesAt)
+	})
+	return &key, nil
+}
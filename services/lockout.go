@@ -0,0 +1,187 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+var (
+	ErrAccountLocked   = errors.New("account is temporarily locked")
+	ErrTooManyAttempts = errors.New("too many failed attempts")
+)
+
+// RetryError refuses an attempt until RetryAfter has passed. It wraps
+// ErrAccountLocked or ErrTooManyAttempts.
+type RetryError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%v, retry in %v", e.Err, e.RetryAfter.Round(time.Second))
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+type LockoutOptions struct {
+	//Threshold is the number of failures that locks an account; zero only
+	//slows attempts down
+	Threshold int
+	//IPThreshold is the number of failures from one address after which
+	//its attempts are slowed down; below it shared addresses are not
+	//penalised
+	IPThreshold int
+	//the wait after a failure starts at BaseDelay and doubles up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	//Window is how long failures are remembered after the last one
+	Window time.Duration
+	//LockDuration is how long a locked account stays locked
+	LockDuration time.Duration
+}
+
+func DefaultLockoutOptions() LockoutOptions {
+	return LockoutOptions{
+		Threshold:    5,
+		IPThreshold:  20,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		Window:       15 * time.Minute,
+		LockDuration: 15 * time.Minute,
+	}
+}
+
+type lockoutServiceImpl struct {
+	attempts repository.AttemptRepository
+	users    repository.UserRepository
+	notifier Notifier
+	opts     LockoutOptions
+	logger   *slog.Logger
+}
+
+func NewLockoutService(attempts repository.AttemptRepository, users repository.UserRepository, notifier Notifier, opts LockoutOptions, logger *slog.Logger) LockoutInterface {
+	return &lockoutServiceImpl{attempts: attempts, users: users, notifier: notifier, opts: opts, logger: logger}
+}
+
+func userKey(userID string) string {
+	return "user:" + userID
+}
+
+func addressKey(ip string) string {
+	return "ip:" + ip
+}
+
+// delay is the wait imposed after the nth consecutive failure
+func (l *lockoutServiceImpl) delay(n int) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	d := l.opts.BaseDelay
+	for i := 1; i < n && d < l.opts.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, l.opts.MaxDelay)
+}
+
+// wait returns how long the counter still blocks attempts, given the
+// failures it tolerates without a delay
+func (l *lockoutServiceImpl) wait(counter *models.AttemptCounter, free int) time.Duration {
+	if counter.Count <= free {
+		return 0
+	}
+	return time.Until(counter.LastFailureAt.Add(l.delay(counter.Count - free)))
+}
+
+func (l *lockoutServiceImpl) CheckAddress(ip string) error {
+	if ip == "" {
+		return nil
+	}
+	counter, err := l.attempts.FetchAttempts(addressKey(ip))
+	if err != nil {
+		return err
+	}
+	if wait := l.wait(counter, l.opts.IPThreshold); wait > 0 {
+		return &RetryError{Err: ErrTooManyAttempts, RetryAfter: wait}
+	}
+	return nil
+}
+
+// Release lifts the lock of a user whose lockout has expired, updating user
+// in place, and refuses users who are still locked.
+func (l *lockoutServiceImpl) Release(user *models.User) error {
+	if user.Status != models.StatusLocked {
+		return nil
+	}
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return &RetryError{Err: ErrAccountLocked, RetryAfter: time.Until(*user.LockedUntil)}
+	}
+	if err := l.users.UnlockUser(user.ID); err != nil {
+		return err
+	}
+	if err := l.attempts.ResetAttempts(userKey(user.ID)); err != nil {
+		return err
+	}
+	user.Status = models.StatusActive
+	user.LockedUntil = nil
+	return nil
+}
+
+func (l *lockoutServiceImpl) CheckUser(user *models.User) error {
+	if err := l.Release(user); err != nil {
+		return err
+	}
+	counter, err := l.attempts.FetchAttempts(userKey(user.ID))
+	if err != nil {
+		return err
+	}
+	if wait := l.wait(counter, 0); wait > 0 {
+		return &RetryError{Err: ErrTooManyAttempts, RetryAfter: wait}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed attempt against the address and, when the
+// account exists, against the user, locking it at the threshold.
+func (l *lockoutServiceImpl) RecordFailure(user *models.User, ip string) error {
+	if ip != "" {
+		if _, err := l.attempts.RecordFailure(addressKey(ip), l.opts.Window); err != nil {
+			return err
+		}
+	}
+	if user == nil {
+		return nil
+	}
+	counter, err := l.attempts.RecordFailure(userKey(user.ID), l.opts.Window)
+	if err != nil {
+		return err
+	}
+	if l.opts.Threshold <= 0 || counter.Count < l.opts.Threshold || user.Status != models.StatusActive {
+		return nil
+	}
+	until := time.Now().Add(l.opts.LockDuration)
+	if err := l.users.LockUser(user.ID, until); err != nil {
+		return err
+	}
+	user.Status = models.StatusLocked
+	user.LockedUntil = &until
+	message := fmt.Sprintf("Your account was locked after %d failed sign-in attempts and will unlock at %s. If this was not you, consider changing your password.",
+		counter.Count, until.UTC().Format(time.RFC1123))
+	if err := l.notifier.Notify(user, "Your account has been locked", message); err != nil {
+		l.logger.Warn("could not notify locked user", slog.String("user", user.ID), slog.Any("error", err))
+	}
+	return nil
+}
+
+func (l *lockoutServiceImpl) RecordSuccess(user *models.User) error {
+	return l.attempts.ResetAttempts(userKey(user.ID))
+}
+
+func (l *lockoutServiceImpl) Unlock(userID string) error {
+	return l.attempts.ResetAttempts(userKey(userID))
+}
@@ -0,0 +1,31 @@
+package services
+
+import (
+	"Users/models"
+	"log/slog"
+)
+
+// Notifier tells a user about security relevant events on their account.
+type Notifier interface {
+	Notify(user *models.User, subject string, message string) error
+}
+
+type logNotifier struct {
+	logger *slog.Logger
+}
+
+// NewLogNotifier writes notifications to the log, for deployments without
+// a mail relay; a delivering Notifier can replace it without other changes.
+func NewLogNotifier(logger *slog.Logger) Notifier {
+	return &logNotifier{logger: logger}
+}
+
+func (l *logNotifier) Notify(user *models.User, subject string, message string) error {
+	l.logger.Info("user notification",
+		slog.String("tenant", user.TenantID),
+		slog.String("user", user.ID),
+		slog.String("email", user.Email),
+		slog.String("subject", subject),
+		slog.String("message", message))
+	return nil
+}
@@ -0,0 +1,526 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// OAuthError is an error response defined by RFC 6749, such as
+// invalid_grant. Handlers send it as is, to the client or to its redirect
+// URI.
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code string, format string, args ...any) *OAuthError {
+	return &OAuthError{Code: code, Description: fmt.Sprintf(format, args...)}
+}
+
+const (
+	oauthTokenMarker = "oat_"
+	oauthSecret      = 32
+	oauthClientID    = 16
+	maxClientName    = 100
+	//PKCE verifiers are 43 to 128 characters (RFC 7636 section 4.1)
+	minVerifier = 43
+	maxVerifier = 128
+)
+
+type OAuthOptions struct {
+	//Issuer is the public base URL of this server, which identifies it in
+	//ID tokens and introspection responses
+	Issuer         string
+	CodeTTL        time.Duration
+	AccessTokenTTL time.Duration
+	IDTokenTTL     time.Duration
+}
+
+func DefaultOAuthOptions() OAuthOptions {
+	return OAuthOptions{
+		Issuer:         "http://localhost:8080",
+		CodeTTL:        time.Minute,
+		AccessTokenTTL: time.Hour,
+		IDTokenTTL:     time.Hour,
+	}
+}
+
+type oauthServiceImpl struct {
+	oauth repository.OAuthRepository
+	keys  KeyRingInterface
+	opts  OAuthOptions
+}
+
+func NewOAuthService(oauth repository.OAuthRepository, keys KeyRingInterface, opts OAuthOptions) OAuthInterface {
+	return &oauthServiceImpl{oauth: oauth, keys: keys, opts: opts}
+}
+
+// IsOAuthAccessToken tells access tokens issued to OAuth clients apart from
+// other bearer tokens, such as those of login sessions.
+func IsOAuthAccessToken(token string) bool {
+	return strings.HasPrefix(token, oauthTokenMarker)
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ParseScope splits a space separated scope parameter, dropping repeats.
+func ParseScope(scope string) []string {
+	return slices.Compact(slices.Sorted(slices.Values(strings.Fields(scope))))
+}
+
+// validScope checks the scope-token syntax of RFC 6749 section 3.3
+func validScope(scope string) bool {
+	if scope == "" {
+		return false
+	}
+	for _, c := range scope {
+		if c < 0x21 || c > 0x7e || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// validRedirectURI accepts absolute https URIs, and http ones on the
+// loopback interface for development
+func validRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Fragment != "" || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		ip := net.ParseIP(host)
+		return host == "localhost" || (ip != nil && ip.IsLoopback())
+	}
+	return false
+}
+
+// ------------------ CLIENTS ------------------
+
+func (o *oauthServiceImpl) RegisterClient(tenantID string, req models.OAuthClientRequest, createdBy string) (*models.OAuthClientCreated, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxClientName {
+		return nil, fmt.Errorf("%w: name is required and must be at most %d characters", ErrInvalidInput, maxClientName)
+	}
+	grants := req.GrantTypes
+	if len(grants) == 0 {
+		grants = []string{models.GrantAuthorizationCode}
+	}
+	for _, grant := range grants {
+		if !slices.Contains(models.OAuthGrantTypes, grant) {
+			return nil, fmt.Errorf("%w: unknown grant type %q, expected one of %s", ErrInvalidInput, grant, strings.Join(models.OAuthGrantTypes, ", "))
+		}
+	}
+	grants = slices.Compact(slices.Sorted(slices.Values(grants)))
+	if req.Public && slices.Contains(grants, models.GrantClientCredentials) {
+		return nil, fmt.Errorf("%w: public clients cannot use the client_credentials grant", ErrInvalidInput)
+	}
+	if slices.Contains(grants, models.GrantAuthorizationCode) && len(req.RedirectURIs) == 0 {
+		return nil, fmt.Errorf("%w: the authorization_code grant needs at least one redirect uri", ErrInvalidInput)
+	}
+	for _, uri := range req.RedirectURIs {
+		if !validRedirectURI(uri) {
+			return nil, fmt.Errorf("%w: redirect uri %q must be an absolute https uri without a fragment, or http on localhost", ErrInvalidInput, uri)
+		}
+	}
+	for _, scope := range req.Scopes {
+		if !validScope(scope) {
+			return nil, fmt.Errorf("%w: invalid scope %q", ErrInvalidInput, scope)
+		}
+	}
+
+	id := make([]byte, oauthClientID)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	client := models.OAuthClient{
+		ID:           hex.EncodeToString(id),
+		TenantID:     tenantID,
+		Name:         name,
+		Public:       req.Public,
+		RedirectURIs: slices.Compact(slices.Clone(req.RedirectURIs)),
+		GrantTypes:   grants,
+		Scopes:       ParseScope(strings.Join(req.Scopes, " ")),
+		CreatedBy:    createdBy,
+		CreatedAt:    time.Now(),
+	}
+	var secret string
+	if !req.Public {
+		var err error
+		if secret, err = randomToken(oauthSecret); err != nil {
+			return nil, err
+		}
+		client.SecretHash = hashTokenSecret(secret)
+	}
+	if err := o.oauth.CreateClient(&client); err != nil {
+		return nil, err
+	}
+	return &models.OAuthClientCreated{OAuthClient: client, Secret: secret}, nil
+}
+
+func (o *oauthServiceImpl) ListClients(tenantID string) ([]models.OAuthClient, error) {
+	return o.oauth.ListClients(tenantID)
+}
+
+// DeleteClient removes a client with every token and consent it holds.
+func (o *oauthServiceImpl) DeleteClient(tenantID string, id string) error {
+	if err := o.oauth.DeleteClient(tenantID, id); err != nil {
+		return err
+	}
+	if err := o.oauth.DeleteTokens(tenantID, id, ""); err != nil {
+		return err
+	}
+	return o.oauth.DeleteConsents(tenantID, id, "")
+}
+
+func (o *oauthServiceImpl) Client(id string) (*models.OAuthClient, error) {
+	client, err := o.oauth.FetchClient(id)
+	if errors.Is(err, repository.ErrOAuthClientNotFound) {
+		return nil, oauthError("invalid_client", "unknown client")
+	}
+	return client, err
+}
+
+// AuthenticateClient checks the credentials a client presented at the token,
+// introspection or revocation endpoint. Public clients present no secret.
+func (o *oauthServiceImpl) AuthenticateClient(id string, secret string) (*models.OAuthClient, error) {
+	if id == "" {
+		return nil, oauthError("invalid_client", "client authentication is required")
+	}
+	client, err := o.Client(id)
+	if err != nil {
+		return nil, err
+	}
+	if client.Public {
+		if secret != "" {
+			return nil, oauthError("invalid_client", "public clients have no secret")
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashTokenSecret(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, oauthError("invalid_client", "client authentication failed")
+	}
+	return client, nil
+}
+
+// ------------------ AUTHORIZATION ------------------
+
+// ValidateAuthorization checks a request to the authorization endpoint and
+// returns the client, the redirect URI to answer on and the scopes asked
+// for. Errors come back with an empty redirect URI until the client and
+// redirect URI are known good; after that they are to be sent to it.
+func (o *oauthServiceImpl) ValidateAuthorization(req models.AuthorizationRequest) (*models.OAuthClient, string, []string, error) {
+	client, err := o.Client(req.ClientID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	redirectURI := req.RedirectURI
+	switch {
+	case redirectURI == "" && len(client.RedirectURIs) == 1:
+		redirectURI = client.RedirectURIs[0]
+	case !slices.Contains(client.RedirectURIs, redirectURI):
+		return client, "", nil, oauthError("invalid_request", "redirect_uri is not registered for the client")
+	}
+
+	if req.ResponseType != "code" {
+		return client, redirectURI, nil, oauthError("unsupported_response_type", "only the code response type is supported")
+	}
+	if !slices.Contains(client.GrantTypes, models.GrantAuthorizationCode) {
+		return client, redirectURI, nil, oauthError("unauthorized_client", "the client may not use the authorization code grant")
+	}
+	if req.CodeChallenge == "" {
+		return client, redirectURI, nil, oauthError("invalid_request", "code_challenge is required")
+	}
+	if req.CodeChallengeMethod != "S256" {
+		return client, redirectURI, nil, oauthError("invalid_request", "code_challenge_method must be S256")
+	}
+	if challenge, err := base64.RawURLEncoding.DecodeString(req.CodeChallenge); err != nil || len(challenge) != sha256.Size {
+		return client, redirectURI, nil, oauthError("invalid_request", "code_challenge must be a base64url SHA-256 digest")
+	}
+	prompts := strings.Fields(req.Prompt)
+	for _, prompt := range prompts {
+		if !slices.Contains(promptValues, prompt) {
+			return client, redirectURI, nil, oauthError("invalid_request", "unsupported prompt %q", prompt)
+		}
+	}
+	if slices.Contains(prompts, "none") && len(prompts) > 1 {
+		return client, redirectURI, nil, oauthError("invalid_request", "prompt none cannot be combined with other values")
+	}
+	if req.MaxAge != "" {
+		if maxAge, err := strconv.Atoi(req.MaxAge); err != nil || maxAge < 0 {
+			return client, redirectURI, nil, oauthError("invalid_request", "max_age must be a non-negative number of seconds")
+		}
+	}
+	scopes, err := o.grantableScopes(client, req.Scope)
+	if err != nil {
+		return client, redirectURI, nil, err
+	}
+	return client, redirectURI, scopes, nil
+}
+
+// grantableScopes resolves a scope parameter against the client's
+// registration; no scope asks for all of them
+func (o *oauthServiceImpl) grantableScopes(client *models.OAuthClient, scope string) ([]string, error) {
+	scopes := ParseScope(scope)
+	if len(scopes) == 0 {
+		return client.Scopes, nil
+	}
+	for _, s := range scopes {
+		if !slices.Contains(client.Scopes, s) {
+			return nil, oauthError("invalid_scope", "scope %q is not registered for the client", s)
+		}
+	}
+	return scopes, nil
+}
+
+// HasConsent reports whether the user already granted the client every one
+// of scopes.
+func (o *oauthServiceImpl) HasConsent(tenantID string, userID string, clientID string, scopes []string) (bool, error) {
+	consent, err := o.oauth.FetchConsent(tenantID, userID, clientID)
+	if errors.Is(err, repository.ErrConsentNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(consent.Scopes, scope) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// GrantConsent records that the user granted the client scopes, on top of
+// what they granted before.
+func (o *oauthServiceImpl) GrantConsent(tenantID string, userID string, clientID string, scopes []string) error {
+	granted := slices.Clone(scopes)
+	previous, err := o.oauth.FetchConsent(tenantID, userID, clientID)
+	if err == nil {
+		granted = append(granted, previous.Scopes...)
+	} else if !errors.Is(err, repository.ErrConsentNotFound) {
+		return err
+	}
+	return o.oauth.SaveConsent(&models.Consent{
+		TenantID:  tenantID,
+		UserID:    userID,
+		ClientID:  clientID,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(granted))),
+		GrantedAt: time.Now(),
+	})
+}
+
+// IssueCode creates the authorization code sent to the client's redirect
+// URI once the user, who logged in at authTime, has consented.
+func (o *oauthServiceImpl) IssueCode(client *models.OAuthClient, userID string, authTime time.Time, req models.AuthorizationRequest, scopes []string) (string, error) {
+	code, err := randomToken(oauthSecret)
+	if err != nil {
+		return "", err
+	}
+	err = o.oauth.SaveCode(&models.AuthorizationCode{
+		ID:            hashTokenSecret(code),
+		TenantID:      client.TenantID,
+		ClientID:      client.ID,
+		UserID:        userID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		AuthTime:      authTime,
+		ExpiresAt:     time.Now().Add(o.opts.CodeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// ------------------ TOKENS ------------------
+
+// ExchangeCode redeems an authorization code for an access token, and
+// returns the grant the code stood for. The code is gone after the first
+// attempt, successful or not.
+func (o *oauthServiceImpl) ExchangeCode(client *models.OAuthClient, code string, redirectURI string, verifier string) (*models.TokenResponse, *models.AuthorizationCode, error) {
+	if !slices.Contains(client.GrantTypes, models.GrantAuthorizationCode) {
+		return nil, nil, oauthError("unauthorized_client", "the client may not use the authorization code grant")
+	}
+	if code == "" {
+		return nil, nil, oauthError("invalid_request", "code is required")
+	}
+	grant, err := o.oauth.TakeCode(hashTokenSecret(code))
+	if errors.Is(err, repository.ErrOAuthCodeNotFound) {
+		return nil, nil, oauthError("invalid_grant", "the code is invalid or has expired")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if grant.ClientID != client.ID {
+		return nil, nil, oauthError("invalid_grant", "the code was issued to another client")
+	}
+	//the redirect URI has to be repeated when it was given at authorization
+	if grant.RedirectURI != "" && grant.RedirectURI != redirectURI {
+		return nil, nil, oauthError("invalid_grant", "redirect_uri does not match the authorization request")
+	}
+	if len(verifier) < minVerifier || len(verifier) > maxVerifier {
+		return nil, nil, oauthError("invalid_grant", "code_verifier must be %d to %d characters", minVerifier, maxVerifier)
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(grant.CodeChallenge)) != 1 {
+		return nil, nil, oauthError("invalid_grant", "code_verifier does not match the code challenge")
+	}
+	token, err := o.issueToken(client, grant.UserID, grant.Scopes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, grant, nil
+}
+
+// ClientCredentials issues a token for the client itself.
+func (o *oauthServiceImpl) ClientCredentials(client *models.OAuthClient, scope string) (*models.TokenResponse, error) {
+	if client.Public || !slices.Contains(client.GrantTypes, models.GrantClientCredentials) {
+		return nil, oauthError("unauthorized_client", "the client may not use the client credentials grant")
+	}
+	scopes, err := o.grantableScopes(client, scope)
+	if err != nil {
+		return nil, err
+	}
+	return o.issueToken(client, "", scopes)
+}
+
+func (o *oauthServiceImpl) issueToken(client *models.OAuthClient, userID string, scopes []string) (*models.TokenResponse, error) {
+	secret, err := randomToken(oauthSecret)
+	if err != nil {
+		return nil, err
+	}
+	token := oauthTokenMarker + secret
+	now := time.Now()
+	err = o.oauth.CreateToken(&models.OAuthToken{
+		ID:        hashTokenSecret(token),
+		TenantID:  client.TenantID,
+		ClientID:  client.ID,
+		UserID:    userID,
+		Scopes:    scopes,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(o.opts.AccessTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(o.opts.AccessTokenTTL / time.Second),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// Introspect describes a token to a confidential client of the same tenant.
+// Unknown and expired tokens, and those of other tenants, are inactive.
+func (o *oauthServiceImpl) Introspect(client *models.OAuthClient, token string) (*models.Introspection, *models.OAuthToken, error) {
+	if client.Public {
+		return nil, nil, oauthError("unauthorized_client", "public clients may not introspect tokens")
+	}
+	issued, err := o.oauth.FetchToken(hashTokenSecret(token))
+	if errors.Is(err, repository.ErrOAuthTokenNotFound) {
+		return &models.Introspection{Active: false}, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if issued.TenantID != client.TenantID {
+		return &models.Introspection{Active: false}, nil, nil
+	}
+	return &models.Introspection{
+		Active:    true,
+		Scope:     strings.Join(issued.Scopes, " "),
+		ClientID:  issued.ClientID,
+		Subject:   issued.UserID,
+		TokenType: "Bearer",
+		ExpiresAt: issued.ExpiresAt.Unix(),
+		IssuedAt:  issued.IssuedAt.Unix(),
+		Issuer:    o.opts.Issuer,
+	}, issued, nil
+}
+
+// AccessToken resolves an access token presented to a resource endpoint
+// such as userinfo.
+func (o *oauthServiceImpl) AccessToken(token string) (*models.OAuthToken, error) {
+	if !IsOAuthAccessToken(token) {
+		return nil, oauthError("invalid_token", "the access token is invalid or has expired")
+	}
+	issued, err := o.oauth.FetchToken(hashTokenSecret(token))
+	if errors.Is(err, repository.ErrOAuthTokenNotFound) {
+		return nil, oauthError("invalid_token", "the access token is invalid or has expired")
+	}
+	return issued, err
+}
+
+// Revoke invalidates a token the client holds. Unknown tokens are not an
+// error (RFC 7009 section 2.2).
+func (o *oauthServiceImpl) Revoke(client *models.OAuthClient, token string) error {
+	id := hashTokenSecret(token)
+	issued, err := o.oauth.FetchToken(id)
+	if errors.Is(err, repository.ErrOAuthTokenNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if issued.ClientID != client.ID {
+		return oauthError("unauthorized_client", "the token was issued to another client")
+	}
+	return o.oauth.DeleteToken(id)
+}
+
+// ------------------ CONSENTS ------------------
+
+func (o *oauthServiceImpl) ListConsents(tenantID string, userID string) ([]models.Consent, error) {
+	return o.oauth.ListConsents(tenantID, userID)
+}
+
+// RevokeConsent withdraws the user's consent to a client along with the
+// tokens the client holds for them.
+func (o *oauthServiceImpl) RevokeConsent(tenantID string, userID string, clientID string) error {
+	if err := o.oauth.DeleteConsents(tenantID, clientID, userID); err != nil {
+		return err
+	}
+	return o.oauth.DeleteTokens(tenantID, clientID, userID)
+}
+
+func (o *oauthServiceImpl) RemoveUser(tenantID string, userID string) error {
+	if err := o.oauth.DeleteUserTokens(tenantID, userID); err != nil {
+		return err
+	}
+	return o.oauth.DeleteUserConsents(tenantID, userID)
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/utils"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	oauthRedirect = "https://app.example.com/callback"
+	//a valid PKCE verifier, 43 characters long
+	oauthVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+type oauthFixture struct {
+	service OAuthInterface
+	client  *models.OAuthClient
+	//other is a second client of the same tenant
+	other *models.OAuthClient
+}
+
+func newOAuthFixture(t *testing.T) *oauthFixture {
+	t.Helper()
+	sealer, err := utils.NewSealer(utils.DeriveKey([]byte("test secret"), "signing-keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewKeyRing(repository.NewMemorySigningKeys(), sealer, DefaultKeyRingOptions())
+	service := NewOAuthService(repository.NewMemoryOAuth(), keys, DefaultOAuthOptions())
+	register := func(name string) *models.OAuthClient {
+		created, err := service.RegisterClient(models.DefaultTenant, models.OAuthClientRequest{
+			Name:         name,
+			RedirectURIs: []string{oauthRedirect, "https://app.example.com/other"},
+			Scopes:       []string{"openid", "profile"},
+		}, "admin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &created.OAuthClient
+	}
+	return &oauthFixture{service: service, client: register("app"), other: register("other app")}
+}
+
+// authorize runs the authorization endpoint for the fixture's client with
+// the challenge of oauthVerifier and returns the code it would redirect with
+func (f *oauthFixture) authorize(t *testing.T, redirectURI string) string {
+	t.Helper()
+	sum := sha256.Sum256([]byte(oauthVerifier))
+	req := models.AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            f.client.ID,
+		RedirectURI:         redirectURI,
+		Scope:               "openid",
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: "S256",
+	}
+	client, _, scopes, err := f.service.ValidateAuthorization(req)
+	if err != nil {
+		t.Fatalf("authorization: %v", err)
+	}
+	code, err := f.service.IssueCode(client, "user-1", time.Now(), req, scopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// wantOAuthError checks err is the RFC 6749 error code, for the reason
+// given by a fragment of its description
+func wantOAuthError(t *testing.T, err error, code string, reason string) {
+	t.Helper()
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) {
+		t.Fatalf("got %v, want %s", err, code)
+	}
+	if oauthErr.Code != code || !strings.Contains(oauthErr.Description, reason) {
+		t.Fatalf("got %v, want %s: ...%s...", oauthErr, code, reason)
+	}
+}
+
+func TestExchangeCode(t *testing.T) {
+	f := newOAuthFixture(t)
+	code := f.authorize(t, oauthRedirect)
+	token, grant, err := f.service.ExchangeCode(f.client, code, oauthRedirect, oauthVerifier)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if grant.UserID != "user-1" || token.Scope != "openid" {
+		t.Fatalf("got a token for %s with scope %q", grant.UserID, token.Scope)
+	}
+	if _, err := f.service.AccessToken(token.AccessToken); err != nil {
+		t.Fatalf("issued token does not resolve: %v", err)
+	}
+}
+
+func TestExchangeCodeRejectsWrongVerifier(t *testing.T) {
+	f := newOAuthFixture(t)
+	code := f.authorize(t, oauthRedirect)
+	wrong := strings.Repeat("a", len(oauthVerifier))
+	_, _, err := f.service.ExchangeCode(f.client, code, oauthRedirect, wrong)
+	wantOAuthError(t, err, "invalid_grant", "does not match the code challenge")
+
+	//the failed attempt used the code up
+	_, _, err = f.service.ExchangeCode(f.client, code, oauthRedirect, oauthVerifier)
+	wantOAuthError(t, err, "invalid_grant", "invalid or has expired")
+}
+
+func TestExchangeCodeRejectsReusedCode(t *testing.T) {
+	f := newOAuthFixture(t)
+	code := f.authorize(t, oauthRedirect)
+	if _, _, err := f.service.ExchangeCode(f.client, code, oauthRedirect, oauthVerifier); err != nil {
+		t.Fatalf("first exchange: %v", err)
+	}
+	_, _, err := f.service.ExchangeCode(f.client, code, oauthRedirect, oauthVerifier)
+	wantOAuthError(t, err, "invalid_grant", "invalid or has expired")
+}
+
+func TestExchangeCodeRejectsMismatchedRedirectURI(t *testing.T) {
+	f := newOAuthFixture(t)
+	code := f.authorize(t, oauthRedirect)
+	_, _, err := f.service.ExchangeCode(f.client, code, "https://app.example.com/other", oauthVerifier)
+	wantOAuthError(t, err, "invalid_grant", "redirect_uri does not match")
+
+	//leaving it out is no better once it was given at authorization
+	code = f.authorize(t, oauthRedirect)
+	_, _, err = f.service.ExchangeCode(f.client, code, "", oauthVerifier)
+	wantOAuthError(t, err, "invalid_grant", "redirect_uri does not match")
+}
+
+func TestExchangeCodeRejectsOtherClientsCode(t *testing.T) {
+	f := newOAuthFixture(t)
+	code := f.authorize(t, oauthRedirect)
+	_, _, err := f.service.ExchangeCode(f.other, code, oauthRedirect, oauthVerifier)
+	wantOAuthError(t, err, "invalid_grant", "issued to another client")
+
+	//nor can the client it was issued to redeem it afterwards
+	_, _, err = f.service.ExchangeCode(f.client, code, oauthRedirect, oauthVerifier)
+	wantOAuthError(t, err, "invalid_grant", "invalid or has expired")
+}
+
+func TestValidateAuthorizationRejectsUnregisteredRedirectURI(t *testing.T) {
+	f := newOAuthFixture(t)
+	sum := sha256.Sum256([]byte(oauthVerifier))
+	_, redirectURI, _, err := f.service.ValidateAuthorization(models.AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            f.client.ID,
+		RedirectURI:         "https://attacker.example.com/callback",
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: "S256",
+	})
+	wantOAuthError(t, err, "invalid_request", "redirect_uri is not registered")
+	//the error must not be sent to the unregistered URI
+	if redirectURI != "" {
+		t.Fatalf("got redirect uri %q, want none", redirectURI)
+	}
+}
+
+func TestValidateAuthorizationRejectsOtherClientsRedirectURI(t *testing.T) {
+	f := newOAuthFixture(t)
+	other, err := f.service.RegisterClient(models.DefaultTenant, models.OAuthClientRequest{
+		Name:         "elsewhere",
+		RedirectURIs: []string{"https://elsewhere.example.com/callback"},
+	}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(oauthVerifier))
+	_, redirectURI, _, err := f.service.ValidateAuthorization(models.AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            f.client.ID,
+		RedirectURI:         other.RedirectURIs[0],
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: "S256",
+	})
+	wantOAuthError(t, err, "invalid_request", "redirect_uri is not registered")
+	if redirectURI != "" {
+		t.Fatalf("got redirect uri %q, want none", redirectURI)
+	}
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"Users/jose"
+	"Users/models"
+	"crypto/sha256"
+	"encoding/base64"
+	"slices"
+	"strings"
+	"time"
+)
+
+// prompt values of OpenID Connect Core section 3.1.2.1; select_account is
+// accepted and ignored since a browser holds one session at a time
+var promptValues = []string{"none", "login", "consent", "select_account"}
+
+// UserInfo returns the claims about user that scopes release. The profile
+// scope maps the username and profile, email and phone their fields; this
+// service does not verify either, so both are reported unverified.
+func (o *oauthServiceImpl) UserInfo(user *models.User, scopes []string) *models.UserInfo {
+	info := &models.UserInfo{Subject: user.ID}
+	unverified := false
+	if slices.Contains(scopes, models.ScopeProfile) {
+		info.PreferredUsername = user.Username
+		if p := user.Profile; p != nil {
+			info.Name = p.DisplayName
+			info.Locale = p.Locale
+			info.Zoneinfo = p.Timezone
+			info.Picture = p.AvatarURL
+			//avatar URLs are relative to this server
+			if strings.HasPrefix(info.Picture, "/") {
+				info.Picture = o.opts.Issuer + info.Picture
+			}
+		}
+	}
+	if slices.Contains(scopes, models.ScopeEmail) && user.Email != "" {
+		info.Email = user.Email
+		info.EmailVerified = &unverified
+	}
+	if slices.Contains(scopes, models.ScopePhone) && user.Profile != nil && user.Profile.Phone != "" {
+		info.PhoneNumber = user.Profile.Phone
+		info.PhoneNumberVerified = &unverified
+	}
+	return info
+}
+
+// IDToken signs the ID token of an authorization code grant for user,
+// bound to the access token issued alongside it.
+func (o *oauthServiceImpl) IDToken(client *models.OAuthClient, user *models.User, grant *models.AuthorizationCode, accessToken string) (string, error) {
+	key, err := o.keys.ActiveKey()
+	if err != nil {
+		return "", err
+	}
+	//at_hash is the left half of the access token's SHA-256 digest
+	sum := sha256.Sum256([]byte(accessToken))
+	now := time.Now()
+	claims := models.IDTokenClaims{
+		Issuer:          o.opts.Issuer,
+		Audience:        client.ID,
+		ExpiresAt:       now.Add(o.opts.IDTokenTTL).Unix(),
+		IssuedAt:        now.Unix(),
+		Nonce:           grant.Nonce,
+		AuthorizedParty: client.ID,
+		AccessTokenHash: base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]),
+		UserInfo:        *o.UserInfo(user, grant.Scopes),
+	}
+	if !grant.AuthTime.IsZero() {
+		claims.AuthTime = grant.AuthTime.Unix()
+	}
+	return jose.Sign(key, claims)
+}
+
+func (o *oauthServiceImpl) JWKS() (*jose.JWKSet, error) {
+	return o.keys.PublicKeys()
+}
+
+// Discovery describes this provider for OpenID Connect clients.
+func (o *oauthServiceImpl) Discovery() *models.OpenIDConfiguration {
+	base := o.opts.Issuer
+	return &models.OpenIDConfiguration{
+		Issuer:                            base,
+		AuthorizationEndpoint:             base + "/oauth/authorize",
+		TokenEndpoint:                     base + "/oauth/token",
+		UserinfoEndpoint:                  base + "/oauth/userinfo",
+		JWKSURI:                           base + "/oauth/jwks",
+		IntrospectionEndpoint:             base + "/oauth/introspect",
+		RevocationEndpoint:                base + "/oauth/revoke",
+		ScopesSupported:                   models.OIDCScopes,
+		ResponseTypesSupported:            []string{"code"},
+		ResponseModesSupported:            []string{"query"},
+		GrantTypesSupported:               models.OAuthGrantTypes,
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  jose.Algorithms,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "azp", "at_hash",
+			"name", "preferred_username", "picture", "locale", "zoneinfo",
+			"email", "email_verified", "phone_number", "phone_number_verified",
+		},
+		PromptValuesSupported: promptValues,
+	}
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/utils"
+	"Users/webauthn"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrPasskeyRejected wraps ceremony verification failures.
+var ErrPasskeyRejected = errors.New("passkey rejected")
+
+const maxPasskeyName = 64
+
+type passkeyServiceImpl struct {
+	passkeys repository.PasskeyRepository
+	users    repository.UserRepository
+	lockout  LockoutInterface
+	rp       *webauthn.RelyingParty
+	canon    *utils.Canonicalizer
+}
+
+func NewPasskeyService(passkeys repository.PasskeyRepository, users repository.UserRepository, lockout LockoutInterface, rp *webauthn.RelyingParty, canon *utils.Canonicalizer) PasskeyInterface {
+	return &passkeyServiceImpl{passkeys: passkeys, users: users, lockout: lockout, rp: rp, canon: canon}
+}
+
+// startCeremony stores a fresh challenge for purpose and returns it with
+// the session ID the client passes back
+func (p *passkeyServiceImpl) startCeremony(purpose string, userID string) (*models.WebAuthnCeremony, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	ceremony := &models.WebAuthnCeremony{
+		ID:        base64.RawURLEncoding.EncodeToString(id),
+		Purpose:   purpose,
+		UserID:    userID,
+		Challenge: challenge,
+		ExpiresAt: time.Now().Add(p.rp.Timeout()),
+	}
+	if err := p.passkeys.SaveCeremony(ceremony); err != nil {
+		return nil, err
+	}
+	return ceremony, nil
+}
+
+// takeCeremony consumes a ceremony, which must be for purpose
+func (p *passkeyServiceImpl) takeCeremony(id string, purpose string) (*models.WebAuthnCeremony, error) {
+	ceremony, err := p.passkeys.TakeCeremony(id)
+	if err != nil {
+		return nil, err
+	}
+	if ceremony.Purpose != purpose {
+		return nil, repository.ErrCeremonyNotFound
+	}
+	return ceremony, nil
+}
+
+func (p *passkeyServiceImpl) BeginRegistration(userID string) (*models.PasskeyCreationOptions, error) {
+	user, err := p.users.FetchUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := p.passkeys.ListPasskeys(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	exclude := make([]webauthn.CredentialDescriptor, 0, len(existing))
+	for _, passkey := range existing {
+		exclude = append(exclude, descriptor(passkey))
+	}
+	ceremony, err := p.startCeremony(models.CeremonyRegistration, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	name := user.Username
+	if name == "" {
+		name = user.Email
+	}
+	displayName := name
+	if user.Profile != nil && user.Profile.DisplayName != "" {
+		displayName = user.Profile.DisplayName
+	}
+	//the user handle is the opaque user ID, never the email
+	entity := webauthn.UserEntity{ID: []byte(user.ID), Name: name, DisplayName: displayName}
+	return &models.PasskeyCreationOptions{
+		Session:   ceremony.ID,
+		PublicKey: p.rp.CreationOptions(ceremony.Challenge, entity, exclude),
+	}, nil
+}
+
+func (p *passkeyServiceImpl) FinishRegistration(userID string, reg models.PasskeyRegistration) (*models.Passkey, error) {
+	ceremony, err := p.takeCeremony(reg.Session, models.CeremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	//a session started for one user cannot register a key for another
+	if ceremony.UserID != userID {
+		return nil, repository.ErrCeremonyNotFound
+	}
+	name := p.canon.Display(reg.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if utf8.RuneCountInString(name) > maxPasskeyName {
+		return nil, fmt.Errorf("%w: passkey name must be at most %d characters", ErrInvalidInput, maxPasskeyName)
+	}
+	cred, err := p.rp.VerifyRegistration(ceremony.Challenge, &reg.Credential)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPasskeyRejected, err)
+	}
+	passkey := &models.Passkey{
+		UserID:         userID,
+		CredentialID:   webauthn.URLEncoded(cred.ID).String(),
+		Name:           name,
+		PublicKey:      cred.PublicKey,
+		Algorithm:      cred.Algorithm,
+		SignCount:      cred.SignCount,
+		AAGUID:         hex.EncodeToString(cred.AAGUID),
+		Transports:     cred.Transports,
+		BackupEligible: cred.BackupEligible,
+		BackupState:    cred.BackupState,
+		CreatedAt:      time.Now(),
+	}
+	if err := p.passkeys.CreatePasskey(passkey); err != nil {
+		return nil, err
+	}
+	return passkey, nil
+}
+
+func (p *passkeyServiceImpl) ListPasskeys(userID string) ([]models.Passkey, error) {
+	if _, err := p.users.FetchUserByID(userID); err != nil {
+		return nil, err
+	}
+	return p.passkeys.ListPasskeys(userID)
+}
+
+func (p *passkeyServiceImpl) DeletePasskey(userID string, credentialID string) error {
+	return p.passkeys.DeletePasskey(userID, credentialID)
+}
+
+func (p *passkeyServiceImpl) RemoveUser(userID string) error {
+	return p.passkeys.DeleteUserPasskeys(userID)
+}
+
+// BeginLogin starts a passkey login. Naming an account narrows the allowed
+// credentials to its passkeys; unknown accounts get the same empty list as
+// an anonymous start, so the response does not reveal who is registered.
+func (p *passkeyServiceImpl) BeginLogin(start models.PasskeyLoginStart) (*models.PasskeyRequestOptions, error) {
+	var allow []webauthn.CredentialDescriptor
+	identifier := start.Email
+	if identifier == "" {
+		identifier = start.Username
+	}
+	if identifier != "" {
+		var user *models.User
+		var err error
+		if strings.Contains(identifier, "@") {
+			user, err = p.users.FetchUserByEmail(p.canon.Email(identifier))
+		} else {
+			user, err = p.users.FetchUserByUsername(p.canon.Username(identifier))
+		}
+		if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+			return nil, err
+		}
+		if user != nil {
+			passkeys, err := p.passkeys.ListPasskeys(user.ID)
+			if err != nil {
+				return nil, err
+			}
+			for _, passkey := range passkeys {
+				allow = append(allow, descriptor(passkey))
+			}
+		}
+	}
+	ceremony, err := p.startCeremony(models.CeremonyLogin, "")
+	if err != nil {
+		return nil, err
+	}
+	return &models.PasskeyRequestOptions{
+		Session:   ceremony.ID,
+		PublicKey: p.rp.RequestOptions(ceremony.Challenge, allow),
+	}, nil
+}
+
+// FinishLogin verifies the assertion and returns the user it logs in. The
+// passkey is user verified, so it stands in for both password and second
+// factor.
+func (p *passkeyServiceImpl) FinishLogin(login models.PasskeyLogin) (*models.User, error) {
+	ceremony, err := p.takeCeremony(login.Session, models.CeremonyLogin)
+	if err != nil {
+		return nil, err
+	}
+	passkey, err := p.passkeys.FetchPasskey(webauthn.URLEncoded(login.Credential.RawID).String())
+	if errors.Is(err, repository.ErrPasskeyNotFound) {
+		return nil, fmt.Errorf("%w: unknown credential", ErrPasskeyRejected)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if handle := login.Credential.Response.UserHandle; len(handle) > 0 && string(handle) != passkey.UserID {
+		return nil, fmt.Errorf("%w: user handle mismatch", ErrPasskeyRejected)
+	}
+	cred := &webauthn.Credential{
+		ID:        login.Credential.RawID,
+		PublicKey: passkey.PublicKey,
+		Algorithm: passkey.Algorithm,
+		SignCount: passkey.SignCount,
+	}
+	assertion, err := p.rp.VerifyAssertion(ceremony.Challenge, &login.Credential, cred)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPasskeyRejected, err)
+	}
+	user, err := p.users.FetchUserByID(passkey.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, fmt.Errorf("%w: unknown credential", ErrPasskeyRejected)
+	}
+	if err != nil {
+		return nil, err
+	}
+	//a passkey cannot be guessed, so only a lock stops it, not the backoff
+	if err := p.lockout.Release(user); err != nil {
+		return nil, err
+	}
+	if user.Status != models.StatusActive {
+		return nil, ErrAccountInactive
+	}
+	err = p.passkeys.RecordPasskeyUse(passkey.CredentialID, passkey.SignCount, assertion.SignCount, assertion.BackupState)
+	if errors.Is(err, repository.ErrStaleSignCount) {
+		return nil, fmt.Errorf("%w: %w", ErrPasskeyRejected, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func descriptor(passkey models.Passkey) webauthn.CredentialDescriptor {
+	id, _ := base64.RawURLEncoding.DecodeString(passkey.CredentialID)
+	return webauthn.CredentialDescriptor{Type: "public-key", ID: id, Transports: passkey.Transports}
+}
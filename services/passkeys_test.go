@@ -0,0 +1,122 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/utils"
+	"Users/webauthn"
+	"Users/webauthn/webauthntest"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+const (
+	passkeyRPID   = "example.com"
+	passkeyOrigin = "https://example.com"
+)
+
+type passkeyFixture struct {
+	service  PasskeyInterface
+	passkeys repository.PasskeyRepository
+	user     *models.User
+	auth     *webauthntest.Authenticator
+}
+
+// newPasskeyFixture registers an ES256 passkey for a new user. wrap, when
+// set, decorates the passkey repository the service uses.
+func newPasskeyFixture(t *testing.T, wrap func(repository.PasskeyRepository) repository.PasskeyRepository) *passkeyFixture {
+	t.Helper()
+	users := repository.NewMemory(models.DefaultTenant)
+	user := &models.User{Username: "alice", UsernameCanonical: "alice", Email: "alice@example.com", EmailCanonical: "alice@example.com"}
+	if err := users.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	passkeys := repository.NewMemoryPasskeys(models.DefaultTenant)
+	if wrap != nil {
+		passkeys = wrap(passkeys)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	lockout := NewLockoutService(repository.NewMemoryAttempts(), users, NewLogNotifier(logger), DefaultLockoutOptions(), logger)
+	rp := webauthn.New(webauthn.Config{RPID: passkeyRPID, RPName: "Example", Origins: []string{passkeyOrigin}})
+	service := NewPasskeyService(passkeys, users, lockout, rp, utils.NewCanonicalizer(utils.DefaultEmailPolicy()))
+
+	auth, err := webauthntest.New(webauthn.AlgES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.UserHandle = []byte(user.ID)
+	options, err := service.BeginRegistration(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := auth.Create(webauthntest.Ceremony{RPID: passkeyRPID, Origin: passkeyOrigin, Challenge: options.PublicKey.Challenge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.FinishRegistration(user.ID, models.PasskeyRegistration{Session: options.Session, Credential: *resp}); err != nil {
+		t.Fatalf("registration: %v", err)
+	}
+	return &passkeyFixture{service: service, passkeys: passkeys, user: user, auth: auth}
+}
+
+// login runs a passkey login with the fixture's authenticator
+func (f *passkeyFixture) login(t *testing.T) (*models.User, error) {
+	t.Helper()
+	options, err := f.service.BeginLogin(models.PasskeyLoginStart{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := f.auth.Get(webauthntest.Ceremony{RPID: passkeyRPID, Origin: passkeyOrigin, Challenge: options.PublicKey.Challenge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.service.FinishLogin(models.PasskeyLogin{Session: options.Session, Credential: *resp})
+}
+
+func TestPasskeyFinishLogin(t *testing.T) {
+	f := newPasskeyFixture(t, nil)
+	user, err := f.login(t)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if user.ID != f.user.ID {
+		t.Fatalf("logged in %s, want %s", user.ID, f.user.ID)
+	}
+}
+
+func TestPasskeyFinishLoginUserHandleMismatch(t *testing.T) {
+	f := newPasskeyFixture(t, nil)
+	f.auth.UserHandle = []byte("someone-else")
+	if _, err := f.login(t); !errors.Is(err, ErrPasskeyRejected) {
+		t.Fatalf("err = %v, want ErrPasskeyRejected", err)
+	}
+}
+
+// racingPasskeys lets another login record its use right after FinishLogin
+// reads the passkey, as a concurrent login with the same counter would
+type racingPasskeys struct {
+	repository.PasskeyRepository
+}
+
+func (r racingPasskeys) FetchPasskey(credentialID string) (*models.Passkey, error) {
+	passkey, err := r.PasskeyRepository.FetchPasskey(credentialID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.RecordPasskeyUse(credentialID, passkey.SignCount, passkey.SignCount+1, false); err != nil {
+		return nil, err
+	}
+	return passkey, nil
+}
+
+func TestPasskeyFinishLoginStaleSignCount(t *testing.T) {
+	f := newPasskeyFixture(t, func(passkeys repository.PasskeyRepository) repository.PasskeyRepository {
+		return racingPasskeys{passkeys}
+	})
+	_, err := f.login(t)
+	if !errors.Is(err, ErrPasskeyRejected) || !errors.Is(err, repository.ErrStaleSignCount) {
+		t.Fatalf("err = %v, want ErrPasskeyRejected with ErrStaleSignCount", err)
+	}
+}
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+type searchServiceImpl struct {
+	users repository.UserRepository
+}
+
+func NewSearchService(users repository.UserRepository) SearchInterface {
+	return &searchServiceImpl{users: users}
+}
+
+func (s *searchServiceImpl) SearchUsers(query string, limit int) ([]models.SearchHit, error) {
+	query = strings.TrimSpace(query)
+	if utf8.RuneCountInString(query) < 2 {
+		return nil, fmt.Errorf("%w: search query must be at least 2 characters", ErrInvalidInput)
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	limit = min(limit, maxSearchLimit)
+
+	hits, err := s.users.SearchUsers(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	//search results never carry password hashes
+	for i := range hits {
+		hits[i].User.Password = ""
+	}
+	return hits, nil
+}
@@ -1,12 +1,202 @@
 package services
 
 import (
+	"Users/jose"
 	"Users/models"
+	"Users/repository"
+	"errors"
+	"time"
 )
 
+// ErrInvalidInput wraps validation failures so handlers can report them as
+// client errors.
+var ErrInvalidInput = errors.New("invalid input")
+
+// ErrUnsupportedPatch is returned for patch media types other than merge
+// patch and JSON Patch.
+var ErrUnsupportedPatch = errors.New("unsupported patch format")
+
 type UpdateInterface interface {
 	UpdateUser(user *models.User) error
+	PatchUser(id string, mediaType string, body []byte) (*models.User, error)
 }
 type CreateInterface interface {
 	CreateUser(user *models.User) error
+	//CreateUserWithRole stores the user with role in the same write, so no
+	//account is left behind without it
+	CreateUserWithRole(user *models.User, role string) error
+}
+type AuthInterface interface {
+	//Authenticate checks a password login from the client address ip
+	Authenticate(identifier string, password string, ip string) (*models.User, error)
+}
+type ImportInterface interface {
+	ImportUsers(rows []ImportRow, opts ImportOptions) (*models.ImportReport, error)
+}
+type AccountInterface interface {
+	//DeleteUser signs the user out, deletes the account and removes what
+	//belonged to it
+	DeleteUser(userID string) error
+	//SetStatus stores the status and runs StatusChanged
+	SetStatus(userID string, status string) error
+	//SignOut revokes the sessions and OAuth grants of a user about to be
+	//deleted
+	SignOut(userID string) error
+	//StatusChanged applies what a new status implies, once it is stored
+	StatusChanged(userID string, status string) error
+	//RemoveUser drops the identities, passkeys, memberships and avatar of a
+	//deleted user
+	RemoveUser(userID string)
+}
+type BulkInterface interface {
+	Run(req models.BulkRequest) (*models.BulkResult, error)
+	Start(req models.BulkRequest) (*models.BulkJob, error)
+	Job(id string) (*models.BulkJob, error)
+}
+type SearchInterface interface {
+	SearchUsers(query string, limit int) ([]models.SearchHit, error)
+}
+type AttributeInterface interface {
+	Schema() (*models.AttributeSchema, error)
+	SetSchema(schema []byte) (*models.AttributeSchema, error)
+	ValidateAttributes(attrs models.Attributes) error
+}
+type AvatarInterface interface {
+	SetAvatar(userID string, data []byte) (string, error)
+	Avatar(userID string, size int) (*repository.Blob, string, error)
+	//RemoveUser drops the thumbnails of a deleted user
+	RemoveUser(userID string) error
+}
+type TenantInterface interface {
+	CreateTenant(tenant *models.Tenant) error
+	Tenant(id string) (*models.Tenant, error)
+	ListTenants() ([]models.Tenant, error)
+}
+type GroupInterface interface {
+	CreateGroup(group *models.Group) error
+	Group(id string) (*models.Group, error)
+	ListGroups() ([]models.Group, error)
+	RenameGroup(id string, name string) (*models.Group, error)
+	DeleteGroup(id string) error
+	AddMember(groupID string, userID string) error
+	RemoveMember(groupID string, userID string) error
+	AddSubgroup(groupID string, childID string) error
+	RemoveSubgroup(groupID string, childID string) error
+	//RemoveUser drops a deleted user from every group
+	RemoveUser(userID string) error
+	UserGroups(userID string) ([]models.Group, error)
+	InAnyGroup(userID string, names []string) (bool, error)
+}
+type InvitationInterface interface {
+	Invite(inv *models.Invitation) (*models.InvitationLink, error)
+	Accept(token string, user *models.User) (*models.User, error)
+}
+type TwoFactorInterface interface {
+	Status(userID string) (*models.TwoFactorStatus, error)
+	Enroll(userID string) (*models.TOTPEnrollment, error)
+	Confirm(userID string, code string) ([]string, error)
+	RegenerateRecoveryCodes(userID string, code string) ([]string, error)
+	Reset(userID string) error
+	//Required reports whether logging in as user needs a second factor
+	Required(user *models.User) bool
+	Challenge(user *models.User) (string, error)
+	CompleteLogin(req models.SecondFactorRequest, ip string) (*models.User, error)
+}
+type PasskeyInterface interface {
+	BeginRegistration(userID string) (*models.PasskeyCreationOptions, error)
+	FinishRegistration(userID string, reg models.PasskeyRegistration) (*models.Passkey, error)
+	ListPasskeys(userID string) ([]models.Passkey, error)
+	DeletePasskey(userID string, credentialID string) error
+	//RemoveUser drops the passkeys of a deleted user
+	RemoveUser(userID string) error
+	BeginLogin(start models.PasskeyLoginStart) (*models.PasskeyRequestOptions, error)
+	FinishLogin(login models.PasskeyLogin) (*models.User, error)
+}
+
+// FederationInterface talks to the upstream OpenID Connect providers.
+type FederationInterface interface {
+	Providers(tenantID string) []models.IdentityProviderInfo
+	AuthorizationURL(tenantID string, providerID string, state string, nonce string, challenge string) (string, error)
+	//Exchange redeems a code and returns the identity its ID token vouches for
+	Exchange(tenantID string, providerID string, code string, verifier string, nonce string) (*models.ExternalIdentity, error)
+	AllowsSignup(tenantID string, providerID string) bool
+}
+type IdentityInterface interface {
+	Providers() []models.IdentityProviderInfo
+	//Begin starts a sign in, or a link to userID when it is set
+	Begin(providerID string, userID string) (*models.FederatedLoginStart, error)
+	FinishLogin(callback models.FederatedCallback) (*models.User, error)
+	FinishLink(userID string, callback models.FederatedCallback) (*models.Identity, error)
+	ListIdentities(userID string) ([]models.Identity, error)
+	Unlink(userID string, providerID string) error
+	//RemoveUser drops the identities of a deleted user
+	RemoveUser(userID string) error
+}
+type LockoutInterface interface {
+	//CheckAddress refuses addresses with too many recent failures
+	CheckAddress(ip string) error
+	//Release lifts an expired lock and refuses users still locked
+	Release(user *models.User) error
+	//CheckUser releases an expired lock and refuses locked users and users
+	//still waiting out the backoff of their last failure
+	CheckUser(user *models.User) error
+	//RecordFailure counts a failed login; user is nil for unknown accounts
+	RecordFailure(user *models.User, ip string) error
+	RecordSuccess(user *models.User) error
+	//Unlock clears the failures of a user reactivated by an admin
+	Unlock(userID string) error
+}
+type APIKeyInterface interface {
+	//CreateAPIKey returns the new key with its secret, which is not stored
+	CreateAPIKey(tenantID string, req models.APIKeyRequest, createdBy string) (*models.APIKeyCreated, error)
+	ListAPIKeys(tenantID string) ([]models.APIKey, error)
+	RevokeAPIKey(tenantID string, id string) error
+	AuthenticateAPIKey(token string) (*models.APIKey, error)
+}
+type SessionInterface interface {
+	//CreateSession returns the new session and its bearer token, whose
+	//secret is not stored
+	CreateSession(tenantID string, user *models.User, userAgent string, ip string) (*models.Session, string, error)
+	AuthenticateSession(token string) (*models.Session, error)
+	ListSessions(tenantID string, userID string) ([]models.Session, error)
+	RevokeSession(tenantID string, userID string, id string) error
+	RevokeUserSessions(tenantID string, userID string) error
+}
+type OAuthInterface interface {
+	//RegisterClient returns the new client with its secret, which is not
+	//stored
+	RegisterClient(tenantID string, req models.OAuthClientRequest, createdBy string) (*models.OAuthClientCreated, error)
+	ListClients(tenantID string) ([]models.OAuthClient, error)
+	DeleteClient(tenantID string, id string) error
+	Client(id string) (*models.OAuthClient, error)
+	AuthenticateClient(id string, secret string) (*models.OAuthClient, error)
+	ValidateAuthorization(req models.AuthorizationRequest) (*models.OAuthClient, string, []string, error)
+	HasConsent(tenantID string, userID string, clientID string, scopes []string) (bool, error)
+	GrantConsent(tenantID string, userID string, clientID string, scopes []string) error
+	IssueCode(client *models.OAuthClient, userID string, authTime time.Time, req models.AuthorizationRequest, scopes []string) (string, error)
+	ExchangeCode(client *models.OAuthClient, code string, redirectURI string, verifier string) (*models.TokenResponse, *models.AuthorizationCode, error)
+	ClientCredentials(client *models.OAuthClient, scope string) (*models.TokenResponse, error)
+	//Introspect also returns the token when it is active
+	Introspect(client *models.OAuthClient, token string) (*models.Introspection, *models.OAuthToken, error)
+	Revoke(client *models.OAuthClient, token string) error
+	AccessToken(token string) (*models.OAuthToken, error)
+	UserInfo(user *models.User, scopes []string) *models.UserInfo
+	IDToken(client *models.OAuthClient, user *models.User, grant *models.AuthorizationCode, accessToken string) (string, error)
+	JWKS() (*jose.JWKSet, error)
+	Discovery() *models.OpenIDConfiguration
+	ListConsents(tenantID string, userID string) ([]models.Consent, error)
+	RevokeConsent(tenantID string, userID string, clientID string) error
+	//RemoveUser drops the tokens and consents of a deleted user
+	RemoveUser(tenantID string, userID string) error
+}
+
+// KeyRingInterface holds the keys ID tokens are signed with.
+type KeyRingInterface interface {
+	//ActiveKey returns the key new tokens are signed with
+	ActiveKey() (*jose.Key, error)
+	//PublicKeys returns every key tokens may still be verified with
+	PublicKeys() (*jose.JWKSet, error)
+	ListKeys() ([]models.SigningKey, error)
+	Rotate(algorithm string, activateIn time.Duration) (*models.SigningKey, error)
+	Revoke(kid string) error
 }
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/utils"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSession = errors.New("invalid session")
+
+const (
+	//tokens read us_<id>_<secret>; the ID is hex, so the first underscore
+	//after it ends it even though the secret is base64url
+	sessionMarker = "us_"
+	sessionID     = 16
+	sessionSecret = 32
+	maxUserAgent  = 512
+	//last use is recorded at most this often per session
+	sessionTouchEvery = time.Minute
+)
+
+// SessionOptions bounds how long a session lasts: TTL from login, and
+// IdleTimeout from its last use.
+type SessionOptions struct {
+	TTL         time.Duration
+	IdleTimeout time.Duration
+}
+
+func DefaultSessionOptions() SessionOptions {
+	return SessionOptions{
+		TTL:         7 * 24 * time.Hour,
+		IdleTimeout: 24 * time.Hour,
+	}
+}
+
+type sessionServiceImpl struct {
+	sessions repository.SessionRepository
+	opts     SessionOptions
+	logger   *slog.Logger
+}
+
+func NewSessionService(sessions repository.SessionRepository, opts SessionOptions, logger *slog.Logger) SessionInterface {
+	return &sessionServiceImpl{sessions: sessions, opts: opts, logger: logger}
+}
+
+func (s *sessionServiceImpl) CreateSession(tenantID string, user *models.User, userAgent string, ip string) (*models.Session, string, error) {
+	id := make([]byte, sessionID)
+	secret := make([]byte, sessionSecret)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	if len(userAgent) > maxUserAgent {
+		userAgent = userAgent[:maxUserAgent]
+	}
+	browser, os := utils.ParseUserAgent(userAgent)
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+	now := time.Now()
+	session := models.Session{
+		ID:         hex.EncodeToString(id),
+		TenantID:   tenantID,
+		UserID:     user.ID,
+		SecretHash: hashTokenSecret(encodedSecret),
+		UserAgent:  userAgent,
+		Browser:    browser,
+		OS:         os,
+		IP:         ip,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(s.opts.TTL),
+	}
+	if err := s.sessions.CreateSession(&session); err != nil {
+		return nil, "", err
+	}
+	return &session, sessionMarker + session.ID + "_" + encodedSecret, nil
+}
+
+// AuthenticateSession resolves a bearer token. Unknown, revoked, expired and
+// idle sessions all fail with ErrInvalidSession.
+func (s *sessionServiceImpl) AuthenticateSession(token string) (*models.Session, error) {
+	rest, ok := strings.CutPrefix(token, sessionMarker)
+	if !ok {
+		return nil, ErrInvalidSession
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(id) != 2*sessionID || secret == "" {
+		return nil, ErrInvalidSession
+	}
+	session, err := s.sessions.FetchSession(id)
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashTokenSecret(secret)), []byte(session.SecretHash)) != 1 {
+		return nil, ErrInvalidSession
+	}
+	now := time.Now()
+	if s.idle(session, now) {
+		if err := s.sessions.DeleteSession(session.TenantID, session.UserID, session.ID); err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+			s.logger.Warn("could not remove idle session", slog.String("tenant", session.TenantID), slog.String("user", session.UserID), slog.Any("error", err))
+		}
+		return nil, ErrInvalidSession
+	}
+	if now.Sub(session.LastSeenAt) >= sessionTouchEvery {
+		if err := s.sessions.TouchSession(session.ID, now); err != nil {
+			s.logger.Warn("could not record session use", slog.String("tenant", session.TenantID), slog.String("user", session.UserID), slog.Any("error", err))
+		} else {
+			session.LastSeenAt = now
+		}
+	}
+	return session, nil
+}
+
+func (s *sessionServiceImpl) ListSessions(tenantID string, userID string) ([]models.Session, error) {
+	sessions, err := s.sessions.ListSessions(tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	live := sessions[:0]
+	for _, session := range sessions {
+		if !s.idle(&session, now) {
+			live = append(live, session)
+		}
+	}
+	return live, nil
+}
+
+func (s *sessionServiceImpl) RevokeSession(tenantID string, userID string, id string) error {
+	return s.sessions.DeleteSession(tenantID, userID, id)
+}
+
+func (s *sessionServiceImpl) RevokeUserSessions(tenantID string, userID string) error {
+	_, err := s.sessions.DeleteUserSessions(tenantID, userID)
+	return err
+}
+
+func (s *sessionServiceImpl) idle(session *models.Session, now time.Time) bool {
+	return s.opts.IdleTimeout > 0 && now.Sub(session.LastSeenAt) >= s.opts.IdleTimeout
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/validation"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tenantCacheTTL is how long a tenant lookup is reused; suspending a tenant
+// takes effect within this delay
+const tenantCacheTTL = 30 * time.Second
+
+type tenantServiceImpl struct {
+	tenants repository.TenantRepository
+	//provision prepares storage for a new tenant before it is registered
+	provision func(tenantID string) error
+	mu        sync.Mutex
+	cache     map[string]cachedTenant
+}
+
+type cachedTenant struct {
+	tenant  models.Tenant
+	expires time.Time
+}
+
+func NewTenantService(tenants repository.TenantRepository, provision func(tenantID string) error) TenantInterface {
+	return &tenantServiceImpl{tenants: tenants, provision: provision, cache: map[string]cachedTenant{}}
+}
+
+func (t *tenantServiceImpl) CreateTenant(tenant *models.Tenant) error {
+	tenant.ID = strings.TrimSpace(tenant.ID)
+	tenant.Name = strings.TrimSpace(tenant.Name)
+	if err := validation.ValidateTenantID(tenant.ID); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	if tenant.Name == "" {
+		tenant.Name = tenant.ID
+	}
+	tenant.Status = models.StatusActive
+	if t.provision != nil {
+		if err := t.provision(tenant.ID); err != nil {
+			return fmt.Errorf("provisioning tenant %s: %w", tenant.ID, err)
+		}
+	}
+	return t.tenants.CreateTenant(tenant)
+}
+
+// Tenant returns a registered tenant, from a short-lived cache since every
+// request looks its tenant up.
+func (t *tenantServiceImpl) Tenant(id string) (*models.Tenant, error) {
+	t.mu.Lock()
+	cached, ok := t.cache[id]
+	t.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		tenant := cached.tenant
+		return &tenant, nil
+	}
+	tenant, err := t.tenants.FetchTenant(id)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.cache[id] = cachedTenant{tenant: *tenant, expires: time.Now().Add(tenantCacheTTL)}
+	t.mu.Unlock()
+	return tenant, nil
+}
+
+func (t *tenantServiceImpl) ListTenants() ([]models.Tenant, error) {
+	return t.tenants.ListTenants()
+}
@@ -0,0 +1,336 @@
+package services
+
+import (
+	"Users/models"
+	"Users/repository"
+	"Users/totp"
+	"Users/utils"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTwoFactorUnavailable = errors.New("two-factor authentication is not configured")
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrNoPendingEnrollment  = errors.New("no two-factor enrollment in progress")
+	ErrInvalidCode          = errors.New("invalid two-factor code")
+	ErrChallengeInvalid     = errors.New("invalid or expired login challenge")
+)
+
+const (
+	recoveryCodeCount = 10
+	//codes are 80 random bits, so a fast hash is enough to store them
+	recoveryCodeBytes = 10
+	//accept the previous and next time step to tolerate clock drift
+	totpSkew = 1
+)
+
+type TwoFactorOptions struct {
+	//Issuer names the service in authenticator apps
+	Issuer string
+	//ChallengeTTL is how long a correct password waits for its second factor
+	ChallengeTTL time.Duration
+}
+
+func DefaultTwoFactorOptions() TwoFactorOptions {
+	return TwoFactorOptions{Issuer: "Users", ChallengeTTL: 5 * time.Minute}
+}
+
+// challengeClaims is the signed payload of a login challenge. Password
+// carries a fingerprint of the password hash, so changing the password
+// voids challenges issued before.
+type challengeClaims struct {
+	Tenant   string `json:"t"`
+	User     string `json:"u"`
+	Password string `json:"p"`
+	Expires  int64  `json:"e"`
+}
+
+type twoFactorServiceImpl struct {
+	users   repository.UserRepository
+	lockout LockoutInterface
+	//sealer encrypts TOTP secrets at rest; nil when no key is configured
+	sealer *utils.Sealer
+	signer *utils.Signer
+	tenant string
+	opts   TwoFactorOptions
+	logger *slog.Logger
+}
+
+func NewTwoFactorService(users repository.UserRepository, lockout LockoutInterface, sealer *utils.Sealer, signer *utils.Signer, tenant string, opts TwoFactorOptions, logger *slog.Logger) TwoFactorInterface {
+	return &twoFactorServiceImpl{users: users, lockout: lockout, sealer: sealer, signer: signer, tenant: tenant, opts: opts, logger: logger}
+}
+
+// associated binds a sealed secret to its user and tenant
+func (t *twoFactorServiceImpl) associated(userID string) []byte {
+	return []byte(t.tenant + "/" + userID)
+}
+
+func (t *twoFactorServiceImpl) Status(userID string) (*models.TwoFactorStatus, error) {
+	user, err := t.users.FetchUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	status := &models.TwoFactorStatus{}
+	if tf := user.TwoFactor; tf != nil {
+		status.Enabled = tf.Enabled
+		status.Pending = tf.PendingSecret != ""
+		status.EnabledAt = tf.EnabledAt
+		status.RecoveryCodesRemaining = len(tf.RecoveryCodes)
+	}
+	return status, nil
+}
+
+// Enroll starts TOTP enrollment with a new secret. Starting again replaces
+// a pending secret; an enabled factor has to be reset first.
+func (t *twoFactorServiceImpl) Enroll(userID string) (*models.TOTPEnrollment, error) {
+	if t.sealer == nil {
+		return nil, ErrTwoFactorUnavailable
+	}
+	user, err := t.users.FetchUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactor != nil && user.TwoFactor.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := t.sealer.Seal(secret, t.associated(user.ID))
+	if err != nil {
+		return nil, err
+	}
+	if err := t.users.SetTwoFactor(user.ID, &models.TwoFactor{PendingSecret: sealed}); err != nil {
+		return nil, err
+	}
+	account := user.Email
+	if account == "" {
+		account = user.Username
+	}
+	uri := totp.URI(t.opts.Issuer, account, secret)
+	png, err := totp.QRCode(uri, 256)
+	if err != nil {
+		return nil, err
+	}
+	return &models.TOTPEnrollment{
+		Secret: totp.EncodeSecret(secret),
+		URI:    uri,
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+// Confirm enables the pending secret once the user proves their app
+// produces its codes, and returns the first recovery codes.
+func (t *twoFactorServiceImpl) Confirm(userID string, code string) ([]string, error) {
+	if t.sealer == nil {
+		return nil, ErrTwoFactorUnavailable
+	}
+	user, err := t.users.FetchUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactor == nil || user.TwoFactor.PendingSecret == "" {
+		return nil, ErrNoPendingEnrollment
+	}
+	secret, err := t.sealer.Open(user.TwoFactor.PendingSecret, t.associated(user.ID))
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, normalizeCode(code), time.Now(), totpSkew)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	codes, hashes, err := t.newRecoveryCodes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	err = t.users.SetTwoFactor(user.ID, &models.TwoFactor{
+		Enabled:       true,
+		Secret:        user.TwoFactor.PendingSecret,
+		RecoveryCodes: hashes,
+		EnabledAt:     &now,
+		LastStep:      step,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces every recovery code, after checking a
+// current code.
+func (t *twoFactorServiceImpl) RegenerateRecoveryCodes(userID string, code string) ([]string, error) {
+	user, err := t.users.FetchUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.verifyCode(user, code); err != nil {
+		return nil, err
+	}
+	//fetching again for the step verifyCode just recorded
+	user, err = t.users.FetchUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	codes, hashes, err := t.newRecoveryCodes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	updated := *user.TwoFactor
+	updated.RecoveryCodes = hashes
+	if err := t.users.SetTwoFactor(user.ID, &updated); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Reset removes the second factor, for users who lost both their app and
+// their recovery codes.
+func (t *twoFactorServiceImpl) Reset(userID string) error {
+	return t.users.SetTwoFactor(userID, nil)
+}
+
+func (t *twoFactorServiceImpl) Required(user *models.User) bool {
+	return user.TwoFactor != nil && user.TwoFactor.Enabled
+}
+
+// Challenge issues the token a client passes back with the second factor
+// after the password was accepted.
+func (t *twoFactorServiceImpl) Challenge(user *models.User) (string, error) {
+	payload, err := json.Marshal(challengeClaims{
+		Tenant:   t.tenant,
+		User:     user.ID,
+		Password: passwordFingerprint(user.Password),
+		Expires:  time.Now().Add(t.opts.ChallengeTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return t.signer.Sign(payload), nil
+}
+
+// CompleteLogin checks the second factor for a challenge and returns the
+// user it logs in. A recovery code is consumed on use. Wrong codes count
+// towards the lockout like wrong passwords.
+func (t *twoFactorServiceImpl) CompleteLogin(req models.SecondFactorRequest, ip string) (*models.User, error) {
+	payload, err := t.signer.Open(req.Token)
+	if err != nil {
+		return nil, ErrChallengeInvalid
+	}
+	var claims challengeClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Tenant != t.tenant || time.Now().Unix() >= claims.Expires {
+		return nil, ErrChallengeInvalid
+	}
+	user, err := t.users.FetchUserByID(claims.User)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrChallengeInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(passwordFingerprint(user.Password)), []byte(claims.Password)) != 1 {
+		return nil, ErrChallengeInvalid
+	}
+	if err := t.lockout.CheckUser(user); err != nil {
+		return nil, err
+	}
+	if user.Status != models.StatusActive {
+		return nil, ErrAccountInactive
+	}
+	if !t.Required(user) {
+		return nil, ErrChallengeInvalid
+	}
+	if req.RecoveryCode != "" {
+		err = t.users.ConsumeRecoveryCode(user.ID, hashRecoveryCode(user.ID, req.RecoveryCode))
+		if errors.Is(err, repository.ErrCodeReused) {
+			t.recordFailure(user, ip)
+			return nil, ErrInvalidCode
+		}
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if err := t.verifyCode(user, req.Code); err != nil {
+		if errors.Is(err, ErrInvalidCode) {
+			t.recordFailure(user, ip)
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (t *twoFactorServiceImpl) recordFailure(user *models.User, ip string) {
+	if err := t.lockout.RecordFailure(user, ip); err != nil {
+		t.logger.Warn("could not record failed second factor", slog.String("user", user.ID), slog.Any("error", err))
+	}
+}
+
+// verifyCode checks a TOTP code against the enabled secret and marks its
+// time step used, so a code cannot be replayed
+func (t *twoFactorServiceImpl) verifyCode(user *models.User, code string) error {
+	if t.sealer == nil {
+		return ErrTwoFactorUnavailable
+	}
+	if !t.Required(user) {
+		return ErrTwoFactorNotEnabled
+	}
+	secret, err := t.sealer.Open(user.TwoFactor.Secret, t.associated(user.ID))
+	if err != nil {
+		return err
+	}
+	step, ok := totp.Validate(secret, normalizeCode(code), time.Now(), totpSkew)
+	if !ok {
+		return ErrInvalidCode
+	}
+	err = t.users.UseTOTPStep(user.ID, step)
+	if errors.Is(err, repository.ErrCodeReused) {
+		return ErrInvalidCode
+	}
+	return err
+}
+
+// newRecoveryCodes returns fresh codes to show and the hashes to store
+func (t *twoFactorServiceImpl) newRecoveryCodes(userID string) ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(raw))
+		codes[i] = code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+		hashes[i] = hashRecoveryCode(userID, code)
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(userID string, code string) string {
+	sum := sha256.Sum256([]byte(userID + ":" + normalizeCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeCode drops the separators users type or paste with codes
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
+
+func passwordFingerprint(hash string) string {
+	sum := sha256.Sum256([]byte(hash))
+	return hex.EncodeToString(sum[:8])
+}
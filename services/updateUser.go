@@ -2,43 +2,284 @@ package services
 
 import (
 	"Users/models"
+	"Users/patch"
 	"Users/repository"
+	"Users/utils"
 	"Users/validation"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 )
 
 type updateServiceImpl struct {
-	update repository.UserRepository
+	update     repository.UserRepository
+	hasher     utils.PasswordHasher
+	canon      *utils.Canonicalizer
+	attributes AttributeInterface
 }
 
-func NewUpdateService(update repository.UserRepository) UpdateInterface {
-	return &updateServiceImpl{update: update}
+func NewUpdateService(update repository.UserRepository, hasher utils.PasswordHasher, canon *utils.Canonicalizer, attributes AttributeInterface) UpdateInterface {
+	return &updateServiceImpl{update: update, hasher: hasher, canon: canon, attributes: attributes}
 }
 
 func (u updateServiceImpl) UpdateUser(user *models.User) error {
 
 	//validating email
 	if user.Email != "" {
+		user.Email = u.canon.Display(user.Email)
 		err := validation.ValidateEmail(user.Email)
 		if err != nil {
-			return err
+			return fmt.Errorf("%w: %v", ErrInvalidInput, err)
 		}
+		user.EmailCanonical = u.canon.Email(user.Email)
 	}
 	if user.Password != "" {
 		err := validation.ValidatePassword(user.Password)
 		if err != nil {
-			return err
+			return fmt.Errorf("%w: %v", ErrInvalidInput, err)
 		}
 	}
 	if user.Username != "" {
+		user.Username = u.canon.Display(user.Username)
 		err := validation.ValidateUsername(user.Username)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+		user.UsernameCanonical = u.canon.Username(user.Username)
+	}
+	profile, err := prepareProfile(u.canon, user.Profile)
+	if err != nil {
+		return err
+	}
+	user.Profile = profile
+	//attributes are merged into the stored ones and the result has to
+	//satisfy the current schema
+	if user.Attributes != nil {
+		stored, err := u.update.FetchUserByID(user.ID)
 		if err != nil {
 			return err
 		}
+		merged := models.Attributes{}
+		for name, value := range stored.Attributes {
+			merged[name] = value
+		}
+		for name, value := range user.Attributes {
+			merged[name] = value
+		}
+		if err := u.attributes.ValidateAttributes(merged); err != nil {
+			return err
+		}
+		user.Attributes = merged
+	}
+	//hashing the new password before it reaches the repository
+	if user.Password != "" {
+		hashedPassword, err := u.hasher.Hash(user.Password)
+		if err != nil {
+			return err
+		}
+		user.Password = hashedPassword
 	}
 	//calling the repository layer to update user
-	err := u.update.UpdateUser(user)
+	err = u.update.UpdateUser(user)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// patchable lists the user members a PATCH may touch; everything else in
+// the stored document is managed by dedicated endpoints
+var patchable = map[string]bool{
+	"username":   true,
+	"email":      true,
+	"password":   true,
+	"profile":    true,
+	"attributes": true,
+}
+
+// nestedPatchable are the members whose contents a JSON Patch may address
+var nestedPatchable = map[string]bool{
+	"profile":    true,
+	"attributes": true,
+}
+
+// PatchUser applies a merge patch or JSON Patch to the user's patchable
+// fields, validates the resulting document and stores it. The password is
+// write-only: it reads as null and is only changed when the patch sets it.
+func (u updateServiceImpl) PatchUser(id string, mediaType string, body []byte) (*models.User, error) {
+	stored, err := u.update.FetchUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	doc := map[string]any{
+		"username":   nil,
+		"email":      stored.Email,
+		"password":   nil,
+		"profile":    nil,
+		"attributes": nil,
+	}
+	if stored.Username != "" {
+		doc["username"] = stored.Username
+	}
+	if !stored.Profile.IsZero() {
+		profile, err := toDocument(stored.Profile)
+		if err != nil {
+			return nil, err
+		}
+		doc["profile"] = profile
+	}
+	if len(stored.Attributes) > 0 {
+		doc["attributes"] = map[string]any(stored.Attributes)
+	}
+
+	switch mediaType {
+	case patch.MediaTypeMergePatch:
+		changes, err := patch.ParseMergePatch(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+		for member := range changes {
+			if !patchable[member] {
+				return nil, fmt.Errorf("%w: /%s is not patchable", ErrInvalidInput, member)
+			}
+		}
+		doc = patch.Merge(doc, changes)
+	case patch.MediaTypeJSONPatch:
+		ops, err := patch.ParseJSONPatch(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+		for _, op := range ops {
+			for _, pointer := range []string{op.Path, op.From} {
+				if err := checkPatchable(pointer); err != nil {
+					return nil, err
+				}
+			}
+		}
+		doc, err = patch.Apply(doc, ops)
+		if errors.Is(err, patch.ErrTestFailed) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+	default:
+		return nil, ErrUnsupportedPatch
+	}
+
+	patched, err := u.patchedUser(stored, doc)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.update.PatchUser(patched); err != nil {
+		return nil, err
+	}
+	patched.Password = ""
+	return patched, nil
+}
+
+// checkPatchable only allows pointers to a whitelisted member, or inside
+// one of the object members
+func checkPatchable(pointer string) error {
+	if pointer == "" {
+		return nil
+	}
+	tokens, err := patch.Paths(pointer)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	if len(tokens) == 0 || !patchable[tokens[0]] || (len(tokens) > 1 && !nestedPatchable[tokens[0]]) {
+		return fmt.Errorf("%w: %s is not patchable", ErrInvalidInput, pointer)
+	}
+	return nil
+}
+
+// toDocument and fromDocument convert between a struct and the generic
+// JSON object patches are applied to
+func toDocument(v any) (map[string]any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	err = json.Unmarshal(data, &doc)
+	return doc, err
+}
+
+func fromDocument(doc map[string]any, v any) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// patchedUser validates the patched document and turns it into the user to
+// store, with canonical fields and a freshly hashed password if one was set
+func (u updateServiceImpl) patchedUser(stored *models.User, doc map[string]any) (*models.User, error) {
+	var err error
+	for member, value := range doc {
+		if !patchable[member] {
+			return nil, fmt.Errorf("%w: /%s is not patchable", ErrInvalidInput, member)
+		}
+		if nestedPatchable[member] {
+			if _, ok := value.(map[string]any); value != nil && !ok {
+				return nil, fmt.Errorf("%w: %s must be an object or null", ErrInvalidInput, member)
+			}
+			continue
+		}
+		if _, ok := value.(string); value != nil && !ok {
+			return nil, fmt.Errorf("%w: %s must be a string or null", ErrInvalidInput, member)
+		}
+	}
+	email, _ := doc["email"].(string)
+	username, _ := doc["username"].(string)
+	password, _ := doc["password"].(string)
+
+	patched := *stored
+	patched.Email = u.canon.Display(email)
+	if err := validation.ValidateEmail(patched.Email); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	patched.EmailCanonical = u.canon.Email(patched.Email)
+	//the username is optional, so null or an empty string clears it
+	patched.Username = u.canon.Display(username)
+	patched.UsernameCanonical = ""
+	if patched.Username != "" {
+		if err := validation.ValidateUsername(patched.Username); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+		patched.UsernameCanonical = u.canon.Username(patched.Username)
+	}
+	patched.Profile = nil
+	if value, ok := doc["profile"].(map[string]any); ok {
+		var profile models.Profile
+		if err := fromDocument(value, &profile); err != nil {
+			return nil, fmt.Errorf("%w: profile: %v", ErrInvalidInput, err)
+		}
+		if patched.Profile, err = prepareProfile(u.canon, &profile); err != nil {
+			return nil, err
+		}
+	}
+	patched.Attributes = nil
+	if value, ok := doc["attributes"].(map[string]any); ok && len(value) > 0 {
+		patched.Attributes = models.Attributes(value)
+		if err := u.attributes.ValidateAttributes(patched.Attributes); err != nil {
+			return nil, err
+		}
+	}
+	if password != "" {
+		if err := validation.ValidatePassword(password); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+		hashed, err := u.hasher.Hash(password)
+		if err != nil {
+			return nil, err
+		}
+		patched.Password = hashed
+	}
+	return &patched, nil
+}
@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2Params are the argon2id cost parameters. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+type argon2idHasher struct {
+	params   Argon2Params
+	pepper   []byte
+	pepperID string
+}
+
+// NewArgon2idHasher returns a hasher producing PHC strings of the form
+// $argon2id$v=19$m=65536,t=3,p=2[,keyid=...]$salt$hash. When a pepper is set
+// the password is HMAC'd with it first and its ID is recorded as keyid.
+func NewArgon2idHasher(params Argon2Params, pepper []byte, pepperID string) (PasswordHasher, error) {
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return nil, errors.New("argon2 parameters must be positive")
+	}
+	if params.SaltLength < 8 || params.KeyLength < 16 {
+		return nil, errors.New("argon2 salt or key length too short")
+	}
+	if len(pepper) > 0 && pepperID == "" {
+		return nil, errors.New("password pepper requires a pepper ID")
+	}
+	return &argon2idHasher{params: params, pepper: pepper, pepperID: pepperID}, nil
+}
+
+func (a *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	key := argon2.IDKey(a.peppered(password, a.pepper), salt, a.params.Iterations, a.params.Memory, a.params.Parallelism, a.params.KeyLength)
+	encoded := phcHash{
+		params:   a.params,
+		pepperID: a.pepperIDIfSet(),
+		salt:     salt,
+		key:      key,
+	}
+	return encoded.String(), nil
+}
+
+func (a *argon2idHasher) Verify(password, encoded string) (bool, error) {
+	h, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	var pepper []byte
+	if h.pepperID != "" {
+		if h.pepperID != a.pepperID {
+			return false, ErrUnknownPepper
+		}
+		pepper = a.pepper
+	}
+	key := argon2.IDKey(a.peppered(password, pepper), h.salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1, nil
+}
+
+func (a *argon2idHasher) NeedsRehash(encoded string) bool {
+	h, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return h.params.Memory != a.params.Memory ||
+		h.params.Iterations != a.params.Iterations ||
+		h.params.Parallelism != a.params.Parallelism ||
+		uint32(len(h.salt)) != a.params.SaltLength ||
+		uint32(len(h.key)) != a.params.KeyLength ||
+		h.pepperID != a.pepperIDIfSet()
+}
+
+func (a *argon2idHasher) peppered(password string, pepper []byte) []byte {
+	if len(pepper) == 0 {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+func (a *argon2idHasher) pepperIDIfSet() string {
+	if len(a.pepper) == 0 {
+		return ""
+	}
+	return a.pepperID
+}
+
+// phcHash is a decoded argon2id PHC string.
+type phcHash struct {
+	params   Argon2Params
+	pepperID string
+	salt     []byte
+	key      []byte
+}
+
+func (h phcHash) String() string {
+	b64 := base64.RawStdEncoding
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", h.params.Memory, h.params.Iterations, h.params.Parallelism)
+	if h.pepperID != "" {
+		params += ",keyid=" + b64.EncodeToString([]byte(h.pepperID))
+	}
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, params, b64.EncodeToString(h.salt), b64.EncodeToString(h.key))
+}
+
+func parseArgon2id(encoded string) (phcHash, error) {
+	var h phcHash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != AlgorithmArgon2id {
+		return h, ErrUnsupportedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return h, ErrUnsupportedHash
+	}
+	b64 := base64.RawStdEncoding
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return h, ErrUnsupportedHash
+		}
+		var err error
+		switch name {
+		case "m":
+			_, err = fmt.Sscanf(value, "%d", &h.params.Memory)
+		case "t":
+			_, err = fmt.Sscanf(value, "%d", &h.params.Iterations)
+		case "p":
+			_, err = fmt.Sscanf(value, "%d", &h.params.Parallelism)
+		case "keyid":
+			var id []byte
+			id, err = b64.DecodeString(value)
+			h.pepperID = string(id)
+		default:
+			err = ErrUnsupportedHash
+		}
+		if err != nil {
+			return h, ErrUnsupportedHash
+		}
+	}
+	if h.params.Memory == 0 || h.params.Iterations == 0 || h.params.Parallelism == 0 {
+		return h, ErrUnsupportedHash
+	}
+	var err error
+	if h.salt, err = b64.DecodeString(parts[4]); err != nil {
+		return h, ErrUnsupportedHash
+	}
+	if h.key, err = b64.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return h, ErrUnsupportedHash
+	}
+	h.params.SaltLength = uint32(len(h.salt))
+	h.params.KeyLength = uint32(len(h.key))
+	return h, nil
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// EmailPolicy describes provider-specific address rules used when building
+// the canonical form of an email. Domains are matched after lowercasing.
+type EmailPolicy struct {
+	//domains where dots in the local part are ignored (a.b@gmail.com == ab@gmail.com)
+	DotInsensitiveDomains []string
+	//domains where everything after '+' in the local part is a tag
+	PlusTagDomains []string
+	//domains that are aliases of another domain
+	DomainAliases map[string]string
+}
+
+// DefaultEmailPolicy folds nothing but case: provider rules such as gmail
+// ignoring dots and '+' tags are opt in, as they merge addresses some
+// domains treat as distinct. Deployments whose users were canonicalized
+// under such rules must keep configuring them, or existing addresses stop
+// matching their stored canonical form.
+func DefaultEmailPolicy() EmailPolicy {
+	return EmailPolicy{DomainAliases: map[string]string{}}
+}
+
+// EmailPolicyFromEnv enables provider rules from the comma separated
+// EMAIL_DOT_INSENSITIVE_DOMAINS and EMAIL_PLUS_TAG_DOMAINS values and the
+// alias=domain pairs of EMAIL_DOMAIN_ALIASES, e.g.
+// EMAIL_DOMAIN_ALIASES=googlemail.com=gmail.com.
+func EmailPolicyFromEnv() EmailPolicy {
+	policy := DefaultEmailPolicy()
+	if v, ok := os.LookupEnv("EMAIL_DOT_INSENSITIVE_DOMAINS"); ok {
+		policy.DotInsensitiveDomains = splitList(v)
+	}
+	if v, ok := os.LookupEnv("EMAIL_PLUS_TAG_DOMAINS"); ok {
+		policy.PlusTagDomains = splitList(v)
+	}
+	if v, ok := os.LookupEnv("EMAIL_DOMAIN_ALIASES"); ok {
+		for _, pair := range splitList(v) {
+			alias, domain, _ := strings.Cut(pair, "=")
+			alias, domain = strings.TrimSpace(alias), strings.TrimSpace(domain)
+			if alias != "" && domain != "" {
+				policy.DomainAliases[alias] = domain
+			}
+		}
+	}
+	return policy
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Canonicalizer produces the display and canonical forms of user identifiers.
+// Display forms keep the user's casing; canonical forms are what uniqueness
+// and lookups are based on.
+type Canonicalizer struct {
+	Policy EmailPolicy
+}
+
+func NewCanonicalizer(policy EmailPolicy) *Canonicalizer {
+	return &Canonicalizer{Policy: policy}
+}
+
+// Display trims and NFKC-normalizes a user supplied identifier.
+func (c *Canonicalizer) Display(value string) string {
+	return strings.TrimSpace(norm.NFKC.String(value))
+}
+
+func (c *Canonicalizer) Username(username string) string {
+	return strings.ToLower(c.Display(username))
+}
+
+func (c *Canonicalizer) Email(email string) string {
+	email = strings.ToLower(c.Display(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], email[at+1:]
+	if alias, ok := c.Policy.DomainAliases[domain]; ok {
+		domain = alias
+	}
+	if contains(c.Policy.PlusTagDomains, domain) {
+		if plus := strings.Index(local, "+"); plus >= 0 {
+			local = local[:plus]
+		}
+	}
+	if contains(c.Policy.DotInsensitiveDomains, domain) {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+	return local + "@" + domain
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
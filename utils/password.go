@@ -1,15 +1,155 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
 
-	"golang.org/x/crypto/bcrypt"
+var (
+	ErrUnsupportedHash = errors.New("unsupported password hash format")
+	ErrUnknownPepper   = errors.New("password hash references an unknown pepper")
 )
 
-func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// PasswordHasher hashes and verifies passwords. Encoded hashes carry their
+// algorithm and parameters, so hashes written under an older configuration
+// stay verifiable and can be upgraded with NeedsRehash.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	NeedsRehash(encoded string) bool
+}
+
+// PasswordConfig selects the algorithm used for new hashes and its cost.
+// The pepper is a server-side secret mixed into argon2id hashes only.
+type PasswordConfig struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+	Pepper     []byte
+	PepperID   string
+}
+
+func DefaultPasswordConfig() PasswordConfig {
+	return PasswordConfig{
+		Algorithm:  AlgorithmArgon2id,
+		BcryptCost: defaultBcryptCost,
+		Argon2:     DefaultArgon2Params(),
+	}
+}
+
+// PasswordConfigFromEnv reads the hashing configuration from the environment,
+// falling back to the defaults for anything that is not set.
+func PasswordConfigFromEnv() (PasswordConfig, error) {
+	cfg := DefaultPasswordConfig()
+	if v := os.Getenv("PASSWORD_HASH_ALGORITHM"); v != "" {
+		cfg.Algorithm = strings.ToLower(v)
+	}
+	var err error
+	if cfg.BcryptCost, err = envInt("BCRYPT_COST", cfg.BcryptCost); err != nil {
+		return cfg, err
+	}
+	memory, err := envInt("ARGON2_MEMORY_KIB", int(cfg.Argon2.Memory))
 	if err != nil {
-		return "", fmt.Errorf("failed to hash password: %w", err)
+		return cfg, err
+	}
+	iterations, err := envInt("ARGON2_ITERATIONS", int(cfg.Argon2.Iterations))
+	if err != nil {
+		return cfg, err
+	}
+	parallelism, err := envInt("ARGON2_PARALLELISM", int(cfg.Argon2.Parallelism))
+	if err != nil {
+		return cfg, err
+	}
+	if memory <= 0 || iterations <= 0 || parallelism <= 0 || parallelism > 255 {
+		return cfg, errors.New("argon2 parameters out of range")
+	}
+	cfg.Argon2.Memory = uint32(memory)
+	cfg.Argon2.Iterations = uint32(iterations)
+	cfg.Argon2.Parallelism = uint8(parallelism)
+
+	if pepper := os.Getenv("PASSWORD_PEPPER"); pepper != "" {
+		cfg.Pepper = []byte(pepper)
+		cfg.PepperID = os.Getenv("PASSWORD_PEPPER_ID")
+		if cfg.PepperID == "" {
+			cfg.PepperID = "1"
+		}
+	}
+	return cfg, nil
+}
+
+func envInt(key string, fallback int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return n, nil
+}
+
+// multiHasher hashes with the configured algorithm and verifies hashes from
+// any supported algorithm, flagging the ones that should be upgraded.
+type multiHasher struct {
+	algorithm string
+	hashers   map[string]PasswordHasher
+}
+
+func NewPasswordHasher(cfg PasswordConfig) (PasswordHasher, error) {
+	bcryptHasher, err := NewBcryptHasher(cfg.BcryptCost)
+	if err != nil {
+		return nil, err
+	}
+	argonHasher, err := NewArgon2idHasher(cfg.Argon2, cfg.Pepper, cfg.PepperID)
+	if err != nil {
+		return nil, err
+	}
+	hashers := map[string]PasswordHasher{
+		AlgorithmBcrypt:   bcryptHasher,
+		AlgorithmArgon2id: argonHasher,
+	}
+	if _, ok := hashers[cfg.Algorithm]; !ok {
+		return nil, fmt.Errorf("unknown password hash algorithm %q", cfg.Algorithm)
+	}
+	return &multiHasher{algorithm: cfg.Algorithm, hashers: hashers}, nil
+}
+
+func (m *multiHasher) Hash(password string) (string, error) {
+	return m.hashers[m.algorithm].Hash(password)
+}
+
+func (m *multiHasher) Verify(password, encoded string) (bool, error) {
+	hasher, ok := m.hashers[hashAlgorithm(encoded)]
+	if !ok {
+		return false, ErrUnsupportedHash
+	}
+	return hasher.Verify(password, encoded)
+}
+
+func (m *multiHasher) NeedsRehash(encoded string) bool {
+	algorithm := hashAlgorithm(encoded)
+	if algorithm != m.algorithm {
+		return true
+	}
+	return m.hashers[algorithm].NeedsRehash(encoded)
+}
+
+// hashAlgorithm identifies the algorithm of a PHC or modular crypt string.
+func hashAlgorithm(encoded string) string {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return AlgorithmArgon2id
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return AlgorithmBcrypt
 	}
-	return string(hashedPassword), nil
+	return ""
 }
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var ErrCannotOpen = errors.New("sealed value could not be decrypted")
+
+// sealVersion prefixes sealed values so the format can change later
+const sealVersion = "v1."
+
+// Sealer encrypts small secrets for storage with AES-256-GCM. The
+// associated data binds a sealed value to its owner, so it cannot be copied
+// into another record.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// NewSealer takes a 32 byte key.
+func NewSealer(key []byte) (*Sealer, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, errors.New("sealer key must be 32 bytes")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+func (s *Sealer) Seal(plaintext []byte, associated []byte) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, plaintext, associated)
+	return sealVersion + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (s *Sealer) Open(sealed string, associated []byte) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(sealed, sealVersion)
+	if !ok {
+		return nil, ErrCannotOpen
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(data) < s.aead.NonceSize() {
+		return nil, ErrCannotOpen
+	}
+	nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, associated)
+	if err != nil {
+		return nil, ErrCannotOpen
+	}
+	return plaintext, nil
+}
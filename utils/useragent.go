@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// browsers in match order: several embed the tokens of the engine they are
+// built on, e.g. Edge and Opera also claim to be Chrome and Safari
+var browserPatterns = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"Edge", regexp.MustCompile(`Edg(?:e|A|iOS)?/(\d+)`)},
+	{"Opera", regexp.MustCompile(`(?:OPR|Opera)/(\d+)`)},
+	{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/(\d+)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/(\d+)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/(\d+)`)},
+	{"Safari", regexp.MustCompile(`Version/(\d+)[.\d]* (?:Mobile/\S+ )?Safari/`)},
+	{"curl", regexp.MustCompile(`^curl/(\d+)`)},
+	{"Go", regexp.MustCompile(`^Go-http-client/(\d+)`)},
+}
+
+var (
+	windowsVersion = regexp.MustCompile(`Windows NT (\d+\.\d+)`)
+	androidVersion = regexp.MustCompile(`Android (\d+)`)
+	iosVersion     = regexp.MustCompile(`OS (\d+)[_\d]* like Mac OS X`)
+	macVersion     = regexp.MustCompile(`Mac OS X (\d+)[_.](\d+)`)
+)
+
+// Windows 11 still reports NT 10.0, so the two can't be told apart
+var windowsReleases = map[string]string{
+	"10.0": "10",
+	"6.3":  "8.1",
+	"6.2":  "8",
+	"6.1":  "7",
+}
+
+// ParseUserAgent names the browser and operating system in a User-Agent
+// header, with their major versions when known, e.g. "Chrome 120" and
+// "Windows 10". Parts it does not recognise are returned empty.
+func ParseUserAgent(ua string) (browser string, os string) {
+	for _, b := range browserPatterns {
+		if m := b.pattern.FindStringSubmatch(ua); m != nil {
+			browser = b.name + " " + m[1]
+			break
+		}
+	}
+
+	switch {
+	case strings.Contains(ua, "Windows"):
+		os = "Windows"
+		if m := windowsVersion.FindStringSubmatch(ua); m != nil {
+			if release, ok := windowsReleases[m[1]]; ok {
+				os += " " + release
+			}
+		}
+	//checked before Linux, which Android agents also name
+	case strings.Contains(ua, "Android"):
+		os = "Android"
+		if m := androidVersion.FindStringSubmatch(ua); m != nil {
+			os += " " + m[1]
+		}
+	//checked before macOS, which iOS agents also name
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"), strings.Contains(ua, "iPod"):
+		os = "iOS"
+		if m := iosVersion.FindStringSubmatch(ua); m != nil {
+			os += " " + m[1]
+		}
+	case strings.Contains(ua, "Mac OS X"):
+		os = "macOS"
+		if m := macVersion.FindStringSubmatch(ua); m != nil {
+			//Safari froze the reported version at 10.15
+			if m[1] == "10" && m[2] != "15" {
+				os += " 10." + m[2]
+			} else if m[1] != "10" {
+				os += " " + m[1]
+			}
+		}
+	case strings.Contains(ua, "CrOS"):
+		os = "ChromeOS"
+	case strings.Contains(ua, "Linux"):
+		os = "Linux"
+	}
+	return browser, os
+}
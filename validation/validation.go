@@ -1,9 +1,17 @@
 package validation
 
 import (
+	"Users/models"
 	"errors"
 	"net/mail"
+	"net/url"
 	"regexp"
+	"time"
+	_ "time/tzdata" //time zones validate the same on hosts without zoneinfo
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
 )
 
 // Pre-compile regex patterns for better performance
@@ -12,6 +20,8 @@ var (
 	lowerRegex    = regexp.MustCompile(`[a-z]`)
 	numberRegex   = regexp.MustCompile(`[0-9]`)
 	usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+	phoneRegex    = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+	tenantRegex   = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,31}$`)
 )
 
 func ValidateEmail(email string) error {
@@ -46,3 +56,80 @@ func ValidateUsername(username string) error {
 	}
 	return nil
 }
+
+// validate status
+func ValidateStatus(status string) error {
+	switch status {
+	case models.StatusActive, models.StatusSuspended:
+		return nil
+	}
+	return errors.New("status must be active or suspended")
+}
+
+// validate role
+func ValidateRole(role string) error {
+	switch role {
+	case models.RoleUser, models.RoleAdmin:
+		return nil
+	}
+	return errors.New("role must be user or admin")
+}
+
+// validate profile
+func ValidateProfile(profile *models.Profile) error {
+	if profile == nil {
+		return nil
+	}
+	if utf8.RuneCountInString(profile.DisplayName) > 100 {
+		return errors.New("display name must be at most 100 characters")
+	}
+	for _, r := range profile.DisplayName {
+		if unicode.IsControl(r) {
+			return errors.New("display name cannot contain control characters")
+		}
+	}
+	if profile.Locale != "" {
+		if _, err := language.Parse(profile.Locale); err != nil {
+			return errors.New("locale must be a BCP 47 language tag")
+		}
+	}
+	if profile.Timezone != "" {
+		if _, err := time.LoadLocation(profile.Timezone); err != nil || profile.Timezone == "Local" {
+			return errors.New("timezone must be an IANA time zone name")
+		}
+	}
+	if profile.Phone != "" && !phoneRegex.MatchString(profile.Phone) {
+		return errors.New("phone must be in E.164 format, e.g. +14155550123")
+	}
+	if profile.AvatarURL != "" {
+		u, err := url.Parse(profile.AvatarURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || len(profile.AvatarURL) > 2048 {
+			return errors.New("avatar URL must be an absolute http or https URL")
+		}
+	}
+	return nil
+}
+
+// validate tenant ID; it ends up in database names, so the alphabet is small
+func ValidateTenantID(id string) error {
+	if !tenantRegex.MatchString(id) {
+		return errors.New("tenant ID must be 2-32 lowercase letters, digits or dashes")
+	}
+	return nil
+}
+
+// validate group name
+func ValidateGroupName(name string) error {
+	if name == "" {
+		return errors.New("group name is required")
+	}
+	if utf8.RuneCountInString(name) > 64 {
+		return errors.New("group name must be at most 64 characters")
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return errors.New("group name cannot contain control characters")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package webauthn
+
+import (
+	"encoding/binary"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// authenticator data flags
+const (
+	flagUserPresent    = 0x01
+	flagUserVerified   = 0x04
+	flagBackupEligible = 0x08
+	flagBackupState    = 0x10
+	flagAttestedData   = 0x40
+	flagExtensions     = 0x80
+)
+
+type attestationObject struct {
+	Format   string          `cbor:"fmt"`
+	AttStmt  cbor.RawMessage `cbor:"attStmt"`
+	AuthData []byte          `cbor:"authData"`
+}
+
+type authenticatorData struct {
+	rpIDHash   []byte
+	flags      byte
+	signCount  uint32
+	credential *attestedCredential
+	raw        []byte
+}
+
+type attestedCredential struct {
+	aaguid    []byte
+	id        []byte
+	publicKey []byte
+}
+
+func parseAttestationObject(data []byte) (*attestationObject, error) {
+	var att attestationObject
+	if err := cbor.Unmarshal(data, &att); err != nil {
+		return nil, failed("attestation object is not valid CBOR")
+	}
+	return &att, nil
+}
+
+// parseAuthenticatorData splits the binary authenticator data: RP ID hash,
+// flags, counter and, when flagged, the attested credential whose CBOR
+// public key has no length prefix
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, failed("authenticator data is too short")
+	}
+	ad := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+		raw:       data,
+	}
+	rest := data[37:]
+	if ad.flags&flagAttestedData != 0 {
+		if len(rest) < 18 {
+			return nil, failed("attested credential data is too short")
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		if len(rest) < 18+idLen {
+			return nil, failed("credential ID is truncated")
+		}
+		cred := &attestedCredential{aaguid: rest[:16], id: rest[18 : 18+idLen]}
+		rest = rest[18+idLen:]
+		var key cbor.RawMessage
+		remaining, err := cbor.UnmarshalFirst(rest, &key)
+		if err != nil {
+			return nil, failed("credential public key is not valid CBOR")
+		}
+		cred.publicKey = []byte(key)
+		rest = remaining
+		ad.credential = cred
+	}
+	if ad.flags&flagExtensions != 0 {
+		var ext cbor.RawMessage
+		remaining, err := cbor.UnmarshalFirst(rest, &ext)
+		if err != nil {
+			return nil, failed("extensions are not valid CBOR")
+		}
+		rest = remaining
+	}
+	if len(rest) != 0 {
+		return nil, failed("unexpected trailing authenticator data")
+	}
+	return ad, nil
+}
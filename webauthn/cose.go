@@ -0,0 +1,145 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSE algorithm identifiers offered to authenticators
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// COSE key types and curves
+const (
+	ktyOKP     = 1
+	ktyEC2     = 2
+	ktyRSA     = 3
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// coseKey holds the members of a COSE_Key; -1 is the curve for EC2 and OKP
+// keys but the modulus for RSA keys, so it is decoded late
+type coseKey struct {
+	Kty int64           `cbor:"1,keyasint"`
+	Alg int64           `cbor:"3,keyasint"`
+	M1  cbor.RawMessage `cbor:"-1,keyasint"`
+	M2  []byte          `cbor:"-2,keyasint"`
+	M3  []byte          `cbor:"-3,keyasint"`
+}
+
+// parseCOSEKey decodes a credential public key and its algorithm
+func parseCOSEKey(data []byte) (crypto.PublicKey, int64, error) {
+	var k coseKey
+	if err := cbor.Unmarshal(data, &k); err != nil {
+		return nil, 0, failed("public key is not a COSE key")
+	}
+	switch {
+	case k.Kty == ktyEC2 && k.Alg == AlgES256:
+		var crv int64
+		if err := cbor.Unmarshal(k.M1, &crv); err != nil || crv != crvP256 {
+			return nil, 0, failed("unsupported EC2 curve")
+		}
+		if len(k.M2) != 32 || len(k.M3) != 32 {
+			return nil, 0, failed("invalid P-256 coordinates")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(k.M2), Y: new(big.Int).SetBytes(k.M3)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, 0, failed("P-256 point is not on the curve")
+		}
+		return key, k.Alg, nil
+	case k.Kty == ktyOKP && k.Alg == AlgEdDSA:
+		var crv int64
+		if err := cbor.Unmarshal(k.M1, &crv); err != nil || crv != crvEd25519 {
+			return nil, 0, failed("unsupported OKP curve")
+		}
+		if len(k.M2) != ed25519.PublicKeySize {
+			return nil, 0, failed("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(k.M2), k.Alg, nil
+	case k.Kty == ktyRSA && k.Alg == AlgRS256:
+		var n []byte
+		if err := cbor.Unmarshal(k.M1, &n); err != nil || len(n) < 256 {
+			return nil, 0, failed("RSA keys must be at least 2048 bits")
+		}
+		e := new(big.Int).SetBytes(k.M2)
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, 0, failed("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(e.Int64())}, k.Alg, nil
+	}
+	return nil, 0, failed("unsupported key type %d with algorithm %d", k.Kty, k.Alg)
+}
+
+// verifySignature checks sig over data with a key of the given algorithm
+func verifySignature(alg int64, key crypto.PublicKey, data []byte, sig []byte) error {
+	switch alg {
+	case AlgES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		digest := sha256.Sum256(data)
+		if ok && ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return nil
+		}
+	case AlgEdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if ok && ed25519.Verify(pub, data, sig) {
+			return nil
+		}
+	case AlgRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		digest := sha256.Sum256(data)
+		if ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil {
+			return nil
+		}
+	}
+	return failed("invalid signature")
+}
+
+type packedStatement struct {
+	Alg int64    `cbor:"alg"`
+	Sig []byte   `cbor:"sig"`
+	X5C [][]byte `cbor:"x5c"`
+}
+
+// verifyAttestation checks the signature of "packed" statements, self or
+// certificate based, without judging the certificate. "none" must be empty
+// and other formats are accepted unverified.
+func verifyAttestation(att *attestationObject, credKey crypto.PublicKey, credAlg int64, clientDataHash []byte) error {
+	signed := append(append([]byte{}, att.AuthData...), clientDataHash...)
+	switch att.Format {
+	case "none":
+		var stmt map[string]any
+		if err := cbor.Unmarshal(att.AttStmt, &stmt); err != nil || len(stmt) != 0 {
+			return failed("none attestation has a statement")
+		}
+		return nil
+	case "packed":
+		var stmt packedStatement
+		if err := cbor.Unmarshal(att.AttStmt, &stmt); err != nil {
+			return failed("invalid packed attestation statement")
+		}
+		if len(stmt.X5C) == 0 {
+			if stmt.Alg != credAlg {
+				return failed("self attestation algorithm mismatch")
+			}
+			return verifySignature(stmt.Alg, credKey, signed, stmt.Sig)
+		}
+		cert, err := x509.ParseCertificate(stmt.X5C[0])
+		if err != nil {
+			return failed("invalid attestation certificate")
+		}
+		return verifySignature(stmt.Alg, cert.PublicKey, signed, stmt.Sig)
+	}
+	return nil
+}
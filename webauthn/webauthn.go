@@ -0,0 +1,351 @@
+// Package webauthn verifies WebAuthn registration and authentication
+// ceremonies for passkeys. It is transport agnostic: callers store the
+// challenges and credentials and pass the browser's JSON responses in, so
+// the ceremonies can be driven by a software authenticator as well.
+//
+// Attestation statements are not checked against trust anchors; passkeys
+// are accepted from any authenticator, as with attestation "none".
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	//ErrVerification is wrapped by every ceremony check failure
+	ErrVerification = errors.New("webauthn verification failed")
+	//ErrSignCount means the authenticator's counter went backwards, which
+	//suggests a cloned authenticator
+	ErrSignCount = errors.New("signature counter did not increase")
+)
+
+const challengeSize = 32
+
+// Config describes the relying party: the domain credentials are scoped to
+// and the origins allowed to run ceremonies for it.
+type Config struct {
+	RPID    string
+	RPName  string
+	Origins []string
+	Timeout time.Duration
+}
+
+type RelyingParty struct {
+	cfg Config
+}
+
+func New(cfg Config) *RelyingParty {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Minute
+	}
+	return &RelyingParty{cfg: cfg}
+}
+
+func (rp *RelyingParty) Timeout() time.Duration {
+	return rp.cfg.Timeout
+}
+
+// URLEncoded is binary data carried as unpadded base64url in JSON, as the
+// WebAuthn JSON serialization does. Padded input is accepted too.
+type URLEncoded []byte
+
+func (u URLEncoded) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(u))
+}
+
+func (u *URLEncoded) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*u = decoded
+	return nil
+}
+
+func (u URLEncoded) String() string {
+	return base64.RawURLEncoding.EncodeToString(u)
+}
+
+// NewChallenge returns a random challenge for one ceremony.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// ------------------ OPTIONS ------------------
+
+type RPEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          URLEncoded `json:"id"`
+	Name        string     `json:"name"`
+	DisplayName string     `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string     `json:"type"`
+	ID         URLEncoded `json:"id"`
+	Transports []string   `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions is the publicKey argument of navigator.credentials.create.
+type CreationOptions struct {
+	Challenge              URLEncoded             `json:"challenge"`
+	RP                     RPEntity               `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is the publicKey argument of navigator.credentials.get.
+// An empty AllowCredentials lets the user pick any discoverable passkey.
+type RequestOptions struct {
+	Challenge        URLEncoded             `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions asks for a discoverable, user-verified passkey for the
+// user handle, excluding credentials the user already registered.
+func (rp *RelyingParty) CreationOptions(challenge []byte, user UserEntity, exclude []CredentialDescriptor) CreationOptions {
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+	return CreationOptions{
+		Challenge: challenge,
+		RP:        RPEntity{ID: rp.cfg.RPID, Name: rp.cfg.RPName},
+		User:      user,
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgEdDSA},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:                rp.cfg.Timeout.Milliseconds(),
+		ExcludeCredentials:     exclude,
+		AuthenticatorSelection: AuthenticatorSelection{ResidentKey: "required", UserVerification: "required"},
+		Attestation:            "none",
+	}
+}
+
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow []CredentialDescriptor) RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.cfg.Timeout.Milliseconds(),
+		RPID:             rp.cfg.RPID,
+		AllowCredentials: allow,
+		UserVerification: "required",
+	}
+}
+
+// ------------------ RESPONSES ------------------
+
+// AttestationResponse is a PublicKeyCredential returned by create().
+type AttestationResponse struct {
+	ID       string     `json:"id"`
+	RawID    URLEncoded `json:"rawId"`
+	Type     string     `json:"type"`
+	Response struct {
+		ClientDataJSON    URLEncoded `json:"clientDataJSON"`
+		AttestationObject URLEncoded `json:"attestationObject"`
+		Transports        []string   `json:"transports"`
+	} `json:"response"`
+}
+
+// AssertionResponse is a PublicKeyCredential returned by get().
+type AssertionResponse struct {
+	ID       string     `json:"id"`
+	RawID    URLEncoded `json:"rawId"`
+	Type     string     `json:"type"`
+	Response struct {
+		ClientDataJSON    URLEncoded `json:"clientDataJSON"`
+		AuthenticatorData URLEncoded `json:"authenticatorData"`
+		Signature         URLEncoded `json:"signature"`
+		UserHandle        URLEncoded `json:"userHandle"`
+	} `json:"response"`
+}
+
+// Credential is what a relying party stores for a registered passkey.
+type Credential struct {
+	ID             []byte
+	PublicKey      []byte
+	Algorithm      int64
+	SignCount      uint32
+	AAGUID         []byte
+	Transports     []string
+	BackupEligible bool
+	BackupState    bool
+}
+
+// Assertion is the outcome of a verified login.
+type Assertion struct {
+	SignCount   uint32
+	BackupState bool
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func failed(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrVerification, fmt.Sprintf(format, args...))
+}
+
+// verifyClientData checks the ceremony type, challenge and origin signed
+// over by the authenticator
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return failed("client data is not JSON")
+	}
+	if cd.Type != ceremony {
+		return failed("client data type is %q", cd.Type)
+	}
+	got, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(cd.Challenge, "="))
+	if err != nil || !bytes.Equal(got, challenge) {
+		return failed("challenge mismatch")
+	}
+	if !slices.Contains(rp.cfg.Origins, cd.Origin) {
+		return failed("origin %q is not allowed", cd.Origin)
+	}
+	if cd.CrossOrigin {
+		return failed("cross-origin ceremonies are not allowed")
+	}
+	return nil
+}
+
+// VerifyRegistration checks a create() response against the challenge it
+// was issued for and returns the credential to store.
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, resp *AttestationResponse) (*Credential, error) {
+	if resp.Type != "public-key" {
+		return nil, failed("credential type is %q", resp.Type)
+	}
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+	att, err := parseAttestationObject(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	data, err := parseAuthenticatorData(att.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(data); err != nil {
+		return nil, err
+	}
+	if data.credential == nil {
+		return nil, failed("no attested credential data")
+	}
+	if len(resp.RawID) > 0 && !bytes.Equal(resp.RawID, data.credential.id) {
+		return nil, failed("credential ID mismatch")
+	}
+	key, alg, err := parseCOSEKey(data.credential.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	if err := verifyAttestation(att, key, alg, clientDataHash[:]); err != nil {
+		return nil, err
+	}
+	return &Credential{
+		ID:             data.credential.id,
+		PublicKey:      data.credential.publicKey,
+		Algorithm:      alg,
+		SignCount:      data.signCount,
+		AAGUID:         data.credential.aaguid,
+		Transports:     resp.Response.Transports,
+		BackupEligible: data.flags&flagBackupEligible != 0,
+		BackupState:    data.flags&flagBackupState != 0,
+	}, nil
+}
+
+// VerifyAssertion checks a get() response against the challenge and the
+// stored credential it claims to come from.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, resp *AssertionResponse, cred *Credential) (*Assertion, error) {
+	if resp.Type != "public-key" {
+		return nil, failed("credential type is %q", resp.Type)
+	}
+	if !bytes.Equal(resp.RawID, cred.ID) {
+		return nil, failed("credential ID mismatch")
+	}
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+	data, err := parseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(data); err != nil {
+		return nil, err
+	}
+	key, _, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(slices.Clip(resp.Response.AuthenticatorData), clientDataHash[:]...)
+	if err := verifySignature(cred.Algorithm, key, signed, resp.Response.Signature); err != nil {
+		return nil, err
+	}
+	//authenticators without a counter always report zero
+	if (data.signCount != 0 || cred.SignCount != 0) && data.signCount <= cred.SignCount {
+		return nil, ErrSignCount
+	}
+	return &Assertion{SignCount: data.signCount, BackupState: data.flags&flagBackupState != 0}, nil
+}
+
+// verifyAuthenticatorData checks the RP ID hash and that the user was
+// present and verified
+func (rp *RelyingParty) verifyAuthenticatorData(data *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(rp.cfg.RPID))
+	if !bytes.Equal(data.rpIDHash, rpIDHash[:]) {
+		return failed("RP ID hash mismatch")
+	}
+	if data.flags&flagUserPresent == 0 {
+		return failed("user was not present")
+	}
+	if data.flags&flagUserVerified == 0 {
+		return failed("user was not verified")
+	}
+	return nil
+}
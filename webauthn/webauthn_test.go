@@ -0,0 +1,168 @@
+package webauthn_test
+
+import (
+	"Users/webauthn"
+	"Users/webauthn/webauthntest"
+	"errors"
+	"testing"
+)
+
+const (
+	rpID   = "example.com"
+	origin = "https://example.com"
+)
+
+var algorithms = map[string]int64{"ES256": webauthn.AlgES256, "EdDSA": webauthn.AlgEdDSA}
+
+func newRelyingParty() *webauthn.RelyingParty {
+	return webauthn.New(webauthn.Config{RPID: rpID, RPName: "Example", Origins: []string{origin}})
+}
+
+func challenge(t *testing.T) []byte {
+	t.Helper()
+	c, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// register runs a registration ceremony that must succeed
+func register(t *testing.T, rp *webauthn.RelyingParty, alg int64) (*webauthntest.Authenticator, *webauthn.Credential) {
+	t.Helper()
+	auth, err := webauthntest.New(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := challenge(t)
+	resp, err := auth.Create(webauthntest.Ceremony{RPID: rpID, Origin: origin, Challenge: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, err := rp.VerifyRegistration(c, resp)
+	if err != nil {
+		t.Fatalf("registration: %v", err)
+	}
+	return auth, cred
+}
+
+func TestRegisterThenLogin(t *testing.T) {
+	for name, alg := range algorithms {
+		t.Run(name, func(t *testing.T) {
+			rp := newRelyingParty()
+			auth, cred := register(t, rp, alg)
+			if cred.Algorithm != alg {
+				t.Fatalf("algorithm = %d, want %d", cred.Algorithm, alg)
+			}
+			for want := uint32(1); want <= 2; want++ {
+				c := challenge(t)
+				resp, err := auth.Get(webauthntest.Ceremony{RPID: rpID, Origin: origin, Challenge: c})
+				if err != nil {
+					t.Fatal(err)
+				}
+				assertion, err := rp.VerifyAssertion(c, resp, cred)
+				if err != nil {
+					t.Fatalf("assertion %d: %v", want, err)
+				}
+				if assertion.SignCount != want {
+					t.Fatalf("sign count = %d, want %d", assertion.SignCount, want)
+				}
+				cred.SignCount = assertion.SignCount
+			}
+		})
+	}
+}
+
+func TestCounterGoingBackwards(t *testing.T) {
+	for name, alg := range algorithms {
+		t.Run(name, func(t *testing.T) {
+			rp := newRelyingParty()
+			auth, cred := register(t, rp, alg)
+			cred.SignCount = 5
+			auth.SignCount = 3
+			c := challenge(t)
+			resp, err := auth.Get(webauthntest.Ceremony{RPID: rpID, Origin: origin, Challenge: c})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := rp.VerifyAssertion(c, resp, cred); !errors.Is(err, webauthn.ErrSignCount) {
+				t.Fatalf("err = %v, want ErrSignCount", err)
+			}
+		})
+	}
+}
+
+// rejections are the ceremonies a relying party must refuse, given the
+// challenge it issued
+var rejections = []struct {
+	name   string
+	modify func(c *webauthntest.Ceremony)
+}{
+	{"wrong origin", func(c *webauthntest.Ceremony) { c.Origin = "https://evil.example" }},
+	{"wrong challenge", func(c *webauthntest.Ceremony) { c.Challenge = append([]byte{}, c.Challenge...); c.Challenge[0]++ }},
+	{"wrong RP ID hash", func(c *webauthntest.Ceremony) { c.RPID = "evil.example" }},
+	{"user not verified", func(c *webauthntest.Ceremony) { c.Flags = webauthntest.FlagUserPresent }},
+}
+
+func TestRegistrationRejected(t *testing.T) {
+	rp := newRelyingParty()
+	for name, alg := range algorithms {
+		for _, tc := range rejections {
+			t.Run(name+"/"+tc.name, func(t *testing.T) {
+				auth, err := webauthntest.New(alg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				issued := challenge(t)
+				ceremony := webauthntest.Ceremony{RPID: rpID, Origin: origin, Challenge: issued}
+				tc.modify(&ceremony)
+				resp, err := auth.Create(ceremony)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := rp.VerifyRegistration(issued, resp); !errors.Is(err, webauthn.ErrVerification) {
+					t.Fatalf("err = %v, want ErrVerification", err)
+				}
+			})
+		}
+	}
+}
+
+func TestAssertionRejected(t *testing.T) {
+	rp := newRelyingParty()
+	for name, alg := range algorithms {
+		for _, tc := range rejections {
+			t.Run(name+"/"+tc.name, func(t *testing.T) {
+				auth, cred := register(t, rp, alg)
+				issued := challenge(t)
+				ceremony := webauthntest.Ceremony{RPID: rpID, Origin: origin, Challenge: issued}
+				tc.modify(&ceremony)
+				resp, err := auth.Get(ceremony)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := rp.VerifyAssertion(issued, resp, cred); !errors.Is(err, webauthn.ErrVerification) {
+					t.Fatalf("err = %v, want ErrVerification", err)
+				}
+			})
+		}
+	}
+}
+
+func TestAssertionWithAnotherKeyRejected(t *testing.T) {
+	rp := newRelyingParty()
+	_, cred := register(t, rp, webauthn.AlgES256)
+	other, err := webauthntest.New(webauthn.AlgES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.ID = cred.ID
+	c := challenge(t)
+	resp, err := other.Get(webauthntest.Ceremony{RPID: rpID, Origin: origin, Challenge: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rp.VerifyAssertion(c, resp, cred); !errors.Is(err, webauthn.ErrVerification) {
+		t.Fatalf("err = %v, want ErrVerification", err)
+	}
+}
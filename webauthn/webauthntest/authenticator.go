@@ -0,0 +1,165 @@
+// Package webauthntest provides an in-process authenticator for testing
+// relying parties without a browser or a security key.
+package webauthntest
+
+import (
+	"Users/webauthn"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// authenticator data flags
+const (
+	FlagUserPresent  byte = 0x01
+	FlagUserVerified byte = 0x04
+	flagAttestedData byte = 0x40
+)
+
+// Ceremony is what the browser and the authenticator are told about the
+// relying party. Zero Flags means user present and verified.
+type Ceremony struct {
+	RPID      string
+	Origin    string
+	Challenge []byte
+	Flags     byte
+}
+
+// Authenticator holds one credential. SignCount is incremented before every
+// assertion, so setting it lower replays an older counter.
+type Authenticator struct {
+	Alg        int64
+	ID         []byte
+	UserHandle []byte
+	SignCount  uint32
+	key        crypto.Signer
+}
+
+// New returns an authenticator with a fresh key for alg, AlgES256 or
+// AlgEdDSA.
+func New(alg int64) (*Authenticator, error) {
+	var key crypto.Signer
+	var err error
+	switch alg {
+	case webauthn.AlgES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case webauthn.AlgEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %d", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &Authenticator{Alg: alg, ID: id, key: key}, nil
+}
+
+// Create registers the credential, answering with a packed self
+// attestation.
+func (a *Authenticator) Create(c Ceremony) (*webauthn.AttestationResponse, error) {
+	clientDataJSON, err := clientData("webauthn.create", c)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := a.coseKey()
+	if err != nil {
+		return nil, err
+	}
+	attested := make([]byte, 18, 18+len(a.ID)+len(publicKey))
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(a.ID)))
+	attested = append(append(attested, a.ID...), publicKey...)
+	authData := authenticatorData(c, flagAttestedData, a.SignCount, attested)
+	sig, err := a.sign(authData, clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	attestation, err := cbor.Marshal(map[string]any{
+		"fmt":      "packed",
+		"attStmt":  map[string]any{"alg": a.Alg, "sig": sig},
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := &webauthn.AttestationResponse{ID: webauthn.URLEncoded(a.ID).String(), RawID: a.ID, Type: "public-key"}
+	resp.Response.ClientDataJSON = clientDataJSON
+	resp.Response.AttestationObject = attestation
+	return resp, nil
+}
+
+// Get signs an assertion with the next counter value.
+func (a *Authenticator) Get(c Ceremony) (*webauthn.AssertionResponse, error) {
+	clientDataJSON, err := clientData("webauthn.get", c)
+	if err != nil {
+		return nil, err
+	}
+	a.SignCount++
+	authData := authenticatorData(c, 0, a.SignCount, nil)
+	sig, err := a.sign(authData, clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	resp := &webauthn.AssertionResponse{ID: webauthn.URLEncoded(a.ID).String(), RawID: a.ID, Type: "public-key"}
+	resp.Response.ClientDataJSON = clientDataJSON
+	resp.Response.AuthenticatorData = authData
+	resp.Response.Signature = sig
+	resp.Response.UserHandle = a.UserHandle
+	return resp, nil
+}
+
+func clientData(ceremony string, c Ceremony) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(c.Challenge),
+		"origin":    c.Origin,
+	})
+}
+
+// authenticatorData lays out the RP ID hash, flags, counter and the
+// attested credential, if any
+func authenticatorData(c Ceremony, extra byte, signCount uint32, attested []byte) []byte {
+	flags := c.Flags
+	if flags == 0 {
+		flags = FlagUserPresent | FlagUserVerified
+	}
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	data := append(rpIDHash[:], flags|extra, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], signCount)
+	return append(data, attested...)
+}
+
+// sign signs authData followed by the client data hash, as both
+// attestations and assertions do
+func (a *Authenticator) sign(authData []byte, clientDataJSON []byte) ([]byte, error) {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+	if a.Alg == webauthn.AlgEdDSA {
+		return a.key.Sign(rand.Reader, signed, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(signed)
+	return a.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// coseKey encodes the public key as a COSE_Key
+func (a *Authenticator) coseKey() ([]byte, error) {
+	switch pub := a.key.Public().(type) {
+	case *ecdsa.PublicKey:
+		return cbor.Marshal(map[int]any{1: 2, 3: a.Alg, -1: 1, -2: pub.X.FillBytes(make([]byte, 32)), -3: pub.Y.FillBytes(make([]byte, 32))})
+	case ed25519.PublicKey:
+		return cbor.Marshal(map[int]any{1: 1, 3: a.Alg, -1: 6, -2: []byte(pub)})
+	}
+	return nil, fmt.Errorf("unsupported key %T", a.key.Public())
+}